@@ -0,0 +1,86 @@
+package airdrop
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/quarantine"
+)
+
+// ErrNoSession is returned for a session ID the server does not hold
+var ErrNoSession = errors.New("no such session")
+
+// SessionInfo describes a session in progress
+type SessionInfo struct {
+	SessionID   string    `json:"session_id"`
+	Sender      string    `json:"sender"` // Device name, as the sender presented it
+	Fingerprint string    `json:"fingerprint"`
+	File        string    `json:"file,omitempty"` // Where it is saved, empty until accepted
+	Size        int64     `json:"size"`
+	Received    int64     `json:"received"`
+	Rate        float64   `json:"rate"` // Bytes per second since it started or was reloaded
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// ActiveSessions lists the sessions in progress, oldest first, including
+// those still waiting for an offer or an answer to their prompt
+func (s *SecureServer) ActiveSessions() []SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	sessions := make([]SessionInfo, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		info := SessionInfo{
+			SessionID:   session.SessionID,
+			Sender:      session.SenderName,
+			Fingerprint: session.Fingerprint,
+			StartedAt:   session.startedAt,
+		}
+		if session.Accepted {
+			info.File = session.FilePath
+			info.Size = session.Metadata.Size
+			info.Received = session.receivedBytes()
+			if elapsed := now.Sub(session.rateSince).Seconds(); elapsed > 0 {
+				info.Rate = float64(info.Received-session.rateBase) / elapsed
+			}
+		}
+		sessions = append(sessions, info)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions
+}
+
+// Kill drops a session and quarantines its partial file. Its sender's next
+// chunk fails as for an unknown session.
+func (s *SecureServer) Kill(sessionID string) error {
+	s.mu.Lock()
+	session, ok := s.sessions[sessionID]
+	delete(s.sessions, sessionID)
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrNoSession
+	}
+	s.forgetSession(sessionID)
+	if session.close() && session.FilePath != "" {
+		log.Printf("Killed transfer: %s", logging.Path(session.FilePath))
+		quarantine.Discard(session.FilePath, quarantine.ReasonCancelled, "airdrop", session.Fingerprint)
+	}
+	return nil
+}
+
+// receivedBytes sums the sizes of the received chunks. The server's mu
+// must be held.
+func (t *TransferSession) receivedBytes() int64 {
+	var received int64
+	for index := range t.ReceivedChunks {
+		received += int64(chunkLength(t.Metadata.Size, index))
+	}
+	return received
+}
@@ -0,0 +1,96 @@
+package airdrop
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+// Benchmarks for chunk encryption. See internal/crypto/bench_test.go for
+// how to run and compare them.
+
+var benchSizes = []struct {
+	name string
+	size int
+}{
+	{"1KiB", 1 << 10},
+	{"64KiB", 64 << 10},
+	{"1MiB", 1 << 20},
+	{"4MiB", ChunkSize},
+}
+
+func benchData(b *testing.B, n int) []byte {
+	b.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkEncryptChunk(b *testing.B) {
+	key := benchData(b, 32)
+	for _, s := range benchSizes {
+		data := benchData(b, s.size)
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if _, err := EncryptChunk(data, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptChunk(b *testing.B) {
+	key := benchData(b, 32)
+	for _, s := range benchSizes {
+		ciphertext, err := EncryptChunk(benchData(b, s.size), key)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if _, err := DecryptChunk(ciphertext, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncryptChunkStream(b *testing.B) {
+	key := benchData(b, 32)
+	for _, s := range benchSizes {
+		data := benchData(b, s.size)
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if _, err := EncryptChunkStream(data, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptChunkStream(b *testing.B) {
+	key := benchData(b, 32)
+	for _, s := range benchSizes {
+		ciphertext, err := EncryptChunkStream(benchData(b, s.size), key)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if _, err := DecryptChunkStream(bytes.NewReader(ciphertext), key, io.Discard, int64(s.size)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
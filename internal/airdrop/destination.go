@@ -0,0 +1,85 @@
+package airdrop
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// destination resolves where an accepted file is saved. An empty dest
+// saves name in the download directory. Otherwise dest is a file name or
+// path, relative to the download directory or absolute, and a trailing
+// separator makes it a directory for name. The result must stay within
+// the download directory or a destination root, also after symlinks are
+// followed; missing directories are created.
+func (s *SecureServer) destination(dest, name string) (string, error) {
+	if dest == "" {
+		return filepath.Join(s.downloadDir, name), nil
+	}
+
+	isDir := strings.HasSuffix(dest, "/") || strings.HasSuffix(dest, string(filepath.Separator))
+	target := filepath.FromSlash(dest)
+	if !filepath.IsAbs(target) {
+		if !filepath.IsLocal(target) {
+			return "", fmt.Errorf("destination %q leaves the download directory", dest)
+		}
+		target = filepath.Join(s.downloadDir, target)
+	}
+	if isDir {
+		target = filepath.Join(target, name)
+	}
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination: %w", err)
+	}
+
+	root, err := s.rootOf(target)
+	if err != nil {
+		return "", err
+	}
+	if target == root {
+		return "", fmt.Errorf("destination %q is a directory", dest)
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	// A symlink inside a root may point out of it
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination: %w", err)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination root: %w", err)
+	}
+	if !within(realRoot, realDir) {
+		return "", fmt.Errorf("destination %q leaves %s", dest, root)
+	}
+	if info, err := os.Lstat(target); err == nil && !info.Mode().IsRegular() {
+		return "", fmt.Errorf("destination %q is not a regular file", dest)
+	}
+	return target, nil
+}
+
+// rootOf returns the allowed root that holds path
+func (s *SecureServer) rootOf(path string) (string, error) {
+	for _, root := range append([]string{s.downloadDir}, s.roots...) {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if within(root, path) {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("destination %s is outside the allowed directories", path)
+}
+
+// within reports whether path is root or below it
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/mdns"
+	"github.com/owner/secure-file-manager/internal/logging"
 )
 
 const (
@@ -26,8 +28,9 @@ type DeviceInfo struct {
 type Discovery struct {
 	deviceName string
 	port       int
-	devices    map[string]*DeviceInfo
+	devices    map[string]*DeviceInfo // Replaced whole by each scan
 	server     *mdns.Server
+	mu         sync.RWMutex
 }
 
 func NewDiscovery(deviceName string, port int) *Discovery {
@@ -68,15 +71,26 @@ func (d *Discovery) StartAdvertising() error {
 		return fmt.Errorf("failed to create mDNS server: %w", err)
 	}
 
+	d.mu.Lock()
+	previous := d.server
 	d.server = server
-	log.Printf("Broadcasting as '%s' on port %d", d.deviceName, d.port)
+	d.mu.Unlock()
+	if previous != nil {
+		previous.Shutdown()
+	}
+	log.Printf("Broadcasting as '%s' on port %d", logging.DeviceName(d.deviceName), d.port)
 	return nil
 }
 
 // StopAdvertising stops broadcasting
 func (d *Discovery) StopAdvertising() error {
-	if d.server != nil {
-		return d.server.Shutdown()
+	d.mu.Lock()
+	server := d.server
+	d.server = nil
+	d.mu.Unlock()
+
+	if server != nil {
+		return server.Shutdown()
 	}
 	return nil
 }
@@ -85,8 +99,9 @@ func (d *Discovery) StopAdvertising() error {
 func (d *Discovery) ScanDevices(ctx context.Context, duration time.Duration) ([]*DeviceInfo, error) {
 	entriesCh := make(chan *mdns.ServiceEntry, 10)
 
-	// Clear previous devices
-	d.devices = make(map[string]*DeviceInfo)
+	// Devices are collected apart and swapped in at the end, so readers see
+	// either the previous scan or this one
+	found := make(map[string]*DeviceInfo)
 
 	// Start scanning
 	go func() {
@@ -128,23 +143,30 @@ func (d *Discovery) ScanDevices(ctx context.Context, duration time.Duration) ([]
 			Timestamp: time.Now(),
 		}
 
-		d.devices[device.IP.String()] = device
+		found[device.IP.String()] = device
 	}
 
-	// Convert to slice
-	result := make([]*DeviceInfo, 0, len(d.devices))
-	for _, device := range d.devices {
-		result = append(result, device)
-	}
+	d.mu.Lock()
+	d.devices = found
+	d.mu.Unlock()
 
-	return result, nil
+	return deviceList(found), nil
 }
 
 // GetDevices returns currently known devices
 func (d *Discovery) GetDevices() []*DeviceInfo {
-	result := make([]*DeviceInfo, 0, len(d.devices))
-	for _, device := range d.devices {
-		result = append(result, device)
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return deviceList(d.devices)
+}
+
+// deviceList copies devices, so callers never share the map or the
+// entries with a later scan
+func deviceList(devices map[string]*DeviceInfo) []*DeviceInfo {
+	result := make([]*DeviceInfo, 0, len(devices))
+	for _, device := range devices {
+		copied := *device
+		result = append(result, &copied)
 	}
 	return result
 }
@@ -1,10 +1,12 @@
 package airdrop
 
 import (
+	"bufio"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"io"
 
@@ -90,3 +92,109 @@ func DecryptChunk(ciphertext, key []byte) ([]byte, error) {
 
 	return plaintext, nil
 }
+
+// Streamed chunks are sealed in segments so a receiver can decrypt them as
+// they arrive: [7-byte nonce prefix], then per segment the ciphertext of up
+// to streamSegmentSize bytes with its 16-byte tag. Segment i is sealed with
+// nonce prefix || uint32(i) || last, so segments cannot be reordered,
+// dropped or cut off without failing authentication.
+const (
+	streamSegmentSize = 64 * 1024
+	streamPrefixSize  = 7
+	streamTagSize     = 16
+)
+
+// maxStreamedChunkSize bounds a streamed chunk body
+const maxStreamedChunkSize = streamPrefixSize + ChunkSize + (ChunkSize/streamSegmentSize+1)*streamTagSize
+
+func streamNonce(prefix []byte, segment uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], segment)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptChunkStream encrypts a chunk in segments for DecryptChunkStream
+func EncryptChunkStream(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := (len(plaintext) + streamSegmentSize - 1) / streamSegmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	out := make([]byte, streamPrefixSize, streamPrefixSize+len(plaintext)+segments*streamTagSize)
+	if _, err := io.ReadFull(rand.Reader, out); err != nil {
+		return nil, err
+	}
+	prefix := out[:streamPrefixSize:streamPrefixSize]
+
+	for i := 0; i < segments; i++ {
+		end := min((i+1)*streamSegmentSize, len(plaintext))
+		nonce := streamNonce(prefix, uint32(i), i == segments-1)
+		out = gcm.Seal(out, nonce, plaintext[i*streamSegmentSize:end], nil)
+	}
+	return out, nil
+}
+
+// DecryptChunkStream decrypts a chunk written by EncryptChunkStream from r
+// into w one segment at a time, writing no more than limit bytes. Only
+// authenticated data is written, but a failure can leave a prefix of the
+// chunk in w.
+func DecryptChunkStream(r io.Reader, key []byte, w io.Writer, limit int64) (int64, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return 0, fmt.Errorf("ciphertext too short")
+	}
+
+	br := bufio.NewReaderSize(r, streamSegmentSize+streamTagSize)
+	segment := make([]byte, streamSegmentSize+streamTagSize)
+	var written int64
+	for i := uint32(0); ; i++ {
+		n, err := io.ReadFull(br, segment)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return written, fmt.Errorf("ciphertext truncated")
+			}
+			return written, err
+		}
+		last := n < len(segment)
+		if !last {
+			_, err := br.Peek(1)
+			last = err == io.EOF
+		}
+
+		plaintext, err := gcm.Open(segment[:0], streamNonce(prefix, i, last), segment[:n], nil)
+		if err != nil {
+			return written, err
+		}
+		if written+int64(len(plaintext)) > limit {
+			return written, fmt.Errorf("chunk too large")
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return written, err
+		}
+		written += int64(len(plaintext))
+		if last {
+			return written, nil
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package airdrop
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/owner/secure-file-manager/internal/fec"
+)
+
+// maxParityGroups bounds how many incomplete groups' parity a receiver
+// buffers at once
+const maxParityGroups = 4
+
+// chunkLength returns the plaintext length of chunk index of a file
+func chunkLength(fileSize int64, index int) int {
+	remaining := fileSize - int64(index)*ChunkSize
+	if remaining > ChunkSize {
+		return ChunkSize
+	}
+	if remaining < 0 {
+		return 0
+	}
+	return int(remaining)
+}
+
+// fecSender accumulates parity for the group of chunks being sent
+type fecSender struct {
+	params FECParams
+	enc    *fec.Encoder
+	parity [][]byte
+	resent int // Chunks resent because parity could not cover them
+}
+
+func newFECSender(params FECParams) (*fecSender, error) {
+	enc, err := fec.New(params.DataChunks, params.ParityChunks)
+	if err != nil {
+		return nil, err
+	}
+	return &fecSender{params: params, enc: enc}, nil
+}
+
+// add folds chunk index into the group's parity
+func (g *fecSender) add(index int, data []byte) error {
+	pos := index % g.params.DataChunks
+	if pos == 0 {
+		// The first chunk of a group is also its largest
+		g.parity = make([][]byte, g.params.ParityChunks)
+		for i := range g.parity {
+			g.parity[i] = make([]byte, len(data))
+		}
+	}
+	return g.enc.AddShard(pos, data, g.parity)
+}
+
+// groupEnd reports whether index is the last chunk of its group
+func (g *fecSender) groupEnd(index, totalChunks int) bool {
+	return index%g.params.DataChunks == g.params.DataChunks-1 || index == totalChunks-1
+}
+
+// finishFECGroup sends the parity for the group ending at index once its
+// chunks are settled. Chunks lost within the group are only resent when
+// parity cannot cover them; any other failure is returned.
+func (c *SecureClient) finishFECGroup(ctx context.Context, pipeline *chunkPipeline, file *os.File, fileSize int64, g *fecSender, base ChunkMetadata, index int, sessionKey []byte) error {
+	failed, lost, err := pipeline.wait()
+	if err != nil && !isChunkLoss(err) {
+		return err
+	}
+
+	// Once every chunk of the last group is acknowledged the receiver has
+	// finished and parity has nothing left to repair
+	if lost == 0 && index == base.Total-1 {
+		g.parity = nil
+		return nil
+	}
+
+	group := index / g.params.DataChunks
+	for i, shard := range g.parity {
+		metadata := base
+		metadata.Index = i
+		metadata.Parity = true
+		metadata.Group = group
+		encrypted, err := sealChunk(&metadata, shard, sessionKey)
+		if err != nil {
+			return err
+		}
+		pipeline.send(ctx, metadata, encrypted)
+	}
+	_, lostParity, err := pipeline.wait()
+	if err != nil && !isChunkLoss(err) {
+		return err
+	}
+	lost += lostParity
+
+	if lost > g.params.ParityChunks {
+		log.Printf("Group %d lost %d chunks, resending %d", group, lost, len(failed))
+		buffer := make([]byte, ChunkSize)
+		for _, chunkIndex := range failed {
+			data := buffer[:chunkLength(fileSize, chunkIndex)]
+			if _, err := file.ReadAt(data, int64(chunkIndex)*ChunkSize); err != nil {
+				return fmt.Errorf("failed to read chunk %d: %w", chunkIndex, err)
+			}
+			metadata := base
+			metadata.Index = chunkIndex
+			encrypted, err := sealChunk(&metadata, data, sessionKey)
+			if err != nil {
+				return err
+			}
+			if err := pipeline.transport.SendChunk(ctx, metadata, encrypted); err != nil {
+				return fmt.Errorf("failed to resend chunk %d: %w", chunkIndex, err)
+			}
+			g.resent++
+		}
+	} else if lost > 0 {
+		log.Printf("Group %d lost %d chunks, recoverable from parity", group, lost)
+	}
+
+	g.parity = nil
+	return nil
+}
+
+// addParity buffers a parity chunk until its group is complete
+func (s *SecureServer) addParity(session *TransferSession, metadata ChunkMetadata, data []byte) error {
+	params := session.FEC
+	if params == nil {
+		return fmt.Errorf("FEC was not negotiated")
+	}
+	groups := (session.TotalChunks + params.DataChunks - 1) / params.DataChunks
+	if metadata.Group < 0 || metadata.Group >= groups || metadata.Index < 0 || metadata.Index >= params.ParityChunks {
+		return fmt.Errorf("invalid parity chunk")
+	}
+	if len(data) != chunkLength(session.Metadata.Size, metadata.Group*params.DataChunks) {
+		return fmt.Errorf("invalid parity size")
+	}
+
+	session.fecMu.Lock()
+	defer session.fecMu.Unlock()
+
+	if session.parity == nil {
+		session.parity = make(map[int]map[int][]byte)
+	}
+	if _, ok := session.parity[metadata.Group]; !ok {
+		if len(session.parity) >= maxParityGroups {
+			return fmt.Errorf("too many incomplete groups")
+		}
+		session.parity[metadata.Group] = make(map[int][]byte)
+	}
+	session.parity[metadata.Group][metadata.Index] = data
+	return nil
+}
+
+// recoverGroup rebuilds the missing chunks of a group once enough chunks
+// and parity have arrived. It returns the number of chunks rebuilt.
+func (s *SecureServer) recoverGroup(session *TransferSession, group int) (int, error) {
+	params := session.FEC
+	if params == nil {
+		return 0, nil
+	}
+
+	session.fecMu.Lock()
+	defer session.fecMu.Unlock()
+
+	first := group * params.DataChunks
+	count := params.DataChunks
+	if first+count > session.TotalChunks {
+		count = session.TotalChunks - first
+	}
+
+	s.mu.Lock()
+	var missing []int
+	for i := first; i < first+count; i++ {
+		if !session.ReceivedChunks[i] {
+			missing = append(missing, i)
+		}
+	}
+	s.mu.Unlock()
+
+	parity := session.parity[group]
+	if len(missing) == 0 {
+		delete(session.parity, group)
+		return 0, nil
+	}
+	if len(missing) > len(parity) {
+		return 0, nil
+	}
+
+	// Chunks past the end of the file count as zero-filled shards
+	size := chunkLength(session.Metadata.Size, first)
+	shards := make([][]byte, params.DataChunks+params.ParityChunks)
+	for i := 0; i < params.DataChunks; i++ {
+		shards[i] = make([]byte, size)
+	}
+	for _, index := range missing {
+		shards[index-first] = nil
+	}
+	err := session.useFile(func(file *os.File) error {
+		for i := 0; i < count; i++ {
+			if shards[i] == nil {
+				continue
+			}
+			length := chunkLength(session.Metadata.Size, first+i)
+			if _, err := file.ReadAt(shards[i][:length], int64(first+i)*ChunkSize); err != nil {
+				return fmt.Errorf("failed to read chunk %d: %w", first+i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for i, data := range parity {
+		shards[params.DataChunks+i] = data
+	}
+
+	enc, err := fec.New(params.DataChunks, params.ParityChunks)
+	if err != nil {
+		return 0, err
+	}
+	if err := enc.Reconstruct(shards); err != nil {
+		return 0, fmt.Errorf("failed to rebuild group %d: %w", group, err)
+	}
+
+	err = session.useFile(func(file *os.File) error {
+		for _, index := range missing {
+			data := shards[index-first][:chunkLength(session.Metadata.Size, index)]
+			if _, err := file.WriteAt(data, int64(index)*ChunkSize); err != nil {
+				return fmt.Errorf("failed to write chunk %d: %w", index, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	for _, index := range missing {
+		session.markReceived(index, sha256.Sum256(shards[index-first][:chunkLength(session.Metadata.Size, index)]))
+	}
+	s.mu.Unlock()
+
+	delete(session.parity, group)
+	return len(missing), nil
+}
@@ -0,0 +1,192 @@
+package airdrop
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Public key formats the device identity is exported in and peers' keys
+// are imported from
+const (
+	KeyFormatOpenSSH = "openssh" // ssh-ed25519 authorized_keys line
+	KeyFormatAge     = "age"     // age1... X25519 recipient
+	KeyFormatPEM     = "pem"     // PKIX "PUBLIC KEY" block
+)
+
+var ErrUnknownKeyFormat = errors.New("not an OpenSSH, age or PEM Ed25519 public key")
+
+// PeerKey is a device identity key read from one of the formats
+type PeerKey struct {
+	Format  string
+	Ed25519 ed25519.PublicKey // nil for an age recipient, which has no Ed25519 form
+	X25519  []byte
+	Comment string // OpenSSH comment, e.g. user@host
+}
+
+// KeyID identifies the key in every format
+func (k *PeerKey) KeyID() string {
+	return hex.EncodeToString(k.X25519)
+}
+
+// Fingerprint is the AirDrop fingerprint of the key, empty for an age
+// recipient
+func (k *PeerKey) Fingerprint() string {
+	if k.Ed25519 == nil {
+		return ""
+	}
+	return generateFingerprint(k.Ed25519)
+}
+
+// ExportPublicKey returns the identity's public key in format. comment
+// labels OpenSSH keys, e.g. with the device name. The age recipient is the
+// X25519 form of the key, as age converts ssh-ed25519 keys.
+func (id *DeviceIdentity) ExportPublicKey(format, comment string) (string, error) {
+	switch format {
+	case KeyFormatOpenSSH:
+		key, err := ssh.NewPublicKey(id.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode key: %w", err)
+		}
+		line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(key)), "\n")
+		if comment != "" {
+			line += " " + comment
+		}
+		return line + "\n", nil
+
+	case KeyFormatAge:
+		u, err := edwardsToMontgomery(id.PublicKey)
+		if err != nil {
+			return "", err
+		}
+		recipient, err := bech32Encode("age", u)
+		if err != nil {
+			return "", err
+		}
+		return recipient + "\n", nil
+
+	case KeyFormatPEM:
+		der, err := x509.MarshalPKIXPublicKey(id.PublicKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode key: %w", err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+	}
+	return "", fmt.Errorf("unknown key format %q", format)
+}
+
+// ParsePublicKey reads an Ed25519 device key in any of the formats,
+// detected from its text
+func ParsePublicKey(text string) (*PeerKey, error) {
+	text = strings.TrimSpace(text)
+	key := &PeerKey{}
+
+	switch {
+	case strings.HasPrefix(text, "ssh-"):
+		parsed, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OpenSSH key: %w", err)
+		}
+		cryptoKey, ok := parsed.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, ErrUnknownKeyFormat
+		}
+		pub, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("OpenSSH key is %s, not Ed25519", parsed.Type())
+		}
+		key.Format, key.Ed25519, key.Comment = KeyFormatOpenSSH, pub, comment
+
+	case strings.HasPrefix(strings.ToLower(text), "age1"):
+		hrp, u, err := bech32Decode(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse age recipient: %w", err)
+		}
+		if hrp != "age" || len(u) != 32 {
+			return nil, fmt.Errorf("not an age X25519 recipient")
+		}
+		key.Format, key.X25519 = KeyFormatAge, u
+		return key, nil
+
+	case strings.HasPrefix(text, "-----BEGIN"):
+		block, _ := pem.Decode([]byte(text))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("no PUBLIC KEY block found")
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse PEM key: %w", err)
+		}
+		pub, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("PEM key is %T, not Ed25519", parsed)
+		}
+		key.Format, key.Ed25519 = KeyFormatPEM, pub
+
+	default:
+		return nil, ErrUnknownKeyFormat
+	}
+
+	u, err := edwardsToMontgomery(key.Ed25519)
+	if err != nil {
+		return nil, err
+	}
+	key.X25519 = u
+	return key, nil
+}
+
+// sameKey reports whether an Ed25519 key is the one k describes
+func (k *PeerKey) sameKey(pub ed25519.PublicKey) bool {
+	if k.Ed25519 != nil {
+		return bytes.Equal(k.Ed25519, pub)
+	}
+	u, err := edwardsToMontgomery(pub)
+	return err == nil && bytes.Equal(u, k.X25519)
+}
+
+var curve25519P, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffed", 16)
+
+// edwardsToMontgomery maps an Ed25519 public key to its X25519 form,
+// u = (1 + y) / (1 - y). A key and its negation share the form, but only
+// the holder of the private key can sign for either.
+func edwardsToMontgomery(pub ed25519.PublicKey) ([]byte, error) {
+	if len(pub) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 key length %d", len(pub))
+	}
+	y := new(big.Int).SetBytes(reversed(pub))
+	y.SetBit(y, 255, 0) // The sign of x
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, fmt.Errorf("invalid Ed25519 key")
+	}
+
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, curve25519P)
+	if den.Sign() == 0 {
+		return nil, fmt.Errorf("invalid Ed25519 key")
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, curve25519P))
+	u.Mod(u, curve25519P)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reversed(out), nil
+}
+
+// reversed converts between little-endian keys and big-endian numbers
+func reversed(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, v := range b {
+		out[len(b)-1-i] = v
+	}
+	return out
+}
@@ -0,0 +1,210 @@
+package airdrop
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/netsim"
+)
+
+// harness runs a receiver behind a TCP proxy for HTTP and a UDP proxy for
+// QUIC chunks
+type harness struct {
+	server *SecureServer
+	dir    string
+	tcp    *netsim.Proxy
+	udp    *netsim.Proxy
+}
+
+func newHarness(t *testing.T, cond netsim.Conditions) *harness {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	h := &harness{dir: filepath.Join(home, "downloads")}
+	port := startServer(t, h)
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	var err error
+	if h.tcp, err = netsim.NewTCP(target, cond); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.tcp.Close() })
+	if h.udp, err = netsim.NewUDP(target, cond); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.udp.Close() })
+
+	// Point senders at the UDP proxy instead of the real QUIC port
+	h.server.mu.Lock()
+	h.server.quicPort = h.udp.Port()
+	h.server.mu.Unlock()
+	return h
+}
+
+// startServer starts a receiver with QUIC on a free port and waits for it
+func startServer(t *testing.T, h *harness) int {
+	t.Helper()
+	for attempt := 0; attempt < 5; attempt++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		server, err := NewSecureServer(port, h.dir, "receiver")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.SetQUIC(true)
+		failed := make(chan error, 1)
+		go func() { failed <- server.Start() }()
+
+	wait:
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			select {
+			case <-failed:
+				break wait // Port taken meanwhile, try another
+			default:
+			}
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ping", port))
+			if err == nil {
+				resp.Body.Close()
+				server.mu.Lock()
+				quic := server.quicPort != 0
+				server.mu.Unlock()
+				if !quic {
+					t.Fatal("QUIC transport did not start")
+				}
+				h.server = server
+				t.Cleanup(func() { server.Stop() })
+				return port
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		server.Stop()
+	}
+	t.Fatal("receiver did not start")
+	return 0
+}
+
+// send transfers data as name through the proxies
+func (h *harness) send(t *testing.T, name string, data []byte, transport string, fec bool) error {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewSecureClient("sender")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetTransport(transport); err != nil {
+		t.Fatal(err)
+	}
+	if fec {
+		if err := client.SetFEC(2, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return client.SendFile("127.0.0.1", h.tcp.Port(), src, nil)
+}
+
+// check fails unless name arrived intact
+func (h *harness) check(t *testing.T, name string, want []byte) {
+	t.Helper()
+	got, err := os.ReadFile(filepath.Join(h.dir, name))
+	if err != nil {
+		t.Fatalf("%s not received: %v", name, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%s differs from what was sent", name)
+	}
+}
+
+func randomData(t *testing.T, size int) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestTransferWithLatency(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{Latency: 5 * time.Millisecond})
+	data := randomData(t, 2*ChunkSize+12345)
+
+	for _, transport := range []string{TransportHTTP, TransportQUIC} {
+		name := transport + ".bin"
+		if err := h.send(t, name, data, transport, false); err != nil {
+			t.Fatalf("%s: %v", transport, err)
+		}
+		h.check(t, name, data)
+	}
+	if h.tcp.Stats().Packets == 0 || h.udp.Stats().Packets == 0 {
+		t.Fatal("traffic bypassed the proxies")
+	}
+}
+
+func TestQUICTransferWithLossAndReordering(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{
+		Latency: 2 * time.Millisecond,
+		Loss:    0.01,
+		Reorder: 0.02,
+		Seed:    1,
+	})
+	data := randomData(t, 2*ChunkSize+1)
+
+	if err := h.send(t, "lossy.bin", data, TransportQUIC, true); err != nil {
+		t.Fatal(err)
+	}
+	h.check(t, "lossy.bin", data)
+
+	stats := h.udp.Stats()
+	if stats.Dropped == 0 || stats.Reordered == 0 {
+		t.Fatalf("no loss or reordering was injected: %+v", stats)
+	}
+}
+
+func TestDisconnectFailsTransferWithoutFEC(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{DisconnectAfter: ChunkSize + ChunkSize/2, MaxDisconnects: 1})
+	data := randomData(t, 4*ChunkSize)
+
+	done := make(chan error, 1)
+	go func() { done <- h.send(t, "cut.bin", data, TransportHTTP, false) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("transfer succeeded across a lost chunk")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("transfer hung after the connection was cut")
+	}
+	if got := h.tcp.Stats().Disconnects; got != 1 {
+		t.Fatalf("expected 1 disconnect, got %d", got)
+	}
+}
+
+func TestDisconnectRecoveredByFEC(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{DisconnectAfter: ChunkSize + ChunkSize/2, MaxDisconnects: 1})
+	data := randomData(t, 4*ChunkSize)
+
+	if err := h.send(t, "fec.bin", data, TransportHTTP, true); err != nil {
+		t.Fatal(err)
+	}
+	h.check(t, "fec.bin", data)
+	if got := h.tcp.Stats().Disconnects; got != 1 {
+		t.Fatalf("expected 1 disconnect, got %d", got)
+	}
+}
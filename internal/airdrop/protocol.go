@@ -1,35 +1,86 @@
 package airdrop
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 )
 
-// HandshakeRequest is sent by sender to initiate transfer
+// ChunkSize is the plaintext size of every chunk but the last
+const ChunkSize = 4 * 1024 * 1024 // 4MB
+
+// maxEncryptedChunkSize bounds a chunk body: nonce, data and GCM tag
+const maxEncryptedChunkSize = ChunkSize + 12 + 16
+
+// HandshakeRequest is sent by sender to initiate key agreement.
+// File metadata is never part of the handshake; it follows in the
+// encrypted offer.
 type HandshakeRequest struct {
-	DeviceName        string       `json:"device_name"`
-	DeviceFingerprint string       `json:"device_fingerprint"`
-	EphemeralPubKey   []byte       `json:"ephemeral_pubkey"`
-	FileMetadata      FileMetadata `json:"file_metadata"`
-	Signature         []byte       `json:"signature"`
+	DeviceName        string     `json:"device_name"`
+	DeviceFingerprint string     `json:"device_fingerprint"`
+	DevicePublicKey   []byte     `json:"device_pubkey,omitempty"` // Lets the receiver check the signature
+	EphemeralPubKey   []byte     `json:"ephemeral_pubkey"`
+	FEC               *FECParams `json:"fec,omitempty"` // Offered error correction
+	Signature         []byte     `json:"signature"`
 }
 
-// HandshakeResponse is sent by receiver
+// HandshakeResponse is sent by receiver once key agreement is done
 type HandshakeResponse struct {
-	Accepted        bool   `json:"accepted"`
-	EphemeralPubKey []byte `json:"ephemeral_pubkey,omitempty"`
-	SessionID       string `json:"session_id,omitempty"`
-	Message         string `json:"message,omitempty"`
+	Accepted        bool       `json:"accepted"`
+	EphemeralPubKey []byte     `json:"ephemeral_pubkey,omitempty"`
+	SessionID       string     `json:"session_id,omitempty"`
+	FEC             *FECParams `json:"fec,omitempty"`           // Agreed error correction, nil if declined
+	QUICPort        int        `json:"quic_port,omitempty"`     // UDP port for QUIC chunks, 0 if unavailable
+	StreamChunks    bool       `json:"stream_chunks,omitempty"` // Receiver accepts chunks sealed by EncryptChunkStream
+	Message         string     `json:"message,omitempty"`
+}
+
+// FECParams describes Reed-Solomon error correction over groups of chunks:
+// each group of DataChunks chunks is followed by ParityChunks parity chunks,
+// and any DataChunks of them rebuild the group
+type FECParams struct {
+	DataChunks   int `json:"data_chunks"`
+	ParityChunks int `json:"parity_chunks"`
+}
+
+// Limits on accepted FEC parameters; the receiver buffers up to
+// MaxParityChunks parity chunks per group
+const (
+	MaxFECDataChunks   = 32
+	MaxFECParityChunks = 8
+)
+
+// Valid reports whether the parameters are within the accepted limits
+func (p *FECParams) Valid() bool {
+	return p != nil &&
+		p.DataChunks > 0 && p.DataChunks <= MaxFECDataChunks &&
+		p.ParityChunks > 0 && p.ParityChunks <= MaxFECParityChunks
+}
+
+// OfferRequest carries the file metadata encrypted under the session key
+type OfferRequest struct {
+	SessionID         string `json:"session_id"`
+	EncryptedMetadata []byte `json:"encrypted_metadata"`
 }
 
-// ChunkMetadata represents a file chunk
+// OfferResponse tells the sender whether the receiver accepted the file
+type OfferResponse struct {
+	Accepted bool   `json:"accepted"`
+	Message  string `json:"message,omitempty"`
+}
+
+// ChunkMetadata represents a file chunk. Parity chunks carry the FEC group
+// in Group and their position within the group's parity in Index.
 type ChunkMetadata struct {
 	Index     int    `json:"index"`
 	Total     int    `json:"total"`
 	Size      int    `json:"size"`
 	Checksum  string `json:"checksum"`
 	SessionID string `json:"session_id"`
+	Parity    bool   `json:"parity,omitempty"`
+	Group     int    `json:"group,omitempty"`
+	Stream    bool   `json:"stream,omitempty"` // Sealed by EncryptChunkStream
 }
 
 // ChunkAck acknowledges chunk receipt
@@ -55,13 +106,15 @@ func CalculateChunkChecksum(data []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
-// CreateHandshakeRequest creates a signed handshake request
-func CreateHandshakeRequest(identity *DeviceIdentity, deviceName string, ephemeralPubKey []byte, metadata FileMetadata) (*HandshakeRequest, error) {
+// CreateHandshakeRequest creates a signed handshake request. fec may be nil
+// to send without error correction.
+func CreateHandshakeRequest(identity *DeviceIdentity, deviceName string, ephemeralPubKey []byte, fec *FECParams) (*HandshakeRequest, error) {
 	req := &HandshakeRequest{
 		DeviceName:        deviceName,
 		DeviceFingerprint: identity.Fingerprint,
+		DevicePublicKey:   identity.PublicKey,
 		EphemeralPubKey:   ephemeralPubKey,
-		FileMetadata:      metadata,
+		FEC:               fec,
 	}
 
 	// Sign the request
@@ -71,8 +124,31 @@ func CreateHandshakeRequest(identity *DeviceIdentity, deviceName string, ephemer
 	return req, nil
 }
 
+// EncryptMetadata encrypts file metadata for the offer
+func EncryptMetadata(metadata FileMetadata, sessionKey []byte) ([]byte, error) {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptChunk(data, sessionKey)
+}
+
+// DecryptMetadata decrypts file metadata from an offer
+func DecryptMetadata(encrypted, sessionKey []byte) (FileMetadata, error) {
+	var metadata FileMetadata
+	data, err := DecryptChunk(encrypted, sessionKey)
+	if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(data, &metadata)
+	return metadata, err
+}
+
 // VerifyHandshakeRequest verifies the handshake signature
 func VerifyHandshakeRequest(req *HandshakeRequest, pubKey []byte) bool {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return false
+	}
 	signature := req.Signature
 	req.Signature = nil
 	data, _ := json.Marshal(req)
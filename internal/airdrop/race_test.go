@@ -0,0 +1,162 @@
+package airdrop
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/netsim"
+)
+
+// These tests are meant for go test -race
+
+func TestDiscoveryScanWhileReading(t *testing.T) {
+	d := NewDiscovery("race-test", 0)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, device := range d.GetDevices() {
+					_ = device.Name
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := d.ScanDevices(context.Background(), 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestStatusDuringTransfer(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{})
+	data := randomData(t, 4*ChunkSize+1)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.server.mu.Lock()
+			ids := make([]string, 0, len(h.server.sessions))
+			for id := range h.server.sessions {
+				ids = append(ids, id)
+			}
+			h.server.mu.Unlock()
+			for _, id := range ids {
+				r := httptest.NewRequest(http.MethodGet, "/status?session_id="+id, nil)
+				h.server.handleStatus(httptest.NewRecorder(), r)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	err := h.send(t, "status.bin", data, TransportQUIC, true)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.check(t, "status.bin", data)
+}
+
+func TestReaperDropsIdleSessions(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{})
+	path := filepath.Join(h.dir, "partial.bin")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	idle := &TransferSession{
+		SessionID:      "idle",
+		ReceivedChunks: make(map[int]bool),
+		FilePath:       path,
+		File:           file,
+		Accepted:       true,
+		lastActive:     now.Add(-2 * DefaultSessionTimeout),
+	}
+	busy := &TransferSession{
+		SessionID:      "busy",
+		ReceivedChunks: make(map[int]bool),
+		busy:           1,
+		lastActive:     now.Add(-2 * DefaultSessionTimeout),
+	}
+	h.server.mu.Lock()
+	h.server.sessions[idle.SessionID] = idle
+	h.server.sessions[busy.SessionID] = busy
+	h.server.mu.Unlock()
+
+	if got := h.server.reapIdle(now); got != 1 {
+		t.Fatalf("expected 1 session dropped, got %d", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("partial file of the idle session was kept")
+	}
+	if err := idle.useFile(func(*os.File) error { return nil }); err != errSessionClosed {
+		t.Fatal("idle session was not closed")
+	}
+	h.server.mu.Lock()
+	_, kept := h.server.sessions[busy.SessionID]
+	h.server.mu.Unlock()
+	if !kept {
+		t.Fatal("busy session was dropped")
+	}
+}
+
+func TestReaperDuringTransfer(t *testing.T) {
+	h := newHarness(t, netsim.Conditions{})
+	data := randomData(t, 4*ChunkSize)
+
+	// Every session is idle as soon as no chunk is in flight
+	h.server.SetSessionTimeout(time.Nanosecond)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				h.server.reapIdle(time.Now())
+			}
+		}
+	}()
+
+	err := h.send(t, "reaped.bin", data, TransportQUIC, false)
+	close(stop)
+	wg.Wait()
+
+	if err == nil {
+		h.check(t, "reaped.bin", data)
+	} else if _, statErr := os.Stat(filepath.Join(h.dir, "reaped.bin")); !os.IsNotExist(statErr) {
+		t.Fatalf("transfer failed (%v) but its partial file was kept", err)
+	}
+}
@@ -2,6 +2,7 @@ package airdrop
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,13 +10,22 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/progress"
+	"github.com/owner/secure-file-manager/internal/status"
+	"github.com/owner/secure-file-manager/internal/telemetry"
 )
 
 type SecureClient struct {
 	httpClient *http.Client
 	identity   *DeviceIdentity
 	deviceName string
+	fec        *FECParams
+	transport  string
 }
 
 func NewSecureClient(deviceName string) (*SecureClient, error) {
@@ -28,7 +38,7 @@ func NewSecureClient(deviceName string) (*SecureClient, error) {
 		return nil, fmt.Errorf("failed to load identity: %w", err)
 	}
 
-	log.Printf("Client fingerprint: %s", identity.Fingerprint)
+	log.Printf("Client fingerprint: %s", logging.Fingerprint(identity.Fingerprint))
 
 	return &SecureClient{
 		httpClient: &http.Client{
@@ -39,7 +49,40 @@ func NewSecureClient(deviceName string) (*SecureClient, error) {
 	}, nil
 }
 
-func (c *SecureClient) SendFile(targetIP string, targetPort int, filePath string, onProgress func(sent, total int64)) error {
+// SetFEC offers Reed-Solomon error correction with parityChunks parity
+// chunks per dataChunks chunks, so a lossy link loses fewer chunks to
+// retransmission. Zero parity disables it.
+func (c *SecureClient) SetFEC(dataChunks, parityChunks int) error {
+	if parityChunks == 0 {
+		c.fec = nil
+		return nil
+	}
+	params := &FECParams{DataChunks: dataChunks, ParityChunks: parityChunks}
+	if !params.Valid() {
+		return fmt.Errorf("invalid FEC parameters: %d data, %d parity chunks", dataChunks, parityChunks)
+	}
+	c.fec = params
+	return nil
+}
+
+// SetTransport selects the chunk transport: TransportHTTP, TransportQUIC,
+// or TransportAuto (the default) to measure round trips and switch to QUIC
+// when head-of-line blocking on TCP is hurting throughput
+func (c *SecureClient) SetTransport(mode string) error {
+	switch mode {
+	case TransportAuto, TransportHTTP, TransportQUIC:
+		c.transport = mode
+		return nil
+	default:
+		return fmt.Errorf("unknown transport: %s", mode)
+	}
+}
+
+func (c *SecureClient) SendFile(targetIP string, targetPort int, filePath string, onProgress progress.Handler) (err error) {
+	ctx, span := telemetry.StartSpan(context.Background(), "airdrop.send",
+		telemetry.AttrPeer.String(fmt.Sprintf("%s:%d", targetIP, targetPort)))
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	// Open file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -51,6 +94,7 @@ func (c *SecureClient) SendFile(targetIP string, targetPort int, filePath string
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
+	span.SetAttributes(telemetry.AttrBytes.Int64(fileInfo.Size()))
 
 	// Generate ephemeral key for ECDH
 	privKey, pubKey, err := GenerateEphemeralKey()
@@ -58,32 +102,16 @@ func (c *SecureClient) SendFile(targetIP string, targetPort int, filePath string
 		return fmt.Errorf("failed to generate ephemeral key: %w", err)
 	}
 
-	// Create file metadata
-	metadata := FileMetadata{
-		Name: filepath.Base(filePath),
-		Size: fileInfo.Size(),
-		Mime: "application/octet-stream",
-	}
-
 	// Create handshake request
-	handshakeReq, err := CreateHandshakeRequest(c.identity, c.deviceName, pubKey, metadata)
+	handshakeReq, err := CreateHandshakeRequest(c.identity, c.deviceName, pubKey, c.fec)
 	if err != nil {
 		return fmt.Errorf("failed to create handshake: %w", err)
 	}
 
 	// Send handshake
-	handshakeURL := fmt.Sprintf("http://%s:%d/handshake", targetIP, targetPort)
-	handshakeBody, _ := json.Marshal(handshakeReq)
-
-	resp, err := c.httpClient.Post(handshakeURL, "application/json", bytes.NewReader(handshakeBody))
+	handshakeResp, err := c.sendHandshake(ctx, targetIP, targetPort, handshakeReq)
 	if err != nil {
-		return fmt.Errorf("failed to send handshake: %w", err)
-	}
-	defer resp.Body.Close()
-
-	var handshakeResp HandshakeResponse
-	if err := json.NewDecoder(resp.Body).Decode(&handshakeResp); err != nil {
-		return fmt.Errorf("failed to decode handshake response: %w", err)
+		return err
 	}
 
 	if !handshakeResp.Accepted {
@@ -91,6 +119,7 @@ func (c *SecureClient) SendFile(targetIP string, targetPort int, filePath string
 	}
 
 	log.Printf("Handshake accepted. Session ID: %s", handshakeResp.SessionID)
+	span.SetAttributes(telemetry.AttrSessionID.String(handshakeResp.SessionID))
 
 	// Derive shared secret
 	sessionKey, err := DeriveSharedSecret(privKey, handshakeResp.EphemeralPubKey)
@@ -98,67 +127,260 @@ func (c *SecureClient) SendFile(targetIP string, targetPort int, filePath string
 		return fmt.Errorf("failed to derive session key: %w", err)
 	}
 
+	// Offer the file; its metadata only travels encrypted
+	metadata := FileMetadata{
+		Name: filepath.Base(filePath),
+		Size: fileInfo.Size(),
+		Mime: "application/octet-stream",
+	}
+
+	offerResp, err := c.sendOffer(ctx, targetIP, targetPort, handshakeResp.SessionID, metadata, sessionKey)
+	if err != nil {
+		return err
+	}
+
+	if !offerResp.Accepted {
+		return fmt.Errorf("transfer rejected: %s", offerResp.Message)
+	}
+
 	// Calculate total chunks
-	chunkSize := int64(4 * 1024 * 1024) // 4MB
+	chunkSize := int64(ChunkSize)
 	totalChunks := int(fileInfo.Size() / chunkSize)
 	if fileInfo.Size()%chunkSize != 0 {
 		totalChunks++
 	}
 
 	log.Printf("Sending %d chunks...", totalChunks)
+	span.SetAttributes(telemetry.AttrChunks.Int(totalChunks))
+
+	transport, window, err := c.chunkTransport(ctx, targetIP, targetPort, handshakeResp.QUICPort)
+	if err != nil {
+		return err
+	}
+	defer transport.Close()
+	pipeline := newChunkPipeline(transport, window)
+	flow := bandwidth.Open(bandwidth.ClassAirDrop)
+	defer flow.Close()
+	meter := status.StartMeter(handshakeResp.SessionID, "airdrop", "send", metadata.Name)
+	defer meter.Stop()
+
+	// The receiver may decline error correction
+	var group *fecSender
+	if handshakeResp.FEC != nil {
+		if group, err = newFECSender(*handshakeResp.FEC); err != nil {
+			return fmt.Errorf("invalid FEC parameters: %w", err)
+		}
+		defer func() { span.SetAttributes(telemetry.AttrRetries.Int(group.resent)) }()
+		log.Printf("Using FEC: %d parity per %d chunks", handshakeResp.FEC.ParityChunks, handshakeResp.FEC.DataChunks)
+	}
+
+	base := ChunkMetadata{
+		Total:     totalChunks,
+		SessionID: handshakeResp.SessionID,
+		Stream:    handshakeResp.StreamChunks,
+	}
 
 	// Send chunks
 	buffer := make([]byte, chunkSize)
+	sent := int64(0)
 	for chunkIndex := 0; chunkIndex < totalChunks; chunkIndex++ {
+		// Without FEC any lost chunk fails the transfer, with FEC only a
+		// failure other than a loss does
+		if err := pipeline.firstError(); err != nil && (group == nil || !isChunkLoss(err)) {
+			pipeline.wait()
+			return err
+		}
+
 		// Read chunk
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
+		n, err := io.ReadFull(file, buffer)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			pipeline.wait()
 			return fmt.Errorf("failed to read chunk %d: %w", chunkIndex, err)
 		}
 
 		chunkData := buffer[:n]
 
-		// Calculate checksum
-		checksum := CalculateChunkChecksum(chunkData)
-
-		// Encrypt chunk
-		encryptedChunk, err := EncryptChunk(chunkData, sessionKey)
+		// Send chunk
+		chunkMetadata := base
+		chunkMetadata.Index = chunkIndex
+		encryptedChunk, err := sealChunk(&chunkMetadata, chunkData, sessionKey)
 		if err != nil {
-			return fmt.Errorf("failed to encrypt chunk %d: %w", chunkIndex, err)
+			pipeline.wait()
+			return err
 		}
-
-		// Create chunk metadata
-		chunkMetadata := ChunkMetadata{
-			Index:     chunkIndex,
-			Total:     totalChunks,
-			Size:      n,
-			Checksum:  checksum,
-			SessionID: handshakeResp.SessionID,
+		pipeline.send(ctx, chunkMetadata, encryptedChunk)
+
+		if group != nil {
+			if err := group.add(chunkIndex, chunkData); err != nil {
+				pipeline.wait()
+				return fmt.Errorf("failed to compute parity: %w", err)
+			}
+			if group.groupEnd(chunkIndex, totalChunks) {
+				if err := c.finishFECGroup(ctx, pipeline, file, fileInfo.Size(), group, base, chunkIndex, sessionKey); err != nil {
+					return err
+				}
+			}
 		}
 
-		// Send chunk
-		if err := c.sendChunk(targetIP, targetPort, chunkMetadata, encryptedChunk); err != nil {
-			return fmt.Errorf("failed to send chunk %d: %w", chunkIndex, err)
+		if err := flow.Wait(ctx, n); err != nil {
+			pipeline.wait()
+			return err
 		}
 
 		// Update progress
+		meter.Add(int64(n))
+		sent += int64(n)
 		if onProgress != nil {
-			onProgress(int64(chunkIndex+1), int64(totalChunks))
+			onProgress(progress.Event{
+				Kind:      progress.KindFile,
+				SessionID: handshakeResp.SessionID,
+				File:      metadata.Name,
+				Bytes:     sent,
+				Total:     metadata.Size,
+			})
 		}
 	}
 
+	if _, _, err := pipeline.wait(); err != nil && (group == nil || !isChunkLoss(err)) {
+		return err
+	}
+
 	log.Printf("✓ All chunks sent successfully")
+	span.AddEvent("complete")
 	return nil
 }
 
-func (c *SecureClient) sendChunk(targetIP string, targetPort int, metadata ChunkMetadata, encryptedData []byte) error {
+func (c *SecureClient) sendHandshake(ctx context.Context, targetIP string, targetPort int, handshakeReq *HandshakeRequest) (resp *HandshakeResponse, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "airdrop.handshake")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	handshakeURL := fmt.Sprintf("http://%s:%d/handshake", targetIP, targetPort)
+	handshakeBody, _ := json.Marshal(handshakeReq)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, handshakeURL, bytes.NewReader(handshakeBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	telemetry.InjectHTTP(ctx, req)
+
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send handshake: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	var handshakeResp HandshakeResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&handshakeResp); err != nil {
+		return nil, fmt.Errorf("failed to decode handshake response: %w", err)
+	}
+
+	return &handshakeResp, nil
+}
+
+func (c *SecureClient) sendOffer(ctx context.Context, targetIP string, targetPort int, sessionID string, metadata FileMetadata, sessionKey []byte) (resp *OfferResponse, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "airdrop.offer")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	encryptedMetadata, err := EncryptMetadata(metadata, sessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+
+	offerURL := fmt.Sprintf("http://%s:%d/offer", targetIP, targetPort)
+	offerBody, _ := json.Marshal(OfferRequest{
+		SessionID:         sessionID,
+		EncryptedMetadata: encryptedMetadata,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, offerURL, bytes.NewReader(offerBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	telemetry.InjectHTTP(ctx, req)
+
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send offer: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	var offerResp OfferResponse
+	if err := json.NewDecoder(httpResp.Body).Decode(&offerResp); err != nil {
+		return nil, fmt.Errorf("failed to decode offer response: %w", err)
+	}
+
+	return &offerResp, nil
+}
+
+// sealChunk fills in the size and checksum of a chunk and encrypts it
+func sealChunk(metadata *ChunkMetadata, data, sessionKey []byte) ([]byte, error) {
+	metadata.Size = len(data)
+	metadata.Checksum = CalculateChunkChecksum(data)
+
+	encrypt := EncryptChunk
+	if metadata.Stream {
+		encrypt = EncryptChunkStream
+	}
+	encryptedChunk, err := encrypt(data, sessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt chunk %d: %w", metadata.Index, err)
+	}
+	return encryptedChunk, nil
+}
+
+// chunkTransport picks how chunks travel and how many may be in flight.
+// Auto mode probes the round trip and uses QUIC only when TCP is stalling.
+func (c *SecureClient) chunkTransport(ctx context.Context, targetIP string, targetPort, quicPort int) (chunkSender, int, error) {
+	mode := c.transport
+	if mode == "" {
+		mode = TransportAuto
+	}
+
+	useQUIC := false
+	switch mode {
+	case TransportQUIC:
+		if quicPort == 0 {
+			return nil, 0, fmt.Errorf("receiver does not offer QUIC")
+		}
+		useQUIC = true
+	case TransportAuto:
+		if quicPort != 0 {
+			samples, failed := c.measureRTT(ctx, targetIP, targetPort, rttProbes)
+			useQUIC = preferQUIC(samples, failed)
+			log.Printf("RTT probes: %v (%d lost)", samples, failed)
+		}
+	}
+
+	if useQUIC {
+		sender, err := dialQUIC(ctx, targetIP, quicPort)
+		if err == nil {
+			log.Printf("Using QUIC chunk transport")
+			return sender, quicWindow, nil
+		}
+		if mode == TransportQUIC {
+			return nil, 0, err
+		}
+		log.Printf("Falling back to HTTP: %v", err)
+	}
+	return &httpSender{client: c, targetIP: targetIP, targetPort: targetPort}, 1, nil
+}
+
+func (c *SecureClient) sendChunk(ctx context.Context, targetIP string, targetPort int, metadata ChunkMetadata, encryptedData []byte) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "airdrop.chunk",
+		telemetry.AttrChunk.Int(metadata.Index),
+		telemetry.AttrBytes.Int(len(encryptedData)))
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	chunkURL := fmt.Sprintf("http://%s:%d/chunk", targetIP, targetPort)
 
 	// Create request
-	req, err := http.NewRequest(http.MethodPost, chunkURL, bytes.NewReader(encryptedData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chunkURL, bytes.NewReader(encryptedData))
 	if err != nil {
 		return err
 	}
+	telemetry.InjectHTTP(ctx, req)
 
 	// Add metadata to header
 	metadataJSON, _ := json.Marshal(metadata)
@@ -172,6 +394,13 @@ func (c *SecureClient) sendChunk(targetIP string, targetPort int, metadata Chunk
 	}
 	defer resp.Body.Close()
 
+	// A 4xx answers the request itself; a 5xx means the chunk did not
+	// arrive whole
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: %s", errChunkRejected, strings.TrimSpace(string(message)))
+	}
+
 	// Read ACK
 	var ack ChunkAck
 	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
@@ -179,7 +408,7 @@ func (c *SecureClient) sendChunk(targetIP string, targetPort int, metadata Chunk
 	}
 
 	if !ack.Success {
-		return fmt.Errorf("chunk rejected: %s", ack.Error)
+		return fmt.Errorf("%w: %s", errChunkRejected, ack.Error)
 	}
 
 	return nil
@@ -1,28 +1,65 @@
 package airdrop
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/onreceive"
+	"github.com/owner/secure-file-manager/internal/progress"
+	"github.com/owner/secure-file-manager/internal/prompt"
+	"github.com/owner/secure-file-manager/internal/settings"
+	"github.com/owner/secure-file-manager/internal/status"
+	"github.com/owner/secure-file-manager/internal/telemetry"
 )
 
 type SecureServer struct {
 	port        int
 	downloadDir string
+	roots       []string // Where accepted files may be saved besides downloadDir
+	webOrigins  []string // Origins of web senders allowed to call the server
 	identity    *DeviceIdentity
 	deviceName  string
-	onRequest   func(req HandshakeRequest) bool
-	onProgress  func(filename string, received, total int64)
+	prompts     *prompt.Queue
+	trust       *TrustStore
+	allowed     map[string]bool // Verified fingerprints of devices accepted this run
+	onProgress  progress.Handler
 	server      *http.Server
+	quic        bool
+	quicPort    int
+	quicClose   func() error
 	sessions    map[string]*TransferSession
-	mu          sync.Mutex
+	// Sessions idle this long are dropped by the reaper, which runs from
+	// Start until Stop closes stopReaper
+	sessionTimeout time.Duration
+	stopReaper     chan struct{}
+	persist        bool       // Sessions are kept in the database
+	verifySample   int        // Chunks checked when a session is reloaded, 0 for all
+	flushMu        sync.Mutex // Serializes flushSessions
+	mu             sync.Mutex
+
+	// Mirror the airdrop.do_not_disturb and airdrop.auto_accept settings,
+	// see FollowSettings
+	doNotDisturb atomic.Bool
+	autoAccept   atomic.Bool
 }
 
 type TransferSession struct {
@@ -35,6 +72,35 @@ type TransferSession struct {
 	ReceivedChunks map[int]bool
 	FilePath       string
 	File           *os.File
+	Accepted       bool
+	FEC            *FECParams
+	reservation    *diskspace.Reservation
+	request        HandshakeRequest
+	parity         map[int]map[int][]byte // FEC group -> parity index -> data
+	fecMu          sync.Mutex
+
+	// Guarded by the server's mu
+	offered    bool
+	busy       int // Chunks being handled
+	lastActive time.Time
+	dirty      bool                      // Chunks received since the last flush
+	sums       map[int][sha256.Size]byte // Checksums of received chunks
+	startedAt  time.Time
+	// Rates are measured from when the session started or was reloaded
+	rateSince time.Time
+	rateBase  int64 // Bytes received before rateSince
+	web       bool  // Started by a browser, see web.go
+
+	// The session's share of the bandwidth limit; an idle flow drops out
+	// of the split on its own, so it is never closed
+	flow     *bandwidth.Flow
+	flowOnce sync.Once
+	meter    *status.Meter // Set once the offer is accepted
+
+	// File is written under a shared lock and closed under the exclusive
+	// one, so it is never closed mid-write
+	fileMu sync.RWMutex
+	closed bool
 }
 
 func NewSecureServer(port int, downloadDir, deviceName string) (*SecureServer, error) {
@@ -47,7 +113,7 @@ func NewSecureServer(port int, downloadDir, deviceName string) (*SecureServer, e
 		return nil, fmt.Errorf("failed to load identity: %w", err)
 	}
 
-	log.Printf("Device fingerprint: %s", identity.Fingerprint)
+	log.Printf("Device fingerprint: %s", logging.Fingerprint(identity.Fingerprint))
 
 	return &SecureServer{
 		port:        port,
@@ -55,34 +121,120 @@ func NewSecureServer(port int, downloadDir, deviceName string) (*SecureServer, e
 		identity:    identity,
 		deviceName:  deviceName,
 		sessions:    make(map[string]*TransferSession),
-		onRequest: func(req HandshakeRequest) bool {
-			return true // Auto-accept by default
-		},
+		allowed:     make(map[string]bool),
+
+		sessionTimeout: DefaultSessionTimeout,
 	}, nil
 }
 
-func (s *SecureServer) SetRequestHandler(handler func(req HandshakeRequest) bool) {
-	s.onRequest = handler
+// SetPrompts makes the server ask before talking to an unknown device and
+// before accepting each file. Without prompts everything is accepted.
+func (s *SecureServer) SetPrompts(prompts *prompt.Queue) {
+	s.prompts = prompts
+}
+
+// SetTrustStore lets devices with an imported key skip the device prompt
+func (s *SecureServer) SetTrustStore(trust *TrustStore) {
+	s.trust = trust
+}
+
+// SetDestinationRoots lets a file prompt's reply save files under these
+// directories too, not only under the download directory
+func (s *SecureServer) SetDestinationRoots(roots ...string) {
+	s.roots = roots
+}
+
+// FollowSettings applies the airdrop.do_not_disturb and airdrop.auto_accept
+// settings now and whenever they change. The database must be open.
+func (s *SecureServer) FollowSettings() {
+	s.doNotDisturb.Store(settings.GetBool(settings.DoNotDisturb))
+	s.autoAccept.Store(settings.GetBool(settings.AutoAccept))
+	settings.Subscribe(settings.DoNotDisturb, func(_ string, value interface{}) {
+		if on, ok := value.(bool); ok {
+			s.doNotDisturb.Store(on)
+		}
+	})
+	settings.Subscribe(settings.AutoAccept, func(_ string, value interface{}) {
+		if on, ok := value.(bool); ok {
+			s.autoAccept.Store(on)
+		}
+	})
 }
 
-func (s *SecureServer) SetProgressHandler(handler func(filename string, received, total int64)) {
+// ask queues a prompt about a handshake's device, accepting if no prompts
+// are set. Do not disturb declines without asking, and auto accept takes
+// files from devices already accepted without asking.
+func (s *SecureServer) ask(r *http.Request, kind string, req HandshakeRequest, details map[string]string) prompt.Reply {
+	if s.doNotDisturb.Load() {
+		log.Printf("Declining %s prompt for %s: do not disturb", kind, logging.Fingerprint(req.DeviceFingerprint))
+		return prompt.Reply{}
+	}
+	if s.prompts == nil || (kind == prompt.KindFile && s.autoAccept.Load()) {
+		return prompt.Reply{Accept: true}
+	}
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	requester := prompt.Requester{Name: req.DeviceName, Fingerprint: req.DeviceFingerprint, Address: host}
+	reply, err := s.prompts.Ask(r.Context(), kind, requester, details)
+	if err != nil {
+		log.Printf("No answer to %s prompt for %s: %v", kind, logging.Fingerprint(req.DeviceFingerprint), err)
+	}
+	return reply
+}
+
+// SetProgressHandler sets the handler progress events are delivered to,
+// one per chunk received
+func (s *SecureServer) SetProgressHandler(handler progress.Handler) {
 	s.onProgress = handler
 }
 
+// SetQUIC enables the QUIC chunk transport on the server's port (UDP).
+// Must be called before Start.
+func (s *SecureServer) SetQUIC(enabled bool) {
+	s.quic = enabled
+}
+
 func (s *SecureServer) Start() error {
 	if err := os.MkdirAll(s.downloadDir, 0755); err != nil {
 		return fmt.Errorf("failed to create download directory: %w", err)
 	}
 
+	s.restoreSessions()
+
+	stop := make(chan struct{})
+	s.mu.Lock()
+	s.stopReaper = stop
+	s.mu.Unlock()
+	go s.reapSessions(stop)
+	if s.persist {
+		go s.persistSessions(stop)
+	}
+
+	// QUIC is optional; senders fall back to HTTP when it is unavailable
+	if s.quic {
+		listener, err := s.listenQUIC()
+		if err != nil {
+			log.Printf("QUIC transport unavailable: %v", err)
+		} else {
+			s.mu.Lock()
+			s.quicPort = listener.Addr().(*net.UDPAddr).Port
+			s.quicClose = listener.Close
+			s.mu.Unlock()
+			go s.serveQUIC(listener)
+			log.Printf("QUIC chunk transport listening on UDP port %d", s.quicPort)
+		}
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/handshake", s.handleHandshake)
+	mux.HandleFunc("/offer", s.handleOffer)
 	mux.HandleFunc("/chunk", s.handleChunk)
 	mux.HandleFunc("/status", s.handleStatus)
 	mux.HandleFunc("/ping", s.handlePing)
+	mux.HandleFunc("/web/handshake", s.handleWebHandshake)
 
 	s.server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.port),
-		Handler: mux,
+		Handler: s.cors(mux),
 	}
 
 	log.Printf("Secure AirDrop server listening on port %d", s.port)
@@ -90,6 +242,19 @@ func (s *SecureServer) Start() error {
 }
 
 func (s *SecureServer) Stop() error {
+	s.mu.Lock()
+	if s.quicClose != nil {
+		s.quicClose()
+		s.quicClose = nil
+		s.quicPort = 0
+	}
+	if s.stopReaper != nil {
+		close(s.stopReaper)
+		s.stopReaper = nil
+	}
+	s.mu.Unlock()
+	s.flushSessions()
+	s.closeSessions()
 	if s.server != nil {
 		return s.server.Close()
 	}
@@ -110,27 +275,48 @@ func (s *SecureServer) handleHandshake(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, span := telemetry.StartSpan(telemetry.ExtractHTTP(r), "airdrop.receive.handshake")
+	defer span.End()
+
 	var req HandshakeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	span.SetAttributes(telemetry.AttrPeer.String(req.DeviceFingerprint))
+
+	log.Printf("Handshake from: %s (%s)", logging.DeviceName(req.DeviceName), logging.Fingerprint(req.DeviceFingerprint))
+
+	// A presented key must match the fingerprint and have signed the
+	// request; older senders present none and can only be prompted for
+	trusted := false
+	verified := len(req.DevicePublicKey) > 0
+	if verified {
+		if generateFingerprint(req.DevicePublicKey) != req.DeviceFingerprint || !VerifyHandshakeRequest(&req, req.DevicePublicKey) {
+			span.AddEvent("bad_signature")
+			http.Error(w, "Invalid device signature", http.StatusForbidden)
+			return
+		}
+		trusted = s.trust != nil && s.trust.Trusts(req.DevicePublicKey)
+	}
 
-	// Verify signature (simplified - in production would verify against stored public keys)
-	log.Printf("Handshake from: %s (%s)", req.DeviceName, req.DeviceFingerprint)
-	log.Printf("File: %s (%d bytes)", req.FileMetadata.Name, req.FileMetadata.Size)
-
-	// Ask user to accept/reject
-	accepted := s.onRequest(req)
-
-	if !accepted {
-		resp := HandshakeResponse{
-			Accepted: false,
-			Message:  "Transfer rejected by user",
+	// Unknown devices need the user's consent before any key agreement.
+	// Only a verified fingerprint is remembered, since anyone can claim one.
+	s.mu.Lock()
+	allowed := trusted || (verified && s.allowed[req.DeviceFingerprint])
+	s.mu.Unlock()
+	if !allowed {
+		if !s.ask(r, prompt.KindDevice, req, nil).Accept {
+			span.AddEvent("declined")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(HandshakeResponse{Accepted: false, Message: "Connection declined"})
+			return
+		}
+		if verified {
+			s.mu.Lock()
+			s.allowed[req.DeviceFingerprint] = true
+			s.mu.Unlock()
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
-		return
 	}
 
 	// Generate ephemeral key for ECDH
@@ -147,34 +333,28 @@ func (s *SecureServer) handleHandshake(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create session
+	// Create a pending session; the file is only known once the sender
+	// sends its encrypted offer
 	sessionID := uuid.New().String()
-	totalChunks := int(req.FileMetadata.Size / (4 * 1024 * 1024))
-	if req.FileMetadata.Size%(4*1024*1024) != 0 {
-		totalChunks++
-	}
-
+	now := time.Now()
 	session := &TransferSession{
 		SessionID:      sessionID,
 		SenderName:     req.DeviceName,
 		Fingerprint:    req.DeviceFingerprint,
-		Metadata:       req.FileMetadata,
 		SessionKey:     sessionKey,
-		TotalChunks:    totalChunks,
 		ReceivedChunks: make(map[int]bool),
-		FilePath:       filepath.Join(s.downloadDir, req.FileMetadata.Name),
+		request:        req,
+		lastActive:     now,
+		startedAt:      now,
+		rateSince:      now,
 	}
-
-	// Create output file
-	file, err := os.Create(session.FilePath)
-	if err != nil {
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
-		return
+	if req.FEC.Valid() {
+		session.FEC = req.FEC
 	}
-	session.File = file
 
 	s.mu.Lock()
 	s.sessions[sessionID] = session
+	quicPort := s.quicPort
 	s.mu.Unlock()
 
 	// Send response
@@ -182,117 +362,393 @@ func (s *SecureServer) handleHandshake(w http.ResponseWriter, r *http.Request) {
 		Accepted:        true,
 		EphemeralPubKey: pubKey,
 		SessionID:       sessionID,
-		Message:         "Transfer accepted",
+		FEC:             session.FEC,
+		QUICPort:        quicPort,
+		StreamChunks:    true,
+		Message:         "Key agreement complete",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 
+	span.SetAttributes(telemetry.AttrSessionID.String(sessionID))
 	log.Printf("Session created: %s", sessionID)
 }
 
-func (s *SecureServer) handleChunk(w http.ResponseWriter, r *http.Request) {
+func (s *SecureServer) handleOffer(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Read chunk metadata from header
-	var metadata ChunkMetadata
-	metadataStr := r.Header.Get("X-Chunk-Metadata")
-	if err := json.Unmarshal([]byte(metadataStr), &metadata); err != nil {
-		http.Error(w, "Invalid chunk metadata", http.StatusBadRequest)
+	_, span := telemetry.StartSpan(telemetry.ExtractHTTP(r), "airdrop.receive.offer")
+	defer span.End()
+
+	var offer OfferRequest
+	if err := json.NewDecoder(r.Body).Decode(&offer); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
+	span.SetAttributes(telemetry.AttrSessionID.String(offer.SessionID))
 
-	// Get session
+	// Claim the session, so a repeated offer cannot open a second file
 	s.mu.Lock()
-	session, exists := s.sessions[metadata.SessionID]
+	session, exists := s.sessions[offer.SessionID]
+	claimed := exists && !session.offered
+	if claimed {
+		session.offered = true
+		session.lastActive = time.Now()
+	}
 	s.mu.Unlock()
 
-	if !exists {
+	if !claimed {
 		http.Error(w, "Invalid session", http.StatusBadRequest)
 		return
 	}
 
-	// Read encrypted chunk
-	encryptedData, err := io.ReadAll(r.Body)
+	// Decrypt file metadata
+	metadata, err := DecryptMetadata(offer.EncryptedMetadata, session.SessionKey)
 	if err != nil {
-		http.Error(w, "Failed to read chunk", http.StatusInternalServerError)
+		http.Error(w, "Failed to decrypt metadata", http.StatusBadRequest)
 		return
 	}
+	metadata.Name = filepath.Base(metadata.Name)
+	span.SetAttributes(telemetry.AttrBytes.Int64(metadata.Size))
+	log.Printf("File: %s (%d bytes)", logging.FileName(metadata.Name), metadata.Size)
+
+	reject := func(message string) {
+		span.AddEvent("rejected")
+		s.mu.Lock()
+		delete(s.sessions, offer.SessionID)
+		s.mu.Unlock()
 
-	// Decrypt chunk
-	decryptedData, err := DecryptChunk(encryptedData, session.SessionKey)
+		resp := OfferResponse{
+			Accepted: false,
+			Message:  message,
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+
+	// Ask user to accept/reject
+	details := map[string]string{
+		"name": metadata.Name,
+		"size": strconv.FormatInt(metadata.Size, 10),
+		"mime": metadata.Mime,
+	}
+	reply := s.ask(r, prompt.KindFile, session.request, details)
+	if !reply.Accept {
+		reject("Transfer rejected by user")
+		return
+	}
+	filePath, err := s.destination(reply.Destination, metadata.Name)
 	if err != nil {
-		http.Error(w, "Failed to decrypt chunk", http.StatusInternalServerError)
+		log.Printf("Rejecting transfer: %v", err)
+		reject("Invalid destination")
 		return
 	}
 
-	// Verify checksum
-	checksum := CalculateChunkChecksum(decryptedData)
-	if checksum != metadata.Checksum {
-		ack := ChunkAck{
-			Index:     metadata.Index,
-			SessionID: metadata.SessionID,
-			Success:   false,
-			Error:     "Checksum mismatch",
-		}
-		json.NewEncoder(w).Encode(ack)
+	// Make sure the file fits before accepting any data
+	reservation, err := diskspace.Reserve(filepath.Dir(filePath), metadata.Size)
+	if err != nil {
+		log.Printf("Rejecting transfer: %v", err)
+		reject(err.Error())
 		return
 	}
 
-	// Write chunk to file
-	offset := int64(metadata.Index) * (4 * 1024 * 1024)
-	if _, err := session.File.WriteAt(decryptedData, offset); err != nil {
-		ack := ChunkAck{
-			Index:     metadata.Index,
-			SessionID: metadata.SessionID,
-			Success:   false,
-			Error:     "Failed to write chunk",
-		}
-		json.NewEncoder(w).Encode(ack)
+	totalChunks := int(metadata.Size / ChunkSize)
+	if metadata.Size%ChunkSize != 0 {
+		totalChunks++
+	}
+
+	// Create output file
+	file, err := os.Create(filePath)
+	if err != nil {
+		reservation.Release()
+		reject("Failed to create file")
 		return
 	}
 
-	// Mark chunk as received
+	// The reaper may have dropped the session while the user was asked
 	s.mu.Lock()
-	session.ReceivedChunks[metadata.Index] = true
-	received := len(session.ReceivedChunks)
+	_, alive := s.sessions[offer.SessionID]
+	if alive {
+		session.Metadata = metadata
+		session.TotalChunks = totalChunks
+		session.FilePath = filePath
+		session.File = file
+		session.reservation = reservation
+		session.meter = status.StartMeter(session.SessionID, "airdrop", "receive", metadata.Name)
+		session.Accepted = true
+		session.lastActive = time.Now()
+	}
 	s.mu.Unlock()
 
-	// Update progress
-	if s.onProgress != nil {
-		progress := float64(received) / float64(session.TotalChunks) * 100
-		s.onProgress(session.Metadata.Name, int64(received), int64(session.TotalChunks))
-		log.Printf("Progress: %.2f%% (%d/%d chunks)", progress, received, session.TotalChunks)
+	if !alive {
+		file.Close()
+		os.Remove(filePath)
+		reservation.Release()
+		reject("Session expired")
+		return
+	}
+	s.saveSession(session)
+
+	resp := OfferResponse{
+		Accepted: true,
+		Message:  "Transfer accepted",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	span.SetAttributes(telemetry.AttrChunks.Int(totalChunks))
+}
+
+func (s *SecureServer) handleChunk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Read chunk metadata from header
+	var metadata ChunkMetadata
+	metadataStr := r.Header.Get("X-Chunk-Metadata")
+	if err := json.Unmarshal([]byte(metadataStr), &metadata); err != nil {
+		http.Error(w, "Invalid chunk metadata", http.StatusBadRequest)
+		return
+	}
+
+	ack, status := s.receiveChunk(telemetry.ExtractHTTP(r), metadata, r.Body)
+	if status != http.StatusOK {
+		http.Error(w, ack.Error, status)
+		return
 	}
 
 	// Send ACK
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ack)
+}
+
+// receiveChunk decrypts, verifies and stores a chunk read from body for any
+// transport. A status other than 200 means the request itself was invalid.
+func (s *SecureServer) receiveChunk(ctx context.Context, metadata ChunkMetadata, body io.Reader) (ChunkAck, int) {
+	_, span := telemetry.StartSpan(ctx, "airdrop.receive.chunk")
+	defer span.End()
+
+	span.SetAttributes(
+		telemetry.AttrSessionID.String(metadata.SessionID),
+		telemetry.AttrChunk.Int(metadata.Index),
+	)
+
 	ack := ChunkAck{
 		Index:     metadata.Index,
 		SessionID: metadata.SessionID,
-		Success:   true,
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(ack)
+	fail := func(message string) (ChunkAck, int) {
+		ack.Error = message
+		return ack, http.StatusOK
+	}
 
-	// Check if transfer complete
-	if received == session.TotalChunks {
-		session.File.Close()
-		log.Printf("✓ Transfer complete: %s", session.FilePath)
+	// Get session
+	session, ok := s.acquire(metadata.SessionID)
+	if !ok {
+		ack.Error = "Invalid session"
+		return ack, http.StatusBadRequest
+	}
+	defer s.release(session)
+
+	group := 0
+	var size int
+	if metadata.Parity {
+		// Parity is kept until its group completes, so it is read whole
+		decryptedData, err := readChunk(body, metadata, session.SessionKey, session.chunkAAD(metadata))
+		if err != nil {
+			ack.Error = "Failed to decrypt chunk"
+			return ack, http.StatusInternalServerError
+		}
+		span.SetAttributes(telemetry.AttrBytes.Int(len(decryptedData)))
+		size = len(decryptedData)
+
+		// Verify checksum
+		if CalculateChunkChecksum(decryptedData) != metadata.Checksum {
+			span.AddEvent("checksum_mismatch")
+			return fail("Checksum mismatch")
+		}
+		if err := s.addParity(session, metadata, decryptedData); err != nil {
+			return fail(err.Error())
+		}
+		group = metadata.Group
+	} else {
+		if metadata.Index < 0 || metadata.Index >= session.TotalChunks {
+			return fail("Invalid chunk index")
+		}
+
+		// Decrypt straight into the file; the chunk only counts once its
+		// checksum matches
+		offset := int64(metadata.Index) * ChunkSize
+		hasher := sha256.New()
+		out := &trackingWriter{}
+		var n int64
+		err := session.useFile(func(file *os.File) error {
+			out.w = io.MultiWriter(io.NewOffsetWriter(file, offset), hasher)
+			var err error
+			n, err = writeChunk(body, metadata, session.SessionKey, session.chunkAAD(metadata), out)
+			return err
+		})
+		if errors.Is(err, errSessionClosed) {
+			return fail("Session closed")
+		} else if err != nil && out.err != nil {
+			return fail("Failed to write chunk")
+		} else if err != nil {
+			ack.Error = "Failed to decrypt chunk"
+			return ack, http.StatusInternalServerError
+		}
+		span.SetAttributes(telemetry.AttrBytes.Int64(n))
+		size = int(n)
+
+		// Verify checksum
+		sum := hasher.Sum(nil)
+		if hex.EncodeToString(sum) != metadata.Checksum {
+			span.AddEvent("checksum_mismatch")
+			return fail("Checksum mismatch")
+		}
 
+		// Mark chunk as received
 		s.mu.Lock()
+		session.markReceived(metadata.Index, [sha256.Size]byte(sum))
+		s.mu.Unlock()
+
+		if session.FEC != nil {
+			group = metadata.Index / session.FEC.DataChunks
+		}
+	}
+
+	// Acknowledging late holds the sender to the session's share
+	session.meter.Add(int64(size))
+	session.share().Wait(ctx, size)
+
+	// Rebuild lost chunks as soon as the group has enough parity
+	if session.FEC != nil {
+		rebuilt, err := s.recoverGroup(session, group)
+		if err != nil {
+			log.Printf("FEC recovery failed: %v", err)
+		} else if rebuilt > 0 {
+			span.AddEvent("fec_recovered")
+			log.Printf("Recovered %d chunks of group %d from parity", rebuilt, group)
+		}
+	}
+
+	s.mu.Lock()
+	received := len(session.ReceivedChunks)
+	receivedBytes := session.receivedBytes()
+	s.mu.Unlock()
+
+	// Update progress
+	if s.onProgress != nil {
+		s.onProgress(progress.Event{
+			Kind:      progress.KindFile,
+			SessionID: session.SessionID,
+			File:      session.Metadata.Name,
+			Bytes:     receivedBytes,
+			Total:     session.Metadata.Size,
+		})
+		log.Printf("Progress: %.2f%% (%d/%d chunks)", float64(received)/float64(session.TotalChunks)*100, received, session.TotalChunks)
+	}
+
+	// Check if transfer complete; chunks may arrive concurrently, so only
+	// the caller that removes the session finishes it
+	if received == session.TotalChunks {
+		s.mu.Lock()
+		_, finish := s.sessions[metadata.SessionID]
 		delete(s.sessions, metadata.SessionID)
 		s.mu.Unlock()
+
+		if finish && session.close() {
+			s.forgetSession(metadata.SessionID)
+			span.AddEvent("complete")
+			log.Printf("✓ Transfer complete: %s", logging.Path(session.FilePath))
+			onreceive.Received(session.FilePath, "airdrop", session.Fingerprint)
+		}
+	}
+
+	ack.Success = true
+	return ack, http.StatusOK
+}
+
+// writeChunk decrypts a chunk body into w, a segment at a time if the
+// sender streamed it. Web sessions authenticate aad with every chunk.
+func writeChunk(body io.Reader, metadata ChunkMetadata, key, aad []byte, w io.Writer) (int64, error) {
+	if metadata.Stream && aad == nil {
+		return DecryptChunkStream(io.LimitReader(body, maxStreamedChunkSize), key, w, ChunkSize)
+	}
+	data, err := readChunk(body, metadata, key, aad)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// readChunk reads and decrypts a whole chunk body
+func readChunk(body io.Reader, metadata ChunkMetadata, key, aad []byte) ([]byte, error) {
+	if metadata.Stream && aad != nil {
+		return nil, fmt.Errorf("web sessions do not stream chunks")
+	}
+	if metadata.Stream {
+		var buf bytes.Buffer
+		if _, err := DecryptChunkStream(io.LimitReader(body, maxStreamedChunkSize), key, &buf, ChunkSize); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	encrypted, err := io.ReadAll(io.LimitReader(body, maxEncryptedChunkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) > maxEncryptedChunkSize {
+		return nil, fmt.Errorf("chunk too large")
+	}
+	if aad != nil {
+		return openWebChunk(encrypted, key, aad)
+	}
+	return DecryptChunk(encrypted, key)
+}
+
+// trackingWriter remembers the error of a failed write, telling write
+// failures apart from read and decrypt failures
+type trackingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil {
+		t.err = err
 	}
+	return n, err
 }
 
 func (s *SecureServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.URL.Query().Get("session_id")
 
+	// Chunks are recorded concurrently, so the status is copied under the lock
 	s.mu.Lock()
 	session, exists := s.sessions[sessionID]
+	var status TransferStatus
+	if exists {
+		receivedChunks := make([]int, 0, len(session.ReceivedChunks))
+		for idx := range session.ReceivedChunks {
+			receivedChunks = append(receivedChunks, idx)
+		}
+		status = TransferStatus{
+			SessionID:      sessionID,
+			TotalChunks:    session.TotalChunks,
+			ReceivedChunks: receivedChunks,
+			Progress:       float64(len(receivedChunks)) / float64(session.TotalChunks) * 100,
+			CanResume:      true,
+		}
+	}
 	s.mu.Unlock()
 
 	if !exists {
@@ -300,19 +756,6 @@ func (s *SecureServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	receivedChunks := make([]int, 0, len(session.ReceivedChunks))
-	for idx := range session.ReceivedChunks {
-		receivedChunks = append(receivedChunks, idx)
-	}
-
-	status := TransferStatus{
-		SessionID:      sessionID,
-		TotalChunks:    session.TotalChunks,
-		ReceivedChunks: receivedChunks,
-		Progress:       float64(len(receivedChunks)) / float64(session.TotalChunks) * 100,
-		CanResume:      true,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
@@ -0,0 +1,347 @@
+package airdrop
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/onreceive"
+	"github.com/owner/secure-file-manager/internal/status"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// persistInterval is how often received chunks are synced to disk and
+// recorded. Chunks received since are sent again after a restart.
+const persistInterval = 2 * time.Second
+
+// SetPersistSessions keeps accepted sessions in the database, so a
+// restarted server reloads them with their partial files and senders can
+// resume. Must be called before Start.
+func (s *SecureServer) SetPersistSessions(enabled bool) {
+	s.persist = enabled
+}
+
+// SetResumeVerification sets how many received chunks of a reloaded session
+// are checked against their recorded checksums before it accepts new ones.
+// 0 checks all of them; if a sample finds a bad chunk, all are checked.
+// Chunks that fail are dropped, so the sender sends them again.
+func (s *SecureServer) SetResumeVerification(sample int) {
+	s.verifySample = sample
+}
+
+// sessionSealKey seals session keys at rest, so the database alone does
+// not decrypt chunks still in flight
+func (s *SecureServer) sessionSealKey() []byte {
+	sum := sha256.Sum256(append([]byte("sfm-airdrop-session-v1"), s.identity.PrivateKey.Seed()...))
+	return sum[:]
+}
+
+// saveSession records a session once its offer is accepted
+func (s *SecureServer) saveSession(session *TransferSession) {
+	if !s.persist {
+		return
+	}
+	sealedKey, err := EncryptChunk(session.SessionKey, s.sessionSealKey())
+	if err != nil {
+		log.Printf("Failed to save session %s: %v", session.SessionID, err)
+		return
+	}
+	metadata, err := json.Marshal(session.Metadata)
+	if err != nil {
+		log.Printf("Failed to save session %s: %v", session.SessionID, err)
+		return
+	}
+
+	record := models.AirDropSession{
+		SessionID:   session.SessionID,
+		SenderName:  session.SenderName,
+		Fingerprint: session.Fingerprint,
+		SessionKey:  sealedKey,
+		Metadata:    metadata,
+		TotalChunks: session.TotalChunks,
+		FilePath:    session.FilePath,
+		Web:         session.web,
+	}
+	if session.FEC != nil {
+		record.FECData, record.FECParity = session.FEC.DataChunks, session.FEC.ParityChunks
+	}
+	if err := storage.DB().Create(&record).Error; err != nil {
+		log.Printf("Failed to save session %s: %v", session.SessionID, err)
+	}
+}
+
+// forgetSession deletes the record of a completed or dropped session
+func (s *SecureServer) forgetSession(sessionID string) {
+	if !s.persist {
+		return
+	}
+	if err := storage.DB().Where("session_id = ?", sessionID).Delete(&models.AirDropSession{}).Error; err != nil {
+		log.Printf("Failed to delete session %s: %v", sessionID, err)
+	}
+}
+
+// persistSessions records received chunks until stop is closed
+func (s *SecureServer) persistSessions(stop <-chan struct{}) {
+	ticker := time.NewTicker(persistInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.flushSessions()
+		}
+	}
+}
+
+// flushSessions records the chunks of every session that received some
+// since the last flush. The file is synced first, so a record never claims
+// chunks the disk may not hold.
+func (s *SecureServer) flushSessions() {
+	if !s.persist {
+		return
+	}
+	// Flushes run one at a time, so an older bitmap never overwrites a
+	// newer one
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	type flush struct {
+		session   *TransferSession
+		bitmap    []byte
+		checksums []byte
+	}
+	var flushes []flush
+	s.mu.Lock()
+	for _, session := range s.sessions {
+		if session.dirty {
+			session.dirty = false
+			flushes = append(flushes, flush{
+				session:   session,
+				bitmap:    chunkBitmap(session.ReceivedChunks, session.TotalChunks),
+				checksums: packChecksums(session.sums, session.TotalChunks),
+			})
+		}
+	}
+	s.mu.Unlock()
+
+	for _, f := range flushes {
+		// A closed session has completed or been dropped
+		if err := f.session.useFile(func(file *os.File) error { return file.Sync() }); err != nil {
+			continue
+		}
+		err := storage.DB().Model(&models.AirDropSession{}).
+			Where("session_id = ?", f.session.SessionID).
+			Updates(map[string]interface{}{"chunks": f.bitmap, "checksums": f.checksums}).Error
+		if err != nil {
+			log.Printf("Failed to save session %s: %v", f.session.SessionID, err)
+		}
+	}
+}
+
+// restoreSessions reloads the sessions a previous run left incomplete.
+// Sessions whose partial file is gone are dropped.
+func (s *SecureServer) restoreSessions() {
+	if !s.persist {
+		return
+	}
+	var records []models.AirDropSession
+	if err := storage.DB().Find(&records).Error; err != nil {
+		log.Printf("Failed to load sessions: %v", err)
+		return
+	}
+
+	restored := 0
+	for _, record := range records {
+		session, err := s.restoreSession(record)
+		if err != nil {
+			log.Printf("Dropping transfer %s: %v", logging.Path(record.FilePath), err)
+			s.forgetSession(record.SessionID)
+			continue
+		}
+
+		// A partial file may have been damaged while the server was down
+		if bad := s.verifyChunks(session); bad > 0 {
+			log.Printf("Discarded %d corrupted chunk(s) of %s", bad, logging.Path(session.FilePath))
+			session.dirty = true
+		}
+
+		// It may have crashed between the last chunk and completion
+		if len(session.ReceivedChunks) == session.TotalChunks {
+			session.close()
+			s.forgetSession(record.SessionID)
+			log.Printf("✓ Transfer complete: %s", logging.Path(session.FilePath))
+			onreceive.Received(session.FilePath, "airdrop", session.Fingerprint)
+			continue
+		}
+
+		session.rateSince, session.rateBase = time.Now(), session.receivedBytes()
+		s.mu.Lock()
+		s.sessions[session.SessionID] = session
+		s.mu.Unlock()
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("Restored %d incomplete transfer(s)", restored)
+	}
+}
+
+func (s *SecureServer) restoreSession(record models.AirDropSession) (*TransferSession, error) {
+	sessionKey, err := DecryptChunk(record.SessionKey, s.sessionSealKey())
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal session key: %w", err)
+	}
+	var metadata FileMetadata
+	if err := json.Unmarshal(record.Metadata, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	file, err := os.OpenFile(record.FilePath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partial file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat partial file: %w", err)
+	}
+	reservation, err := diskspace.Reserve(filepath.Dir(record.FilePath), metadata.Size-info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	session := &TransferSession{
+		SessionID:      record.SessionID,
+		SenderName:     record.SenderName,
+		Fingerprint:    record.Fingerprint,
+		Metadata:       metadata,
+		SessionKey:     sessionKey,
+		TotalChunks:    record.TotalChunks,
+		ReceivedChunks: chunksFromBitmap(record.Chunks, record.TotalChunks),
+		sums:           unpackChecksums(record.Checksums, record.TotalChunks),
+		FilePath:       record.FilePath,
+		File:           file,
+		Accepted:       true,
+		reservation:    reservation,
+		meter:          status.StartMeter(record.SessionID, "airdrop", "receive", metadata.Name),
+		offered:        true,
+		lastActive:     time.Now(),
+		startedAt:      record.CreatedAt,
+		web:            record.Web,
+	}
+	if record.FECData > 0 {
+		fec := &FECParams{DataChunks: record.FECData, ParityChunks: record.FECParity}
+		if fec.Valid() {
+			session.FEC = fec
+		}
+	}
+	return session, nil
+}
+
+// verifyChunks checks received chunks on disk against their checksums, a
+// sample or all of them, and drops those that fail. Chunks without a
+// recorded checksum cannot be trusted and fail too. It returns how many
+// were dropped.
+func (s *SecureServer) verifyChunks(session *TransferSession) int {
+	received := make([]int, 0, len(session.ReceivedChunks))
+	for index := range session.ReceivedChunks {
+		received = append(received, index)
+	}
+	sort.Ints(received)
+
+	check := func(indexes []int) []int {
+		var bad []int
+		buf := make([]byte, ChunkSize)
+		err := session.useFile(func(file *os.File) error {
+			for _, index := range indexes {
+				sum, ok := session.sums[index]
+				data := buf[:chunkLength(session.Metadata.Size, index)]
+				if !ok {
+					bad = append(bad, index)
+				} else if _, err := file.ReadAt(data, int64(index)*ChunkSize); err != nil || sha256.Sum256(data) != sum {
+					bad = append(bad, index)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return indexes
+		}
+		return bad
+	}
+
+	var bad []int
+	if s.verifySample > 0 && s.verifySample < len(received) {
+		sample := make([]int, s.verifySample)
+		for i, j := range rand.Perm(len(received))[:s.verifySample] {
+			sample[i] = received[j]
+		}
+		if len(check(sample)) > 0 {
+			bad = check(received)
+		}
+	} else {
+		bad = check(received)
+	}
+
+	for _, index := range bad {
+		delete(session.ReceivedChunks, index)
+		delete(session.sums, index)
+	}
+	return len(bad)
+}
+
+// chunkBitmap packs received chunk indexes, one bit each
+func chunkBitmap(chunks map[int]bool, total int) []byte {
+	bitmap := make([]byte, (total+7)/8)
+	for index := range chunks {
+		if index >= 0 && index < total {
+			bitmap[index/8] |= 1 << (index % 8)
+		}
+	}
+	return bitmap
+}
+
+func chunksFromBitmap(bitmap []byte, total int) map[int]bool {
+	chunks := make(map[int]bool)
+	for index := 0; index < total && index/8 < len(bitmap); index++ {
+		if bitmap[index/8]&(1<<(index%8)) != 0 {
+			chunks[index] = true
+		}
+	}
+	return chunks
+}
+
+// packChecksums lays out chunk checksums by index, zeros for chunks not
+// received
+func packChecksums(sums map[int][sha256.Size]byte, total int) []byte {
+	packed := make([]byte, total*sha256.Size)
+	for index, sum := range sums {
+		if index >= 0 && index < total {
+			copy(packed[index*sha256.Size:], sum[:])
+		}
+	}
+	return packed
+}
+
+func unpackChecksums(packed []byte, total int) map[int][sha256.Size]byte {
+	sums := make(map[int][sha256.Size]byte)
+	var zero [sha256.Size]byte
+	for index := 0; index < total && (index+1)*sha256.Size <= len(packed); index++ {
+		sum := [sha256.Size]byte(packed[index*sha256.Size:])
+		if sum != zero {
+			sums[index] = sum
+		}
+	}
+	return sums
+}
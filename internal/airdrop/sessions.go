@@ -0,0 +1,159 @@
+package airdrop
+
+import (
+	"crypto/sha256"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/quarantine"
+)
+
+const (
+	// DefaultSessionTimeout is how long a session may sit idle, e.g. after
+	// its sender vanished, before it and its partial file are dropped
+	DefaultSessionTimeout = 10 * time.Minute
+
+	reapInterval = 30 * time.Second
+)
+
+var errSessionClosed = errors.New("session closed")
+
+// SetSessionTimeout sets how long a session may sit idle before it is
+// dropped. 0 keeps sessions until they complete.
+func (s *SecureServer) SetSessionTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessionTimeout = timeout
+}
+
+// acquire looks up an accepted session and marks it busy, so it is not
+// reaped while a chunk is handled. Callers release it when done.
+func (s *SecureServer) acquire(sessionID string) (*TransferSession, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || !session.Accepted {
+		return nil, false
+	}
+	session.busy++
+	session.lastActive = time.Now()
+	return session, true
+}
+
+func (s *SecureServer) release(session *TransferSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.busy--
+	session.lastActive = time.Now()
+}
+
+// reapSessions drops idle sessions until stop is closed
+func (s *SecureServer) reapSessions(stop <-chan struct{}) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.reapIdle(now)
+		}
+	}
+}
+
+// reapIdle drops the sessions idle since before now minus the timeout and
+// quarantines their partial files. Sessions handling a chunk are never idle.
+func (s *SecureServer) reapIdle(now time.Time) int {
+	s.mu.Lock()
+	if s.sessionTimeout <= 0 {
+		s.mu.Unlock()
+		return 0
+	}
+	var idle []*TransferSession
+	for id, session := range s.sessions {
+		if session.busy > 0 || now.Sub(session.lastActive) < s.sessionTimeout {
+			continue
+		}
+		delete(s.sessions, id)
+		idle = append(idle, session)
+	}
+	s.mu.Unlock()
+
+	for _, session := range idle {
+		s.forgetSession(session.SessionID)
+		if session.close() && session.FilePath != "" {
+			log.Printf("Dropped idle transfer: %s", logging.Path(session.FilePath))
+			quarantine.Discard(session.FilePath, quarantine.ReasonIdle, "airdrop", session.Fingerprint)
+		}
+	}
+	return len(idle)
+}
+
+// closeSessions drops every session, keeping partial files
+func (s *SecureServer) closeSessions() {
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*TransferSession)
+	s.mu.Unlock()
+
+	for _, session := range sessions {
+		session.close()
+	}
+}
+
+// markReceived records a chunk written to the file and its checksum. The
+// server's mu must be held.
+func (t *TransferSession) markReceived(index int, sum [sha256.Size]byte) {
+	t.ReceivedChunks[index] = true
+	if t.sums == nil {
+		t.sums = make(map[int][sha256.Size]byte)
+	}
+	t.sums[index] = sum
+	t.dirty = true
+}
+
+// share returns the session's flow, opening it on first use
+func (t *TransferSession) share() *bandwidth.Flow {
+	t.flowOnce.Do(func() { t.flow = bandwidth.Open(bandwidth.ClassAirDrop) })
+	return t.flow
+}
+
+// useFile runs fn with the session's file, which stays open until fn
+// returns. It fails with errSessionClosed once the session is closed.
+func (t *TransferSession) useFile(fn func(*os.File) error) error {
+	t.fileMu.RLock()
+	defer t.fileMu.RUnlock()
+
+	if t.closed || t.File == nil {
+		return errSessionClosed
+	}
+	return fn(t.File)
+}
+
+// close waits for writes in progress, then closes the session's file and
+// releases its disk reservation. It reports whether this call closed it.
+// The session must already be removed from the server, so no new handler
+// can find it.
+func (t *TransferSession) close() bool {
+	t.fileMu.Lock()
+	defer t.fileMu.Unlock()
+
+	if t.closed {
+		return false
+	}
+	t.closed = true
+	if t.File != nil {
+		t.File.Close()
+	}
+	if t.reservation != nil {
+		t.reservation.Release()
+	}
+	t.meter.Stop()
+	return true
+}
@@ -9,6 +9,8 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+
+	"github.com/owner/secure-file-manager/internal/logging"
 )
 
 type FileMetadata struct {
@@ -195,7 +197,7 @@ func (s *Server) handleSend(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	log.Printf("Received file: %s (%d bytes)", filename, received)
+	log.Printf("Received file: %s (%d bytes)", logging.FileName(filename), received)
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
@@ -0,0 +1,380 @@
+package airdrop
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Chunk transports a sender can choose
+const (
+	TransportAuto = "auto" // QUIC when RTT probes show TCP stalling
+	TransportHTTP = "http"
+	TransportQUIC = "quic"
+)
+
+const (
+	quicALPN = "sfm-airdrop-chunk/1"
+
+	// quicWindow is how many chunks are in flight over QUIC; each travels
+	// on its own stream so one lost packet stalls only its chunk
+	quicWindow = 4
+
+	// maxChunkMetadataSize bounds the metadata frame on a QUIC stream
+	maxChunkMetadataSize = 4 * 1024
+
+	rttProbes = 8
+)
+
+// errChunkRejected wraps a chunk the receiver answered with a failure, as
+// opposed to one lost in transit
+var errChunkRejected = errors.New("chunk rejected")
+
+// isChunkLoss reports whether a chunk failed in transit, which FEC parity
+// or a resend can make up for. A rejected chunk or a cancelled send cannot.
+func isChunkLoss(err error) bool {
+	return !errors.Is(err, errChunkRejected) && !errors.Is(err, context.Canceled)
+}
+
+// chunkSender delivers encrypted chunks to the receiver
+type chunkSender interface {
+	SendChunk(ctx context.Context, metadata ChunkMetadata, encryptedData []byte) error
+	Close() error
+}
+
+// httpSender posts each chunk to /chunk
+type httpSender struct {
+	client     *SecureClient
+	targetIP   string
+	targetPort int
+}
+
+func (h *httpSender) SendChunk(ctx context.Context, metadata ChunkMetadata, encryptedData []byte) error {
+	return h.client.sendChunk(ctx, h.targetIP, h.targetPort, metadata, encryptedData)
+}
+
+func (h *httpSender) Close() error {
+	return nil
+}
+
+// quicSender sends each chunk on its own stream of one QUIC connection.
+// Chunks are already encrypted with the session key; TLS here only frames
+// the connection, so the receiver's certificate is not verified.
+type quicSender struct {
+	conn *quic.Conn
+}
+
+func dialQUIC(ctx context.Context, targetIP string, port int) (*quicSender, error) {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quicALPN},
+	}
+	addr := net.JoinHostPort(targetIP, strconv.Itoa(port))
+	conn, err := quic.DialAddr(ctx, addr, tlsConf, &quic.Config{MaxIdleTimeout: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial QUIC: %w", err)
+	}
+	return &quicSender{conn: conn}, nil
+}
+
+func (q *quicSender) SendChunk(ctx context.Context, metadata ChunkMetadata, encryptedData []byte) error {
+	stream, err := q.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer stream.Close()
+
+	metadataJSON, _ := json.Marshal(metadata)
+	if err := writeFrame(stream, metadataJSON); err != nil {
+		return err
+	}
+	if err := writeFrame(stream, encryptedData); err != nil {
+		return err
+	}
+	// Closing the write side tells the receiver the request is complete
+	if err := stream.Close(); err != nil {
+		return err
+	}
+
+	ackJSON, err := readFrame(stream, maxChunkMetadataSize)
+	if err != nil {
+		return fmt.Errorf("failed to read ACK: %w", err)
+	}
+	var ack ChunkAck
+	if err := json.Unmarshal(ackJSON, &ack); err != nil {
+		return fmt.Errorf("failed to decode ACK: %w", err)
+	}
+	if !ack.Success {
+		return fmt.Errorf("%w: %s", errChunkRejected, ack.Error)
+	}
+	return nil
+}
+
+func (q *quicSender) Close() error {
+	return q.conn.CloseWithError(0, "done")
+}
+
+// writeFrame writes [length: uint32][data]
+func writeFrame(w io.Writer, data []byte) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// readFrame reads a frame written by writeFrame of at most limit bytes
+func readFrame(r io.Reader, limit int) ([]byte, error) {
+	body, err := frameReader(r, limit)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, body.N)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// frameReader reads the length of a frame of at most limit bytes and
+// returns a reader for its data
+func frameReader(r io.Reader, limit int) (*io.LimitedReader, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if int64(length) > int64(limit) {
+		return nil, fmt.Errorf("frame too large: %d bytes", length)
+	}
+	return &io.LimitedReader{R: r, N: int64(length)}, nil
+}
+
+// chunkPipeline keeps up to window chunks in flight and collects failures
+type chunkPipeline struct {
+	transport chunkSender
+	slots     chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	failed    []int // Data chunks that failed since the last wait
+	lost      int   // Data and parity chunks that failed since the last wait
+	err       error // First failure since the last wait
+	fatal     error // First failure since the last wait that was not a loss
+}
+
+func newChunkPipeline(transport chunkSender, window int) *chunkPipeline {
+	return &chunkPipeline{transport: transport, slots: make(chan struct{}, window)}
+}
+
+// send hands a chunk to the transport, blocking while the window is full.
+// With a window of one it sends inline.
+func (p *chunkPipeline) send(ctx context.Context, metadata ChunkMetadata, encryptedData []byte) {
+	if cap(p.slots) == 1 {
+		p.record(metadata, p.transport.SendChunk(ctx, metadata, encryptedData))
+		return
+	}
+
+	p.slots <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		p.record(metadata, p.transport.SendChunk(ctx, metadata, encryptedData))
+	}()
+}
+
+func (p *chunkPipeline) record(metadata ChunkMetadata, err error) {
+	if err == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !metadata.Parity {
+		p.failed = append(p.failed, metadata.Index)
+		err = fmt.Errorf("failed to send chunk %d: %w", metadata.Index, err)
+	} else {
+		err = fmt.Errorf("failed to send parity chunk %d of group %d: %w", metadata.Index, metadata.Group, err)
+	}
+	p.lost++
+	if p.err == nil {
+		p.err = err
+	}
+	if p.fatal == nil && !isChunkLoss(err) {
+		p.fatal = err
+	}
+}
+
+// firstError returns the first failure since the last wait without
+// waiting, preferring one that was not a loss
+func (p *chunkPipeline) firstError() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fatal != nil {
+		return p.fatal
+	}
+	return p.err
+}
+
+// wait waits for chunks in flight and returns and resets the failures. The
+// error is the first that was not a loss, if any, as for firstError.
+func (p *chunkPipeline) wait() (failed []int, lost int, err error) {
+	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	failed, lost, err = p.failed, p.lost, p.err
+	if p.fatal != nil {
+		err = p.fatal
+	}
+	sort.Ints(failed)
+	p.failed, p.lost, p.err, p.fatal = nil, 0, nil, nil
+	return failed, lost, err
+}
+
+// measureRTT times sequential /ping requests over the HTTP connection. It
+// returns the successful samples and how many probes failed.
+func (c *SecureClient) measureRTT(ctx context.Context, targetIP string, targetPort int, probes int) ([]time.Duration, int) {
+	pingURL := fmt.Sprintf("http://%s:%d/ping", targetIP, targetPort)
+	probeClient := &http.Client{Transport: c.httpClient.Transport, Timeout: 2 * time.Second}
+
+	var samples []time.Duration
+	failed := 0
+	for i := 0; i < probes; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+		if err != nil {
+			failed++
+			continue
+		}
+		start := time.Now()
+		resp, err := probeClient.Do(req)
+		if err != nil {
+			failed++
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		samples = append(samples, time.Since(start))
+	}
+	return samples, failed
+}
+
+// preferQUIC reports whether RTT probes show TCP stalling: any lost probe
+// (a TCP retransmit timeout) or a wide spread between the fastest and the
+// typical round trip, which is what queued data behind a loss looks like
+func preferQUIC(samples []time.Duration, failed int) bool {
+	if failed > 0 {
+		return true
+	}
+	if len(samples) < 2 {
+		return false
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	fastest := sorted[0]
+	typical := sorted[len(sorted)*3/4]
+	return typical > 3*fastest && typical-fastest > 20*time.Millisecond
+}
+
+// serveQUIC accepts chunk streams until the listener is closed
+func (s *SecureServer) serveQUIC(listener *quic.Listener) {
+	for {
+		conn, err := listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			for {
+				stream, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				go s.handleQUICStream(stream)
+			}
+		}()
+	}
+}
+
+func (s *SecureServer) handleQUICStream(stream *quic.Stream) {
+	defer stream.Close()
+
+	metadataJSON, err := readFrame(stream, maxChunkMetadataSize)
+	if err != nil {
+		stream.CancelRead(0)
+		return
+	}
+	var metadata ChunkMetadata
+	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
+		stream.CancelRead(0)
+		return
+	}
+	body, err := frameReader(stream, maxStreamedChunkSize)
+	if err != nil {
+		stream.CancelRead(0)
+		return
+	}
+
+	ack, status := s.receiveChunk(context.Background(), metadata, body)
+	if status == http.StatusInternalServerError {
+		// The chunk could not be read whole; like a dropped stream, no
+		// answer lets the sender count it as lost
+		stream.CancelRead(0)
+		return
+	}
+	ackJSON, _ := json.Marshal(ack)
+	writeFrame(stream, ackJSON)
+}
+
+// listenQUIC starts the QUIC chunk listener on the server's port
+func (s *SecureServer) listenQUIC() (*quic.Listener, error) {
+	cert, err := selfSignedCertificate()
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{quicALPN},
+	}
+	listener, err := quic.ListenAddr(fmt.Sprintf(":%d", s.port), tlsConf, &quic.Config{MaxIdleTimeout: 30 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for QUIC: %w", err)
+	}
+	return listener, nil
+}
+
+// selfSignedCertificate creates a throwaway certificate for QUIC's TLS
+func selfSignedCertificate() (tls.Certificate, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, nil
+}
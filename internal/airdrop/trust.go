@@ -0,0 +1,93 @@
+package airdrop
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+// TrustStore holds the device keys the user imported. A device whose
+// handshake is signed by a trusted key is not prompted for.
+type TrustStore struct{}
+
+func NewTrustStore() *TrustStore {
+	return &TrustStore{}
+}
+
+// Add imports a device key in any supported format under name, or the
+// OpenSSH comment if name is empty. Importing a key again updates its name,
+// and fills in its Ed25519 form if it was known only as an age recipient.
+func (t *TrustStore) Add(name, text string) (*models.TrustedKey, error) {
+	key, err := ParsePublicKey(text)
+	if err != nil {
+		return nil, err
+	}
+
+	db := storage.DB()
+	var trusted models.TrustedKey
+	err = db.Where("key_id = ?", key.KeyID()).First(&trusted).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load trusted key: %w", err)
+	}
+
+	trusted.KeyID = key.KeyID()
+	if name != "" {
+		trusted.Name = name
+	} else if trusted.Name == "" {
+		trusted.Name = key.Comment
+	}
+	if key.Ed25519 != nil {
+		trusted.PublicKey = key.Ed25519
+		trusted.Fingerprint = key.Fingerprint()
+	}
+	if trusted.Format == "" || key.Ed25519 != nil {
+		trusted.Format = key.Format
+	}
+	if err := db.Save(&trusted).Error; err != nil {
+		return nil, fmt.Errorf("failed to save trusted key: %w", err)
+	}
+	return &trusted, nil
+}
+
+// List returns the trusted keys
+func (t *TrustStore) List() ([]models.TrustedKey, error) {
+	var keys []models.TrustedKey
+	if err := storage.DB().Order("name").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to load trusted keys: %w", err)
+	}
+	return keys, nil
+}
+
+// Remove stops trusting a key, given its key ID or fingerprint
+func (t *TrustStore) Remove(id string) error {
+	result := storage.DB().Where("key_id = ? OR fingerprint = ?", id, id).Delete(&models.TrustedKey{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove trusted key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no trusted key %s", id)
+	}
+	return nil
+}
+
+// Trusts reports whether pub is a trusted key
+func (t *TrustStore) Trusts(pub ed25519.PublicKey) bool {
+	u, err := edwardsToMontgomery(pub)
+	if err != nil {
+		return false
+	}
+	key := &PeerKey{X25519: u}
+
+	var trusted models.TrustedKey
+	if err := storage.DB().Where("key_id = ?", key.KeyID()).First(&trusted).Error; err != nil {
+		return false
+	}
+	if len(trusted.PublicKey) > 0 {
+		key.Ed25519 = trusted.PublicKey
+	}
+	return key.sameKey(pub)
+}
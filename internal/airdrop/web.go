@@ -0,0 +1,221 @@
+package airdrop
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/prompt"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+)
+
+// Browsers send through a variant of the protocol built only from what
+// WebCrypto offers, so their transfers are end-to-end encrypted too rather
+// than trusting TLS alone:
+//
+//   - Key agreement is ECDH on P-256; public keys are uncompressed points,
+//     as exportKey("raw") gives them
+//   - The session key is HKDF-SHA256 over the shared secret, with the
+//     sender's then the receiver's public key as salt and WebKeyInfo as
+//     info, 32 bytes for AES-256-GCM
+//   - Offers and chunks are the 12-byte IV followed by the AES-GCM output,
+//     as encrypt() returns it. Chunks are sealed whole, with additional
+//     data binding them to their session and position (see WebChunkAAD).
+//
+// After the handshake, /offer, /chunk and /status work as for devices.
+const (
+	WebKeyInfo        = "sfm-airdrop-web-v1"
+	webTranscriptInfo = "sfm-airdrop-web-handshake-v1"
+)
+
+// WebHandshakeRequest starts a session from a browser. Browsers have no
+// device identity, so the user is always asked about a web sender.
+type WebHandshakeRequest struct {
+	DeviceName      string `json:"device_name"`
+	EphemeralPubKey []byte `json:"ephemeral_pubkey"` // P-256, uncompressed
+}
+
+// WebHandshakeResponse completes key agreement. Signature is the
+// receiver's Ed25519 signature over WebTranscript, so a page that knows
+// the receiver's key can tell it is talking to the receiver end to end.
+type WebHandshakeResponse struct {
+	Accepted        bool   `json:"accepted"`
+	SessionID       string `json:"session_id,omitempty"`
+	EphemeralPubKey []byte `json:"ephemeral_pubkey,omitempty"`
+	Fingerprint     string `json:"fingerprint,omitempty"` // Receiver's, to show and compare
+	PublicKey       []byte `json:"public_key,omitempty"`  // Receiver's Ed25519 identity key
+	Signature       []byte `json:"signature,omitempty"`
+	ChunkSize       int    `json:"chunk_size,omitempty"`
+	Message         string `json:"message,omitempty"`
+}
+
+// SetWebOrigins lets pages served from these origins, e.g.
+// "https://send.example.com", call the server from a browser. "*" allows
+// any origin. Without origins only same-origin pages can.
+func (s *SecureServer) SetWebOrigins(origins ...string) {
+	s.webOrigins = origins
+}
+
+// DeriveWebSessionKey derives the session key of a web session from the
+// ECDH shared secret and both public keys
+func DeriveWebSessionKey(sharedSecret, senderPub, receiverPub []byte) ([]byte, error) {
+	salt := append(append([]byte{}, senderPub...), receiverPub...)
+	return hkdf.Key(sha256.New, sharedSecret, salt, WebKeyInfo, 32)
+}
+
+// WebTranscript is what the receiver signs in a web handshake
+func WebTranscript(sessionID string, senderPub, receiverPub []byte) []byte {
+	transcript := []byte(webTranscriptInfo)
+	transcript = append(transcript, sessionID...)
+	transcript = append(transcript, senderPub...)
+	return append(transcript, receiverPub...)
+}
+
+// WebChunkAAD is the additional data a web chunk is sealed with:
+// "<session>:<index>" for data chunks, "<session>:p<group>:<index>" for
+// parity
+func WebChunkAAD(metadata ChunkMetadata) []byte {
+	if metadata.Parity {
+		return []byte(metadata.SessionID + ":p" + strconv.Itoa(metadata.Group) + ":" + strconv.Itoa(metadata.Index))
+	}
+	return []byte(metadata.SessionID + ":" + strconv.Itoa(metadata.Index))
+}
+
+// chunkAAD returns the additional data chunks of the session must carry,
+// nil for device sessions
+func (t *TransferSession) chunkAAD(metadata ChunkMetadata) []byte {
+	if !t.web {
+		return nil
+	}
+	return WebChunkAAD(metadata)
+}
+
+func openWebChunk(ciphertext, key, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func (s *SecureServer) handleWebHandshake(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, span := telemetry.StartSpan(telemetry.ExtractHTTP(r), "airdrop.receive.web_handshake")
+	defer span.End()
+
+	var req WebHandshakeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	senderPub, err := ecdh.P256().NewPublicKey(req.EphemeralPubKey)
+	if err != nil {
+		http.Error(w, "Invalid public key", http.StatusBadRequest)
+		return
+	}
+
+	// A web sender is identified only by its ephemeral key, so it is
+	// always asked about
+	fingerprint := "web:" + CalculateChunkChecksum(req.EphemeralPubKey)[:16]
+	request := HandshakeRequest{DeviceName: req.DeviceName, DeviceFingerprint: fingerprint}
+	span.SetAttributes(telemetry.AttrPeer.String(fingerprint))
+	log.Printf("Web handshake from: %s (%s)", logging.DeviceName(req.DeviceName), logging.Fingerprint(fingerprint))
+
+	if !s.ask(r, prompt.KindDevice, request, map[string]string{"web": "true"}).Accept {
+		span.AddEvent("declined")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(WebHandshakeResponse{Accepted: false, Message: "Connection declined"})
+		return
+	}
+
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		http.Error(w, "Failed to generate key", http.StatusInternalServerError)
+		return
+	}
+	shared, err := priv.ECDH(senderPub)
+	if err != nil {
+		http.Error(w, "Failed to derive session key", http.StatusInternalServerError)
+		return
+	}
+	receiverPub := priv.PublicKey().Bytes()
+	sessionKey, err := DeriveWebSessionKey(shared, req.EphemeralPubKey, receiverPub)
+	if err != nil {
+		http.Error(w, "Failed to derive session key", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := uuid.New().String()
+	now := time.Now()
+	session := &TransferSession{
+		SessionID:      sessionID,
+		SenderName:     req.DeviceName,
+		Fingerprint:    fingerprint,
+		SessionKey:     sessionKey,
+		ReceivedChunks: make(map[int]bool),
+		request:        request,
+		lastActive:     now,
+		startedAt:      now,
+		rateSince:      now,
+		web:            true,
+	}
+	s.mu.Lock()
+	s.sessions[sessionID] = session
+	s.mu.Unlock()
+
+	resp := WebHandshakeResponse{
+		Accepted:        true,
+		SessionID:       sessionID,
+		EphemeralPubKey: receiverPub,
+		Fingerprint:     s.identity.Fingerprint,
+		PublicKey:       s.identity.PublicKey,
+		Signature:       s.identity.Sign(WebTranscript(sessionID, req.EphemeralPubKey, receiverPub)),
+		ChunkSize:       ChunkSize,
+		Message:         "Key agreement complete",
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+
+	span.SetAttributes(telemetry.AttrSessionID.String(sessionID))
+	log.Printf("Web session created: %s", sessionID)
+}
+
+// cors lets the allowed web origins call the server, answering their
+// preflight requests
+func (s *SecureServer) cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(slices.Contains(s.webOrigins, origin) || slices.Contains(s.webOrigins, "*")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Chunk-Metadata")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
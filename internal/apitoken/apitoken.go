@@ -0,0 +1,231 @@
+// Package apitoken issues and checks scoped bearer tokens for the daemon
+// API, so a client such as a dashboard widget gets only the access it
+// needs: it can read status without also being able to unpair devices or
+// unlock vaults.
+package apitoken
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/audit"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Scopes a token can hold
+const (
+	ScopeStatus   = "status"   // Read-only status, history and progress
+	ScopeTransfer = "transfer" // Start, pause and cancel transfers
+	ScopeAdmin    = "admin"    // Everything, including pairing and unlocking containers
+)
+
+// Audit actions
+const (
+	AuditIssued  = "api_token.issued"
+	AuditRevoked = "api_token.revoked"
+)
+
+const auditActor = "local"
+
+// tokenPrefix marks SFM tokens so they are recognizable in config files
+// and secret scanners
+const tokenPrefix = "sfm_"
+
+var (
+	ErrInvalidToken = errors.New("invalid API token")
+	ErrExpired      = errors.New("API token has expired")
+	ErrRevoked      = errors.New("API token has been revoked")
+	ErrForbidden    = errors.New("API token lacks the required scope")
+)
+
+var allScopes = []string{ScopeStatus, ScopeTransfer, ScopeAdmin}
+
+// Token describes an issued token, without the secret
+type Token struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+	LastUsed  time.Time `json:"last_used,omitzero"`
+}
+
+// Allows reports whether the token grants scope. Admin grants every scope.
+func (t *Token) Allows(scope string) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// Issue creates a token named name holding scopes, valid for ttl or
+// forever if ttl is 0. The secret is returned only here.
+func Issue(name string, scopes []string, ttl time.Duration) (string, *Token, error) {
+	if name == "" {
+		return "", nil, fmt.Errorf("token needs a name")
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("token needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(allScopes, scope) {
+			return "", nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	encoded, err := json.Marshal(scopes)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode scopes: %w", err)
+	}
+	record := models.APIToken{
+		Name:   name,
+		Hash:   hashToken(token),
+		Scopes: string(encoded),
+	}
+	if ttl > 0 {
+		record.ExpiresAt = time.Now().Add(ttl)
+	}
+	if err := storage.DB().Create(&record).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to save token: %w", err)
+	}
+
+	recordAudit(AuditIssued, name, fmt.Sprintf("token %d with scopes %s", record.ID, strings.Join(scopes, ",")))
+	return token, toToken(record), nil
+}
+
+// Authorize checks that token is valid and grants scope, and returns it
+func Authorize(token, scope string) (*Token, error) {
+	if !strings.HasPrefix(token, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	db := storage.DB()
+	var record models.APIToken
+	err := db.Where("hash = ?", hashToken(token)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token: %w", err)
+	}
+
+	if !record.RevokedAt.IsZero() {
+		return nil, ErrRevoked
+	}
+	now := time.Now()
+	if !record.ExpiresAt.IsZero() && now.After(record.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	t := toToken(record)
+	if !t.Allows(scope) {
+		return nil, ErrForbidden
+	}
+
+	db.Model(&record).UpdateColumn("last_used", now)
+	t.LastUsed = now
+	return t, nil
+}
+
+// Revoke revokes a token at once. Revoked tokens are kept so they show in
+// List until deleted.
+func Revoke(id uint) error {
+	var record models.APIToken
+	if err := storage.DB().First(&record, id).Error; err != nil {
+		return fmt.Errorf("failed to load token: %w", err)
+	}
+	if !record.RevokedAt.IsZero() {
+		return nil
+	}
+	if err := storage.DB().Model(&record).Update("revoked_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	recordAudit(AuditRevoked, record.Name, fmt.Sprintf("token %d", record.ID))
+	return nil
+}
+
+// Delete removes a token record
+func Delete(id uint) error {
+	if err := storage.DB().Delete(&models.APIToken{}, id).Error; err != nil {
+		return fmt.Errorf("failed to delete token: %w", err)
+	}
+	return nil
+}
+
+// List returns every token, newest first
+func List() ([]Token, error) {
+	var records []models.APIToken
+	if err := storage.DB().Order("created_at DESC").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	tokens := make([]Token, len(records))
+	for i, record := range records {
+		tokens[i] = *toToken(record)
+	}
+	return tokens, nil
+}
+
+// Require wraps an API handler so it only runs for requests bearing a
+// token that grants scope
+func Require(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sfm"`)
+			http.Error(w, "missing API token", http.StatusUnauthorized)
+			return
+		}
+		_, err := Authorize(strings.TrimSpace(token), scope)
+		switch {
+		case err == nil:
+			next.ServeHTTP(w, r)
+		case errors.Is(err, ErrForbidden):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrExpired), errors.Is(err, ErrRevoked):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sfm", error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		default:
+			log.Printf("Failed to check API token: %v", err)
+			http.Error(w, "failed to check API token", http.StatusInternalServerError)
+		}
+	})
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func toToken(record models.APIToken) *Token {
+	var scopes []string
+	json.Unmarshal([]byte(record.Scopes), &scopes)
+	return &Token{
+		ID:        record.ID,
+		Name:      record.Name,
+		Scopes:    scopes,
+		CreatedAt: record.CreatedAt,
+		ExpiresAt: record.ExpiresAt,
+		RevokedAt: record.RevokedAt,
+		LastUsed:  record.LastUsed,
+	}
+}
+
+func recordAudit(action, target, detail string) {
+	if err := audit.Record(action, auditActor, target, detail); err != nil {
+		log.Printf("Failed to audit %s: %v", action, err)
+	}
+}
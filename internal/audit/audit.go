@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// Record appends an event to the audit log
+func Record(action, actor, target, detail string) error {
+	event := models.AuditEvent{
+		Action: action,
+		Actor:  actor,
+		Target: target,
+		Detail: detail,
+	}
+	if err := storage.DB().Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// Events returns up to limit events since a time, newest first. An empty
+// action returns events of every action.
+func Events(action string, since time.Time, limit int) ([]models.AuditEvent, error) {
+	query := storage.DB().Where("created_at >= ?", since)
+	if action != "" {
+		query = query.Where("action = ?", action)
+	}
+	var events []models.AuditEvent
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/power"
+	"github.com/owner/secure-file-manager/internal/sched"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// BackupStats summarizes a backup run
+type BackupStats struct {
+	Files          int
+	Dirs           int
+	UnchangedFiles int   // Reused from the parent snapshot without reading
+	BytesRead      int64 // File data read and chunked
+	BytesAdded     int64 // New encrypted data written to the repository
+	NewChunks      int
+	Duration       time.Duration
+}
+
+// Backup snapshots the directory source. Files whose size and modification
+// time match the previous snapshot of the same source are not re-read. It
+// waits until the machine is plugged in and idle unless backups are ungated,
+// then runs at the encryption job's scheduling class.
+func (r *Repository) Backup(ctx context.Context, source string, tags []string) (snap *Snapshot, stats BackupStats, err error) {
+	if err := power.Wait(ctx, power.JobBackup); err != nil {
+		return nil, stats, err
+	}
+	sched.Run(sched.JobEncryption, func() {
+		snap, stats, err = r.backup(ctx, source, tags)
+	})
+	return snap, stats, err
+}
+
+func (r *Repository) backup(ctx context.Context, source string, tags []string) (snap *Snapshot, stats BackupStats, err error) {
+	start := time.Now()
+
+	lock, err := r.lock(false)
+	if err != nil {
+		return nil, stats, err
+	}
+	defer lock.unlock()
+
+	ctx, span := telemetry.StartSpan(ctx, "backup.snapshot")
+	defer func() {
+		span.SetAttributes(
+			telemetry.AttrFiles.Int(stats.Files),
+			telemetry.AttrBytes.Int64(stats.BytesAdded),
+			telemetry.AttrChunks.Int(stats.NewChunks),
+		)
+		telemetry.EndSpan(span, err)
+	}()
+
+	source, err = filepath.Abs(source)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to resolve source: %w", err)
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to stat source: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, stats, fmt.Errorf("source must be a directory: %s", source)
+	}
+
+	parent, err := r.latestFor(source)
+	if err != nil {
+		return nil, stats, err
+	}
+	previous := make(map[string]Node)
+	if parent != nil {
+		for _, node := range parent.Nodes {
+			previous[node.Path] = node
+		}
+	}
+
+	id, err := newSnapshotID()
+	if err != nil {
+		return nil, stats, err
+	}
+	hostname, _ := os.Hostname()
+
+	snap = &Snapshot{
+		ID:       id,
+		Time:     time.Now().UTC(),
+		Source:   source,
+		Hostname: hostname,
+		Tags:     tags,
+	}
+	if parent != nil {
+		snap.Parent = parent.ID
+	}
+
+	err = filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		node := Node{
+			Path:    filepath.ToSlash(rel),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+
+		switch {
+		case info.IsDir():
+			node.Type = NodeDir
+			stats.Dirs++
+
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", path, err)
+			}
+			node.Type = NodeSymlink
+			node.Target = target
+
+		case info.Mode().IsRegular():
+			node.Type = NodeFile
+			node.Size = info.Size()
+			stats.Files++
+
+			if old, ok := previous[node.Path]; ok && old.Type == NodeFile &&
+				old.Size == node.Size && old.ModTime.Equal(node.ModTime) {
+				node.Chunks = old.Chunks
+				stats.UnchangedFiles++
+				break
+			}
+
+			chunks, err := r.backupFile(path, info.Size(), &stats)
+			if err != nil {
+				return err
+			}
+			node.Chunks = chunks
+
+		default:
+			// Sockets, devices and pipes are skipped
+			return nil
+		}
+
+		snap.Nodes = append(snap.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		return nil, stats, fmt.Errorf("backup failed: %w", err)
+	}
+
+	if err := r.saveSnapshot(snap); err != nil {
+		return nil, stats, err
+	}
+
+	storage.DB().Create(&models.BackupRun{
+		Source:     source,
+		Repository: r.path,
+		SnapshotID: snap.ID,
+		Files:      stats.Files,
+		BytesAdded: stats.BytesAdded,
+	})
+
+	stats.Duration = time.Since(start)
+	return snap, stats, nil
+}
+
+// backupFile chunks a file into the repository and returns its chunk IDs
+func (r *Repository) backupFile(path string, size int64, stats *BackupStats) ([]string, error) {
+	// Worst case every chunk is new
+	reservation, err := diskspace.Reserve(r.path, size)
+	if err != nil {
+		return nil, err
+	}
+	defer reservation.Release()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var chunks []string
+	c := newChunker(file)
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		id, written, err := r.saveChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		if written > 0 {
+			stats.NewChunks++
+			stats.BytesAdded += written
+		}
+		stats.BytesRead += int64(len(data))
+		chunks = append(chunks, id)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// Content-defined chunking parameters. Boundaries depend only on content,
+// so an insertion early in a file does not shift every later chunk.
+const (
+	minChunkSize = 512 * 1024
+	maxChunkSize = 8 * 1024 * 1024
+	chunkMask    = 1<<20 - 1 // ~1MB average
+)
+
+// gearTable is derived deterministically so chunk boundaries are stable
+// across versions and platforms
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{'s', 'f', 'm', byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// chunker splits a stream into content-defined chunks
+type chunker struct {
+	reader *bufio.Reader
+	buf    []byte
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		reader: bufio.NewReaderSize(r, 1024*1024),
+		buf:    make([]byte, 0, maxChunkSize),
+	}
+}
+
+// Next returns the next chunk or io.EOF. The returned slice is only valid
+// until the next call.
+func (c *chunker) Next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+
+	for len(c.buf) < maxChunkSize {
+		b, err := c.reader.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c.buf = append(c.buf, b)
+		hash = hash<<1 + gearTable[b]
+		if len(c.buf) >= minChunkSize && hash&chunkMask == 0 {
+			break
+		}
+	}
+
+	if len(c.buf) == 0 {
+		return nil, io.EOF
+	}
+	return c.buf, nil
+}
@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GCReport summarizes a garbage collection run
+type GCReport struct {
+	DryRun             bool
+	Snapshots          int
+	StoredChunks       int
+	ReferencedChunks   int
+	UnreferencedChunks int // Chunks no snapshot references, deleted unless DryRun
+	UnreferencedBytes  int64
+	Removed            int // Chunks and leftover temp files deleted
+	Reclaimed          int64
+	MissingChunks      []string // Referenced by a snapshot but not stored
+	Duration           time.Duration
+}
+
+func (r GCReport) String() string {
+	s := fmt.Sprintf("%d snapshots reference %d of %d chunks, %d unreferenced (%d bytes)",
+		r.Snapshots, r.ReferencedChunks, r.StoredChunks, r.UnreferencedChunks, r.UnreferencedBytes)
+	if r.DryRun {
+		s += ", nothing removed (dry run)"
+	} else {
+		s += fmt.Sprintf(", removed %d files (%d bytes)", r.Removed, r.Reclaimed)
+	}
+	if len(r.MissingChunks) > 0 {
+		s += fmt.Sprintf(", %d chunks missing", len(r.MissingChunks))
+	}
+	return s
+}
+
+// RefCounts returns how many file references each chunk has across all
+// snapshots. A chunk shared by two files, or by the same file in two
+// snapshots, counts twice.
+func (r *Repository) RefCounts() (map[string]int, error) {
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	return refCounts(snapshots), nil
+}
+
+// Reclaimable returns the number of chunks, and their stored size, that
+// forgetting the given snapshots would let GC delete
+func (r *Repository) Reclaimable(ids ...string) (int, int64, error) {
+	counts, err := r.RefCounts()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, id := range ids {
+		snap, err := r.FindSnapshot(id)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, node := range snap.Nodes {
+			for _, chunk := range node.Chunks {
+				counts[chunk]--
+			}
+		}
+	}
+
+	chunks := 0
+	var size int64
+	for id, count := range counts {
+		if count > 0 {
+			continue
+		}
+		chunks++
+		if info, err := os.Stat(r.chunkPath(id)); err == nil {
+			size += info.Size()
+		}
+	}
+	return chunks, size, nil
+}
+
+// GC deletes chunks no snapshot references, along with temp files left by
+// interrupted writes. It takes an exclusive lock, so it fails with
+// ErrRepositoryLocked while a backup or restore is running and makes them
+// wait for it. A dry run only reports and takes no lock, so it may count
+// chunks of a backup in progress as unreferenced.
+func (r *Repository) GC(ctx context.Context, dryRun bool) (*GCReport, error) {
+	start := time.Now()
+	if !dryRun {
+		lock, err := r.lock(true)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.unlock()
+	}
+
+	// Mark
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	counts := refCounts(snapshots)
+	report := &GCReport{DryRun: dryRun, Snapshots: len(snapshots)}
+
+	// Sweep
+	seen := make(map[string]bool, len(counts))
+	err = filepath.Walk(filepath.Join(r.path, "data"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		name := info.Name()
+		temp := strings.HasPrefix(name, ".")
+		if !temp {
+			report.StoredChunks++
+			if counts[name] > 0 {
+				report.ReferencedChunks++
+				seen[name] = true
+				return nil
+			}
+			report.UnreferencedChunks++
+			report.UnreferencedBytes += info.Size()
+		}
+		if dryRun {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove chunk: %w", err)
+		}
+		report.Removed++
+		report.Reclaimed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("garbage collection failed: %w", err)
+	}
+
+	for id := range counts {
+		if !seen[id] {
+			report.MissingChunks = append(report.MissingChunks, id)
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+func refCounts(snapshots []*Snapshot) map[string]int {
+	counts := make(map[string]int)
+	for _, snap := range snapshots {
+		for _, node := range snap.Nodes {
+			for _, id := range node.Chunks {
+				counts[id]++
+			}
+		}
+	}
+	return counts
+}
@@ -0,0 +1,139 @@
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A lock is a file under <repo>/locks whose holder refreshes its
+// modification time while it runs. Backups and restores take shared locks
+// and garbage collection an exclusive one, so chunks are never deleted
+// while something may still reference them. A lock not refreshed within
+// staleLockAge was left by a crashed process and is ignored.
+const (
+	lockRefreshInterval = 5 * time.Minute
+	staleLockAge        = 30 * time.Minute
+)
+
+var ErrRepositoryLocked = errors.New("repository is locked")
+
+type lockInfo struct {
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	PID       int       `json:"pid"`
+	Time      time.Time `json:"time"`
+}
+
+type repoLock struct {
+	path string
+	stop chan struct{}
+	done chan struct{}
+}
+
+// lock takes a shared or exclusive lock on the repository, failing with
+// ErrRepositoryLocked if a conflicting lock is held
+func (r *Repository) lock(exclusive bool) (*repoLock, error) {
+	dir := filepath.Join(r.path, "locks")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate lock ID: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(lockInfo{
+		Exclusive: exclusive,
+		Hostname:  hostname,
+		PID:       os.Getpid(),
+		Time:      time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, hex.EncodeToString(id))
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, fmt.Errorf("failed to write lock: %w", err)
+	}
+
+	// Written before checking, so of two processes locking at once at least
+	// one sees the other
+	if err := checkLocks(dir, path, exclusive); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	l := &repoLock{path: path, stop: make(chan struct{}), done: make(chan struct{})}
+	go l.refresh()
+	return l, nil
+}
+
+// checkLocks returns an error if a live lock other than own conflicts
+// with taking a lock of the given kind. Stale locks are removed.
+func checkLocks(dir, own string, exclusive bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list locks: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || path == own {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(path)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var holder lockInfo
+		if err := json.Unmarshal(data, &holder); err != nil {
+			continue
+		}
+		if exclusive || holder.Exclusive {
+			kind := "shared"
+			if holder.Exclusive {
+				kind = "exclusive"
+			}
+			return fmt.Errorf("%w: %s lock held by %s (pid %d) since %s",
+				ErrRepositoryLocked, kind, holder.Hostname, holder.PID, holder.Time.Local().Format(time.DateTime))
+		}
+	}
+	return nil
+}
+
+func (l *repoLock) refresh() {
+	defer close(l.done)
+	ticker := time.NewTicker(lockRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// unlock releases the lock
+func (l *repoLock) unlock() {
+	close(l.stop)
+	<-l.done
+	os.Remove(l.path)
+}
@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/owner/secure-file-manager/internal/crypto"
+)
+
+const repoVersion = 1
+
+var (
+	ErrRepositoryExists  = errors.New("repository already exists")
+	ErrNotRepository     = errors.New("not a backup repository")
+	ErrWrongPassword     = errors.New("wrong repository password")
+	ErrCorruptChunk      = errors.New("chunk content does not match its ID")
+	ErrSnapshotNotFound  = errors.New("snapshot not found")
+	ErrAmbiguousSnapshot = errors.New("snapshot ID prefix is ambiguous")
+)
+
+// repoConfig is stored unencrypted in <repo>/config. The master key is
+// wrapped with a key derived from the password so the password can be
+// changed without rewriting data.
+type repoConfig struct {
+	Version       int    `json:"version"`
+	Salt          []byte `json:"salt"`
+	Argon2Time    uint32 `json:"argon2_time"`
+	Argon2Memory  uint32 `json:"argon2_memory"`
+	Argon2Threads uint8  `json:"argon2_threads"`
+	MasterKey     []byte `json:"master_key"` // Encrypted encryption key + ID key
+}
+
+// Repository is a deduplicated, encrypted snapshot store:
+//
+//	config                  password-wrapped master key
+//	data/<id[:2]>/<id>      encrypted chunks, id = HMAC-SHA256(plaintext)
+//	snapshots/<id>          encrypted snapshot manifests
+type Repository struct {
+	path   string
+	encKey []byte
+	idKey  []byte
+}
+
+// InitRepository creates a new repository at path
+func InitRepository(path, password string, argon2Time, argon2Memory uint32, argon2Threads uint8) (*Repository, error) {
+	configPath := filepath.Join(path, "config")
+	if _, err := os.Stat(configPath); err == nil {
+		return nil, ErrRepositoryExists
+	}
+
+	for _, dir := range []string{path, filepath.Join(path, "data"), filepath.Join(path, "snapshots")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create repository: %w", err)
+		}
+	}
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+
+	master := make([]byte, 2*crypto.KeySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %w", err)
+	}
+
+	wrapped, err := crypto.Encrypt(master, crypto.DeriveKey(password, salt, argon2Time, argon2Memory, argon2Threads))
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := repoConfig{
+		Version:       repoVersion,
+		Salt:          salt,
+		Argon2Time:    argon2Time,
+		Argon2Memory:  argon2Memory,
+		Argon2Threads: argon2Threads,
+		MasterKey:     wrapped,
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(configPath, data); err != nil {
+		return nil, fmt.Errorf("failed to write repository config: %w", err)
+	}
+
+	return newRepository(path, master), nil
+}
+
+// OpenRepository opens an existing repository
+func OpenRepository(path, password string) (*Repository, error) {
+	data, err := os.ReadFile(filepath.Join(path, "config"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotRepository
+		}
+		return nil, fmt.Errorf("failed to read repository config: %w", err)
+	}
+
+	var cfg repoConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, ErrNotRepository
+	}
+	if cfg.Version != repoVersion {
+		return nil, fmt.Errorf("unsupported repository version %d", cfg.Version)
+	}
+
+	key := crypto.DeriveKey(password, cfg.Salt, cfg.Argon2Time, cfg.Argon2Memory, cfg.Argon2Threads)
+	master, err := crypto.Decrypt(cfg.MasterKey, key)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	if len(master) != 2*crypto.KeySize {
+		return nil, ErrNotRepository
+	}
+
+	return newRepository(path, master), nil
+}
+
+func newRepository(path string, master []byte) *Repository {
+	return &Repository{
+		path:   path,
+		encKey: master[:crypto.KeySize],
+		idKey:  master[crypto.KeySize:],
+	}
+}
+
+// Path returns the repository directory
+func (r *Repository) Path() string {
+	return r.path
+}
+
+// chunkID is keyed so chunk names do not reveal plaintext hashes
+func (r *Repository) chunkID(data []byte) string {
+	mac := hmac.New(sha256.New, r.idKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (r *Repository) chunkPath(id string) string {
+	return filepath.Join(r.path, "data", id[:2], id)
+}
+
+func (r *Repository) hasChunk(id string) bool {
+	_, err := os.Stat(r.chunkPath(id))
+	return err == nil
+}
+
+// saveChunk stores data if not already present. It returns the chunk ID
+// and the number of bytes written to the repository.
+func (r *Repository) saveChunk(data []byte) (string, int64, error) {
+	id := r.chunkID(data)
+	if r.hasChunk(id) {
+		return id, 0, nil
+	}
+
+	encrypted, err := crypto.Encrypt(data, r.encKey)
+	if err != nil {
+		return "", 0, err
+	}
+
+	path := r.chunkPath(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", 0, fmt.Errorf("failed to create chunk directory: %w", err)
+	}
+	if err := writeFileAtomic(path, encrypted); err != nil {
+		return "", 0, fmt.Errorf("failed to write chunk: %w", err)
+	}
+	return id, int64(len(encrypted)), nil
+}
+
+// loadChunk reads, decrypts and verifies a chunk
+func (r *Repository) loadChunk(id string) ([]byte, error) {
+	if len(id) < 2 {
+		return nil, ErrCorruptChunk
+	}
+
+	encrypted, err := os.ReadFile(r.chunkPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk %s: %w", id, err)
+	}
+
+	data, err := crypto.Decrypt(encrypted, r.encKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %s: %w", id, err)
+	}
+	if !hmac.Equal([]byte(r.chunkID(data)), []byte(id)) {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptChunk, id)
+	}
+	return data, nil
+}
+
+// writeFileAtomic writes via a temp file so interrupted runs never leave
+// truncated chunks or manifests behind
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
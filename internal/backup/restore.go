@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+)
+
+// Restore writes the contents of a snapshot into target. If include is
+// non-empty only nodes under that slash-separated path are restored.
+func (r *Repository) Restore(ctx context.Context, snap *Snapshot, target, include string) (err error) {
+	lock, err := r.lock(false)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	ctx, span := telemetry.StartSpan(ctx, "backup.restore")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	include = strings.Trim(include, "/")
+
+	var total int64
+	var nodes []Node
+	for _, node := range snap.Nodes {
+		if include != "" && node.Path != include && !strings.HasPrefix(node.Path, include+"/") {
+			continue
+		}
+		nodes = append(nodes, node)
+		total += node.Size
+	}
+	if include != "" && len(nodes) == 0 {
+		return fmt.Errorf("path not found in snapshot: %s", include)
+	}
+
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("failed to create target: %w", err)
+	}
+
+	reservation, err := diskspace.Reserve(target, total)
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
+
+	var dirs []Node
+	for _, node := range nodes {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		path, err := restorePath(target, node.Path)
+		if err != nil {
+			return err
+		}
+
+		switch node.Type {
+		case NodeDir:
+			if err := os.MkdirAll(path, 0700); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			dirs = append(dirs, node)
+
+		case NodeSymlink:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			os.Remove(path)
+			if err := os.Symlink(node.Target, path); err != nil {
+				return fmt.Errorf("failed to restore symlink %s: %w", node.Path, err)
+			}
+
+		case NodeFile:
+			if err := r.restoreFile(path, node); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Directory modes and times last, writing files changes them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path, _ := restorePath(target, dirs[i].Path)
+		os.Chmod(path, dirs[i].Mode.Perm())
+		os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime)
+	}
+
+	return nil
+}
+
+func (r *Repository) restoreFile(path string, node Node) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, id := range node.Chunks {
+		data, err := r.loadChunk(id)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to restore %s: %w", node.Path, err)
+		}
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write %s: %w", node.Path, err)
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", node.Path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", node.Path, err)
+	}
+	os.Chmod(path, node.Mode.Perm())
+	os.Chtimes(path, node.ModTime, node.ModTime)
+	return nil
+}
+
+// restorePath maps a snapshot path into target, rejecting escapes
+func restorePath(target, rel string) (string, error) {
+	path := filepath.Join(target, filepath.FromSlash(rel))
+	check, err := filepath.Rel(target, path)
+	if err != nil || check == ".." || strings.HasPrefix(check, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path in snapshot: %s", rel)
+	}
+	return path, nil
+}
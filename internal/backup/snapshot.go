@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/crypto"
+)
+
+// Node types
+const (
+	NodeFile    = "file"
+	NodeDir     = "dir"
+	NodeSymlink = "symlink"
+)
+
+// Node is one entry of a snapshot, keyed by its slash-separated path
+// relative to the backup source
+type Node struct {
+	Path    string      `json:"path"`
+	Type    string      `json:"type"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+	Size    int64       `json:"size,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+	Target  string      `json:"target,omitempty"` // Symlink target
+}
+
+// Snapshot is a point-in-time manifest of a backed up directory
+type Snapshot struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Hostname string    `json:"hostname"`
+	Tags     []string  `json:"tags,omitempty"`
+	Parent   string    `json:"parent,omitempty"`
+	Nodes    []Node    `json:"nodes"`
+}
+
+// ShortID returns the first 8 characters of the snapshot ID
+func (s *Snapshot) ShortID() string {
+	if len(s.ID) > 8 {
+		return s.ID[:8]
+	}
+	return s.ID
+}
+
+// Size returns the total size of the files in the snapshot
+func (s *Snapshot) Size() int64 {
+	var total int64
+	for _, n := range s.Nodes {
+		total += n.Size
+	}
+	return total
+}
+
+func newSnapshotID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate snapshot ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (r *Repository) saveSnapshot(snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	encrypted, err := crypto.Encrypt(data, r.encKey)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filepath.Join(r.path, "snapshots", snap.ID), encrypted); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot loads a snapshot by its full ID
+func (r *Repository) LoadSnapshot(id string) (*Snapshot, error) {
+	encrypted, err := os.ReadFile(filepath.Join(r.path, "snapshots", filepath.Base(id)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrSnapshotNotFound
+		}
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	data, err := crypto.Decrypt(encrypted, r.encKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot %s: %w", id, err)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", id, err)
+	}
+	return &snap, nil
+}
+
+// Snapshots returns all snapshots, oldest first
+func (r *Repository) Snapshots() ([]*Snapshot, error) {
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]*Snapshot, 0, len(ids))
+	for _, id := range ids {
+		snap, err := r.LoadSnapshot(id)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snap)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+func (r *Repository) snapshotIDs() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.path, "snapshots"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// FindSnapshot resolves a unique ID prefix, or "latest"
+func (r *Repository) FindSnapshot(prefix string) (*Snapshot, error) {
+	if prefix == "latest" {
+		snapshots, err := r.Snapshots()
+		if err != nil {
+			return nil, err
+		}
+		if len(snapshots) == 0 {
+			return nil, ErrSnapshotNotFound
+		}
+		return snapshots[len(snapshots)-1], nil
+	}
+
+	ids, err := r.snapshotIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	match := ""
+	for _, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			if match != "" {
+				return nil, ErrAmbiguousSnapshot
+			}
+			match = id
+		}
+	}
+	if match == "" {
+		return nil, ErrSnapshotNotFound
+	}
+	return r.LoadSnapshot(match)
+}
+
+// latestFor returns the newest snapshot of source, or nil
+func (r *Repository) latestFor(source string) (*Snapshot, error) {
+	snapshots, err := r.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].Source == source {
+			return snapshots[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Forget removes a snapshot manifest. Its chunks are reclaimed by Prune.
+func (r *Repository) Forget(id string) error {
+	snap, err := r.FindSnapshot(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(r.path, "snapshots", snap.ID)); err != nil {
+		return fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	return nil
+}
+
+// Prune deletes chunks no longer referenced by any snapshot and returns
+// the number of chunks and bytes reclaimed. See GC.
+func (r *Repository) Prune() (int, int64, error) {
+	report, err := r.GC(context.Background(), false)
+	if err != nil {
+		return 0, 0, err
+	}
+	return report.Removed, report.Reclaimed, nil
+}
@@ -0,0 +1,182 @@
+// Package bandwidth shares a bandwidth limit fairly between the transfers
+// running at once, so one fast peer cannot starve the others. Each
+// transfer is a flow of a class; flows that are moving data split the
+// limit in proportion to their class weights, and a flow that goes quiet
+// leaves its share to the rest.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	gosync "sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/settings"
+)
+
+// Classes of transfer
+const (
+	ClassAirDrop = "airdrop"
+	ClassSync    = "sync"
+)
+
+const (
+	// activeWindow is how long after its last data a flow still counts
+	// toward the split
+	activeWindow = time.Second
+
+	// burstWindow is how much unused share a flow may save up
+	burstWindow = 250 * time.Millisecond
+)
+
+// Scheduler splits a limit between flows
+type Scheduler struct {
+	mu      gosync.Mutex
+	limit   int64 // Bytes per second, 0 for no limit
+	weights map[string]int
+	active  map[*Flow]bool
+}
+
+// Flow is one transfer's use of the limit
+type Flow struct {
+	s      *Scheduler
+	weight int
+	next   time.Time // When the data already allowed has been paid for
+}
+
+// NewScheduler creates a scheduler without a limit
+func NewScheduler() *Scheduler {
+	return &Scheduler{weights: map[string]int{}, active: map[*Flow]bool{}}
+}
+
+var shared = NewScheduler()
+
+// SetLimit sets the bandwidth shared by all transfers in bytes per
+// second, 0 for no limit
+func (s *Scheduler) SetLimit(bytesPerSecond int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = max(bytesPerSecond, 0)
+}
+
+// SetWeights sets the weight of each class; classes not listed weigh 1
+func (s *Scheduler) SetWeights(weights map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.weights = make(map[string]int, len(weights))
+	for class, weight := range weights {
+		if weight > 0 {
+			s.weights[class] = weight
+		}
+	}
+}
+
+// Open starts a flow of class. Its weight is fixed when it opens.
+func (s *Scheduler) Open(class string) *Flow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	weight := s.weights[class]
+	if weight <= 0 {
+		weight = 1
+	}
+	return &Flow{s: s, weight: weight}
+}
+
+// Wait blocks until the flow may move n more bytes, or ctx is done
+func (f *Flow) Wait(ctx context.Context, n int) error {
+	s := f.s
+	s.mu.Lock()
+	if s.limit == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	now := time.Now()
+	total := f.weight
+	for other := range s.active {
+		if other == f {
+			continue
+		}
+		if now.Sub(other.next) > activeWindow {
+			delete(s.active, other)
+			continue
+		}
+		total += other.weight
+	}
+	s.active[f] = true
+
+	// Each flow runs its own clock at its share of the limit
+	share := float64(s.limit) * float64(f.weight) / float64(total)
+	if earliest := now.Add(-burstWindow); f.next.Before(earliest) {
+		f.next = earliest
+	}
+	f.next = f.next.Add(time.Duration(float64(n) / share * float64(time.Second)))
+	wait := f.next.Sub(now)
+	s.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Close ends the flow, handing its share to the others at once
+func (f *Flow) Close() {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	delete(f.s.active, f)
+}
+
+// Reader returns a reader that waits for the flow after every read
+func (f *Flow) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &flowReader{ctx: ctx, flow: f, r: r}
+}
+
+type flowReader struct {
+	ctx  context.Context
+	flow *Flow
+	r    io.Reader
+}
+
+func (fr *flowReader) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if n > 0 {
+		if waitErr := fr.flow.Wait(fr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// SetLimit sets the limit shared by all transfers
+func SetLimit(bytesPerSecond int64) {
+	shared.SetLimit(bytesPerSecond)
+}
+
+// SetWeights sets the class weights of all transfers
+func SetWeights(weights map[string]int) {
+	shared.SetWeights(weights)
+}
+
+// Open starts a flow sharing the limit of all transfers
+func Open(class string) *Flow {
+	return shared.Open(class)
+}
+
+// FollowSetting applies the transfer.bandwidth_limit setting now and
+// whenever it changes. The database must be open.
+func FollowSetting() {
+	SetLimit(settings.GetInt(settings.BandwidthLimit))
+	settings.Subscribe(settings.BandwidthLimit, func(_ string, value interface{}) {
+		if limit, ok := value.(int64); ok {
+			SetLimit(limit)
+		}
+	})
+}
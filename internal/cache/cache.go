@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+const evictBatch = 100
+
+// Cache stores data derived from files, such as thumbnails, previews and
+// OCR text, on disk under a size cap. Entries are tied to the content hash
+// of their source and dropped when it changes; the least recently used
+// entries are evicted when the cap is exceeded.
+//
+// Data derived from files inside a mounted container is only cached,
+// encrypted, while the cache holds the container's key (see
+// UnlockContainer).
+type Cache struct {
+	dir     string
+	maxSize int64
+	mu      sync.Mutex
+	size    int64
+	vaults  map[string]*vault // Unlocked containers by path
+}
+
+// New opens the cache in dir, keeping at most maxSize bytes
+func New(dir string, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	var size int64
+	if err := storage.DB().Model(&models.CacheEntry{}).Select("COALESCE(SUM(size), 0)").Scan(&size).Error; err != nil {
+		return nil, fmt.Errorf("failed to load cache size: %w", err)
+	}
+
+	c := &Cache{dir: dir, maxSize: maxSize, size: size}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.evict(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Size returns the bytes currently cached
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// Get returns the data of kind cached for source, if it was derived from
+// content with the given hash. An entry for other content is dropped.
+func (c *Cache) Get(kind, source, hash string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v := c.vaultFor(source)
+	if v != nil {
+		source, hash = v.mac(source), v.mac(hash)
+	}
+
+	db := storage.DB()
+	var entry models.CacheEntry
+	if err := db.Where("kind = ? AND source_path = ?", kind, source).First(&entry).Error; err != nil {
+		return nil, false
+	}
+	if entry.SourceHash != hash {
+		c.remove(&entry)
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.blobPath(kind, source))
+	if err == nil && v != nil {
+		data, err = v.open(kind, source, data)
+	}
+	if err != nil {
+		c.remove(&entry)
+		return nil, false
+	}
+	db.Model(&entry).Update("accessed_at", time.Now())
+	return data, true
+}
+
+// Put caches data of kind derived from source, whose content has the
+// given hash, replacing any previous entry
+func (c *Cache) Put(kind, source, hash string, data []byte) error {
+	if int64(len(data)) > c.maxSize {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var container string
+	if v := c.vaultFor(source); v != nil {
+		source, hash, container = v.mac(source), v.mac(hash), v.container
+		sealed, err := v.seal(kind, source, data)
+		if err != nil {
+			return err
+		}
+		data = sealed
+	} else if inLockedContainer(source) {
+		return nil
+	}
+
+	blob := c.blobPath(kind, source)
+	if err := os.MkdirAll(filepath.Dir(blob), 0700); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(blob), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), blob)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+
+	db := storage.DB()
+	entry := models.CacheEntry{Kind: kind, SourcePath: source}
+	if err := db.Where("kind = ? AND source_path = ?", kind, source).FirstOrInit(&entry).Error; err != nil {
+		return fmt.Errorf("failed to save cache entry: %w", err)
+	}
+	c.size -= entry.Size
+	entry.SourceHash = hash
+	entry.Container = container
+	entry.Size = int64(len(data))
+	entry.AccessedAt = time.Now()
+	if err := db.Save(&entry).Error; err != nil {
+		os.Remove(blob)
+		return fmt.Errorf("failed to save cache entry: %w", err)
+	}
+	c.size += entry.Size
+
+	return c.evict()
+}
+
+// Invalidate drops everything cached for path, or for anything below it
+// if it is a directory. Entries of an unlocked container are named by
+// keyed hashes, so a directory inside one drops all of the container's.
+func (c *Cache) Invalidate(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator))
+	query := storage.DB().Where(`source_path = ? OR source_path LIKE ? ESCAPE '\'`, path, prefix+"%")
+	for _, v := range c.vaults {
+		switch {
+		case within(v.mountPoint, path):
+			query = query.Or("container = ?", v.container)
+		case within(path, v.mountPoint):
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				query = query.Or("source_path = ?", v.mac(path))
+			} else {
+				query = query.Or("container = ?", v.container)
+			}
+		}
+	}
+	var entries []models.CacheEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to load cache entries: %w", err)
+	}
+	for i := range entries {
+		if err := c.remove(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Trim drops entries whose source file no longer exists and files in the
+// cache directory that no entry refers to. It returns the number of
+// entries and files removed.
+func (c *Cache) Trim() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	known := make(map[string]bool)
+	var batch []models.CacheEntry
+	result := storage.DB().FindInBatches(&batch, evictBatch, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			entry := &batch[i]
+			// The source of an encrypted entry is hidden; it goes with its container
+			source := entry.SourcePath
+			if entry.Container != "" {
+				source = entry.Container
+			}
+			if _, err := os.Stat(source); errors.Is(err, fs.ErrNotExist) {
+				if err := c.remove(entry); err != nil {
+					return err
+				}
+				removed++
+				continue
+			}
+			known[c.blobPath(entry.Kind, entry.SourcePath)] = true
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return removed, fmt.Errorf("failed to trim cache: %w", result.Error)
+	}
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || known[path] {
+			return err
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to trim cache: %w", err)
+	}
+	return removed, nil
+}
+
+// evict removes least recently used entries until the cache fits its cap.
+// The caller holds c.mu.
+func (c *Cache) evict() error {
+	for c.size > c.maxSize {
+		var oldest []models.CacheEntry
+		if err := storage.DB().Order("accessed_at").Limit(evictBatch).Find(&oldest).Error; err != nil {
+			return fmt.Errorf("failed to evict cache entries: %w", err)
+		}
+		if len(oldest) == 0 {
+			c.size = 0
+			return nil
+		}
+		for i := range oldest {
+			if c.size <= c.maxSize {
+				break
+			}
+			if err := c.remove(&oldest[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes an entry and its data. The caller holds c.mu.
+func (c *Cache) remove(entry *models.CacheEntry) error {
+	if err := os.Remove(c.blobPath(entry.Kind, entry.SourcePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove cache entry: %w", err)
+	}
+	if err := storage.DB().Delete(entry).Error; err != nil {
+		return fmt.Errorf("failed to remove cache entry: %w", err)
+	}
+	c.size -= entry.Size
+	return nil
+}
+
+// blobPath returns where the data of an entry is stored
+func (c *Cache) blobPath(kind, source string) string {
+	sum := sha256.Sum256([]byte(kind + "\x00" + source))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
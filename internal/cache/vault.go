@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// vault holds the keys for caching data derived from files inside an
+// unlocked container. Entries for such files name their source and its
+// hash by keyed hashes, and their data is encrypted, so the cache shows
+// nothing of what the container holds while it is locked.
+type vault struct {
+	container  string
+	mountPoint string
+	names      []byte // HMAC key for source paths and hashes
+	aead       cipher.AEAD
+}
+
+// UnlockContainer lets the cache store and serve data derived from files
+// under mountPoint, encrypted with a key derived from the container's data
+// key, until LockContainer is called
+func (c *Cache) UnlockContainer(containerPath, mountPoint string, key []byte) error {
+	names, err := hkdf.Key(sha256.New, key, nil, "sfm-cache-names", 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive cache key: %w", err)
+	}
+	dataKey, err := hkdf.Key(sha256.New, key, nil, "sfm-cache-data", 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive cache key: %w", err)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.vaults == nil {
+		c.vaults = make(map[string]*vault)
+	}
+	c.vaults[containerPath] = &vault{
+		container:  containerPath,
+		mountPoint: mountPoint,
+		names:      names,
+		aead:       aead,
+	}
+	return nil
+}
+
+// LockContainer stops serving data cached for the container's files. The
+// encrypted entries stay for the next unlock.
+func (c *Cache) LockContainer(containerPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if v, ok := c.vaults[containerPath]; ok {
+		clear(v.names)
+		delete(c.vaults, containerPath)
+	}
+}
+
+// vaultFor returns the unlocked container source lies in, or nil. The
+// caller holds c.mu.
+func (c *Cache) vaultFor(source string) *vault {
+	for _, v := range c.vaults {
+		if within(source, v.mountPoint) {
+			return v
+		}
+	}
+	return nil
+}
+
+// inLockedContainer reports whether source lies in a mounted container
+// the cache has no key for, whose derived data must not be cached in the
+// clear
+func inLockedContainer(source string) bool {
+	var containers []models.EncryptedContainer
+	if err := storage.DB().Where("is_mounted = ? AND mount_point <> ''", true).Find(&containers).Error; err != nil {
+		return true
+	}
+	for _, container := range containers {
+		if within(source, container.MountPoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// mac returns the keyed hash stored in place of a source path or hash
+func (v *vault) mac(s string) string {
+	h := hmac.New(sha256.New, v.names)
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// seal encrypts data bound to the entry it is stored under
+func (v *vault) seal(kind, name string, data []byte) ([]byte, error) {
+	nonce := make([]byte, v.aead.NonceSize(), v.aead.NonceSize()+len(data)+v.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return v.aead.Seal(nonce, nonce, data, []byte(kind+"\x00"+name)), nil
+}
+
+func (v *vault) open(kind, name string, sealed []byte) ([]byte, error) {
+	if len(sealed) < v.aead.NonceSize() {
+		return nil, fmt.Errorf("cache entry too short")
+	}
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+	return v.aead.Open(nil, nonce, ciphertext, []byte(kind+"\x00"+name))
+}
+
+// within reports whether path is dir or below it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
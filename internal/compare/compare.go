@@ -0,0 +1,225 @@
+package compare
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Difference reasons
+const (
+	ReasonType    = "type"
+	ReasonSize    = "size"
+	ReasonHash    = "hash"
+	ReasonModTime = "mtime"
+)
+
+// Entry describes one file or directory relative to a scanned root
+type Entry struct {
+	Path    string    `json:"path"` // Slash-separated, relative to the root
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash,omitempty"` // Hex SHA-256, only when hashing
+}
+
+// Options controls scanning and comparison
+type Options struct {
+	Hash             bool          // Compare content hashes, not just size and mtime
+	ModTimeTolerance time.Duration // FAT and some network filesystems round mtimes
+	Exclude          []string      // path.Match patterns against base names and relative paths
+}
+
+// Difference is a path present on both sides that does not match
+type Difference struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Left   Entry  `json:"left"`
+	Right  Entry  `json:"right"`
+}
+
+// Result is a tree-level diff between two sides
+type Result struct {
+	OnlyLeft  []Entry      `json:"only_left"`
+	OnlyRight []Entry      `json:"only_right"`
+	Differing []Difference `json:"differing"`
+	Identical int          `json:"identical"`
+}
+
+// Equal reports whether both sides match
+func (r *Result) Equal() bool {
+	return len(r.OnlyLeft) == 0 && len(r.OnlyRight) == 0 && len(r.Differing) == 0
+}
+
+// Scan lists every file and directory under root, sorted by path
+func Scan(ctx context.Context, root string, opts Options) ([]Entry, error) {
+	var entries []Entry
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if excluded(rel, opts.Exclude) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Symlinks, sockets and devices are not compared
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		entry := Entry{
+			Path:    rel,
+			IsDir:   info.IsDir(),
+			ModTime: info.ModTime().UTC(),
+		}
+		if !entry.IsDir {
+			entry.Size = info.Size()
+			if opts.Hash {
+				if entry.Hash, err = hashFile(p); err != nil {
+					return err
+				}
+			}
+		}
+
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", root, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// Dirs compares two local directories
+func Dirs(ctx context.Context, left, right string, opts Options) (*Result, error) {
+	leftEntries, err := Scan(ctx, left, opts)
+	if err != nil {
+		return nil, err
+	}
+	rightEntries, err := Scan(ctx, right, opts)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(leftEntries, rightEntries, opts), nil
+}
+
+// Diff compares two scans. Entries below a directory that exists on only
+// one side are reported individually so callers can act on each path.
+func Diff(left, right []Entry, opts Options) *Result {
+	rightByPath := make(map[string]Entry, len(right))
+	for _, e := range right {
+		rightByPath[e.Path] = e
+	}
+
+	result := &Result{}
+	seen := make(map[string]bool, len(left))
+
+	for _, l := range left {
+		seen[l.Path] = true
+
+		r, ok := rightByPath[l.Path]
+		if !ok {
+			result.OnlyLeft = append(result.OnlyLeft, l)
+			continue
+		}
+
+		if reason := differ(l, r, opts); reason != "" {
+			result.Differing = append(result.Differing, Difference{Path: l.Path, Reason: reason, Left: l, Right: r})
+		} else {
+			result.Identical++
+		}
+	}
+
+	for _, r := range right {
+		if !seen[r.Path] {
+			result.OnlyRight = append(result.OnlyRight, r)
+		}
+	}
+
+	return result
+}
+
+// differ returns why two entries for the same path do not match
+func differ(l, r Entry, opts Options) string {
+	if l.IsDir != r.IsDir {
+		return ReasonType
+	}
+	if l.IsDir {
+		return ""
+	}
+	if l.Size != r.Size {
+		return ReasonSize
+	}
+	if l.Hash != "" && r.Hash != "" {
+		// Same content is a match even if mtimes drifted
+		if l.Hash != r.Hash {
+			return ReasonHash
+		}
+		return ""
+	}
+
+	delta := l.ModTime.Sub(r.ModTime)
+	if delta < 0 {
+		delta = -delta
+	}
+	if delta > opts.ModTimeTolerance {
+		return ReasonModTime
+	}
+	return ""
+}
+
+func excluded(rel string, patterns []string) bool {
+	base := path.Base(rel)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, base); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(rel+"/", pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashFile(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -1,19 +1,45 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/location"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/onreceive"
+	"github.com/owner/secure-file-manager/internal/power"
+	"github.com/owner/secure-file-manager/internal/quarantine"
+	"github.com/owner/secure-file-manager/internal/sched"
+	"github.com/owner/secure-file-manager/internal/status"
+	"github.com/owner/secure-file-manager/internal/telemetry"
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Database DatabaseConfig `mapstructure:"database"`
-	Crypto   CryptoConfig   `mapstructure:"crypto"`
-	Search   SearchConfig   `mapstructure:"search"`
-	Sync     SyncConfig     `mapstructure:"sync"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	Crypto     CryptoConfig     `mapstructure:"crypto"`
+	Search     SearchConfig     `mapstructure:"search"`
+	Sync       SyncConfig       `mapstructure:"sync"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+	Settings   SettingsConfig   `mapstructure:"settings"`
+	Telemetry  TelemetryConfig  `mapstructure:"telemetry"`
+	Disk       DiskConfig       `mapstructure:"disk"`
+	Gateway    GatewayConfig    `mapstructure:"gateway"`
+	Status     StatusConfig     `mapstructure:"status"`
+	Cache      CacheConfig      `mapstructure:"cache"`
+	Scrub      ScrubConfig      `mapstructure:"scrub"`
+	Quarantine QuarantineConfig `mapstructure:"quarantine"`
+	Power      PowerConfig      `mapstructure:"power"`
+	Scheduling SchedulingConfig `mapstructure:"scheduling"`
+	OnReceive  OnReceiveConfig  `mapstructure:"on_receive"`
+	Offsite    OffsiteConfig    `mapstructure:"offsite"`
+	Transfer   TransferConfig   `mapstructure:"transfer"`
+	Directory  DirectoryConfig  `mapstructure:"directory"`
 }
 
 type DatabaseConfig struct {
@@ -21,30 +47,295 @@ type DatabaseConfig struct {
 }
 
 type CryptoConfig struct {
-	Argon2Time    uint32 `mapstructure:"argon2_time"`
-	Argon2Memory  uint32 `mapstructure:"argon2_memory"`
-	Argon2Threads uint8  `mapstructure:"argon2_threads"`
-	KeyLength     uint32 `mapstructure:"key_length"`
+	Argon2Time     uint32               `mapstructure:"argon2_time"`
+	Argon2Memory   uint32               `mapstructure:"argon2_memory"`
+	Argon2Threads  uint8                `mapstructure:"argon2_threads"`
+	KeyLength      uint32               `mapstructure:"key_length"`
+	UnlockPolicies []UnlockPolicyConfig `mapstructure:"unlock_policies"`
+}
+
+// UnlockPolicyConfig only lets containers be unlocked on trusted networks
+type UnlockPolicyConfig struct {
+	Container string   `mapstructure:"container"` // Path or glob
+	SSIDs     []string `mapstructure:"ssids"`
+	Subnets   []string `mapstructure:"subnets"` // CIDR
 }
 
 type SearchConfig struct {
-	IndexPath      string `mapstructure:"index_path"`
-	MaxWorkers     int    `mapstructure:"max_workers"`
-	IndexContent   bool   `mapstructure:"index_content"`
-	MaxContentSize int64  `mapstructure:"max_content_size"`
+	IndexPath      string            `mapstructure:"index_path"`
+	MaxWorkers     int               `mapstructure:"max_workers"`
+	IndexContent   bool              `mapstructure:"index_content"`
+	MaxContentSize int64             `mapstructure:"max_content_size"`
+	OCR            OCRConfig         `mapstructure:"ocr"`
+	Analyzer       AnalyzerConfig    `mapstructure:"analyzer"`
+	Languages      map[string]string `mapstructure:"languages"` // Indexed directory -> analyzer language
+	TagRules       []TagRuleConfig   `mapstructure:"tag_rules"`
+}
+
+// TagRuleConfig assigns tags to indexed files matching every condition set
+type TagRuleConfig struct {
+	Name    string   `mapstructure:"name"`
+	Tags    []string `mapstructure:"tags"`
+	Glob    string   `mapstructure:"glob"`   // Base name, or end of the path if it contains a slash; ** matches directories
+	MIME    string   `mapstructure:"mime"`   // e.g. image/*
+	Camera  string   `mapstructure:"camera"` // EXIF make and model, e.g. *iPhone*
+	MinSize int64    `mapstructure:"min_size"`
+	MaxSize int64    `mapstructure:"max_size"`
+}
+
+// AnalyzerConfig controls how indexed text is split into searchable terms
+type AnalyzerConfig struct {
+	Language       string `mapstructure:"language"` // english, german, french, spanish, italian, portuguese or simple
+	Stemming       bool   `mapstructure:"stemming"`
+	StopWords      bool   `mapstructure:"stop_words"`
+	FoldDiacritics bool   `mapstructure:"fold_diacritics"`
+	CJKBigrams     bool   `mapstructure:"cjk_bigrams"`
+}
+
+// OCRConfig controls text recognition of images and scanned PDFs
+type OCRConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Command   string        `mapstructure:"command"`   // tesseract or a compatible CLI
+	Languages []string      `mapstructure:"languages"` // tesseract language codes
+	Workers   int           `mapstructure:"workers"`
+	Timeout   time.Duration `mapstructure:"timeout"` // Per image
 }
 
 type SyncConfig struct {
-	ListenPort     int      `mapstructure:"listen_port"`
-	BootstrapPeers []string `mapstructure:"bootstrap_peers"`
-	EnableMDNS     bool     `mapstructure:"enable_mdns"`
-	RelayEnabled   bool     `mapstructure:"relay_enabled"`
-	DataDir        string   `mapstructure:"data_dir"`
+	ListenPort     int                 `mapstructure:"listen_port"`
+	BootstrapPeers []string            `mapstructure:"bootstrap_peers"`
+	EnableMDNS     bool                `mapstructure:"enable_mdns"`
+	RelayEnabled   bool                `mapstructure:"relay_enabled"`
+	DataDir        string              `mapstructure:"data_dir"`
+	SharedFolders  map[string]string   `mapstructure:"shared_folders"` // Share name -> directory paired peers may list
+	ShareAccounts  map[string]string   `mapstructure:"share_accounts"` // Share name -> account name or ID; unlisted shares are open to every account
+	ShareWriters   map[string][]string `mapstructure:"share_writers"`  // Share name -> peer IDs that may mirror into it; unlisted shares are read-only
+	CloudRelay     CloudRelayConfig    `mapstructure:"cloud_relay"`
+	WakeOnLAN      WakeOnLANConfig     `mapstructure:"wake_on_lan"`
+	Prefetch       PrefetchConfig      `mapstructure:"prefetch"`
+	Approval       ApprovalConfig      `mapstructure:"approval"`
+	DedupMinSize   int64               `mapstructure:"dedup_min_size"` // Offer files this large by hash before sending, 0 disables
+	Courier        CourierConfig       `mapstructure:"courier"`
+	KeyCheck       time.Duration       `mapstructure:"key_check_interval"` // How often to re-verify paired devices' keys, 0 disables
+}
+
+// CourierConfig controls carrying shared folder deltas on removable drives
+// to devices with no usable network
+type CourierConfig struct {
+	Enabled      bool                  `mapstructure:"enabled"`
+	PollInterval time.Duration         `mapstructure:"poll_interval"` // How often to look for plugged-in drives
+	Targets      []CourierTargetConfig `mapstructure:"targets"`
+}
+
+// CourierTargetConfig is a share carried to a device on every trip
+type CourierTargetConfig struct {
+	Device string `mapstructure:"device"` // Peer ID
+	Share  string `mapstructure:"share"`
+}
+
+// ApprovalConfig controls which files need a second trusted device to
+// approve sending them
+type ApprovalConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Tags    []string      `mapstructure:"tags"`
+	Folders []string      `mapstructure:"folders"`
+	Timeout time.Duration `mapstructure:"timeout"` // How long a transfer waits for approval
+}
+
+// PrefetchConfig controls fetching placeholders likely to be opened soon
+type PrefetchConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Interval     time.Duration `mapstructure:"interval"`
+	IdleAfter    time.Duration `mapstructure:"idle_after"` // Quiet time after the last transfer
+	MaxBytes     int64         `mapstructure:"max_bytes"`  // Per run
+	AllowMetered bool          `mapstructure:"allow_metered"`
+}
+
+// WakeOnLANConfig controls waking sleeping LAN devices before scheduled runs
+type WakeOnLANConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Broadcast string        `mapstructure:"broadcast"` // UDP host:port for magic packets
+	Timeout   time.Duration `mapstructure:"timeout"`   // How long to wait for the device
+}
+
+// CloudRelayConfig controls store-and-forward delivery to offline devices
+// through a user-provided S3 bucket or WebDAV folder
+type CloudRelayConfig struct {
+	Enabled      bool          `mapstructure:"enabled"`
+	Backend      string        `mapstructure:"backend"` // s3, webdav or dir
+	Endpoint     string        `mapstructure:"endpoint"`
+	Bucket       string        `mapstructure:"bucket"` // S3 only
+	Region       string        `mapstructure:"region"` // S3 only
+	AccessKey    string        `mapstructure:"access_key"`
+	SecretKey    string        `mapstructure:"secret_key"`
+	Username     string        `mapstructure:"username"` // WebDAV only
+	Password     string        `mapstructure:"password"` // WebDAV only
+	MaxSize      int64         `mapstructure:"max_size"`
+	TTL          time.Duration `mapstructure:"ttl"`
+	PollInterval time.Duration `mapstructure:"poll_interval"`
 }
 
 type LoggingConfig struct {
-	Level  string `mapstructure:"level"`
-	Output string `mapstructure:"output"`
+	Level      string `mapstructure:"level"`
+	Output     string `mapstructure:"output"`
+	Redact     bool   `mapstructure:"redact"`      // Redact fingerprints, file names and paths
+	RedactMode string `mapstructure:"redact_mode"` // hash or truncate
+	RedactKey  string `mapstructure:"redact_key"`  // Per-install key file for hash mode
+}
+
+// SettingsConfig controls runtime settings stored in the database.
+// Keys listed in Pinned always take their value from this file and
+// cannot be changed at runtime.
+type SettingsConfig struct {
+	Pinned []string `mapstructure:"pinned"`
+}
+
+// TelemetryConfig controls OpenTelemetry trace export
+type TelemetryConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Endpoint    string  `mapstructure:"endpoint"` // OTLP/HTTP collector host:port
+	Insecure    bool    `mapstructure:"insecure"`
+	ServiceName string  `mapstructure:"service_name"`
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// DiskConfig controls free-space pre-flight checks
+type DiskConfig struct {
+	MinFreeBytes int64            `mapstructure:"min_free_bytes"` // Safety margin kept free on every volume
+	Quotas       map[string]int64 `mapstructure:"quotas"`         // Directory -> max bytes
+}
+
+// GatewayConfig controls protocol gateways over SFM-managed data
+type GatewayConfig struct {
+	S3 S3GatewayConfig `mapstructure:"s3"`
+}
+
+// S3GatewayConfig controls the S3-compatible gateway. Mounted containers
+// are exposed as buckets alongside SharedFolders.
+type S3GatewayConfig struct {
+	Enabled       bool              `mapstructure:"enabled"`
+	Port          int               `mapstructure:"port"`
+	AccessKey     string            `mapstructure:"access_key"`
+	SecretKey     string            `mapstructure:"secret_key"`
+	ReadWrite     bool              `mapstructure:"read_write"`
+	SharedFolders map[string]string `mapstructure:"shared_folders"` // Bucket name -> directory
+}
+
+// StatusConfig lists the folders the protection dashboard reports on
+type StatusConfig struct {
+	ImportantFolders []string      `mapstructure:"important_folders"`
+	BackupMaxAge     time.Duration `mapstructure:"backup_max_age"` // Older backups raise a warning
+	SyncMaxAge       time.Duration `mapstructure:"sync_max_age"`
+}
+
+// CacheConfig controls the cache of derived data such as thumbnails,
+// previews and OCR text
+type CacheConfig struct {
+	Path    string `mapstructure:"path"`
+	MaxSize int64  `mapstructure:"max_size"` // Least recently used entries are evicted above this
+}
+
+// ScrubConfig controls background re-verification of indexed files and
+// containers
+type ScrubConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Interval time.Duration `mapstructure:"interval"`
+	Rate     int64         `mapstructure:"rate"`   // Bytes read per second, 0 for no limit
+	Repair   bool          `mapstructure:"repair"` // Replace corrupt files with verified copies from peers
+
+	ArchiveMaxAge time.Duration `mapstructure:"archive_max_age"` // Remind when an archive container has not been verified for this long
+}
+
+// QuarantineConfig controls where failed and cancelled downloads are kept
+// instead of being deleted
+type QuarantineConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Path            string        `mapstructure:"path"`
+	MaxAge          time.Duration `mapstructure:"max_age"` // Older files are deleted, 0 keeps them
+	CleanupInterval time.Duration `mapstructure:"cleanup_interval"`
+}
+
+// PowerConfig holds heavy jobs (indexing, scrub, backup, ocr) back until
+// the machine is plugged in and idle
+type PowerConfig struct {
+	RequireAC   bool          `mapstructure:"require_ac"`
+	RequireIdle bool          `mapstructure:"require_idle"`
+	IdleAfter   time.Duration `mapstructure:"idle_after"` // Without user input
+	Ungated     []string      `mapstructure:"ungated"`    // Jobs that run regardless
+}
+
+// SchedulingConfig sets the CPU and IO priority of background workers
+type SchedulingConfig struct {
+	Indexing   SchedClassConfig `mapstructure:"indexing"`   // Indexer and OCR workers
+	Hashing    SchedClassConfig `mapstructure:"hashing"`    // Integrity scrubs
+	Encryption SchedClassConfig `mapstructure:"encryption"` // Backups
+}
+
+type SchedClassConfig struct {
+	Nice    int    `mapstructure:"nice"`     // -20 to 19, higher runs less
+	IOClass string `mapstructure:"io_class"` // realtime, best-effort or idle
+	IOLevel int    `mapstructure:"io_level"` // 0 to 7, higher runs less
+}
+
+func (c SchedClassConfig) class() sched.Class {
+	return sched.Class{Nice: c.Nice, IOClass: c.IOClass, IOLevel: c.IOLevel}
+}
+
+// OnReceiveConfig runs actions on received files by type once they pass
+// their integrity checks
+type OnReceiveConfig struct {
+	Rules []OnReceiveRuleConfig `mapstructure:"rules"`
+}
+
+// OnReceiveRuleConfig applies an action to received files matching every
+// condition set
+type OnReceiveRuleConfig struct {
+	Name    string `mapstructure:"name"`
+	MIME    string `mapstructure:"mime"`    // e.g. image/*
+	Glob    string `mapstructure:"glob"`    // Base name, e.g. *.ics
+	Action  string `mapstructure:"action"`  // move, webhook or archive
+	Dest    string `mapstructure:"dest"`    // Directory to move to or extract into
+	URL     string `mapstructure:"url"`     // Webhook
+	Extract bool   `mapstructure:"extract"` // Unpack archives that verify
+}
+
+// OffsiteConfig controls differential backups of encrypted containers to
+// an S3 bucket or WebDAV folder
+type OffsiteConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	Backend   string        `mapstructure:"backend"` // s3, webdav or dir
+	Endpoint  string        `mapstructure:"endpoint"`
+	Bucket    string        `mapstructure:"bucket"` // S3 only
+	Region    string        `mapstructure:"region"` // S3 only
+	AccessKey string        `mapstructure:"access_key"`
+	SecretKey string        `mapstructure:"secret_key"`
+	Username  string        `mapstructure:"username"` // WebDAV only
+	Password  string        `mapstructure:"password"` // WebDAV only
+	Prefix    string        `mapstructure:"prefix"`   // Where in the bucket backups go
+	Interval  time.Duration `mapstructure:"interval"`
+	Keep      int           `mapstructure:"keep"` // Generations kept
+}
+
+// TransferConfig controls how concurrent AirDrop and sync transfers share
+// bandwidth
+type TransferConfig struct {
+	BandwidthLimit int64          `mapstructure:"bandwidth_limit"` // Bytes per second for all transfers, 0 for none; adjustable at runtime
+	Weights        map[string]int `mapstructure:"weights"`         // Share of airdrop and sync transfers, 1 by default
+}
+
+// DirectoryConfig controls resolving handle@host addresses to device keys
+// and the optional self-hosted key directory server
+type DirectoryConfig struct {
+	Insecure bool                  `mapstructure:"insecure"` // Resolve over plain HTTP
+	Server   DirectoryServerConfig `mapstructure:"server"`
+}
+
+// DirectoryServerConfig controls the key directory server
+type DirectoryServerConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	Port         int    `mapstructure:"port"`
+	Path         string `mapstructure:"path"`          // Where entries are stored
+	AllowPublish bool   `mapstructure:"allow_publish"` // Accept signed entries over HTTP
 }
 
 var globalConfig *Config
@@ -90,6 +381,52 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	logging.ConfigureRedaction(cfg.Logging.Redact, cfg.Logging.RedactMode)
+	if err := logging.LoadRedactionKey(cfg.Logging.RedactKey); err != nil {
+		return nil, err
+	}
+	diskspace.Configure(cfg.Disk.MinFreeBytes, cfg.Disk.Quotas)
+	status.Configure(cfg.Status.ImportantFolders, cfg.Status.BackupMaxAge, cfg.Status.SyncMaxAge)
+	if cfg.Quarantine.Enabled {
+		quarantine.Configure(cfg.Quarantine.Path, cfg.Quarantine.MaxAge)
+	} else {
+		quarantine.Configure("", 0)
+	}
+	power.Configure(power.Policy{
+		RequireAC:   cfg.Power.RequireAC,
+		RequireIdle: cfg.Power.RequireIdle,
+		IdleAfter:   cfg.Power.IdleAfter,
+		Ungated:     cfg.Power.Ungated,
+	})
+	sched.Configure(map[string]sched.Class{
+		sched.JobIndexing:   cfg.Scheduling.Indexing.class(),
+		sched.JobHashing:    cfg.Scheduling.Hashing.class(),
+		sched.JobEncryption: cfg.Scheduling.Encryption.class(),
+	})
+	bandwidth.SetWeights(cfg.Transfer.Weights)
+	actions := make([]onreceive.Rule, len(cfg.OnReceive.Rules))
+	for i, r := range cfg.OnReceive.Rules {
+		actions[i] = onreceive.Rule{Name: r.Name, MIME: r.MIME, Glob: r.Glob, Action: r.Action, Dest: r.Dest, URL: r.URL, Extract: r.Extract}
+	}
+	if err := onreceive.Configure(actions); err != nil {
+		return nil, fmt.Errorf("invalid on_receive rule: %w", err)
+	}
+
+	policies := make([]location.Policy, len(cfg.Crypto.UnlockPolicies))
+	for i, p := range cfg.Crypto.UnlockPolicies {
+		policies[i] = location.Policy{Container: p.Container, SSIDs: p.SSIDs, Subnets: p.Subnets}
+	}
+	location.Configure(policies)
+
+	if err := telemetry.Configure(context.Background(), cfg.Telemetry.Enabled, telemetry.Options{
+		Endpoint:    cfg.Telemetry.Endpoint,
+		Insecure:    cfg.Telemetry.Insecure,
+		ServiceName: cfg.Telemetry.ServiceName,
+		SampleRatio: cfg.Telemetry.SampleRatio,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to start telemetry: %w", err)
+	}
+
 	globalConfig = &cfg
 	return globalConfig, nil
 }
@@ -109,6 +446,17 @@ func setDefaults(configDir string) {
 	viper.SetDefault("search.max_workers", 8)
 	viper.SetDefault("search.index_content", true)
 	viper.SetDefault("search.max_content_size", 10*1024*1024) // 10MB
+	viper.SetDefault("search.ocr.enabled", false)
+	viper.SetDefault("search.ocr.command", "tesseract")
+	viper.SetDefault("search.ocr.languages", []string{"eng"})
+	viper.SetDefault("search.ocr.workers", 1)
+	viper.SetDefault("search.ocr.timeout", 2*time.Minute)
+	viper.SetDefault("search.analyzer.language", "english")
+	viper.SetDefault("search.analyzer.stemming", true)
+	viper.SetDefault("search.analyzer.stop_words", true)
+	viper.SetDefault("search.analyzer.fold_diacritics", true)
+	viper.SetDefault("search.analyzer.cjk_bigrams", true)
+	viper.SetDefault("search.tag_rules", []TagRuleConfig{})
 
 	// Sync
 	viper.SetDefault("sync.listen_port", 0) // Random port
@@ -119,10 +467,111 @@ func setDefaults(configDir string) {
 	viper.SetDefault("sync.enable_mdns", true)
 	viper.SetDefault("sync.relay_enabled", true)
 	viper.SetDefault("sync.data_dir", filepath.Join(configDir, "p2p"))
+	viper.SetDefault("sync.shared_folders", map[string]string{})
+	viper.SetDefault("sync.share_accounts", map[string]string{})
+	viper.SetDefault("sync.share_writers", map[string][]string{})
+	viper.SetDefault("sync.cloud_relay.enabled", false)
+	viper.SetDefault("sync.cloud_relay.backend", "s3")
+	viper.SetDefault("sync.cloud_relay.region", "us-east-1")
+	viper.SetDefault("sync.cloud_relay.max_size", 100*1024*1024) // 100MB
+	viper.SetDefault("sync.cloud_relay.ttl", 7*24*time.Hour)
+	viper.SetDefault("sync.cloud_relay.poll_interval", 5*time.Minute)
+	viper.SetDefault("sync.wake_on_lan.enabled", false)
+	viper.SetDefault("sync.wake_on_lan.broadcast", "255.255.255.255:9")
+	viper.SetDefault("sync.wake_on_lan.timeout", 2*time.Minute)
+	viper.SetDefault("sync.prefetch.enabled", false)
+	viper.SetDefault("sync.prefetch.interval", 15*time.Minute)
+	viper.SetDefault("sync.prefetch.idle_after", 5*time.Minute)
+	viper.SetDefault("sync.prefetch.max_bytes", 1024*1024*1024) // 1GB
+	viper.SetDefault("sync.prefetch.allow_metered", false)
+	viper.SetDefault("sync.approval.enabled", false)
+	viper.SetDefault("sync.approval.tags", []string{"restricted"})
+	viper.SetDefault("sync.approval.folders", []string{})
+	viper.SetDefault("sync.approval.timeout", 10*time.Minute)
+	viper.SetDefault("sync.dedup_min_size", 1024*1024) // 1MB
+	viper.SetDefault("sync.courier.enabled", false)
+	viper.SetDefault("sync.courier.poll_interval", 5*time.Second)
+	viper.SetDefault("sync.courier.targets", []map[string]string{})
+	viper.SetDefault("sync.key_check_interval", 6*time.Hour)
 
 	// Logging
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.output", filepath.Join(configDir, "sfm.log"))
+	viper.SetDefault("logging.redact", false)
+	viper.SetDefault("logging.redact_mode", "hash")
+	viper.SetDefault("logging.redact_key", filepath.Join(configDir, "redact.key"))
+
+	// Settings
+	viper.SetDefault("settings.pinned", []string{})
+
+	// Telemetry
+	viper.SetDefault("telemetry.enabled", false)
+	viper.SetDefault("telemetry.endpoint", "localhost:4318")
+	viper.SetDefault("telemetry.insecure", true)
+	viper.SetDefault("telemetry.service_name", "sfm")
+	viper.SetDefault("telemetry.sample_ratio", 1.0)
+
+	// Disk
+	viper.SetDefault("disk.min_free_bytes", 100*1024*1024) // 100MB
+	viper.SetDefault("disk.quotas", map[string]int64{})
+
+	// Status dashboard
+	viper.SetDefault("status.important_folders", []string{})
+	viper.SetDefault("status.backup_max_age", 7*24*time.Hour)
+	viper.SetDefault("status.sync_max_age", 7*24*time.Hour)
+
+	// Derived data cache
+	viper.SetDefault("cache.path", filepath.Join(configDir, "cache"))
+	viper.SetDefault("cache.max_size", 1024*1024*1024) // 1GB
+
+	// Integrity scrubbing
+	viper.SetDefault("scrub.enabled", false)
+	viper.SetDefault("scrub.interval", 7*24*time.Hour)
+	viper.SetDefault("scrub.rate", 20*1024*1024) // 20MB/s
+	viper.SetDefault("scrub.repair", false)
+	viper.SetDefault("scrub.archive_max_age", 180*24*time.Hour) // About 6 months
+
+	// Failed downloads
+	viper.SetDefault("quarantine.enabled", true)
+	viper.SetDefault("quarantine.path", filepath.Join(configDir, "quarantine"))
+	viper.SetDefault("quarantine.max_age", 7*24*time.Hour)
+	viper.SetDefault("quarantine.cleanup_interval", time.Hour)
+
+	// Offsite container backups
+	viper.SetDefault("offsite.enabled", false)
+	viper.SetDefault("offsite.backend", "s3")
+	viper.SetDefault("offsite.region", "us-east-1")
+	viper.SetDefault("offsite.prefix", "sfm-backup")
+	viper.SetDefault("offsite.interval", 24*time.Hour)
+	viper.SetDefault("offsite.keep", 30)
+
+	// Heavy jobs
+	viper.SetDefault("power.require_ac", true)
+	viper.SetDefault("power.require_idle", true)
+	viper.SetDefault("power.idle_after", 5*time.Minute)
+	viper.SetDefault("power.ungated", []string{})
+
+	// Scheduling classes of background workers
+	viper.SetDefault("scheduling.indexing.nice", 10)
+	viper.SetDefault("scheduling.indexing.io_class", "idle")
+	viper.SetDefault("scheduling.hashing.nice", 10)
+	viper.SetDefault("scheduling.hashing.io_class", "idle")
+	viper.SetDefault("scheduling.encryption.nice", 5)
+	viper.SetDefault("scheduling.encryption.io_class", "best-effort")
+	viper.SetDefault("scheduling.encryption.io_level", 7)
+
+	// Gateways
+	viper.SetDefault("gateway.s3.enabled", false)
+	viper.SetDefault("gateway.s3.port", 9000)
+	viper.SetDefault("gateway.s3.read_write", false)
+	viper.SetDefault("gateway.s3.shared_folders", map[string]string{})
+
+	// Key directory
+	viper.SetDefault("directory.insecure", false)
+	viper.SetDefault("directory.server.enabled", false)
+	viper.SetDefault("directory.server.port", 8443)
+	viper.SetDefault("directory.server.path", filepath.Join(configDir, "directory"))
+	viper.SetDefault("directory.server.allow_publish", false)
 }
 
 // Get returns the global config instance
@@ -0,0 +1,214 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Benchmarks for the primitives and container operations, to compare
+// cipher, buffering and parallelism changes on the same hardware:
+//
+//	go test -run '^$' -bench . -benchmem -count 6 ./internal/crypto ./internal/airdrop > new.txt
+//	benchstat old.txt new.txt
+//
+// Sub-benchmarks are named by data size, e.g. -bench 'Encrypt/1MiB'.
+
+var benchSizes = []struct {
+	name string
+	size int
+}{
+	{"1KiB", 1 << 10},
+	{"64KiB", 64 << 10},
+	{"1MiB", 1 << 20},
+	{"16MiB", 16 << 20},
+}
+
+// benchKDF is cheap so container benchmarks measure I/O, compression and
+// encryption rather than key derivation, which BenchmarkKDF covers
+var benchKDF = Argon2idParams(1, 8*1024, 1)
+
+const benchPassword = "benchmark password"
+
+// benchData returns n random, hence incompressible, bytes
+func benchData(b *testing.B, n int) []byte {
+	b.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func benchKey(b *testing.B) []byte {
+	b.Helper()
+	return benchData(b, KeySize)
+}
+
+func BenchmarkEncrypt(b *testing.B) {
+	key := benchKey(b)
+	for _, s := range benchSizes {
+		data := benchData(b, s.size)
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if _, err := Encrypt(data, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecrypt(b *testing.B) {
+	key := benchKey(b)
+	for _, s := range benchSizes {
+		ciphertext, err := Encrypt(benchData(b, s.size), key)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if _, err := Decrypt(ciphertext, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEncryptStream(b *testing.B) {
+	key := benchKey(b)
+	for _, s := range benchSizes {
+		data := benchData(b, s.size)
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if err := EncryptStream(bytes.NewReader(data), io.Discard, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDecryptStream(b *testing.B) {
+	key := benchKey(b)
+	for _, s := range benchSizes {
+		var ciphertext bytes.Buffer
+		if err := EncryptStream(bytes.NewReader(benchData(b, s.size)), &ciphertext, key); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if err := DecryptStream(bytes.NewReader(ciphertext.Bytes()), io.Discard, key); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkKDF measures one key derivation with the default Argon2id costs
+// and the costs commonly used for the other KDFs
+func BenchmarkKDF(b *testing.B) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		b.Fatal(err)
+	}
+	kdfs := []KDFParams{
+		Argon2idParams(3, 64*1024, 4),
+		Argon2idParams(1, 64*1024, 4),
+		Argon2idParams(3, 256*1024, 4),
+		{KDF: KDFScrypt, Time: 15, Memory: 8, Threads: 1},
+		{KDF: KDFPBKDF2SHA256, Time: 600000},
+		{KDF: KDFPBKDF2SHA512, Time: 210000},
+	}
+	for _, kdf := range kdfs {
+		name := fmt.Sprintf("%s/t=%d,m=%d,p=%d", kdf.Name(), kdf.Time, kdf.Memory, kdf.Threads)
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := kdf.DeriveKey(benchPassword, salt); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// benchSource writes a directory holding one file of size random bytes
+func benchSource(b *testing.B, size int) string {
+	b.Helper()
+	dir := filepath.Join(b.TempDir(), "source")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.bin"), benchData(b, size), 0644); err != nil {
+		b.Fatal(err)
+	}
+	return dir
+}
+
+// benchContainer creates a container holding size random bytes
+func benchContainer(b *testing.B, size int) string {
+	b.Helper()
+	containerPath := filepath.Join(b.TempDir(), "bench.sfm")
+	err := CreateContainerWithKDF(benchSource(b, size), containerPath, benchPassword, DuressOptions{}, benchKDF)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return containerPath
+}
+
+func BenchmarkCreateContainer(b *testing.B) {
+	for _, s := range benchSizes {
+		source := benchSource(b, s.size)
+		containerPath := filepath.Join(b.TempDir(), "bench.sfm")
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if err := CreateContainerWithKDF(source, containerPath, benchPassword, DuressOptions{}, benchKDF); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkExtractContainer(b *testing.B) {
+	for _, s := range benchSizes {
+		containerPath := benchContainer(b, s.size)
+		output := filepath.Join(b.TempDir(), "output")
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if err := ExtractContainer(containerPath, output, benchPassword); err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				os.RemoveAll(output)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkExtractToWriter(b *testing.B) {
+	for _, s := range benchSizes {
+		containerPath := benchContainer(b, s.size)
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(s.size))
+			for b.Loop() {
+				if err := ExtractToWriter(containerPath, benchPassword, io.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
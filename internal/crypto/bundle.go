@@ -0,0 +1,242 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	_ "embed"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	BundleMagic            = "SFMB"
+	BundleVersion          = 1
+	MaxBundleSize          = 20 * 1024 * 1024 // Fits common email attachment limits
+	DefaultPassphraseWords = 7
+
+	bundleSaltSize = 16
+	armorBegin     = "-----BEGIN SFM BUNDLE-----"
+	armorEnd       = "-----END SFM BUNDLE-----"
+	armorLineWidth = 64
+)
+
+var (
+	ErrBundleTooLarge = errors.New("file too large for a bundle")
+	ErrInvalidBundle  = errors.New("not an SFM bundle")
+)
+
+//go:embed wordlist.txt
+var wordlistData string
+
+var wordlist = strings.Fields(wordlistData)
+
+// GeneratePassphrase returns n random words from the embedded word list,
+// joined by dashes. Seven words give about 68 bits of entropy.
+func GeneratePassphrase(n int) (string, error) {
+	words := make([]string, n)
+	max := big.NewInt(int64(len(wordlist)))
+	for i := range words {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate passphrase: %w", err)
+		}
+		words[i] = wordlist[idx.Int64()]
+	}
+	return strings.Join(words, "-"), nil
+}
+
+// normalizePassphrase makes retyped passphrases match: case, spaces and
+// dashes between words do not matter
+func normalizePassphrase(passphrase string) string {
+	fields := strings.FieldsFunc(strings.ToLower(passphrase), func(r rune) bool {
+		return r == '-' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+	return strings.Join(fields, "-")
+}
+
+// CreateBundle encrypts a single file into an ASCII-armored bundle that can
+// be emailed or pasted into chat. The passphrase should be sent separately.
+func CreateBundle(sourcePath, bundlePath, passphrase string, argon2Time, argon2Memory uint32, argon2Threads uint8) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat source: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("bundles hold a single file, use a container for directories")
+	}
+	if info.Size() > MaxBundleSize {
+		return ErrBundleTooLarge
+	}
+
+	content, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read source: %w", err)
+	}
+
+	// Plaintext: name length (uint16) | name | content, gzipped
+	name := filepath.Base(sourcePath)
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	binary.Write(gz, binary.BigEndian, uint16(len(name)))
+	gz.Write([]byte(name))
+	gz.Write(content)
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress: %w", err)
+	}
+
+	salt := make([]byte, bundleSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := DeriveKey(normalizePassphrase(passphrase), salt, argon2Time, argon2Memory, argon2Threads)
+	ciphertext, err := Encrypt(plain.Bytes(), key)
+	if err != nil {
+		return err
+	}
+
+	// Header: magic | version | argon2 time | argon2 memory | argon2 threads | salt
+	var raw bytes.Buffer
+	raw.WriteString(BundleMagic)
+	raw.WriteByte(BundleVersion)
+	binary.Write(&raw, binary.BigEndian, argon2Time)
+	binary.Write(&raw, binary.BigEndian, argon2Memory)
+	raw.WriteByte(argon2Threads)
+	raw.Write(salt)
+	raw.Write(ciphertext)
+
+	if err := os.WriteFile(bundlePath, armor(raw.Bytes()), 0644); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// OpenBundle decrypts a bundle into outputDir and returns the path of the
+// restored file. It accepts armored text (including text copied out of an
+// email body) as well as raw bundles.
+func OpenBundle(bundlePath, outputDir, passphrase string) (string, error) {
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	name, content, err := DecryptBundle(data, passphrase)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create output directory: %w", err)
+	}
+	outputPath := filepath.Join(outputDir, name)
+	if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return outputPath, nil
+}
+
+// DecryptBundle decrypts bundle data and returns the file name and content
+func DecryptBundle(data []byte, passphrase string) (string, []byte, error) {
+	raw, err := unarmor(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	headerSize := len(BundleMagic) + 1 + 4 + 4 + 1 + bundleSaltSize
+	if len(raw) < headerSize || string(raw[:len(BundleMagic)]) != BundleMagic {
+		return "", nil, ErrInvalidBundle
+	}
+	if raw[len(BundleMagic)] != BundleVersion {
+		return "", nil, fmt.Errorf("unsupported bundle version %d", raw[len(BundleMagic)])
+	}
+
+	p := raw[len(BundleMagic)+1:]
+	argon2Time := binary.BigEndian.Uint32(p[0:4])
+	argon2Memory := binary.BigEndian.Uint32(p[4:8])
+	argon2Threads := p[8]
+	salt := p[9 : 9+bundleSaltSize]
+	ciphertext := raw[headerSize:]
+
+	// Refuse parameters that would exhaust memory on the recipient's machine
+	if argon2Memory > 1024*1024 || argon2Time > 64 || argon2Threads == 0 {
+		return "", nil, ErrInvalidBundle
+	}
+
+	key := DeriveKey(normalizePassphrase(passphrase), salt, argon2Time, argon2Memory, argon2Threads)
+	compressed, err := Decrypt(ciphertext, key)
+	if err != nil {
+		return "", nil, fmt.Errorf("wrong passphrase or corrupted bundle")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", nil, ErrInvalidBundle
+	}
+	plain, err := io.ReadAll(io.LimitReader(gz, MaxBundleSize+1024))
+	if err != nil {
+		return "", nil, ErrInvalidBundle
+	}
+
+	if len(plain) < 2 {
+		return "", nil, ErrInvalidBundle
+	}
+	nameLen := int(binary.BigEndian.Uint16(plain))
+	if len(plain) < 2+nameLen {
+		return "", nil, ErrInvalidBundle
+	}
+	name := filepath.Base(string(plain[2 : 2+nameLen]))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "", nil, ErrInvalidBundle
+	}
+
+	return name, plain[2+nameLen:], nil
+}
+
+func armor(raw []byte) []byte {
+	encoded := base64.StdEncoding.EncodeToString(raw)
+
+	var b bytes.Buffer
+	b.WriteString(armorBegin + "\n")
+	for len(encoded) > armorLineWidth {
+		b.WriteString(encoded[:armorLineWidth] + "\n")
+		encoded = encoded[armorLineWidth:]
+	}
+	b.WriteString(encoded + "\n")
+	b.WriteString(armorEnd + "\n")
+	return b.Bytes()
+}
+
+func unarmor(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, []byte(BundleMagic)) {
+		return data, nil
+	}
+
+	text := string(data)
+	start := strings.Index(text, armorBegin)
+	end := strings.Index(text, armorEnd)
+	if start < 0 || end < start {
+		return nil, ErrInvalidBundle
+	}
+
+	// Mail clients may re-wrap lines or add quoting, keep base64 only
+	body := strings.Map(func(r rune) rune {
+		if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '+' || r == '/' || r == '=' {
+			return r
+		}
+		return -1
+	}, text[start+len(armorBegin):end])
+
+	raw, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, ErrInvalidBundle
+	}
+	return raw, nil
+}
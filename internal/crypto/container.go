@@ -4,7 +4,6 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -13,105 +12,74 @@ import (
 
 const (
 	MagicBytes = "SFM\x00"
-	Version    = 1
+	Version    = 2 // Version 1 had no key slots; it is still read
 	HeaderSize = 64
 )
 
 // ContainerHeader represents the encrypted container header
 type ContainerHeader struct {
-	Magic         [4]byte
-	Version       uint32
-	Salt          [32]byte
-	Argon2Time    uint32
-	Argon2Memory  uint32
-	Argon2Threads uint8
-	Reserved      [15]byte
+	Magic      [4]byte
+	Version    uint32
+	Salt       [32]byte
+	KDFTime    uint32
+	KDFMemory  uint32
+	KDFThreads uint8
+	KDF        uint8 // Was reserved, so older containers hold 0, Argon2id
+	Reserved   [14]byte
+}
+
+// KDFParams returns the key derivation the header's passwords use
+func (h *ContainerHeader) KDFParams() KDFParams {
+	return KDFParams{KDF: h.KDF, Time: h.KDFTime, Memory: h.KDFMemory, Threads: h.KDFThreads}
 }
 
 // CreateContainer creates an encrypted container from a file or directory
 func CreateContainer(sourcePath, containerPath, password string, argon2Time, argon2Memory uint32, argon2Threads uint8) error {
-	// Generate salt
-	salt, err := GenerateSalt()
-	if err != nil {
-		return err
-	}
+	return CreateContainerWithKDF(sourcePath, containerPath, password, DuressOptions{}, Argon2idParams(argon2Time, argon2Memory, argon2Threads))
+}
 
-	// Derive key
-	key := DeriveKey(password, salt, argon2Time, argon2Memory, argon2Threads)
+// CreateDuressContainer creates an encrypted container that also opens with
+// a duress password
+func CreateDuressContainer(sourcePath, containerPath, password string, duress DuressOptions, argon2Time, argon2Memory uint32, argon2Threads uint8) error {
+	return CreateContainerWithKDF(sourcePath, containerPath, password, duress, Argon2idParams(argon2Time, argon2Memory, argon2Threads))
+}
 
-	// Create container file
-	containerFile, err := os.Create(containerPath)
-	if err != nil {
-		return fmt.Errorf("failed to create container: %w", err)
-	}
-	defer containerFile.Close()
-
-	// Write header
-	header := ContainerHeader{
-		Version:       Version,
-		Argon2Time:    argon2Time,
-		Argon2Memory:  argon2Memory,
-		Argon2Threads: argon2Threads,
+// CreateContainerWithKDF creates an encrypted container whose passwords
+// are stretched with the given KDF, e.g. to keep the KDF of an imported
+// archive
+func CreateContainerWithKDF(sourcePath, containerPath, password string, duress DuressOptions, kdf KDFParams) error {
+	if err := kdf.Check(); err != nil {
+		return err
 	}
-	copy(header.Magic[:], MagicBytes)
-	copy(header.Salt[:], salt)
-
-	if err := binary.Write(containerFile, binary.LittleEndian, &header); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+	archive, err := archiveSource(sourcePath)
+	if err != nil {
+		return err
 	}
+	return buildContainer(containerPath, archive, password, duress, kdf)
+}
 
-	// Create tar.gz archive in memory
-	var buf bytes.Buffer
-	gzWriter := gzip.NewWriter(&buf)
-	tarWriter := tar.NewWriter(gzWriter)
-
-	// Add files to archive
-	if err := addToArchive(tarWriter, sourcePath, ""); err != nil {
+// CreateContainerFromArchive creates an encrypted container holding a
+// tar.gz archive built elsewhere, e.g. by an importer that never writes
+// the plaintext to disk
+func CreateContainerFromArchive(archive *bytes.Buffer, containerPath, password string, kdf KDFParams) error {
+	if err := kdf.Check(); err != nil {
 		return err
 	}
-
-	tarWriter.Close()
-	gzWriter.Close()
-
-	// Encrypt and write data
-	if err := EncryptStream(&buf, containerFile, key); err != nil {
-		return fmt.Errorf("failed to encrypt data: %w", err)
+	if err := checkArchive(bytes.NewReader(archive.Bytes())); err != nil {
+		return err
 	}
-
-	return nil
+	return buildContainer(containerPath, archive, password, DuressOptions{}, kdf)
 }
 
 // ExtractContainer extracts an encrypted container
 func ExtractContainer(containerPath, outputPath, password string) error {
-	// Open container file
-	containerFile, err := os.Open(containerPath)
+	buf, err := openContainer(containerPath, password)
 	if err != nil {
-		return fmt.Errorf("failed to open container: %w", err)
-	}
-	defer containerFile.Close()
-
-	// Read header
-	var header ContainerHeader
-	if err := binary.Read(containerFile, binary.LittleEndian, &header); err != nil {
-		return fmt.Errorf("failed to read header: %w", err)
-	}
-
-	// Verify magic bytes
-	if string(header.Magic[:]) != MagicBytes {
-		return fmt.Errorf("invalid container format")
-	}
-
-	// Derive key
-	key := DeriveKey(password, header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads)
-
-	// Decrypt data
-	var buf bytes.Buffer
-	if err := DecryptStream(containerFile, &buf, key); err != nil {
-		return fmt.Errorf("failed to decrypt data (wrong password?): %w", err)
+		return err
 	}
 
 	// Extract tar.gz archive
-	gzReader, err := gzip.NewReader(&buf)
+	gzReader, err := gzip.NewReader(buf)
 	if err != nil {
 		return fmt.Errorf("failed to create gzip reader: %w", err)
 	}
@@ -154,6 +122,54 @@ func ExtractContainer(containerPath, outputPath, password string) error {
 	return nil
 }
 
+// VerifyContainer checks that a container decrypts and its archive reads
+// through to the end with the given password
+func VerifyContainer(containerPath, password string) error {
+	buf, err := openContainer(containerPath, password)
+	if err != nil {
+		return err
+	}
+	return checkArchive(buf)
+}
+
+// checkArchive reads a tar.gz archive through to the end
+func checkArchive(r io.Reader) error {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer gzReader.Close()
+
+	tarReader := tar.NewReader(gzReader)
+	for {
+		_, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar: %w", err)
+		}
+		if _, err := io.Copy(io.Discard, tarReader); err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+	}
+}
+
+// archiveSource packs a file or directory as tar.gz in memory
+func archiveSource(sourcePath string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	gzWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzWriter)
+
+	if err := addToArchive(tarWriter, sourcePath, ""); err != nil {
+		return nil, err
+	}
+
+	tarWriter.Close()
+	gzWriter.Close()
+	return &buf, nil
+}
+
 func addToArchive(tarWriter *tar.Writer, source, baseDir string) error {
 	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -84,12 +84,8 @@ func EncryptStream(reader io.Reader, writer io.Writer, key []byte) error {
 		return fmt.Errorf("failed to create cipher: %w", err)
 	}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return fmt.Errorf("failed to create GCM: %w", err)
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
+	// CTR needs a full block-sized IV
+	nonce := make([]byte, aes.BlockSize)
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return fmt.Errorf("failed to generate nonce: %w", err)
 	}
@@ -113,19 +109,9 @@ func EncryptStream(reader io.Reader, writer io.Writer, key []byte) error {
 
 // DecryptStream decrypts data in streaming mode
 func DecryptStream(reader io.Reader, writer io.Writer, key []byte) error {
-	block, err := aes.NewCipher(key)
+	streamReader, err := decryptReader(reader, key)
 	if err != nil {
-		return fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	nonce := make([]byte, NonceSize)
-	if _, err := io.ReadFull(reader, nonce); err != nil {
-		return fmt.Errorf("failed to read nonce: %w", err)
-	}
-
-	streamReader := &cipher.StreamReader{
-		S: cipher.NewCTR(block, nonce),
-		R: reader,
+		return err
 	}
 
 	if _, err := io.Copy(writer, streamReader); err != nil {
@@ -0,0 +1,172 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifiers, as stored in container headers. Containers written
+// before there was a choice hold 0 there, so it stays Argon2id; IDs are
+// never reused.
+const (
+	KDFArgon2id     uint8 = 0
+	KDFScrypt       uint8 = 1
+	KDFPBKDF2SHA256 uint8 = 2
+	KDFPBKDF2SHA512 uint8 = 3
+)
+
+// Limits on the costs a header may ask for
+const (
+	maxKDFMemory        = 1 << 30 // Memory any KDF may use (1GB)
+	maxScryptThreads    = 16      // scrypt p
+	maxScryptWork       = 1 << 25 // scrypt N·r·p, 4 passes over maxKDFMemory
+	maxPBKDF2Iterations = 10_000_000
+)
+
+// KDFParams selects a KDF and its costs. What the costs mean depends on
+// the KDF:
+//
+//	Argon2id: Time passes, Memory KiB, Threads lanes
+//	scrypt:   Time log2 of N, Memory r, Threads p
+//	PBKDF2:   Time iterations; Memory and Threads are 0
+type KDFParams struct {
+	KDF     uint8
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// Argon2idParams returns Argon2id parameters, the default KDF
+func Argon2idParams(time, memory uint32, threads uint8) KDFParams {
+	return KDFParams{KDF: KDFArgon2id, Time: time, Memory: memory, Threads: threads}
+}
+
+// KDF derives KeySize keys from passwords
+type KDF struct {
+	Name   string
+	Derive func(password, salt []byte, params KDFParams) ([]byte, error)
+	// Check rejects costs that are invalid or too expensive, since they
+	// may come from an untrusted header
+	Check func(params KDFParams) error
+}
+
+var kdfs = map[uint8]KDF{}
+
+// RegisterKDF makes a KDF available under id. It panics if the ID or name
+// is taken, as registration happens at init.
+func RegisterKDF(id uint8, kdf KDF) {
+	if _, ok := kdfs[id]; ok {
+		panic(fmt.Sprintf("KDF %d registered twice", id))
+	}
+	if _, ok := KDFByName(kdf.Name); ok {
+		panic(fmt.Sprintf("KDF %q registered twice", kdf.Name))
+	}
+	kdfs[id] = kdf
+}
+
+// KDFByName returns the ID of a registered KDF, e.g. for configuration
+func KDFByName(name string) (uint8, bool) {
+	for id, kdf := range kdfs {
+		if kdf.Name == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// Name returns the name of the KDF, or its number if it is unknown
+func (p KDFParams) Name() string {
+	if kdf, ok := kdfs[p.KDF]; ok {
+		return kdf.Name
+	}
+	return fmt.Sprintf("kdf-%d", p.KDF)
+}
+
+// Check fails if the KDF is unknown or the costs are out of range
+func (p KDFParams) Check() error {
+	kdf, ok := kdfs[p.KDF]
+	if !ok {
+		return fmt.Errorf("unsupported key derivation function %d", p.KDF)
+	}
+	if err := kdf.Check(p); err != nil {
+		return fmt.Errorf("invalid %s parameters: %w", kdf.Name, err)
+	}
+	return nil
+}
+
+// DeriveKey derives a key from password with the KDF and costs of p
+func (p KDFParams) DeriveKey(password string, salt []byte) ([]byte, error) {
+	if err := p.Check(); err != nil {
+		return nil, err
+	}
+	key, err := kdfs[p.KDF].Derive([]byte(password), salt, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+func init() {
+	RegisterKDF(KDFArgon2id, KDF{
+		Name: "argon2id",
+		Derive: func(password, salt []byte, p KDFParams) ([]byte, error) {
+			return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, KeySize), nil
+		},
+		Check: func(p KDFParams) error {
+			if p.Time == 0 || p.Time > 64 || p.Threads == 0 || p.Memory < 8*uint32(p.Threads) {
+				return fmt.Errorf("time %d, memory %d, threads %d", p.Time, p.Memory, p.Threads)
+			}
+			if uint64(p.Memory)*1024 > maxKDFMemory {
+				return fmt.Errorf("memory %dKiB exceeds the limit", p.Memory)
+			}
+			return nil
+		},
+	})
+
+	RegisterKDF(KDFScrypt, KDF{
+		Name: "scrypt",
+		Derive: func(password, salt []byte, p KDFParams) ([]byte, error) {
+			return scrypt.Key(password, salt, 1<<p.Time, int(p.Memory), int(p.Threads), KeySize)
+		},
+		Check: func(p KDFParams) error {
+			if p.Time == 0 || p.Time > 30 || p.Memory == 0 || p.Threads == 0 || p.Threads > maxScryptThreads {
+				return fmt.Errorf("log2 N %d, r %d, p %d", p.Time, p.Memory, p.Threads)
+			}
+			// scrypt needs 128 * N * r bytes; divide rather than multiply
+			// so a large r cannot overflow
+			if uint64(p.Memory) > maxKDFMemory>>(7+p.Time) {
+				return fmt.Errorf("N and r exceed the memory limit")
+			}
+			// p runs the memory-hard mix p times over, so bound the work
+			// as well; N * r is at most 2^23 here
+			if (uint64(p.Memory)<<p.Time)*uint64(p.Threads) > maxScryptWork {
+				return fmt.Errorf("N, r and p exceed the work limit")
+			}
+			return nil
+		},
+	})
+
+	RegisterKDF(KDFPBKDF2SHA256, pbkdf2KDF("pbkdf2-sha256", sha256.New))
+	RegisterKDF(KDFPBKDF2SHA512, pbkdf2KDF("pbkdf2-sha512", sha512.New))
+}
+
+func pbkdf2KDF(name string, h func() hash.Hash) KDF {
+	return KDF{
+		Name: name,
+		Derive: func(password, salt []byte, p KDFParams) ([]byte, error) {
+			return pbkdf2.Key(password, salt, int(p.Time), KeySize, h), nil
+		},
+		Check: func(p KDFParams) error {
+			if p.Time == 0 || p.Time > maxPBKDF2Iterations || p.Memory != 0 || p.Threads != 0 {
+				return fmt.Errorf("iterations %d, memory %d, threads %d", p.Time, p.Memory, p.Threads)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,426 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+)
+
+// Version 2 containers store the data key in key slots after the header:
+//
+//	[Header][Slot 0][Slot 1][Payload A][Payload B]
+//
+// Each slot is AES-256-GCM(data key, flags, payload offset, payload length)
+// under a key derived from a password with the header's salt and KDF. Every
+// container has both slots and both payloads; without a duress password
+// the spare slot and payload are random bytes, indistinguishable from real
+// ones, and the order of slots and payloads is random.
+const (
+	KeySlots = 2
+
+	slotPlainSize = KeySize + 1 + 8 + 8
+	slotSize      = NonceSize + slotPlainSize + 16 // Nonce, sealed slot, GCM tag
+	slotAreaSize  = KeySlots * slotSize
+
+	// minChaff is the least random data written in place of a decoy payload
+	minChaff = 64 * 1024
+)
+
+// Slot flags
+const (
+	slotWipe   = 1 << 0 // Opening destroys the other slots
+	slotNoData = 1 << 1 // Nothing to open; report a wrong password
+)
+
+var ErrWrongPassword = errors.New("wrong password")
+
+// unlockCheck vets every unlock before the key is derived
+var (
+	unlockCheckMu sync.RWMutex
+	unlockCheck   func(containerPath string) error
+)
+
+// SetUnlockCheck sets a check run before a container's key is derived;
+// an error from it fails the unlock. nil removes the check.
+func SetUnlockCheck(check func(containerPath string) error) {
+	unlockCheckMu.Lock()
+	defer unlockCheckMu.Unlock()
+	unlockCheck = check
+}
+
+func loadUnlockCheck() func(containerPath string) error {
+	unlockCheckMu.RLock()
+	defer unlockCheckMu.RUnlock()
+	return unlockCheck
+}
+
+// DuressOptions sets an alternate container password. Opening the container
+// with it yields the decoy instead of the real data, and with Wipe also
+// destroys the real key slot, making the real data unrecoverable.
+type DuressOptions struct {
+	Password  string
+	DecoyPath string // File or directory to show; empty fails as a wrong password would
+	Wipe      bool
+}
+
+// region is a payload of a version 2 container and the slot opening it
+type region struct {
+	kek    []byte // Seals the slot; nil for a spare slot
+	dek    []byte
+	flags  byte
+	data   io.Reader // Plaintext tar.gz; nil for chaff
+	chaff  int64
+	offset int64
+	length int64
+}
+
+// SetDuressPassword rebuilds a container, which may be version 1, with a
+// new duress password, or none if duress.Password is empty. The container
+// is rebuilt around whatever password opens.
+func SetDuressPassword(containerPath, password string, duress DuressOptions) error {
+	header, err := ReadContainerHeader(containerPath)
+	if err != nil {
+		return err
+	}
+	archive, err := openContainer(containerPath, password)
+	if err != nil {
+		return err
+	}
+	return replaceContainer(containerPath, archive, password, duress, header)
+}
+
+// replaceContainer atomically replaces a container with a rebuilt one
+// holding archive, keeping the KDF of header
+func replaceContainer(containerPath string, archive *bytes.Buffer, password string, duress DuressOptions, header *ContainerHeader) error {
+	// Version 1 cannot tell a wrong password from damage until the archive is read
+	if err := checkArchive(bytes.NewReader(archive.Bytes())); err != nil {
+		return err
+	}
+
+	tmp := containerPath + ".tmp"
+	if err := buildContainer(tmp, archive, password, duress, header.KDFParams()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, containerPath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace container: %w", err)
+	}
+	return nil
+}
+
+// buildContainer writes a version 2 container holding archive
+func buildContainer(containerPath string, archive *bytes.Buffer, password string, duress DuressOptions, kdf KDFParams) error {
+	if duress.Password != "" {
+		if duress.Password == password {
+			return fmt.Errorf("duress password must differ from the password")
+		}
+		if duress.DecoyPath == "" && !duress.Wipe {
+			return fmt.Errorf("duress password needs a decoy or wipe")
+		}
+	}
+
+	salt, err := GenerateSalt()
+	if err != nil {
+		return err
+	}
+	header := ContainerHeader{
+		Version:    Version,
+		KDFTime:    kdf.Time,
+		KDFMemory:  kdf.Memory,
+		KDFThreads: kdf.Threads,
+		KDF:        kdf.KDF,
+	}
+	copy(header.Magic[:], MagicBytes)
+	copy(header.Salt[:], salt)
+
+	kek, err := kdf.DeriveKey(password, salt)
+	if err != nil {
+		return err
+	}
+	primary := &region{kek: kek, data: archive}
+
+	chaff, err := randomInt(int64(archive.Len())/4 + 1)
+	if err != nil {
+		return err
+	}
+	spare := &region{chaff: minChaff + chaff}
+	if duress.Password != "" {
+		if spare.kek, err = kdf.DeriveKey(duress.Password, salt); err != nil {
+			return err
+		}
+		if duress.Wipe {
+			spare.flags |= slotWipe
+		}
+		if duress.DecoyPath != "" {
+			if spare.data, err = archiveSource(duress.DecoyPath); err != nil {
+				return fmt.Errorf("failed to archive decoy: %w", err)
+			}
+		} else {
+			spare.flags |= slotNoData
+		}
+	}
+
+	return writeContainer(containerPath, header, []*region{primary, spare})
+}
+
+func writeContainer(containerPath string, header ContainerHeader, regions []*region) error {
+	containerFile, err := os.Create(containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	defer containerFile.Close()
+
+	if err := binary.Write(containerFile, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	// Slots are filled in once payload offsets are known
+	if _, err := containerFile.Write(make([]byte, slotAreaSize)); err != nil {
+		return fmt.Errorf("failed to write key slots: %w", err)
+	}
+
+	payloads, err := shuffled(regions)
+	if err != nil {
+		return err
+	}
+	offset := int64(0)
+	for _, r := range payloads {
+		counter := &countingWriter{w: containerFile}
+		if r.data != nil {
+			r.dek = make([]byte, KeySize)
+			if _, err := rand.Read(r.dek); err != nil {
+				return fmt.Errorf("failed to generate key: %w", err)
+			}
+			if err := EncryptStream(r.data, counter, r.dek); err != nil {
+				return fmt.Errorf("failed to encrypt data: %w", err)
+			}
+		} else if _, err := io.CopyN(counter, rand.Reader, r.chaff); err != nil {
+			return fmt.Errorf("failed to write padding: %w", err)
+		}
+		r.offset, r.length = offset, counter.n
+		offset += counter.n
+	}
+
+	slots, err := shuffled(regions)
+	if err != nil {
+		return err
+	}
+	area := make([]byte, 0, slotAreaSize)
+	for _, r := range slots {
+		slot, err := sealSlot(r)
+		if err != nil {
+			return err
+		}
+		area = append(area, slot...)
+	}
+	if _, err := containerFile.WriteAt(area, HeaderSize); err != nil {
+		return fmt.Errorf("failed to write key slots: %w", err)
+	}
+	return containerFile.Sync()
+}
+
+// sealSlot encrypts the slot of a region, or returns random bytes for a
+// spare slot
+func sealSlot(r *region) ([]byte, error) {
+	if r.kek == nil {
+		slot := make([]byte, slotSize)
+		if _, err := rand.Read(slot); err != nil {
+			return nil, fmt.Errorf("failed to generate key slot: %w", err)
+		}
+		return slot, nil
+	}
+
+	plain := make([]byte, slotPlainSize)
+	if r.dek != nil {
+		copy(plain, r.dek)
+	} else if _, err := rand.Read(plain[:KeySize]); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	plain[KeySize] = r.flags
+	binary.BigEndian.PutUint64(plain[KeySize+1:], uint64(r.offset))
+	binary.BigEndian.PutUint64(plain[KeySize+9:], uint64(r.length))
+	return Encrypt(plain, r.kek)
+}
+
+// openContainer returns the decrypted tar.gz a password opens
+func openContainer(containerPath, password string) (*bytes.Buffer, error) {
+	containerFile, err := os.Open(containerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container: %w", err)
+	}
+	defer containerFile.Close()
+
+	header, err := readHeader(containerFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := unlock(containerPath, containerFile, header, password)
+	if err != nil {
+		return nil, err
+	}
+	return decryptPayload(containerFile, key)
+}
+
+// unlock returns the key to the payload a password opens, destroying the
+// other slots if it is a duress password set to wipe. containerFile must
+// be positioned after the header.
+func unlock(containerPath string, containerFile *os.File, header *ContainerHeader, password string) (*RecoveryKey, error) {
+	if check := loadUnlockCheck(); check != nil {
+		if err := check(containerPath); err != nil {
+			return nil, err
+		}
+	}
+	kek, err := header.KDFParams().DeriveKey(password, header.Salt[:])
+	if err != nil {
+		return nil, err
+	}
+	id := containerID(header)
+
+	switch header.Version {
+	case 1: // The password's key encrypts the data directly
+		return &RecoveryKey{ContainerID: id, Key: kek, Offset: 0, Length: -1}, nil
+
+	case Version:
+		area := make([]byte, slotAreaSize)
+		if _, err := io.ReadFull(containerFile, area); err != nil {
+			return nil, fmt.Errorf("failed to read key slots: %w", err)
+		}
+		r, index := openSlot(area, kek)
+		if r == nil {
+			return nil, fmt.Errorf("failed to decrypt data (wrong password?): %w", ErrWrongPassword)
+		}
+		if r.flags&slotWipe != 0 {
+			// Failure is ignored: it must look no different from success
+			wipeSlots(containerPath, index)
+		}
+		if r.flags&slotNoData != 0 {
+			return nil, fmt.Errorf("failed to decrypt data (wrong password?): %w", ErrWrongPassword)
+		}
+		return &RecoveryKey{ContainerID: id, Key: r.dek, Offset: r.offset, Length: r.length}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported container version %d", header.Version)
+	}
+}
+
+// decryptPayload decrypts the payload a key opens
+func decryptPayload(containerFile *os.File, key *RecoveryKey) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if err := DecryptStream(payloadReader(containerFile, key), &buf, key.Key); err != nil {
+		return nil, fmt.Errorf("failed to decrypt data (wrong password?): %w", err)
+	}
+	return &buf, nil
+}
+
+// payloadReader returns the encrypted payload a key opens
+func payloadReader(containerFile *os.File, key *RecoveryKey) io.Reader {
+	if key.Length < 0 {
+		return io.NewSectionReader(containerFile, HeaderSize, 1<<62)
+	}
+	return io.NewSectionReader(containerFile, HeaderSize+slotAreaSize+key.Offset, key.Length)
+}
+
+// openSlot finds the slot kek opens and returns its region and index
+func openSlot(area, kek []byte) (*region, int) {
+	var found *region
+	index := -1
+	// Every slot is tried so timing does not tell which one matched
+	for i := 0; i < KeySlots; i++ {
+		plain, err := Decrypt(area[i*slotSize:(i+1)*slotSize], kek)
+		if err != nil || found != nil {
+			continue
+		}
+		found = &region{
+			dek:    plain[:KeySize],
+			flags:  plain[KeySize],
+			offset: int64(binary.BigEndian.Uint64(plain[KeySize+1:])),
+			length: int64(binary.BigEndian.Uint64(plain[KeySize+9:])),
+		}
+		index = i
+	}
+	return found, index
+}
+
+// wipeSlots overwrites every key slot but keep with random bytes
+func wipeSlots(containerPath string, keep int) error {
+	containerFile, err := os.OpenFile(containerPath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer containerFile.Close()
+
+	for i := 0; i < KeySlots; i++ {
+		if i == keep {
+			continue
+		}
+		slot := make([]byte, slotSize)
+		if _, err := rand.Read(slot); err != nil {
+			return err
+		}
+		if _, err := containerFile.WriteAt(slot, HeaderSize+int64(i*slotSize)); err != nil {
+			return err
+		}
+	}
+	return containerFile.Sync()
+}
+
+// ReadContainerHeader reads and checks the header of a container
+func ReadContainerHeader(containerPath string) (*ContainerHeader, error) {
+	containerFile, err := os.Open(containerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container: %w", err)
+	}
+	defer containerFile.Close()
+	return readHeader(containerFile)
+}
+
+// readHeader reads and checks a container header
+func readHeader(r io.Reader) (*ContainerHeader, error) {
+	var header ContainerHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(header.Magic[:]) != MagicBytes {
+		return nil, fmt.Errorf("invalid container format")
+	}
+	return &header, nil
+}
+
+// shuffled returns the regions in random order
+func shuffled(regions []*region) ([]*region, error) {
+	out := append([]*region(nil), regions...)
+	for i := len(out) - 1; i > 0; i-- {
+		j, err := randomInt(int64(i + 1))
+		if err != nil {
+			return nil, err
+		}
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
+
+// randomInt returns a uniform random number in [0, n)
+func randomInt(n int64) (int64, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(n))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate random number: %w", err)
+	}
+	return v.Int64(), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
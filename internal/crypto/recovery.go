@@ -0,0 +1,75 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrRecoveryKeyMismatch = errors.New("recovery key belongs to a different container")
+
+// RecoveryKey opens a container's data without its password. It is only
+// valid until the container is rebuilt, e.g. by SetDuressPassword.
+type RecoveryKey struct {
+	ContainerID string `json:"container_id"`
+	Key         []byte `json:"key"`
+	Offset      int64  `json:"offset"`
+	Length      int64  `json:"length"` // -1 for the rest of a version 1 container
+}
+
+// ContainerID identifies a container without revealing anything about its
+// contents. It changes when the container is rebuilt.
+func ContainerID(containerPath string) (string, error) {
+	header, err := ReadContainerHeader(containerPath)
+	if err != nil {
+		return "", err
+	}
+	return containerID(header), nil
+}
+
+func containerID(header *ContainerHeader) string {
+	sum := sha256.Sum256(append([]byte("sfm-container-id"), header.Salt[:]...))
+	return hex.EncodeToString(sum[:16])
+}
+
+// ExportRecoveryKey returns the recovery key of a container
+func ExportRecoveryKey(containerPath, password string) (*RecoveryKey, error) {
+	containerFile, err := os.Open(containerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container: %w", err)
+	}
+	defer containerFile.Close()
+
+	header, err := readHeader(containerFile)
+	if err != nil {
+		return nil, err
+	}
+	return unlock(containerPath, containerFile, header, password)
+}
+
+// RecoverContainer rebuilds a container with a new password using its
+// recovery key. A duress password, if any, is dropped.
+func RecoverContainer(containerPath string, key *RecoveryKey, newPassword string) error {
+	containerFile, err := os.Open(containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to open container: %w", err)
+	}
+	header, err := readHeader(containerFile)
+	if err != nil {
+		containerFile.Close()
+		return err
+	}
+	if containerID(header) != key.ContainerID {
+		containerFile.Close()
+		return ErrRecoveryKeyMismatch
+	}
+	archive, err := decryptPayload(containerFile, key)
+	containerFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return replaceContainer(containerPath, archive, newPassword, DuressOptions{}, header)
+}
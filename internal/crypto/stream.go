@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// streamBufferSize is the read buffer used when streaming a container,
+// large enough that the file is read in few system calls
+const streamBufferSize = 1 << 20
+
+var ErrEntryNotFound = errors.New("entry not found in container")
+
+// ExtractToWriter streams the contents of a container to w as an
+// uncompressed tar archive, without writing anything to disk, e.g. to pipe
+// into tar or to serve a download of the whole container
+func ExtractToWriter(containerPath, password string, w io.Writer) error {
+	return streamArchive(containerPath, password, func(gz *gzip.Reader) error {
+		if _, err := io.Copy(w, gz); err != nil {
+			return fmt.Errorf("failed to stream container: %w", err)
+		}
+		return nil
+	})
+}
+
+// ExtractEntryToWriter streams one file of a container to w, without
+// writing anything to disk, and returns the bytes written. name is the
+// entry's slash-separated path in the container.
+func ExtractEntryToWriter(containerPath, password, name string, w io.Writer) (int64, error) {
+	want := cleanEntryName(name)
+	var written int64
+	err := streamArchive(containerPath, password, func(gz *gzip.Reader) error {
+		tarReader := tar.NewReader(gz)
+		for {
+			header, err := tarReader.Next()
+			if err == io.EOF {
+				return ErrEntryNotFound
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read tar: %w", err)
+			}
+			if header.Typeflag != tar.TypeReg || cleanEntryName(header.Name) != want {
+				continue
+			}
+			written, err = io.Copy(w, tarReader)
+			if err != nil {
+				return fmt.Errorf("failed to stream entry: %w", err)
+			}
+			return nil
+		}
+	})
+	return written, err
+}
+
+// streamArchive unlocks a container and passes its decrypting,
+// decompressing reader to fn
+func streamArchive(containerPath, password string, fn func(*gzip.Reader) error) error {
+	containerFile, err := os.Open(containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to open container: %w", err)
+	}
+	defer containerFile.Close()
+
+	header, err := readHeader(containerFile)
+	if err != nil {
+		return err
+	}
+	key, err := unlock(containerPath, containerFile, header, password)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := decryptReader(bufio.NewReaderSize(payloadReader(containerFile, key), streamBufferSize), key.Key)
+	if err != nil {
+		return err
+	}
+
+	gzReader, err := gzip.NewReader(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt data (wrong password?): %w", err)
+	}
+	defer gzReader.Close()
+	return fn(gzReader)
+}
+
+// decryptReader returns a reader decrypting a stream written by
+// EncryptStream
+func decryptReader(r io.Reader, key []byte) (io.Reader, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	nonce := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("failed to read nonce: %w", err)
+	}
+	return &cipher.StreamReader{S: cipher.NewCTR(block, nonce), R: r}, nil
+}
+
+// cleanEntryName normalizes an archive path for comparison
+func cleanEntryName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, `\`, "/")), "/")
+}
@@ -0,0 +1,154 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+const clientTimeout = 10 * time.Second
+
+// Client resolves and publishes directory addresses
+type Client struct {
+	http   *http.Client
+	scheme string
+}
+
+// NewClient creates a directory client. insecure uses plain HTTP, for
+// servers on a trusted network or in testing.
+func NewClient(insecure bool) *Client {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &Client{http: &http.Client{Timeout: clientTimeout}, scheme: scheme}
+}
+
+func (c *Client) entryURL(handle, host string) string {
+	return (&url.URL{Scheme: c.scheme, Host: host, Path: entryPath + handle}).String()
+}
+
+// Resolve looks up address ("handle@host") and returns its verified
+// entry. The first owner key seen for an address is pinned, and entries
+// signed by any other key are refused with ErrOwnerChanged.
+func (c *Client) Resolve(ctx context.Context, address string) (*Entry, error) {
+	handle, host, err := ParseAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.entryURL(handle, host), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query directory: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("directory returned %s", resp.Status)
+	}
+
+	var entry Entry
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEntrySize)).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("failed to decode entry: %w", err)
+	}
+	if entry.Handle != handle {
+		return nil, fmt.Errorf("directory returned the entry for %q", entry.Handle)
+	}
+	if err := entry.Verify(); err != nil {
+		return nil, err
+	}
+	if err := pin(handle+"@"+host, entry.Owner); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Publish signs an entry listing devices with key and publishes it at
+// address. The handle must be unclaimed or already owned by key.
+func (c *Client) Publish(ctx context.Context, address string, key crypto.PrivKey, devices []Device) error {
+	handle, host, err := ParseAddress(address)
+	if err != nil {
+		return err
+	}
+	entry, err := NewEntry(handle, devices, key)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode entry: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.entryURL(handle, host), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish entry: %w", err)
+	}
+	defer resp.Body.Close()
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+	case http.StatusForbidden:
+		return ErrOwnerChanged
+	case http.StatusConflict:
+		return ErrStale
+	default:
+		return fmt.Errorf("directory refused entry: %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	return pin(handle+"@"+host, entry.Owner)
+}
+
+// pin records owner for address the first time, and fails if a different
+// owner was recorded before
+func pin(address, owner string) error {
+	db := storage.DB()
+	var existing models.DirectoryPin
+	err := db.Where("address = ?", address).First(&existing).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if err := db.Create(&models.DirectoryPin{Address: address, Owner: owner}).Error; err != nil {
+			return fmt.Errorf("failed to pin directory key: %w", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load directory pin: %w", err)
+	}
+	if existing.Owner != owner {
+		return ErrOwnerChanged
+	}
+	return nil
+}
+
+// Unpin forgets the key pinned for address, to accept a new owner after
+// checking it out of band
+func Unpin(address string) error {
+	handle, host, err := ParseAddress(address)
+	if err != nil {
+		return err
+	}
+	if err := storage.DB().Where("address = ?", handle+"@"+host).Delete(&models.DirectoryPin{}).Error; err != nil {
+		return fmt.Errorf("failed to remove directory pin: %w", err)
+	}
+	return nil
+}
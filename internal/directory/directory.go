@@ -0,0 +1,152 @@
+// Package directory publishes device keys under a handle on a
+// self-hosted server, so "alice@example.org" can be resolved to the peer
+// IDs of Alice's devices. The server only ever sees public keys; content
+// is still end-to-end encrypted between the devices.
+//
+// Entries are signed by their owner's key, so a server can refuse or
+// withhold an entry but cannot forge one for a key it does not hold.
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// MaxDevices caps the devices one entry may list
+const MaxDevices = 32
+
+var (
+	ErrBadSignature = errors.New("directory entry signature is invalid")
+	ErrNotFound     = errors.New("handle is not in the directory")
+	ErrOwnerChanged = errors.New("handle belongs to a different key")
+	ErrStale        = errors.New("directory entry is older than the one stored")
+)
+
+var handlePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// Device is one device published under a handle. Its peer ID is also its
+// public key and the fingerprint shown when pairing.
+type Device struct {
+	Name   string `json:"name"`
+	PeerID string `json:"peer_id"`
+}
+
+// Entry lists the devices published under a handle
+type Entry struct {
+	Handle    string    `json:"handle"`
+	Owner     string    `json:"owner"` // Peer ID of the key that signs the entry
+	Devices   []Device  `json:"devices"`
+	Updated   time.Time `json:"updated"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// ValidHandle reports whether handle may be published
+func ValidHandle(handle string) bool {
+	return handlePattern.MatchString(handle)
+}
+
+// ParseAddress splits "handle@host" into its handle and server host
+func ParseAddress(address string) (handle, host string, err error) {
+	handle, host, ok := strings.Cut(strings.TrimSpace(address), "@")
+	handle = strings.ToLower(handle)
+	if !ok || host == "" || strings.ContainsAny(host, "/?#@") || !ValidHandle(handle) {
+		return "", "", fmt.Errorf("invalid directory address %q", address)
+	}
+	return handle, host, nil
+}
+
+// NewEntry creates an entry for handle listing devices, signed by key
+func NewEntry(handle string, devices []Device, key crypto.PrivKey) (*Entry, error) {
+	owner, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	entry := &Entry{
+		Handle:  handle,
+		Owner:   owner.String(),
+		Devices: devices,
+		Updated: time.Now().UTC().Truncate(time.Second),
+	}
+	if err := entry.check(); err != nil {
+		return nil, err
+	}
+	payload, err := entry.signedBytes()
+	if err != nil {
+		return nil, err
+	}
+	entry.Signature, err = key.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign entry: %w", err)
+	}
+	return entry, nil
+}
+
+// Verify checks that the entry is well formed and signed by its owner
+func (e *Entry) Verify() error {
+	if err := e.check(); err != nil {
+		return err
+	}
+	owner, err := peer.Decode(e.Owner)
+	if err != nil {
+		return fmt.Errorf("invalid owner: %w", err)
+	}
+	pub, err := owner.ExtractPublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to extract owner key: %w", err)
+	}
+	payload, err := e.signedBytes()
+	if err != nil {
+		return err
+	}
+	if ok, err := pub.Verify(payload, e.Signature); err != nil || !ok {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// PeerIDs returns the peer IDs of the entry's devices
+func (e *Entry) PeerIDs() ([]peer.ID, error) {
+	ids := make([]peer.ID, 0, len(e.Devices))
+	for _, device := range e.Devices {
+		id, err := peer.Decode(device.PeerID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID for %s: %w", device.Name, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (e *Entry) check() error {
+	if !ValidHandle(e.Handle) {
+		return fmt.Errorf("invalid handle %q", e.Handle)
+	}
+	if len(e.Devices) == 0 || len(e.Devices) > MaxDevices {
+		return fmt.Errorf("entry must list 1 to %d devices", MaxDevices)
+	}
+	for _, device := range e.Devices {
+		if _, err := peer.Decode(device.PeerID); err != nil {
+			return fmt.Errorf("invalid peer ID for %s: %w", device.Name, err)
+		}
+	}
+	return nil
+}
+
+// signedBytes returns the encoding the signature covers: the entry without
+// its signature
+func (e *Entry) signedBytes() ([]byte, error) {
+	unsigned := *e
+	unsigned.Signature = nil
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode entry: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,191 @@
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// entryPath is the URL path entries are served under
+const entryPath = "/v1/entries/"
+
+const maxEntrySize = 64 * 1024
+
+// Server serves directory entries stored as <handle>.json files in a
+// directory. It is read-only unless publishing is allowed; an admin can
+// also publish by placing signed entry files there.
+type Server struct {
+	port         int
+	dir          string
+	allowPublish bool
+	server       *http.Server
+	mu           sync.Mutex
+}
+
+// NewServer creates a directory server for the entries in dir
+func NewServer(port int, dir string, allowPublish bool) *Server {
+	return &Server{port: port, dir: dir, allowPublish: allowPublish}
+}
+
+// Start starts the HTTP server. Put it behind a TLS-terminating proxy;
+// clients expect HTTPS.
+func (s *Server) Start() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	s.mu.Lock()
+	s.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", s.port),
+		Handler: s,
+	}
+	s.mu.Unlock()
+
+	mode := "read-only"
+	if s.allowPublish {
+		mode = "publishing allowed"
+	}
+	log.Printf("Key directory (%s) listening on port %d", mode, s.port)
+	return s.server.ListenAndServe()
+}
+
+// Stop stops the HTTP server
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		return s.server.Close()
+	}
+	return nil
+}
+
+// ServeHTTP serves GET and, if allowed, PUT of /v1/entries/<handle>
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handle, ok := strings.CutPrefix(r.URL.Path, entryPath)
+	if !ok || !ValidHandle(handle) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		entry, err := s.load(handle)
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to load directory entry %s: %v", handle, err)
+			http.Error(w, "failed to load entry", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+
+	case http.MethodPut:
+		if !s.allowPublish {
+			http.Error(w, "directory is read-only", http.StatusMethodNotAllowed)
+			return
+		}
+		var entry Entry
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxEntrySize)).Decode(&entry); err != nil {
+			http.Error(w, "invalid entry", http.StatusBadRequest)
+			return
+		}
+		if entry.Handle != handle {
+			http.Error(w, "handle does not match path", http.StatusBadRequest)
+			return
+		}
+		if err := entry.Verify(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.publish(&entry); err != nil {
+			switch {
+			case errors.Is(err, ErrOwnerChanged):
+				http.Error(w, err.Error(), http.StatusForbidden)
+			case errors.Is(err, ErrStale):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				log.Printf("Failed to publish directory entry %s: %v", handle, err)
+				http.Error(w, "failed to save entry", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) entryFile(handle string) string {
+	return filepath.Join(s.dir, handle+".json")
+}
+
+func (s *Server) load(handle string) (*Entry, error) {
+	data, err := os.ReadFile(s.entryFile(handle))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to decode entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// publish stores a verified entry. A handle stays with the key that first
+// published it, and entries only move forward in time.
+func (s *Server) publish(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.load(entry.Handle)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if existing != nil {
+		if existing.Owner != entry.Owner {
+			return ErrOwnerChanged
+		}
+		if !entry.Updated.After(existing.Updated) {
+			return ErrStale
+		}
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode entry: %w", err)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.entryFile(entry.Handle)); err != nil {
+		return fmt.Errorf("failed to save entry: %w", err)
+	}
+	log.Printf("Published directory entry %s with %d devices", entry.Handle, len(entry.Devices))
+	return nil
+}
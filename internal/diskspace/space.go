@@ -0,0 +1,203 @@
+package diskspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrInsufficientSpace is returned when a reservation does not fit
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+var (
+	mu           sync.Mutex
+	reserved     = map[string]int64{} // volume -> bytes
+	minFree      int64
+	quotas       = map[string]int64{} // directory -> max bytes
+	quotaReserve = map[string]int64{} // directory -> reserved bytes
+)
+
+// Configure sets the free-space safety margin kept on every volume and
+// optional per-directory quotas
+func Configure(minFreeBytes int64, dirQuotas map[string]int64) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	minFree = minFreeBytes
+	quotas = make(map[string]int64, len(dirQuotas))
+	for dir, limit := range dirQuotas {
+		if abs, err := filepath.Abs(dir); err == nil {
+			quotas[abs] = limit
+		}
+	}
+}
+
+// Reservation holds space on a volume until released
+type Reservation struct {
+	volume   string
+	quotaDir string
+	size     int64
+	once     sync.Once
+}
+
+// Reserve checks that size bytes fit in dir (after existing reservations,
+// the safety margin and any quota) and holds them until Release
+func Reserve(dir string, size int64) (*Reservation, error) {
+	if size < 0 {
+		size = 0
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	// Check the nearest existing ancestor, the target may not exist yet
+	probe := existingAncestor(dir)
+
+	free, err := Free(probe)
+	if err != nil {
+		return nil, err
+	}
+	volume, err := volumeID(probe)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	available := int64(free) - reserved[volume] - minFree
+	if size > available {
+		if available < 0 {
+			available = 0
+		}
+		return nil, fmt.Errorf("%w: need %s, %s available on %s", ErrInsufficientSpace,
+			FormatBytes(size), FormatBytes(available), probe)
+	}
+
+	quotaDir, limit := quotaFor(dir)
+	if quotaDir != "" {
+		used, err := DirSize(quotaDir)
+		if err != nil {
+			return nil, err
+		}
+		remaining := limit - used - quotaReserve[quotaDir]
+		if size > remaining {
+			if remaining < 0 {
+				remaining = 0
+			}
+			return nil, fmt.Errorf("%w: need %s, quota for %s has %s left", ErrInsufficientSpace,
+				FormatBytes(size), quotaDir, FormatBytes(remaining))
+		}
+		quotaReserve[quotaDir] += size
+	}
+
+	reserved[volume] += size
+
+	return &Reservation{volume: volume, quotaDir: quotaDir, size: size}, nil
+}
+
+// Release returns the reserved space. Safe to call more than once.
+func (r *Reservation) Release() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reserved[r.volume] -= r.size
+		if reserved[r.volume] <= 0 {
+			delete(reserved, r.volume)
+		}
+		if r.quotaDir != "" {
+			quotaReserve[r.quotaDir] -= r.size
+			if quotaReserve[r.quotaDir] <= 0 {
+				delete(quotaReserve, r.quotaDir)
+			}
+		}
+	})
+}
+
+// Size returns the number of reserved bytes
+func (r *Reservation) Size() int64 {
+	return r.size
+}
+
+// Check reports whether size bytes would fit in dir without holding them
+func Check(dir string, size int64) error {
+	r, err := Reserve(dir, size)
+	if err != nil {
+		return err
+	}
+	r.Release()
+	return nil
+}
+
+// DirSize returns the total size of regular files under path
+func DirSize(path string) (int64, error) {
+	var total int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure %s: %w", path, err)
+	}
+	return total, nil
+}
+
+// FormatBytes formats a byte count for error messages
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// quotaFor returns the innermost configured quota directory containing dir
+func quotaFor(dir string) (string, int64) {
+	best := ""
+	var limit int64
+	for quotaDir, l := range quotas {
+		rel, err := filepath.Rel(quotaDir, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(quotaDir) > len(best) {
+			best, limit = quotaDir, l
+		}
+	}
+	return best, limit
+}
+
+func existingAncestor(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
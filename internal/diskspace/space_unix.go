@@ -0,0 +1,25 @@
+//go:build !windows
+
+package diskspace
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Free returns the bytes available to unprivileged users on path's filesystem
+func Free(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to stat filesystem: %w", err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+func volumeID(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	return fmt.Sprintf("dev:%d", stat.Dev), nil
+}
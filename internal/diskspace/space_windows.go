@@ -0,0 +1,29 @@
+//go:build windows
+
+package diskspace
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Free returns the bytes available to the current user on path's volume
+func Free(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var freeBytes uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &freeBytes, nil, nil); err != nil {
+		return 0, fmt.Errorf("failed to query free space: %w", err)
+	}
+	return freeBytes, nil
+}
+
+func volumeID(path string) (string, error) {
+	return strings.ToUpper(filepath.VolumeName(path)), nil
+}
@@ -0,0 +1,261 @@
+package fec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the largest data + parity count a GF(2^8) code supports
+const MaxShards = 256
+
+var (
+	ErrTooFewShards  = errors.New("too few shards to reconstruct")
+	ErrShardSize     = errors.New("shards differ in size")
+	ErrInvalidShards = errors.New("invalid shard counts")
+)
+
+// Encoder is a systematic Reed-Solomon erasure code over GF(2^8). Data
+// shards are sent unchanged; any data-many of the data + parity shards are
+// enough to rebuild the rest.
+type Encoder struct {
+	data   int
+	parity int
+	matrix [][]byte // Parity rows of the encoding matrix
+}
+
+// New creates an encoder for data data shards and parity parity shards
+func New(data, parity int) (*Encoder, error) {
+	if data <= 0 || parity <= 0 || data+parity > MaxShards {
+		return nil, fmt.Errorf("%w: %d data, %d parity", ErrInvalidShards, data, parity)
+	}
+
+	// A Cauchy matrix has every square submatrix invertible, so stacking it
+	// under the identity gives an MDS code
+	matrix := make([][]byte, parity)
+	for i := range matrix {
+		matrix[i] = make([]byte, data)
+		for j := range matrix[i] {
+			matrix[i][j] = gfInv(byte(data+i) ^ byte(j))
+		}
+	}
+
+	return &Encoder{data: data, parity: parity, matrix: matrix}, nil
+}
+
+// DataShards returns the number of data shards
+func (e *Encoder) DataShards() int {
+	return e.data
+}
+
+// ParityShards returns the number of parity shards
+func (e *Encoder) ParityShards() int {
+	return e.parity
+}
+
+// Encode fills shards[data:] with parity computed from shards[:data]. All
+// shards must be allocated and the same size.
+func (e *Encoder) Encode(shards [][]byte) error {
+	if len(shards) != e.data+e.parity {
+		return ErrInvalidShards
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+	for _, s := range shards {
+		if len(s) != size {
+			return ErrShardSize
+		}
+	}
+
+	for i, row := range e.matrix {
+		codeRow(row, shards[:e.data], shards[e.data+i])
+	}
+	return nil
+}
+
+// AddShard adds data shard i to parity computed incrementally, so a sender
+// can stream shards without holding the whole group. parity must start
+// zeroed; a short shard counts as zero-padded.
+func (e *Encoder) AddShard(i int, shard []byte, parity [][]byte) error {
+	if i < 0 || i >= e.data || len(parity) != e.parity {
+		return ErrInvalidShards
+	}
+	for p, row := range e.matrix {
+		if len(shard) > len(parity[p]) {
+			return ErrShardSize
+		}
+		mul := mulTable[row[i]][:]
+		out := parity[p]
+		for k, b := range shard {
+			out[k] ^= mul[b]
+		}
+	}
+	return nil
+}
+
+// Reconstruct rebuilds missing (nil) shards in place
+func (e *Encoder) Reconstruct(shards [][]byte) error {
+	if len(shards) != e.data+e.parity {
+		return ErrInvalidShards
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	// Pick the first data-many present shards and their encoding rows
+	rows := make([][]byte, 0, e.data)
+	inputs := make([][]byte, 0, e.data)
+	for i, s := range shards {
+		if s == nil {
+			continue
+		}
+		if len(s) != size {
+			return ErrShardSize
+		}
+		rows = append(rows, e.row(i))
+		inputs = append(inputs, s)
+		if len(rows) == e.data {
+			break
+		}
+	}
+	if len(rows) < e.data {
+		return ErrTooFewShards
+	}
+
+	decode, err := invert(rows)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < e.data; i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			codeRow(decode[i], inputs, shards[i])
+		}
+	}
+	for i, row := range e.matrix {
+		if shards[e.data+i] == nil {
+			shards[e.data+i] = make([]byte, size)
+			codeRow(row, shards[:e.data], shards[e.data+i])
+		}
+	}
+	return nil
+}
+
+// row returns row i of the full encoding matrix (identity over parity)
+func (e *Encoder) row(i int) []byte {
+	if i >= e.data {
+		return e.matrix[i-e.data]
+	}
+	row := make([]byte, e.data)
+	row[i] = 1
+	return row
+}
+
+func shardSize(shards [][]byte) (int, error) {
+	for _, s := range shards {
+		if s != nil {
+			return len(s), nil
+		}
+	}
+	return 0, ErrTooFewShards
+}
+
+// codeRow sets out to the linear combination of inputs given by coeffs
+func codeRow(coeffs []byte, inputs [][]byte, out []byte) {
+	for i := range out {
+		out[i] = 0
+	}
+	for j, c := range coeffs {
+		if c == 0 {
+			continue
+		}
+		mul := mulTable[c][:]
+		for i, b := range inputs[j] {
+			out[i] ^= mul[b]
+		}
+	}
+}
+
+// invert inverts a square matrix by Gauss-Jordan elimination
+func invert(m [][]byte) ([][]byte, error) {
+	n := len(m)
+	work := make([][]byte, n)
+	for i := range m {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], m[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		pivot := -1
+		for r := col; r < n; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errors.New("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := gfInv(work[col][col])
+		for k := range work[col] {
+			work[col][k] = gfMul(work[col][k], scale)
+		}
+		for r := 0; r < n; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			f := work[r][col]
+			for k := range work[r] {
+				work[r][k] ^= gfMul(f, work[col][k])
+			}
+		}
+	}
+
+	result := make([][]byte, n)
+	for i := range work {
+		result[i] = work[i][n:]
+	}
+	return result, nil
+}
+
+// GF(2^8) arithmetic with the 0x11d polynomial
+var (
+	expTable [510]byte
+	logTable [256]byte
+	mulTable [256][256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			mulTable[a][b] = gfMul(byte(a), byte(b))
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func gfInv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
@@ -0,0 +1,193 @@
+package hold
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+// Record kinds that can be held
+const (
+	KindContainer = "container"
+	KindTransfer  = "transfer"
+)
+
+// All holds every record of a kind
+const All = "*"
+
+// Argon2id parameters for the master credential
+const (
+	credentialTime    = 3
+	credentialMemory  = 64 * 1024
+	credentialThreads = 4
+)
+
+var (
+	ErrHeld               = errors.New("record is under legal hold")
+	ErrNoMasterCredential = errors.New("no master credential configured")
+	ErrBadCredential      = errors.New("master credential is incorrect")
+	ErrUnknownKind        = errors.New("unknown hold kind")
+)
+
+// SetMasterCredential sets or changes the credential that releases holds.
+// Changing it requires the current credential.
+func SetMasterCredential(current, next string) error {
+	if next == "" {
+		return fmt.Errorf("master credential must not be empty")
+	}
+
+	db := storage.DB()
+	var existing models.MasterCredential
+	found := db.First(&existing).Error == nil
+	if found && !verify(existing, current) {
+		return ErrBadCredential
+	}
+
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return err
+	}
+	record := models.MasterCredential{
+		Salt:          salt,
+		Hash:          crypto.DeriveKey(next, salt, credentialTime, credentialMemory, credentialThreads),
+		Argon2Time:    credentialTime,
+		Argon2Memory:  credentialMemory,
+		Argon2Threads: credentialThreads,
+	}
+	if found {
+		record.ID = existing.ID
+		record.CreatedAt = existing.CreatedAt
+	}
+	if err := db.Save(&record).Error; err != nil {
+		return fmt.Errorf("failed to save master credential: %w", err)
+	}
+	return nil
+}
+
+func verify(record models.MasterCredential, credential string) bool {
+	hash := crypto.DeriveKey(credential, record.Salt, record.Argon2Time, record.Argon2Memory, record.Argon2Threads)
+	return subtle.ConstantTimeCompare(hash, record.Hash) == 1
+}
+
+// Place puts a record under hold. A master credential must exist first so
+// the hold can eventually be released.
+func Place(kind, target, reason string) error {
+	target, err := normalize(kind, target)
+	if err != nil {
+		return err
+	}
+
+	db := storage.DB()
+	var count int64
+	db.Model(&models.MasterCredential{}).Count(&count)
+	if count == 0 {
+		return ErrNoMasterCredential
+	}
+
+	hold := models.LegalHold{Kind: kind, Target: target, Reason: reason}
+	if err := db.Where("kind = ? AND target = ?", kind, target).FirstOrCreate(&hold).Error; err != nil {
+		return fmt.Errorf("failed to place hold: %w", err)
+	}
+	return nil
+}
+
+// Release lifts a hold after checking the master credential
+func Release(kind, target, credential string) error {
+	target, err := normalize(kind, target)
+	if err != nil {
+		return err
+	}
+
+	db := storage.DB()
+	var record models.MasterCredential
+	if err := db.First(&record).Error; err != nil {
+		return ErrNoMasterCredential
+	}
+	if !verify(record, credential) {
+		return ErrBadCredential
+	}
+
+	return db.Where("kind = ? AND target = ?", kind, target).Delete(&models.LegalHold{}).Error
+}
+
+// List returns all active holds
+func List() ([]models.LegalHold, error) {
+	var holds []models.LegalHold
+	err := storage.DB().Order("kind, target").Find(&holds).Error
+	return holds, err
+}
+
+// Check returns an ErrHeld error if the record, or every record of its
+// kind, is held
+func Check(kind, target string) error {
+	target, err := normalize(kind, target)
+	if err != nil {
+		return err
+	}
+
+	var hold models.LegalHold
+	err = storage.DB().Where("kind = ? AND target IN ?", kind, []string{target, All}).First(&hold).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		// Fail closed so a database error never bypasses a hold
+		return fmt.Errorf("failed to check hold: %w", err)
+	}
+	if hold.Reason != "" {
+		return fmt.Errorf("%w: %s (%s)", ErrHeld, target, hold.Reason)
+	}
+	return fmt.Errorf("%w: %s", ErrHeld, target)
+}
+
+// CheckContainer checks a container path
+func CheckContainer(path string) error {
+	return Check(KindContainer, path)
+}
+
+// CheckTransfer checks a transfer history record
+func CheckTransfer(id uint) error {
+	return Check(KindTransfer, strconv.FormatUint(uint64(id), 10))
+}
+
+// HeldTransferIDs returns the IDs of individually held transfers and
+// whether all transfers are held
+func HeldTransferIDs() ([]string, bool, error) {
+	var holds []models.LegalHold
+	if err := storage.DB().Where("kind = ?", KindTransfer).Find(&holds).Error; err != nil {
+		return nil, false, err
+	}
+	ids := make([]string, 0, len(holds))
+	for _, h := range holds {
+		if h.Target == All {
+			return nil, true, nil
+		}
+		ids = append(ids, h.Target)
+	}
+	return ids, false, nil
+}
+
+func normalize(kind, target string) (string, error) {
+	switch kind {
+	case KindContainer:
+		if target == All {
+			return target, nil
+		}
+		abs, err := filepath.Abs(target)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve path: %w", err)
+		}
+		return abs, nil
+	case KindTransfer:
+		return target, nil
+	default:
+		return "", ErrUnknownKind
+	}
+}
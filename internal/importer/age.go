@@ -0,0 +1,49 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// maxAgeWorkFactor bounds the scrypt work factor a file may ask for (log2
+// N), as age itself does
+const maxAgeWorkFactor = 22
+
+// readAge decrypts a passphrase-protected age file, armored or binary. age
+// stores no name or times, so the file is named after the source and
+// keeps its modification time.
+func readAge(file *os.File, info os.FileInfo, passphrase string, add func(entry) error) error {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to use passphrase: %w", err)
+	}
+	identity.SetMaxWorkFactor(maxAgeWorkFactor)
+
+	in := bufio.NewReader(file)
+	var src io.Reader = in
+	if head, _ := in.Peek(len(armor.Header)); bytes.Equal(head, []byte(armor.Header)) {
+		src = armor.NewReader(in)
+	}
+
+	plain, err := age.Decrypt(src, identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return ErrWrongPassword
+		}
+		return fmt.Errorf("failed to open age file: %w", err)
+	}
+	return add(entry{
+		name:    plainName(info.Name(), ".age"),
+		mode:    info.Mode().Perm(),
+		modTime: info.ModTime(),
+		data:    plain,
+	})
+}
@@ -0,0 +1,71 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// readGPG decrypts a symmetrically encrypted OpenPGP message, as written
+// by gpg --symmetric, armored or binary. The name and time stored in the
+// message are kept when set.
+func readGPG(file *os.File, info os.FileInfo, passphrase string, add func(entry) error) error {
+	in := bufio.NewReader(file)
+	var src io.Reader = in
+	if head, _ := in.Peek(5); bytes.Equal(head, []byte("-----")) {
+		block, err := armor.Decode(in)
+		if err != nil {
+			return fmt.Errorf("failed to read armor: %w", err)
+		}
+		src = block.Body
+	}
+
+	// The prompt is asked again after a wrong passphrase
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if !symmetric {
+			return nil, fmt.Errorf("message is encrypted to a key, not a passphrase")
+		}
+		if tried {
+			return nil, ErrWrongPassword
+		}
+		tried = true
+		return []byte(passphrase), nil
+	}
+
+	md, err := openpgp.ReadMessage(src, openpgp.EntityList{}, prompt, nil)
+	if err != nil {
+		if errors.Is(err, ErrWrongPassword) {
+			return ErrWrongPassword
+		}
+		return fmt.Errorf("failed to open OpenPGP message: %w", err)
+	}
+	if !md.IsSymmetricallyEncrypted {
+		return fmt.Errorf("OpenPGP message is not passphrase-encrypted")
+	}
+
+	literal := md.LiteralData
+	name := plainName(info.Name(), ".gpg", ".pgp", ".asc")
+	if stored := filepath.Base(literal.FileName); literal.FileName != "" && literal.FileName != "_CONSOLE" &&
+		stored != "." && stored != ".." && stored != string(filepath.Separator) {
+		name = stored
+	}
+	modTime := info.ModTime()
+	if literal.Time != 0 {
+		modTime = time.Unix(int64(literal.Time), 0)
+	}
+	return add(entry{
+		name:    name,
+		mode:    info.Mode().Perm(),
+		modTime: modTime,
+		data:    md.UnverifiedBody,
+	})
+}
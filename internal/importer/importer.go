@@ -0,0 +1,260 @@
+// Package importer opens files encrypted by other tools and re-wraps their
+// contents into SFM containers, easing migration into SFM
+package importer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/hold"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/scrub"
+)
+
+// Formats that can be imported
+const (
+	FormatAge = "age" // age with a passphrase (scrypt recipient)
+	FormatGPG = "gpg" // OpenPGP symmetrically encrypted message
+	FormatZip = "zip" // Zip with WinZip AES-encrypted entries
+)
+
+var (
+	ErrUnknownFormat = errors.New("not an encrypted file format that can be imported")
+	ErrWrongPassword = errors.New("wrong passphrase")
+)
+
+// Options control an import
+type Options struct {
+	Passphrase string // Opens the source
+	Password   string // Protects the new container; Passphrase if empty
+	KDF        crypto.KDFParams
+}
+
+// Result describes an import
+type Result struct {
+	Format string
+	Files  int
+	Bytes  int64 // Plaintext size of the imported files
+}
+
+// entry is a file or directory found in a source, with its metadata
+type entry struct {
+	name    string // Slash-separated, relative
+	dir     bool
+	mode    os.FileMode
+	modTime time.Time
+	data    io.Reader
+}
+
+// Detect returns the format of an encrypted file
+func Detect(sourcePath string) (string, error) {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source: %w", err)
+	}
+	defer file.Close()
+
+	head, err := bufio.NewReader(file).Peek(64)
+	if err != nil && len(head) == 0 {
+		return "", fmt.Errorf("failed to read source: %w", err)
+	}
+	return detect(head)
+}
+
+func detect(head []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte("age-encryption.org/")),
+		bytes.HasPrefix(head, []byte("-----BEGIN AGE ENCRYPTED FILE-----")):
+		return FormatAge, nil
+	case bytes.HasPrefix(head, []byte("-----BEGIN PGP MESSAGE-----")):
+		return FormatGPG, nil
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return FormatZip, nil
+	case len(head) > 0 && isPGPPacket(head[0]):
+		return FormatGPG, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// isPGPPacket reports whether b starts a symmetric-key encrypted session
+// key packet (tag 3), which symmetrically encrypted messages begin with
+func isPGPPacket(b byte) bool {
+	if b&0x80 == 0 {
+		return false
+	}
+	if b&0x40 != 0 {
+		return b&0x3f == 3 // New format
+	}
+	return (b>>2)&0x0f == 3 // Old format
+}
+
+// Import decrypts sourcePath and writes its files, with their names, modes
+// and modification times, to a new container at containerPath. The
+// plaintext is only ever held in memory.
+func Import(sourcePath, containerPath string, opts Options) (*Result, error) {
+	if opts.Passphrase == "" {
+		return nil, fmt.Errorf("passphrase is required")
+	}
+	if opts.Password == "" {
+		opts.Password = opts.Passphrase
+	}
+	if err := opts.KDF.Check(); err != nil {
+		return nil, err
+	}
+	// Creating over a held container would replace it
+	if err := hold.CheckContainer(containerPath); err != nil {
+		return nil, err
+	}
+
+	format, err := Detect(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat source: %w", err)
+	}
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open source: %w", err)
+	}
+	defer file.Close()
+
+	result := &Result{Format: format}
+	archive := newArchive(result)
+	switch format {
+	case FormatAge:
+		err = readAge(file, info, opts.Passphrase, archive.add)
+	case FormatGPG:
+		err = readGPG(file, info, opts.Passphrase, archive.add)
+	case FormatZip:
+		err = readZip(file, info, opts.Passphrase, archive.add)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if result.Files == 0 {
+		return nil, fmt.Errorf("source holds no files")
+	}
+	buf, err := archive.close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build aside, so a failure never leaves a partial container behind
+	tmp := containerPath + ".tmp"
+	if err := crypto.CreateContainerFromArchive(buf, tmp, opts.Password, opts.KDF); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+	if err := crypto.VerifyContainer(tmp, opts.Password); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("container verification failed: %w", err)
+	}
+	if err := os.Rename(tmp, containerPath); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to save container: %w", err)
+	}
+
+	if err := scrub.TrackContainer(containerPath, sourcePath); err != nil {
+		log.Printf("Failed to track container %s: %v", logging.Path(containerPath), err)
+	}
+	log.Printf("Imported %s file %s: %d files", format, logging.Path(sourcePath), result.Files)
+	return result, nil
+}
+
+// archive builds the tar.gz a container holds
+type archive struct {
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	dirs   map[string]bool
+	result *Result
+}
+
+func newArchive(result *Result) *archive {
+	a := &archive{dirs: make(map[string]bool), result: result}
+	a.gz = gzip.NewWriter(&a.buf)
+	a.tw = tar.NewWriter(a.gz)
+	return a
+}
+
+// add writes an entry, and any parent directories not added yet
+func (a *archive) add(e entry) error {
+	name := strings.TrimSuffix(e.name, "/")
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("refusing entry outside the archive: %q", e.name)
+	}
+	if parent := filepath.ToSlash(filepath.Dir(filepath.FromSlash(name))); parent != "." && !a.dirs[parent] {
+		if err := a.add(entry{name: parent, dir: true, mode: 0755, modTime: e.modTime}); err != nil {
+			return err
+		}
+	}
+
+	if e.dir {
+		if a.dirs[name] {
+			return nil
+		}
+		a.dirs[name] = true
+		return a.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     name + "/",
+			Mode:     int64(e.mode.Perm()),
+			ModTime:  e.modTime,
+		})
+	}
+
+	// The size goes in the header, so the file is read first
+	data, err := io.ReadAll(e.data)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", name, err)
+	}
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(e.mode.Perm()),
+		ModTime:  e.modTime,
+		Size:     int64(len(data)),
+	}); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", name, err)
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", name, err)
+	}
+	a.result.Files++
+	a.result.Bytes += int64(len(data))
+	return nil
+}
+
+func (a *archive) close() (*bytes.Buffer, error) {
+	if err := a.tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := a.gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return &a.buf, nil
+}
+
+// plainName is the name of a single encrypted file without the extension
+// its tool added
+func plainName(sourcePath string, extensions ...string) string {
+	name := filepath.Base(sourcePath)
+	for _, ext := range extensions {
+		if trimmed := strings.TrimSuffix(name, ext); trimmed != name && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
@@ -0,0 +1,185 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// WinZip AES encryption (AE-1 and AE-2), as written by 7-Zip and WinZip
+const (
+	zipMethodAES     = 99
+	zipExtraAES      = 0x9901
+	zipAESIterations = 1000
+	zipVerifierSize  = 2
+	zipAuthSize      = 10
+	zipFlagEncrypted = 0x1
+)
+
+// readZip extracts a zip whose entries are AES-encrypted, keeping their
+// paths, modes and modification times. Unencrypted entries are taken as
+// they are; legacy ZipCrypto entries are refused, as that cipher is broken.
+func readZip(file *os.File, info os.FileInfo, passphrase string, add func(entry) error) error {
+	r, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("failed to read zip: %w", err)
+	}
+
+	for _, f := range r.File {
+		mode := f.Mode()
+		e := entry{name: f.Name, mode: mode.Perm(), modTime: f.Modified}
+		if mode.IsDir() || strings.HasSuffix(f.Name, "/") {
+			e.dir = true
+			if e.mode == 0 {
+				e.mode = 0755
+			}
+			if err := add(e); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			log.Printf("Skipping %s in zip: not a regular file", logging.Path(f.Name))
+			continue
+		}
+		if e.mode == 0 {
+			e.mode = 0644
+		}
+
+		var data io.ReadCloser
+		switch {
+		case f.Flags&zipFlagEncrypted == 0:
+			if data, err = f.Open(); err != nil {
+				return fmt.Errorf("failed to open %s: %w", f.Name, err)
+			}
+		case f.Method == zipMethodAES:
+			plain, err := openZipAES(f, passphrase)
+			if err != nil {
+				return err
+			}
+			data = io.NopCloser(plain)
+		default:
+			return fmt.Errorf("%s uses ZipCrypto encryption, which is not supported", f.Name)
+		}
+		e.data = data
+		err = add(e)
+		data.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openZipAES authenticates and decrypts an AES-encrypted zip entry
+func openZipAES(f *zip.File, passphrase string) (io.Reader, error) {
+	version, strength, method, ok := zipAESExtra(f.Extra)
+	if !ok {
+		return nil, fmt.Errorf("%s: missing AES parameters", f.Name)
+	}
+	if strength < 1 || strength > 3 {
+		return nil, fmt.Errorf("%s: unknown AES strength %d", f.Name, strength)
+	}
+	keySize := 8 * (int(strength) + 1) // 1, 2, 3 are AES-128, -192, -256
+	saltSize := keySize / 2
+
+	raw, err := f.OpenRaw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Name, err)
+	}
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+	}
+	if len(data) < saltSize+zipVerifierSize+zipAuthSize {
+		return nil, fmt.Errorf("%s: entry too short", f.Name)
+	}
+	salt := data[:saltSize]
+	verifier := data[saltSize : saltSize+zipVerifierSize]
+	body := data[saltSize+zipVerifierSize : len(data)-zipAuthSize]
+	auth := data[len(data)-zipAuthSize:]
+
+	keys := pbkdf2.Key([]byte(passphrase), salt, zipAESIterations, 2*keySize+zipVerifierSize, sha1.New)
+	encKey, macKey := keys[:keySize], keys[keySize:2*keySize]
+	if !hmac.Equal(keys[2*keySize:], verifier) {
+		return nil, ErrWrongPassword
+	}
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil)[:zipAuthSize], auth) {
+		return nil, fmt.Errorf("%s: authentication failed, the entry is damaged", f.Name)
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	plain := make([]byte, len(body))
+	zipCTR(block, plain, body)
+
+	switch method {
+	case zip.Store:
+	case zip.Deflate:
+		if plain, err = io.ReadAll(flate.NewReader(bytes.NewReader(plain))); err != nil {
+			return nil, fmt.Errorf("failed to decompress %s: %w", f.Name, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported compression method %d", f.Name, method)
+	}
+
+	// AE-2 leaves the CRC out, relying on the MAC alone
+	if version == 1 && crc32.ChecksumIEEE(plain) != f.CRC32 {
+		return nil, fmt.Errorf("%s: checksum mismatch", f.Name)
+	}
+	return bytes.NewReader(plain), nil
+}
+
+// zipAESExtra reads the AES extra field of an entry
+func zipAESExtra(extra []byte) (version uint16, strength byte, method uint16, ok bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != zipExtraAES || size < 7 || string(field[2:4]) != "AE" {
+			continue
+		}
+		return binary.LittleEndian.Uint16(field), field[4], binary.LittleEndian.Uint16(field[5:]), true
+	}
+	return 0, 0, 0, false
+}
+
+// zipCTR is AES-CTR as WinZip uses it: a little-endian counter starting at
+// 1, unlike the big-endian one cipher.NewCTR increments
+func zipCTR(block cipher.Block, dst, src []byte) {
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(src); i += aes.BlockSize {
+		for j := range counter {
+			counter[j]++
+			if counter[j] != 0 {
+				break
+			}
+		}
+		block.Encrypt(stream[:], counter[:])
+		n := min(aes.BlockSize, len(src)-i)
+		subtle.XORBytes(dst[i:i+n], src[i:i+n], stream[:n])
+	}
+}
@@ -0,0 +1,142 @@
+package journal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/hold"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/scrub"
+)
+
+// KindSecureContainer creates a container, verifies it, then removes the source
+const KindSecureContainer = "container.create_verify_remove"
+
+type secureContainerParams struct {
+	Source    string `json:"source"`
+	Container string `json:"container"`
+}
+
+func init() {
+	RegisterRecovery(KindSecureContainer, recoverSecureContainer)
+}
+
+// SecureContainer moves sourcePath into a new encrypted container. The
+// container is built beside containerPath and only moved into place once
+// it has been verified to decrypt with the password, and the source is
+// removed after that; a crash at any point is resolved by Recover.
+// Nothing already at containerPath is touched unless the new container
+// replaces it.
+func SecureContainer(sourcePath, containerPath, password string, argon2Time, argon2Memory uint32, argon2Threads uint8) error {
+	// Creating over a held container would truncate it
+	if err := hold.CheckContainer(containerPath); err != nil {
+		return err
+	}
+
+	// Compression usually shrinks the data, but plan for the worst case
+	sourceSize, err := diskspace.DirSize(sourcePath)
+	if err != nil {
+		return err
+	}
+	reservation, err := diskspace.Reserve(filepath.Dir(containerPath), sourceSize)
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
+
+	op, err := Begin(KindSecureContainer, secureContainerParams{
+		Source:    sourcePath,
+		Container: containerPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp := containerPath + ".tmp"
+	if err := op.Run("create", nil, func() error {
+		return crypto.CreateContainer(sourcePath, tmp, password, argon2Time, argon2Memory, argon2Threads)
+	}); err != nil {
+		os.Remove(tmp)
+		op.Abort(err)
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+
+	if err := op.Run("verify", nil, func() error {
+		return crypto.VerifyContainer(tmp, password)
+	}); err != nil {
+		os.Remove(tmp)
+		op.Abort(err)
+		return fmt.Errorf("container verification failed: %w", err)
+	}
+
+	if err := op.Run("place", nil, func() error {
+		return os.Rename(tmp, containerPath)
+	}); err != nil {
+		os.Remove(tmp)
+		op.Abort(err)
+		return fmt.Errorf("failed to move container into place: %w", err)
+	}
+
+	if err := op.Run("remove_source", nil, func() error {
+		return os.RemoveAll(sourcePath)
+	}); err != nil {
+		// The container is good; keep it and report the leftover source
+		op.Abort(err)
+		return fmt.Errorf("failed to remove source: %w", err)
+	}
+
+	if err := op.Commit(); err != nil {
+		return err
+	}
+	// Lets scrubs detect later corruption of the container
+	if err := scrub.TrackContainer(containerPath, sourcePath); err != nil {
+		log.Printf("Failed to track container %s: %v", logging.Path(containerPath), err)
+	}
+	return nil
+}
+
+// recoverSecureContainer rolls forward once moving the verified container
+// into place had started, otherwise discards the unverified container if
+// one was being built. Whatever is at the container path is never removed.
+func recoverSecureContainer(opID string, decodeParams func(v interface{}) error, steps []StepRecord) error {
+	var params secureContainerParams
+	if err := decodeParams(&params); err != nil {
+		return fmt.Errorf("failed to decode params: %w", err)
+	}
+	tmp := params.Container + ".tmp"
+
+	if hasStep(steps, "place") {
+		if err := os.Rename(tmp, params.Container); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move container into place: %w", err)
+		}
+		if err := os.RemoveAll(params.Source); err != nil {
+			return fmt.Errorf("failed to finish removing source: %w", err)
+		}
+		return nil
+	}
+
+	for _, step := range steps {
+		if step.Name != "create" {
+			continue
+		}
+		if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove partial container: %w", err)
+		}
+		MarkUndone(opID, step.Seq)
+	}
+	return nil
+}
+
+// hasStep reports whether a step named name was journaled
+func hasStep(steps []StepRecord, name string) bool {
+	for _, step := range steps {
+		if step.Name == name {
+			return true
+		}
+	}
+	return false
+}
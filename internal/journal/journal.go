@@ -0,0 +1,240 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// Operation states
+const (
+	StatePending   = "pending"
+	StateCommitted = "committed"
+	StateAborted   = "aborted"
+	StateRecovered = "recovered"
+)
+
+// Step states
+const (
+	StepIntent = "intent"
+	StepDone   = "done"
+	StepFailed = "failed"
+	StepUndone = "undone"
+)
+
+// Operation is a journaled multi-step operation. Each step's intent is
+// written before it runs, so after a crash the recovery handler for the
+// operation's kind can tell exactly how far it got.
+type Operation struct {
+	ID   string
+	Kind string
+	seq  int
+}
+
+// StepRecord is a journaled step as seen by recovery handlers
+type StepRecord struct {
+	Seq   int
+	Name  string
+	State string
+	data  string
+}
+
+// Decode unmarshals the step's data
+func (s StepRecord) Decode(v interface{}) error {
+	if s.data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.data), v)
+}
+
+// RecoveryFunc completes or rolls back an interrupted operation.
+// decodeParams unmarshals the parameters passed to Begin.
+type RecoveryFunc func(opID string, decodeParams func(v interface{}) error, steps []StepRecord) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]RecoveryFunc{}
+)
+
+// RegisterRecovery registers the recovery handler for an operation kind
+func RegisterRecovery(kind string, fn RecoveryFunc) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[kind] = fn
+}
+
+// Begin starts a journaled operation
+func Begin(kind string, params interface{}) (*Operation, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode operation params: %w", err)
+	}
+
+	op := &Operation{
+		ID:   uuid.New().String(),
+		Kind: kind,
+	}
+
+	record := models.JournalOperation{
+		OpID:   op.ID,
+		Kind:   kind,
+		Params: string(data),
+		State:  StatePending,
+	}
+	if err := storage.DB().Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to journal operation: %w", err)
+	}
+
+	return op, nil
+}
+
+// Run records the intent of a step, runs it, and records the outcome.
+// data should carry whatever recovery needs to undo or redo the step.
+func (op *Operation) Run(name string, data interface{}, fn func() error) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode step data: %w", err)
+	}
+
+	op.seq++
+	step := models.JournalStep{
+		OpID:  op.ID,
+		Seq:   op.seq,
+		Name:  name,
+		State: StepIntent,
+		Data:  string(encoded),
+	}
+
+	db := storage.DB()
+	if err := db.Create(&step).Error; err != nil {
+		return fmt.Errorf("failed to journal step %s: %w", name, err)
+	}
+
+	if err := fn(); err != nil {
+		db.Model(&step).Update("state", StepFailed)
+		return err
+	}
+
+	if err := db.Model(&step).Update("state", StepDone).Error; err != nil {
+		return fmt.Errorf("failed to journal step %s: %w", name, err)
+	}
+	return nil
+}
+
+// Commit marks the operation as successfully completed
+func (op *Operation) Commit() error {
+	return op.finish(StateCommitted, nil)
+}
+
+// Abort marks the operation as abandoned after the caller cleaned up
+func (op *Operation) Abort(cause error) error {
+	return op.finish(StateAborted, cause)
+}
+
+func (op *Operation) finish(state string, cause error) error {
+	updates := map[string]interface{}{"state": state}
+	if cause != nil {
+		updates["error"] = cause.Error()
+	}
+	return storage.DB().Model(&models.JournalOperation{}).
+		Where("op_id = ?", op.ID).
+		Updates(updates).Error
+}
+
+// MarkUndone records that a step's effects were rolled back
+func MarkUndone(opID string, seq int) error {
+	return storage.DB().Model(&models.JournalStep{}).
+		Where("op_id = ? AND seq = ?", opID, seq).
+		Update("state", StepUndone).Error
+}
+
+// RecoveryResult reports what Recover did with an interrupted operation
+type RecoveryResult struct {
+	OpID  string
+	Kind  string
+	Error error
+}
+
+// Recover runs the registered handler for every operation left pending by
+// a crash. It should be called once at startup, after storage.Init.
+func Recover() ([]RecoveryResult, error) {
+	db := storage.DB()
+
+	var pending []models.JournalOperation
+	if err := db.Where("state = ?", StatePending).Order("id").Find(&pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to load journal: %w", err)
+	}
+
+	results := make([]RecoveryResult, 0, len(pending))
+	for _, op := range pending {
+		result := RecoveryResult{OpID: op.OpID, Kind: op.Kind}
+
+		handlersMu.RLock()
+		fn, ok := handlers[op.Kind]
+		handlersMu.RUnlock()
+		if !ok {
+			result.Error = fmt.Errorf("no recovery handler for %s", op.Kind)
+			results = append(results, result)
+			continue
+		}
+
+		var steps []models.JournalStep
+		if err := db.Where("op_id = ?", op.OpID).Order("seq").Find(&steps).Error; err != nil {
+			return results, fmt.Errorf("failed to load journal steps: %w", err)
+		}
+
+		records := make([]StepRecord, 0, len(steps))
+		for _, step := range steps {
+			records = append(records, StepRecord{
+				Seq:   step.Seq,
+				Name:  step.Name,
+				State: step.State,
+				data:  step.Data,
+			})
+		}
+
+		params := op.Params
+		decodeParams := func(v interface{}) error {
+			return json.Unmarshal([]byte(params), v)
+		}
+
+		result.Error = fn(op.OpID, decodeParams, records)
+
+		updates := map[string]interface{}{"state": StateRecovered}
+		if result.Error != nil {
+			// Leave it pending so the next startup retries
+			updates = map[string]interface{}{"error": result.Error.Error()}
+			log.Printf("Journal recovery failed for %s (%s): %v", op.OpID, op.Kind, result.Error)
+		}
+		db.Model(&models.JournalOperation{}).Where("op_id = ?", op.OpID).Updates(updates)
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// Prune deletes finished operations and their steps
+func Prune() error {
+	db := storage.DB()
+
+	var finished []models.JournalOperation
+	if err := db.Where("state <> ?", StatePending).Find(&finished).Error; err != nil {
+		return err
+	}
+
+	for _, op := range finished {
+		if err := db.Where("op_id = ?", op.OpID).Delete(&models.JournalStep{}).Error; err != nil {
+			return err
+		}
+		if err := db.Delete(&op).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/owner/secure-file-manager/internal/quarantine"
+)
+
+// KindReceive receives a file into a partial file, verifies its checksum,
+// then moves it into place
+const KindReceive = "transfer.receive_verify_place"
+
+// partialDir holds incoming files in the download directory until they
+// have arrived whole and been verified
+const partialDir = ".sfm-partial"
+
+// ReceiveParams describes a journaled receive
+type ReceiveParams struct {
+	Part   string `json:"part"`
+	Target string `json:"target"`
+	Source string `json:"source"`         // What received it, e.g. sync
+	Peer   string `json:"peer,omitempty"` // Who sent it
+}
+
+func init() {
+	RegisterRecovery(KindReceive, recoverReceive)
+}
+
+// PartialPath returns where a download to target is written until it is
+// complete. The file keeps its name, so a quarantined part is recognizable.
+func PartialPath(target string) string {
+	return filepath.Join(filepath.Dir(target), partialDir, filepath.Base(target))
+}
+
+// recoverReceive finishes moving a verified file into place, and
+// otherwise quarantines what had arrived of it
+func recoverReceive(opID string, decodeParams func(v interface{}) error, steps []StepRecord) error {
+	var params ReceiveParams
+	if err := decodeParams(&params); err != nil {
+		return fmt.Errorf("failed to decode params: %w", err)
+	}
+
+	if hasStep(steps, "place") {
+		if err := os.Rename(params.Part, params.Target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to move received file into place: %w", err)
+		}
+		return nil
+	}
+
+	quarantine.Discard(params.Part, quarantine.ReasonFailed, params.Source, params.Peer)
+	for _, step := range steps {
+		if step.Name == "receive" {
+			MarkUndone(opID, step.Seq)
+		}
+	}
+	return nil
+}
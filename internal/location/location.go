@@ -0,0 +1,165 @@
+// Package location restricts unlocking containers to trusted networks,
+// identified by Wi-Fi SSID or local subnet. The check runs before the key
+// is derived, so a vault is unusable elsewhere even with its password.
+package location
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/logging"
+)
+
+var ErrUntrustedNetwork = errors.New("container may not be unlocked on this network")
+
+// Policy limits the containers matching Container, a path or glob, to
+// networks with one of the SSIDs or an address in one of the subnets
+type Policy struct {
+	Container string
+	SSIDs     []string
+	Subnets   []string // CIDR, e.g. 192.168.1.0/24
+}
+
+// Location is the network this device is on
+type Location struct {
+	SSIDs     []string // Of connected Wi-Fi interfaces
+	Addresses []net.IP // Non-loopback interface addresses
+}
+
+type policy struct {
+	pattern string
+	ssids   []string
+	subnets []*net.IPNet
+}
+
+var (
+	mu       gosync.RWMutex
+	policies []policy
+)
+
+// Configure sets the unlock policies and installs the check in the
+// container code. Invalid policies are skipped with a warning.
+func Configure(list []Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	policies = nil
+	for _, p := range list {
+		parsed, err := parsePolicy(p)
+		if err != nil {
+			log.Printf("Ignoring unlock policy for %s: %v", logging.Path(p.Container), err)
+			continue
+		}
+		policies = append(policies, parsed)
+	}
+	crypto.SetUnlockCheck(Check)
+}
+
+func parsePolicy(p Policy) (policy, error) {
+	if p.Container == "" {
+		return policy{}, fmt.Errorf("no container given")
+	}
+	if len(p.SSIDs) == 0 && len(p.Subnets) == 0 {
+		return policy{}, fmt.Errorf("no SSID or subnet given")
+	}
+	pattern, err := filepath.Abs(p.Container)
+	if err != nil {
+		return policy{}, err
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return policy{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	parsed := policy{pattern: pattern, ssids: p.SSIDs}
+	for _, cidr := range p.Subnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return policy{}, fmt.Errorf("invalid subnet %q", cidr)
+		}
+		parsed.subnets = append(parsed.subnets, subnet)
+	}
+	return parsed, nil
+}
+
+// Check returns ErrUntrustedNetwork unless every policy covering a
+// container allows the current network
+func Check(containerPath string) error {
+	abs, err := filepath.Abs(containerPath)
+	if err != nil {
+		return err
+	}
+
+	mu.RLock()
+	var covering []policy
+	for _, p := range policies {
+		if matched, _ := filepath.Match(p.pattern, abs); matched {
+			covering = append(covering, p)
+		}
+	}
+	mu.RUnlock()
+	if len(covering) == 0 {
+		return nil
+	}
+
+	here, err := Current()
+	if err != nil {
+		// An unknown network is not a trusted one
+		log.Printf("Failed to determine network for %s: %v", logging.Path(containerPath), err)
+		return ErrUntrustedNetwork
+	}
+	for _, p := range covering {
+		if !p.allows(here) {
+			log.Printf("Refused to unlock %s on an untrusted network", logging.Path(containerPath))
+			return ErrUntrustedNetwork
+		}
+	}
+	return nil
+}
+
+func (p policy) allows(here *Location) bool {
+	for _, want := range p.ssids {
+		for _, ssid := range here.SSIDs {
+			if ssid == want {
+				return true
+			}
+		}
+	}
+	for _, subnet := range p.subnets {
+		for _, ip := range here.Addresses {
+			if subnet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Current returns the SSIDs and addresses of this device. A device with
+// no Wi-Fi, or whose SSID cannot be read, has none.
+func Current() (*Location, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses: %w", err)
+	}
+
+	here := &Location{}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() {
+			continue
+		}
+		here.Addresses = append(here.Addresses, ipNet.IP)
+	}
+	for _, ssid := range wifiSSIDs() {
+		if ssid = strings.TrimSpace(ssid); ssid != "" {
+			here.SSIDs = append(here.SSIDs, ssid)
+		}
+	}
+	return here, nil
+}
@@ -0,0 +1,39 @@
+//go:build darwin
+
+package location
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// wifiSSIDs reads the network of every Wi-Fi port networksetup knows
+func wifiSSIDs() []string {
+	out, err := exec.Command("networksetup", "-listallhardwareports").Output()
+	if err != nil {
+		return nil
+	}
+
+	// Hardware Port: Wi-Fi
+	// Device: en0
+	var ssids []string
+	wifi := false
+	for _, line := range strings.Split(string(out), "\n") {
+		if port, ok := strings.CutPrefix(line, "Hardware Port: "); ok {
+			wifi = port == "Wi-Fi" || port == "AirPort"
+			continue
+		}
+		device, ok := strings.CutPrefix(line, "Device: ")
+		if !ok || !wifi {
+			continue
+		}
+		network, err := exec.Command("networksetup", "-getairportnetwork", strings.TrimSpace(device)).Output()
+		if err != nil {
+			continue
+		}
+		if ssid, ok := strings.CutPrefix(strings.TrimSpace(string(network)), "Current Wi-Fi Network: "); ok {
+			ssids = append(ssids, ssid)
+		}
+	}
+	return ssids
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package location
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// wifiSSIDs asks NetworkManager for the active Wi-Fi networks, falling
+// back to iwgetid
+func wifiSSIDs() []string {
+	if out, err := exec.Command("nmcli", "-t", "-f", "active,ssid", "device", "wifi").Output(); err == nil {
+		var ssids []string
+		for _, line := range strings.Split(string(out), "\n") {
+			// "yes:Home", with colons in the SSID escaped as \:
+			if ssid, ok := strings.CutPrefix(line, "yes:"); ok {
+				ssids = append(ssids, strings.ReplaceAll(ssid, `\:`, ":"))
+			}
+		}
+		return ssids
+	}
+	if out, err := exec.Command("iwgetid", "-r").Output(); err == nil {
+		return []string{string(out)}
+	}
+	return nil
+}
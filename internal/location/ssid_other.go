@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package location
+
+// wifiSSIDs is not supported here; policies can still use subnets
+func wifiSSIDs() []string {
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package location
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// wifiSSIDs reads the connected networks from netsh
+func wifiSSIDs() []string {
+	out, err := exec.Command("netsh", "wlan", "show", "interfaces").Output()
+	if err != nil {
+		return nil
+	}
+
+	// "    SSID                   : Home", not to be confused with BSSID
+	var ssids []string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "SSID" {
+			ssids = append(ssids, strings.TrimSpace(value))
+		}
+	}
+	return ssids
+}
@@ -0,0 +1,141 @@
+package logging
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Redaction modes
+const (
+	ModeHash     = "hash"     // Replace values with a short stable hash
+	ModeTruncate = "truncate" // Keep a short non-identifying prefix/extension
+)
+
+const redactionKeySize = 32
+
+var (
+	mu      sync.RWMutex
+	enabled bool
+	mode    = ModeHash
+	hashKey = newRedactionKey() // Replaced by the per-install key on LoadRedactionKey
+)
+
+// ConfigureRedaction enables or disables redaction of PII in log output
+func ConfigureRedaction(enable bool, redactMode string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	enabled = enable
+	switch redactMode {
+	case ModeTruncate:
+		mode = ModeTruncate
+	default:
+		mode = ModeHash
+	}
+}
+
+// LoadRedactionKey loads the key hashed values are keyed with from path,
+// creating it on first use. Hashes stay stable across restarts, but cannot
+// be reversed by hashing guessed names without the key.
+func LoadRedactionKey(path string) error {
+	key, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read redaction key: %w", err)
+	}
+	if len(key) != redactionKeySize {
+		key = newRedactionKey()
+		if err := os.WriteFile(path, key, 0600); err != nil {
+			return fmt.Errorf("failed to save redaction key: %w", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	hashKey = key
+	return nil
+}
+
+func newRedactionKey() []byte {
+	key := make([]byte, redactionKeySize)
+	rand.Read(key)
+	return key
+}
+
+func settings() (bool, string) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled, mode
+}
+
+// Fingerprint redacts a device fingerprint or peer ID
+func Fingerprint(fp string) string {
+	on, m := settings()
+	if !on || fp == "" {
+		return fp
+	}
+	if m == ModeTruncate {
+		return truncate(fp, 5)
+	}
+	return hashed(fp)
+}
+
+// FileName redacts a file name, keeping its extension in truncate mode
+func FileName(name string) string {
+	on, m := settings()
+	if !on || name == "" {
+		return name
+	}
+	if m == ModeTruncate {
+		return "…" + filepath.Ext(name)
+	}
+	return hashed(name)
+}
+
+// Path redacts a file system path
+func Path(path string) string {
+	on, m := settings()
+	if !on || path == "" {
+		return path
+	}
+	if m == ModeTruncate {
+		return "…" + string(filepath.Separator) + FileName(filepath.Base(path))
+	}
+	return hashed(path)
+}
+
+// DeviceName redacts a user-chosen device name
+func DeviceName(name string) string {
+	on, m := settings()
+	if !on || name == "" {
+		return name
+	}
+	if m == ModeTruncate {
+		return truncate(name, 2)
+	}
+	return hashed(name)
+}
+
+// hashed returns a short keyed hash so the same value can still be
+// correlated across log lines without revealing it
+func hashed(value string) string {
+	mu.RLock()
+	mac := hmac.New(sha256.New, hashKey)
+	mu.RUnlock()
+	mac.Write([]byte(value))
+	return "h:" + hex.EncodeToString(mac.Sum(nil)[:4])
+}
+
+func truncate(value string, keep int) string {
+	runes := []rune(value)
+	if len(runes) <= keep {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:keep]) + "…"
+}
@@ -0,0 +1,228 @@
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	gosync "sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/owner/secure-file-manager/internal/compare"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/internal/sync"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// PeerTarget mirrors into a shared folder on a paired device. Pointing the
+// share at a mounted container on that device mirrors into the container.
+type PeerTarget struct {
+	tm     *sync.TransferManager
+	peerID peer.ID
+	share  string
+}
+
+// NewPeerTarget creates a target for share on peerID
+func NewPeerTarget(tm *sync.TransferManager, peerID peer.ID, share string) *PeerTarget {
+	return &PeerTarget{tm: tm, peerID: peerID, share: share}
+}
+
+func (t *PeerTarget) Manifest(ctx context.Context, opts compare.Options) ([]compare.Entry, error) {
+	return t.tm.FetchManifest(ctx, t.peerID, t.share, opts)
+}
+
+func (t *PeerTarget) Put(ctx context.Context, relPath, localPath string) error {
+	return t.tm.MirrorPutFile(ctx, t.peerID, t.share, relPath, localPath)
+}
+
+func (t *PeerTarget) Mkdir(ctx context.Context, relPath string) error {
+	return t.tm.MirrorMkdir(ctx, t.peerID, t.share, relPath)
+}
+
+func (t *PeerTarget) Delete(ctx context.Context, relPath string) error {
+	return t.tm.MirrorDelete(ctx, t.peerID, t.share, relPath)
+}
+
+func (t *PeerTarget) Archive(ctx context.Context, relPath, stamp string) error {
+	return t.tm.MirrorArchive(ctx, t.peerID, t.share, relPath, stamp)
+}
+
+// CreateJob validates and saves a mirror job. interval of 0 means the job
+// only runs on demand.
+func CreateJob(name, source string, peerID peer.ID, share, policy string, interval time.Duration, exclude []string) (*models.MirrorJob, error) {
+	if !ValidPolicy(policy) {
+		return nil, fmt.Errorf("unknown deletion policy %q", policy)
+	}
+	info, err := os.Stat(source)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("source must be an existing directory: %s", source)
+	}
+
+	encoded, err := json.Marshal(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.MirrorJob{
+		Name:         name,
+		SourcePath:   source,
+		PeerID:       peerID.String(),
+		Share:        share,
+		DeletePolicy: policy,
+		Interval:     int64(interval / time.Second),
+		Exclude:      string(encoded),
+		Enabled:      true,
+	}
+	if err := storage.DB().Create(job).Error; err != nil {
+		return nil, fmt.Errorf("failed to save mirror job: %w", err)
+	}
+	return job, nil
+}
+
+// ListJobs returns all mirror jobs
+func ListJobs() ([]models.MirrorJob, error) {
+	var jobs []models.MirrorJob
+	if err := storage.DB().Order("name").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// DeleteJob removes a mirror job by name
+func DeleteJob(name string) error {
+	return storage.DB().Where("name = ?", name).Delete(&models.MirrorJob{}).Error
+}
+
+// SetJobEnabled pauses or resumes scheduled runs of a job
+func SetJobEnabled(name string, enabled bool) error {
+	return storage.DB().Model(&models.MirrorJob{}).Where("name = ?", name).Update("enabled", enabled).Error
+}
+
+// RunJob runs a job once and records the outcome
+func RunJob(ctx context.Context, tm *sync.TransferManager, job *models.MirrorJob) (Stats, error) {
+	stats, err := runJob(ctx, tm, job)
+
+	updates := map[string]interface{}{
+		"last_run":    time.Now(),
+		"last_status": "completed",
+		"last_error":  "",
+	}
+	if err != nil {
+		updates["last_status"] = "failed"
+		updates["last_error"] = err.Error()
+	}
+	storage.DB().Model(job).Updates(updates)
+
+	return stats, err
+}
+
+func runJob(ctx context.Context, tm *sync.TransferManager, job *models.MirrorJob) (Stats, error) {
+	peerID, err := peer.Decode(job.PeerID)
+	if err != nil {
+		return Stats{}, fmt.Errorf("invalid peer ID: %w", err)
+	}
+
+	var exclude []string
+	if job.Exclude != "" {
+		if err := json.Unmarshal([]byte(job.Exclude), &exclude); err != nil {
+			return Stats{}, fmt.Errorf("invalid exclude list: %w", err)
+		}
+	}
+
+	return Run(ctx, job.SourcePath, NewPeerTarget(tm, peerID, job.Share), job.DeletePolicy, exclude)
+}
+
+// Scheduler runs enabled jobs whose interval has elapsed
+type Scheduler struct {
+	tm          *sync.TransferManager
+	mu          gosync.Mutex
+	running     map[uint]bool
+	wakeTimeout time.Duration
+}
+
+// NewScheduler creates a scheduler that mirrors through tm
+func NewScheduler(tm *sync.TransferManager) *Scheduler {
+	return &Scheduler{tm: tm, running: make(map[uint]bool)}
+}
+
+// SetWakeTimeout enables Wake-on-LAN before each run: a sleeping peer with
+// a known MAC is woken and given up to timeout to come online. 0 disables.
+func (s *Scheduler) SetWakeTimeout(timeout time.Duration) {
+	s.wakeTimeout = timeout
+}
+
+// Start checks for due jobs every checkInterval until ctx is done
+func (s *Scheduler) Start(ctx context.Context, checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		s.runDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context) {
+	var jobs []models.MirrorJob
+	if err := storage.DB().Where("enabled = ? AND interval > 0", true).Find(&jobs).Error; err != nil {
+		log.Printf("Failed to load mirror jobs: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range jobs {
+		job := &jobs[i]
+		if now.Sub(job.LastRun) < time.Duration(job.Interval)*time.Second {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.running[job.ID] {
+			s.mu.Unlock()
+			continue
+		}
+		s.running[job.ID] = true
+		s.mu.Unlock()
+
+		go func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.running, job.ID)
+				s.mu.Unlock()
+			}()
+
+			s.wake(ctx, job)
+
+			stats, err := RunJob(ctx, s.tm, job)
+			if err != nil {
+				log.Printf("Mirror job %s failed: %v", job.Name, err)
+				return
+			}
+			log.Printf("Mirror job %s: %d copied, %d dirs, %d deleted, %d archived",
+				job.Name, stats.Copied, stats.Created, stats.Deleted, stats.Archived)
+		}()
+	}
+}
+
+// wake wakes the job's peer if needed; the run goes ahead either way and
+// records its own failure if the peer stays unreachable
+func (s *Scheduler) wake(ctx context.Context, job *models.MirrorJob) {
+	if s.wakeTimeout <= 0 {
+		return
+	}
+	peerID, err := peer.Decode(job.PeerID)
+	if err != nil {
+		return
+	}
+	if err := s.tm.WakePeer(ctx, peerID, s.wakeTimeout); err != nil && !errors.Is(err, sync.ErrNoMACAddress) {
+		log.Printf("Mirror job %s: %v", job.Name, err)
+	}
+}
@@ -0,0 +1,178 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/owner/secure-file-manager/internal/compare"
+	"github.com/owner/secure-file-manager/internal/progress"
+	"github.com/owner/secure-file-manager/internal/sync"
+)
+
+// Deletion policies for paths that exist only on the target
+const (
+	PolicyPropagate = "propagate" // Delete them
+	PolicyRetain    = "retain"    // Leave them alone
+	PolicyArchive   = "archive"   // Move them into the target's archive folder
+)
+
+// Target is the receiving side of a mirror
+type Target interface {
+	Manifest(ctx context.Context, opts compare.Options) ([]compare.Entry, error)
+	Put(ctx context.Context, relPath, localPath string) error
+	Mkdir(ctx context.Context, relPath string) error
+	Delete(ctx context.Context, relPath string) error
+	Archive(ctx context.Context, relPath, stamp string) error
+}
+
+// Stats summarizes a mirror run
+type Stats struct {
+	Copied   int
+	Created  int // Directories
+	Deleted  int
+	Archived int
+	Retained int
+	Bytes    int64
+}
+
+// ValidPolicy reports whether policy is a known deletion policy
+func ValidPolicy(policy string) bool {
+	return policy == PolicyPropagate || policy == PolicyRetain || policy == PolicyArchive
+}
+
+// Run makes target match source. Nothing is ever copied back: files that
+// differ are overwritten from source, and paths only on the target are
+// handled according to policy.
+func Run(ctx context.Context, source string, target Target, policy string, exclude []string) (Stats, error) {
+	var stats Stats
+	if !ValidPolicy(policy) {
+		return stats, fmt.Errorf("unknown deletion policy %q", policy)
+	}
+
+	opts := compare.Options{
+		ModTimeTolerance: 2 * time.Second,
+		Exclude:          append(append([]string(nil), exclude...), sync.MirrorArchiveDir+"/"),
+	}
+
+	local, err := compare.Scan(ctx, source, opts)
+	if err != nil {
+		return stats, err
+	}
+	remote, err := target.Manifest(ctx, opts)
+	if err != nil {
+		return stats, err
+	}
+	diff := compare.Diff(local, remote, opts)
+
+	// Type changes (file <-> directory) must clear the target path first
+	for _, d := range diff.Differing {
+		if d.Reason == compare.ReasonType {
+			if err := target.Delete(ctx, d.Path); err != nil {
+				return stats, err
+			}
+			diff.OnlyLeft = append(diff.OnlyLeft, d.Left)
+		}
+	}
+	sort.Slice(diff.OnlyLeft, func(i, j int) bool { return diff.OnlyLeft[i].Path < diff.OnlyLeft[j].Path })
+
+	// Targets that report progress do so as one session for the whole run
+	ctx = progress.WithSession(ctx, newSession(diff))
+
+	// Paths are sorted, so parents are created before their children
+	for _, e := range diff.OnlyLeft {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if e.IsDir {
+			if err := target.Mkdir(ctx, e.Path); err != nil {
+				return stats, err
+			}
+			stats.Created++
+			continue
+		}
+		if err := target.Put(ctx, e.Path, filepath.Join(source, filepath.FromSlash(e.Path))); err != nil {
+			return stats, err
+		}
+		stats.Copied++
+		stats.Bytes += e.Size
+	}
+
+	for _, d := range diff.Differing {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		if d.Reason == compare.ReasonType || d.Left.IsDir {
+			continue
+		}
+		if err := target.Put(ctx, d.Path, filepath.Join(source, filepath.FromSlash(d.Path))); err != nil {
+			return stats, err
+		}
+		stats.Copied++
+		stats.Bytes += d.Left.Size
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	var handled []string
+	for _, e := range diff.OnlyRight {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		// Deleting or archiving a directory takes its children with it
+		if underAny(e.Path, handled) {
+			continue
+		}
+
+		switch policy {
+		case PolicyRetain:
+			stats.Retained++
+			continue
+		case PolicyPropagate:
+			if err := target.Delete(ctx, e.Path); err != nil {
+				return stats, err
+			}
+			stats.Deleted++
+		case PolicyArchive:
+			if err := target.Archive(ctx, e.Path, stamp); err != nil {
+				return stats, err
+			}
+			stats.Archived++
+		}
+		if e.IsDir {
+			handled = append(handled, e.Path+"/")
+		}
+	}
+
+	return stats, nil
+}
+
+// newSession sizes a progress session to the files diff copies
+func newSession(diff *compare.Result) *progress.Session {
+	files, bytes := 0, int64(0)
+	for _, e := range diff.OnlyLeft {
+		if !e.IsDir {
+			files++
+			bytes += e.Size
+		}
+	}
+	for _, d := range diff.Differing {
+		if d.Reason != compare.ReasonType && !d.Left.IsDir {
+			files++
+			bytes += d.Left.Size
+		}
+	}
+	return progress.NewSession(uuid.New().String(), files, bytes)
+}
+
+func underAny(p string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
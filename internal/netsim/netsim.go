@@ -0,0 +1,390 @@
+// Package netsim runs in-process TCP and UDP proxies that add latency,
+// loss, reordering and disconnects, so transfers can be tested against a
+// bad network without leaving the process
+package netsim
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Conditions describe the network a proxy simulates. Random choices are
+// drawn from Seed, separately per direction and connection, so a run with
+// the same traffic makes the same choices.
+type Conditions struct {
+	Latency time.Duration // Added one way, in each direction
+	Loss    float64       // UDP: chance a datagram is dropped
+	Reorder float64       // UDP: chance a datagram is held back by another Latency
+
+	DisconnectAfter int64 // TCP: bytes forwarded per connection before it is cut, 0 never
+	MaxDisconnects  int   // TCP: connections cut at most, 0 unlimited
+
+	Seed int64
+}
+
+// Stats counts what a proxy did
+type Stats struct {
+	Packets     int64 // Reads (TCP) or datagrams (UDP) forwarded
+	Dropped     int64
+	Reordered   int64
+	Disconnects int64
+}
+
+// Proxy forwards traffic from its address to a target under Conditions
+type Proxy struct {
+	target string
+	cond   Conditions
+	mu     sync.Mutex
+	closed chan struct{}
+	close  func() error
+	addr   net.Addr
+	conns  sync.WaitGroup
+	seq    int64 // Connections or UDP sessions started, for seeding
+
+	packets     atomic.Int64
+	dropped     atomic.Int64
+	reordered   atomic.Int64
+	disconnects atomic.Int64
+}
+
+// Addr returns the address clients should connect to
+func (p *Proxy) Addr() net.Addr {
+	return p.addr
+}
+
+// Port returns the port clients should connect to
+func (p *Proxy) Port() int {
+	switch addr := p.addr.(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	}
+	return 0
+}
+
+// SetConditions changes the conditions for traffic from now on
+func (p *Proxy) SetConditions(cond Conditions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cond = cond
+}
+
+func (p *Proxy) conditions() Conditions {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cond
+}
+
+// Stats returns what the proxy did so far
+func (p *Proxy) Stats() Stats {
+	return Stats{
+		Packets:     p.packets.Load(),
+		Dropped:     p.dropped.Load(),
+		Reordered:   p.reordered.Load(),
+		Disconnects: p.disconnects.Load(),
+	}
+}
+
+// Close stops the proxy and cuts every connection through it
+func (p *Proxy) Close() error {
+	select {
+	case <-p.closed:
+		return nil
+	default:
+	}
+	close(p.closed)
+	err := p.close()
+	p.conns.Wait()
+	return err
+}
+
+// rng returns the random source for the next connection or session
+func (p *Proxy) rng() *rand.Rand {
+	p.mu.Lock()
+	p.seq++
+	seed := p.cond.Seed*1000003 + p.seq
+	p.mu.Unlock()
+	return rand.New(rand.NewSource(seed))
+}
+
+// NewTCP starts a TCP proxy on a free local port in front of target
+func NewTCP(target string, cond Conditions) (*Proxy, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return NewTCPOn(listener, target, cond), nil
+}
+
+// NewTCPOn starts a TCP proxy accepting on listener
+func NewTCPOn(listener net.Listener, target string, cond Conditions) *Proxy {
+	p := &Proxy{
+		target: target,
+		cond:   cond,
+		closed: make(chan struct{}),
+		close:  listener.Close,
+		addr:   listener.Addr(),
+	}
+	p.conns.Add(1)
+	go p.acceptTCP(listener)
+	return p
+}
+
+func (p *Proxy) acceptTCP(listener net.Listener) {
+	defer p.conns.Done()
+	var active sync.Map
+	defer active.Range(func(conn, _ any) bool {
+		conn.(net.Conn).Close()
+		return true
+	})
+
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+		active.Store(client, true)
+		active.Store(server, true)
+
+		cut := &tcpCut{proxy: p, client: client, server: server}
+		p.conns.Add(2)
+		go func() {
+			defer p.conns.Done()
+			p.pipeTCP(client, server, cut)
+			active.Delete(client)
+		}()
+		go func() {
+			defer p.conns.Done()
+			p.pipeTCP(server, client, cut)
+			active.Delete(server)
+		}()
+	}
+}
+
+// tcpCut counts a connection's bytes in both directions and cuts it once
+// DisconnectAfter is reached
+type tcpCut struct {
+	proxy     *Proxy
+	client    net.Conn
+	server    net.Conn
+	forwarded atomic.Int64
+	once      sync.Once
+}
+
+// allow reports how many of n more bytes may pass before the cut
+func (c *tcpCut) allow(n int) int {
+	cond := c.proxy.conditions()
+	if cond.DisconnectAfter <= 0 {
+		return n
+	}
+	if cond.MaxDisconnects > 0 && c.proxy.disconnects.Load() >= int64(cond.MaxDisconnects) {
+		return n
+	}
+	total := c.forwarded.Add(int64(n))
+	if total <= cond.DisconnectAfter {
+		return n
+	}
+	over := total - cond.DisconnectAfter
+	if over >= int64(n) {
+		return 0
+	}
+	return n - int(over)
+}
+
+func (c *tcpCut) disconnect() {
+	c.once.Do(func() {
+		c.proxy.disconnects.Add(1)
+		c.client.Close()
+		c.server.Close()
+	})
+}
+
+type delayed struct {
+	data []byte
+	due  time.Time
+}
+
+// pipeTCP copies src to dst through a delay line, keeping order
+func (p *Proxy) pipeTCP(src, dst net.Conn, cut *tcpCut) {
+	line := make(chan delayed, 1024)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		failed := false
+		for packet := range line {
+			// Keep draining after a failure so the reader never blocks
+			if failed {
+				continue
+			}
+			if wait := time.Until(packet.due); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-p.closed:
+					failed = true
+					continue
+				}
+			}
+			if _, err := dst.Write(packet.data); err != nil {
+				failed = true
+			}
+		}
+		// Pass on the end of the stream once everything before it arrived
+		if tcp, ok := dst.(*net.TCPConn); ok && !failed {
+			tcp.CloseWrite()
+		}
+	}()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			allowed := cut.allow(n)
+			if allowed > 0 {
+				p.packets.Add(1)
+				line <- delayed{data: append([]byte(nil), buf[:allowed]...), due: time.Now().Add(p.conditions().Latency)}
+			}
+			if allowed < n {
+				close(line)
+				<-done
+				cut.disconnect()
+				return
+			}
+		}
+		if err != nil {
+			close(line)
+			<-done
+			if !errors.Is(err, io.EOF) {
+				cut.disconnect()
+			}
+			return
+		}
+	}
+}
+
+// NewUDP starts a UDP proxy on a free local port in front of target
+func NewUDP(target string, cond Conditions) (*Proxy, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPOn(conn, target, cond)
+}
+
+// NewUDPOn starts a UDP proxy receiving on conn
+func NewUDPOn(conn net.PacketConn, target string, cond Conditions) (*Proxy, error) {
+	targetAddr, err := net.ResolveUDPAddr("udp", target)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := &Proxy{
+		target: target,
+		cond:   cond,
+		closed: make(chan struct{}),
+		addr:   conn.LocalAddr(),
+	}
+	sessions := make(map[string]*udpSession)
+	var sessionsMu sync.Mutex
+	p.close = func() error {
+		err := conn.Close()
+		sessionsMu.Lock()
+		for _, session := range sessions {
+			session.upstream.Close()
+		}
+		sessionsMu.Unlock()
+		return err
+	}
+
+	p.conns.Add(1)
+	go func() {
+		defer p.conns.Done()
+		upRng := p.rng()
+		buf := make([]byte, 64*1024)
+		for {
+			n, client, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			sessionsMu.Lock()
+			session, ok := sessions[client.String()]
+			if !ok {
+				upstream, err := net.DialUDP("udp", nil, targetAddr)
+				if err != nil {
+					sessionsMu.Unlock()
+					continue
+				}
+				session = &udpSession{upstream: upstream}
+				sessions[client.String()] = session
+
+				// Replies from the target go back to this client
+				p.conns.Add(1)
+				go func() {
+					defer p.conns.Done()
+					downRng := p.rng()
+					reply := make([]byte, 64*1024)
+					for {
+						n, err := upstream.Read(reply)
+						if err != nil {
+							return
+						}
+						p.forwardUDP(downRng, reply[:n], func(data []byte) {
+							conn.WriteTo(data, client)
+						})
+					}
+				}()
+			}
+			sessionsMu.Unlock()
+
+			p.forwardUDP(upRng, buf[:n], func(data []byte) {
+				session.upstream.Write(data)
+			})
+		}
+	}()
+	return p, nil
+}
+
+type udpSession struct {
+	upstream *net.UDPConn
+}
+
+// forwardUDP drops, delays or holds back a datagram. Each datagram is sent
+// on its own timer, so held-back ones are overtaken.
+func (p *Proxy) forwardUDP(rng *rand.Rand, data []byte, send func([]byte)) {
+	cond := p.conditions()
+	if cond.Loss > 0 && rng.Float64() < cond.Loss {
+		p.dropped.Add(1)
+		return
+	}
+	delay := cond.Latency
+	if cond.Reorder > 0 && rng.Float64() < cond.Reorder {
+		p.reordered.Add(1)
+		delay += max(cond.Latency, time.Millisecond)
+	}
+	p.packets.Add(1)
+
+	packet := append([]byte(nil), data...)
+	if delay <= 0 {
+		send(packet)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		select {
+		case <-p.closed:
+		default:
+			send(packet)
+		}
+	})
+}
@@ -0,0 +1,407 @@
+// Package offsite backs encrypted containers up to a cloud bucket,
+// differentially. Each container file is cut into fixed-size segments
+// named by their hash, and a run uploads only the segments the bucket does
+// not hold yet. Every run writes a generation manifest listing each
+// container's segments, so any generation can be restored.
+//
+// Containers are encrypted already, so segments are uploaded as they are
+// and the bucket never sees plaintext. Manifests hold container names and
+// sizes in the clear.
+package offsite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/power"
+	"github.com/owner/secure-file-manager/internal/relay"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// SegmentSize is the size containers are cut into; the last segment of a
+// container may be shorter
+const SegmentSize = 4 * 1024 * 1024
+
+const (
+	segmentsDir    = "segments/"
+	generationsDir = "generations/"
+)
+
+// ErrNoGeneration is returned for a generation the bucket does not hold
+var ErrNoGeneration = errors.New("no such backup generation")
+
+// Container is one container in a generation
+type Container struct {
+	Name     string    `json:"name"` // Base name; containers restore under it
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Segments []string  `json:"segments"` // Hex SHA-256 of each segment, in order
+}
+
+// Manifest lists the containers of a generation
+type Manifest struct {
+	Generation int         `json:"generation"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Containers []Container `json:"containers"`
+}
+
+// Stats summarizes a backup run
+type Stats struct {
+	Containers    int
+	Unchanged     int // Containers reused from the previous generation without reading them
+	Segments      int
+	Uploaded      int
+	UploadedBytes int64
+	SkippedBytes  int64 // Already in the bucket
+}
+
+// Backup uploads containers to a bucket
+type Backup struct {
+	backend relay.Backend
+	prefix  string
+}
+
+// New creates a backup storing objects under prefix in backend
+func New(backend relay.Backend, prefix string) *Backup {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Backup{backend: backend, prefix: prefix}
+}
+
+func (b *Backup) segmentKey(hash string) string {
+	return b.prefix + segmentsDir + hash[:2] + "/" + hash
+}
+
+func (b *Backup) generationKey(generation int) string {
+	return fmt.Sprintf("%s%s%010d.json", b.prefix, generationsDir, generation)
+}
+
+// Run backs up the containers at paths as a new generation. Containers
+// whose size and modification time match the previous generation are not
+// read again.
+func (b *Backup) Run(ctx context.Context, paths []string) (*Manifest, Stats, error) {
+	var stats Stats
+
+	generations, err := b.Generations(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+	previous := map[string]Container{}
+	next := 1
+	if len(generations) > 0 {
+		last := generations[len(generations)-1]
+		manifest, err := b.Manifest(ctx, last)
+		if err != nil {
+			return nil, stats, err
+		}
+		for _, c := range manifest.Containers {
+			previous[c.Name] = c
+		}
+		next = last + 1
+	}
+	stored, err := b.storedSegments(ctx)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	manifest := &Manifest{Generation: next, CreatedAt: time.Now().UTC()}
+	names := make(map[string]bool)
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, stats, err
+		}
+		name := filepath.Base(path)
+		if names[name] {
+			return nil, stats, fmt.Errorf("two containers are named %s", name)
+		}
+		names[name] = true
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, stats, fmt.Errorf("failed to stat container: %w", err)
+		}
+		if prev, ok := previous[name]; ok && prev.Size == info.Size() && prev.ModTime.Equal(info.ModTime().UTC()) {
+			manifest.Containers = append(manifest.Containers, prev)
+			stats.Containers++
+			stats.Unchanged++
+			stats.Segments += len(prev.Segments)
+			continue
+		}
+
+		container, err := b.upload(ctx, path, stored, &stats)
+		if err != nil {
+			return nil, stats, fmt.Errorf("failed to back up %s: %w", logging.Path(path), err)
+		}
+		container.Name = name
+		container.ModTime = info.ModTime().UTC()
+		manifest.Containers = append(manifest.Containers, *container)
+		stats.Containers++
+	}
+
+	// The manifest goes last, so it never names a segment not yet stored
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := b.backend.Put(ctx, b.generationKey(next), bytes.NewReader(data), int64(len(data))); err != nil {
+		return nil, stats, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return manifest, stats, nil
+}
+
+// upload cuts a container into segments and uploads those not stored
+func (b *Backup) upload(ctx context.Context, path string, stored map[string]bool, stats *Stats) (*Container, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open container: %w", err)
+	}
+	defer file.Close()
+
+	container := &Container{}
+	buffer := make([]byte, SegmentSize)
+	for {
+		n, err := io.ReadFull(file, buffer)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read container: %w", err)
+		}
+		segment := buffer[:n]
+		sum := sha256.Sum256(segment)
+		hash := hex.EncodeToString(sum[:])
+
+		if stored[hash] {
+			stats.SkippedBytes += int64(n)
+		} else {
+			if err := b.backend.Put(ctx, b.segmentKey(hash), bytes.NewReader(segment), int64(n)); err != nil {
+				return nil, fmt.Errorf("failed to upload segment: %w", err)
+			}
+			stored[hash] = true
+			stats.Uploaded++
+			stats.UploadedBytes += int64(n)
+		}
+		container.Segments = append(container.Segments, hash)
+		container.Size += int64(n)
+		stats.Segments++
+	}
+	return container, nil
+}
+
+// storedSegments lists the hashes of the segments in the bucket
+func (b *Backup) storedSegments(ctx context.Context) (map[string]bool, error) {
+	objects, err := b.backend.List(ctx, b.prefix+segmentsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	stored := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		stored[object.Key[strings.LastIndex(object.Key, "/")+1:]] = true
+	}
+	return stored, nil
+}
+
+// Generations lists the generations in the bucket, oldest first
+func (b *Backup) Generations(ctx context.Context) ([]int, error) {
+	objects, err := b.backend.List(ctx, b.prefix+generationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list generations: %w", err)
+	}
+	var generations []int
+	for _, object := range objects {
+		name := strings.TrimSuffix(object.Key[strings.LastIndex(object.Key, "/")+1:], ".json")
+		if generation, err := strconv.Atoi(name); err == nil {
+			generations = append(generations, generation)
+		}
+	}
+	sort.Ints(generations)
+	return generations, nil
+}
+
+// Manifest loads the manifest of a generation
+func (b *Backup) Manifest(ctx context.Context, generation int) (*Manifest, error) {
+	r, err := b.backend.Get(ctx, b.generationKey(generation))
+	if errors.Is(err, relay.ErrNotFound) {
+		return nil, ErrNoGeneration
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download manifest: %w", err)
+	}
+	defer r.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// Restore writes a container of a generation to target, verifying every
+// segment against its hash
+func (b *Backup) Restore(ctx context.Context, generation int, name, target string) error {
+	manifest, err := b.Manifest(ctx, generation)
+	if err != nil {
+		return err
+	}
+	var container *Container
+	for i := range manifest.Containers {
+		if manifest.Containers[i].Name == name {
+			container = &manifest.Containers[i]
+		}
+	}
+	if container == nil {
+		return fmt.Errorf("generation %d has no container %s", generation, name)
+	}
+
+	tmp := target + ".restore"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	err = b.restoreSegments(ctx, container, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	os.Chtimes(tmp, container.ModTime, container.ModTime)
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move restored container: %w", err)
+	}
+	return nil
+}
+
+func (b *Backup) restoreSegments(ctx context.Context, container *Container, w io.Writer) error {
+	for i, hash := range container.Segments {
+		r, err := b.backend.Get(ctx, b.segmentKey(hash))
+		if err != nil {
+			return fmt.Errorf("failed to download segment %d: %w", i, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(r, SegmentSize+1))
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed to download segment %d: %w", i, err)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+			return fmt.Errorf("segment %d is corrupt", i)
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write container: %w", err)
+		}
+	}
+	return nil
+}
+
+// Prune deletes all but the newest keep generations, then the segments no
+// remaining generation uses. It returns how many segments were deleted.
+func (b *Backup) Prune(ctx context.Context, keep int) (int, error) {
+	generations, err := b.Generations(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if keep < 1 || len(generations) <= keep {
+		return 0, nil
+	}
+	for _, generation := range generations[:len(generations)-keep] {
+		if err := b.backend.Delete(ctx, b.generationKey(generation)); err != nil {
+			return 0, fmt.Errorf("failed to delete generation %d: %w", generation, err)
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, generation := range generations[len(generations)-keep:] {
+		manifest, err := b.Manifest(ctx, generation)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range manifest.Containers {
+			for _, hash := range c.Segments {
+				used[hash] = true
+			}
+		}
+	}
+	stored, err := b.storedSegments(ctx)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for hash := range stored {
+		if used[hash] {
+			continue
+		}
+		if err := b.backend.Delete(ctx, b.segmentKey(hash)); err != nil {
+			return deleted, fmt.Errorf("failed to delete segment: %w", err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// TrackedContainers returns the paths of the containers in the database
+func TrackedContainers() ([]string, error) {
+	var containers []models.EncryptedContainer
+	if err := storage.DB().Find(&containers).Error; err != nil {
+		return nil, fmt.Errorf("failed to load containers: %w", err)
+	}
+	paths := make([]string, 0, len(containers))
+	for _, c := range containers {
+		if _, err := os.Stat(c.Path); err == nil {
+			paths = append(paths, c.Path)
+		}
+	}
+	return paths, nil
+}
+
+// Start backs the tracked containers up every interval, keeping keep
+// generations, until ctx is done. Runs wait for the machine to be plugged
+// in and idle.
+func (b *Backup) Start(ctx context.Context, interval time.Duration, keep int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := power.Wait(ctx, power.JobBackup); err != nil {
+				return
+			}
+			paths, err := TrackedContainers()
+			if err != nil {
+				log.Printf("Offsite backup failed: %v", err)
+				continue
+			}
+			manifest, stats, err := b.Run(ctx, paths)
+			if err != nil {
+				log.Printf("Offsite backup failed: %v", err)
+				continue
+			}
+			log.Printf("Offsite backup generation %d: %d containers (%d unchanged), uploaded %d of %d segments (%d bytes)",
+				manifest.Generation, stats.Containers, stats.Unchanged, stats.Uploaded, stats.Segments, stats.UploadedBytes)
+			if deleted, err := b.Prune(ctx, keep); err != nil {
+				log.Printf("Offsite backup prune failed: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Pruned %d unused offsite segment(s)", deleted)
+			}
+		}
+	}
+}
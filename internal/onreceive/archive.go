@@ -0,0 +1,207 @@
+package onreceive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/quarantine"
+)
+
+// Limits on what an extracted archive may unpack to, against archive bombs
+const (
+	maxExtractEntries = 100000
+	maxExtractSize    = 16 << 30 // 16GB
+)
+
+var errExtractLimit = errors.New("archive exceeds extraction limits")
+
+// archive verifies the checksums an archive carries (zip and gzip CRC-32,
+// tar header checksums) and unpacks it into a directory of its own under
+// rule.Dest if rule.Extract is set. An archive that fails verification is
+// quarantined.
+func archive(rule Rule, filePath, source, peer string) error {
+	if archiveExt(filepath.Base(filePath)) == "" {
+		return fmt.Errorf("unsupported archive type")
+	}
+	if err := walkArchive(filePath, nil); err != nil {
+		quarantine.Discard(filePath, quarantine.ReasonChecksum, source, peer)
+		return fmt.Errorf("archive failed verification: %w", err)
+	}
+	if !rule.Extract {
+		return nil
+	}
+
+	name := filepath.Base(filePath)
+	sandbox := uniquePath(filepath.Join(rule.Dest, strings.TrimSuffix(name, archiveExt(name))))
+	if err := os.MkdirAll(sandbox, 0755); err != nil {
+		return fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+	if err := walkArchive(filePath, extractTo(sandbox)); err != nil {
+		os.RemoveAll(sandbox)
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+	log.Printf("Extracted %s into %s", logging.Path(filePath), logging.Path(sandbox))
+	return nil
+}
+
+// archiveExt returns the archive extension of name, or "" if it is not a
+// supported archive
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip", ".gz"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[len(name)-len(ext):]
+		}
+	}
+	return ""
+}
+
+// entryFunc receives each entry of an archive. r is nil for directories.
+type entryFunc func(name string, r io.Reader) error
+
+// walkArchive reads every entry of an archive through to the end, so its
+// checksums are verified, passing each to fn if set
+func walkArchive(filePath string, fn entryFunc) error {
+	ext := strings.ToLower(archiveExt(filepath.Base(filePath)))
+	if ext == ".zip" {
+		return walkZip(filePath, fn)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if ext != ".tar" {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if ext == ".gz" {
+		name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+		return visit(fn, name, r)
+	}
+	return walkTar(r, fn)
+}
+
+func walkZip(filePath string, fn entryFunc) error {
+	zr, err := zip.OpenReader(filePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	if len(zr.File) > maxExtractEntries {
+		return errExtractLimit
+	}
+	for _, zf := range zr.File {
+		if zf.FileInfo().IsDir() {
+			if fn != nil {
+				if err := fn(zf.Name, nil); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = visit(fn, zf.Name, rc)
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", zf.Name, err)
+		}
+	}
+	return nil
+}
+
+func walkTar(r io.Reader, fn entryFunc) error {
+	tr := tar.NewReader(r)
+	for entries := 0; ; entries++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entries >= maxExtractEntries {
+			return errExtractLimit
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fn != nil {
+				if err := fn(hdr.Name, nil); err != nil {
+					return err
+				}
+			}
+		case tar.TypeReg:
+			if err := visit(fn, hdr.Name, tr); err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+		}
+		// Links, devices and the like are never extracted
+	}
+}
+
+// visit passes an entry to fn, or reads it through if fn is nil
+func visit(fn entryFunc, name string, r io.Reader) error {
+	if fn == nil {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+	return fn(name, r)
+}
+
+// extractTo writes entries below dir. Names that would leave dir are
+// refused, and the total size is capped.
+func extractTo(dir string) entryFunc {
+	var written int64
+	return func(name string, r io.Reader) error {
+		rel := filepath.Clean(filepath.FromSlash(name))
+		if rel == "." {
+			return nil
+		}
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("entry %q leaves the extraction directory", name)
+		}
+		target := filepath.Join(dir, rel)
+		if r == nil {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(out, io.LimitReader(r, maxExtractSize-written+1))
+		written += n
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && written > maxExtractSize {
+			err = errExtractLimit
+		}
+		return err
+	}
+}
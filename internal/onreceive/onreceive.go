@@ -0,0 +1,307 @@
+// Package onreceive runs configured actions on files received from other
+// devices, chosen by their type, once the files have passed their
+// integrity checks.
+package onreceive
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+)
+
+// Actions a rule can take
+const (
+	ActionMove    = "move"    // Move the file into Dest
+	ActionWebhook = "webhook" // POST the file's details to URL
+	ActionArchive = "archive" // Verify an archive's checksums, and unpack it into Dest if Extract is set
+)
+
+const webhookTimeout = 10 * time.Second
+
+// Rule applies Action to received files matching every condition set.
+// All matching rules run, in order; after a move later rules see the new
+// path.
+type Rule struct {
+	Name    string
+	MIME    string // e.g. "image/*"
+	Glob    string // Base name, e.g. "*.ics"
+	Action  string
+	Dest    string // Directory for move and extract; ~ is the home directory
+	URL     string // For webhook
+	Extract bool   // For archive
+}
+
+// File describes a received file, as posted to webhooks
+type File struct {
+	Rule       string    `json:"rule"`
+	Path       string    `json:"path"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	MIME       string    `json:"mime"`
+	SHA256     string    `json:"sha256"`
+	Source     string    `json:"source"`         // What received it, e.g. airdrop or sync
+	Peer       string    `json:"peer,omitempty"` // Who sent it
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+var (
+	mu    gosync.RWMutex
+	rules []Rule
+
+	client = &http.Client{Timeout: webhookTimeout}
+)
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule needs a name")
+	}
+	if r.MIME == "" && r.Glob == "" {
+		return fmt.Errorf("rule %s has no conditions", r.Name)
+	}
+	for _, pattern := range []string{r.MIME, r.Glob} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule %s: bad pattern %q", r.Name, pattern)
+		}
+	}
+
+	switch r.Action {
+	case ActionMove:
+		if r.Dest == "" {
+			return fmt.Errorf("rule %s moves files but has no dest", r.Name)
+		}
+	case ActionWebhook:
+		if !strings.HasPrefix(r.URL, "http://") && !strings.HasPrefix(r.URL, "https://") {
+			return fmt.Errorf("rule %s needs an http(s) url", r.Name)
+		}
+	case ActionArchive:
+		if r.Extract && r.Dest == "" {
+			return fmt.Errorf("rule %s extracts archives but has no dest", r.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown action %q", r.Name, r.Action)
+	}
+
+	if strings.HasPrefix(r.Dest, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("failed to get home directory: %w", err)
+		}
+		r.Dest = filepath.Join(home, r.Dest[2:])
+	}
+	return nil
+}
+
+func (r Rule) matches(filePath, mimeType string) bool {
+	if r.Glob != "" {
+		if ok, _ := path.Match(r.Glob, filepath.Base(filePath)); !ok {
+			return false
+		}
+	}
+	if r.MIME != "" {
+		if ok, _ := path.Match(r.MIME, mimeType); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Configure sets the rules run on received files
+func Configure(newRules []Rule) error {
+	checked := make([]Rule, len(newRules))
+	for i, rule := range newRules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		checked[i] = rule
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	rules = checked
+	return nil
+}
+
+// Received runs the matching rules on a file that arrived whole and
+// verified, in the background. Failures are logged.
+func Received(filePath, source, peer string) {
+	mu.RLock()
+	active := rules
+	mu.RUnlock()
+	if len(active) == 0 {
+		return
+	}
+
+	go func() {
+		if _, err := Run(context.Background(), active, filePath, source, peer); err != nil {
+			log.Printf("Post-receive actions on %s failed: %v", logging.Path(filePath), err)
+		}
+	}()
+}
+
+// Run runs the matching rules on a received file and returns where it
+// ended up. It stops at the first action that fails.
+func Run(ctx context.Context, rules []Rule, filePath, source, peer string) (string, error) {
+	mimeType := mimeType(filePath)
+	for _, rule := range rules {
+		if !rule.matches(filePath, mimeType) {
+			continue
+		}
+		var err error
+		switch rule.Action {
+		case ActionMove:
+			filePath, err = move(filePath, rule.Dest)
+		case ActionWebhook:
+			err = notify(ctx, rule, filePath, mimeType, source, peer)
+		case ActionArchive:
+			err = archive(rule, filePath, source, peer)
+		}
+		if err != nil {
+			return filePath, fmt.Errorf("rule %s: %w", rule.Name, err)
+		}
+	}
+	return filePath, nil
+}
+
+// move moves a file into dir, renaming it if the name is taken
+func move(filePath, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return filePath, fmt.Errorf("failed to create directory: %w", err)
+	}
+	target := uniquePath(filepath.Join(dir, filepath.Base(filePath)))
+	if err := os.Rename(filePath, target); err != nil {
+		// Across volumes
+		if err := copyFile(filePath, target); err != nil {
+			return filePath, err
+		}
+		if err := os.Remove(filePath); err != nil {
+			return target, fmt.Errorf("failed to remove original file: %w", err)
+		}
+	}
+	log.Printf("Moved received %s to %s", logging.Path(filePath), logging.Path(target))
+	return target, nil
+}
+
+// notify posts the file's details to the rule's webhook
+func notify(ctx context.Context, rule Rule, filePath, mimeType, source, peer string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	sum, err := hashFile(filePath)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(File{
+		Rule:       rule.Name,
+		Path:       filePath,
+		Name:       filepath.Base(filePath),
+		Size:       info.Size(),
+		MIME:       mimeType,
+		SHA256:     sum,
+		Source:     source,
+		Peer:       peer,
+		ReceivedAt: info.ModTime(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// mimeType returns the MIME type of a file from its extension, or from
+// its first bytes if the extension is unknown
+func mimeType(filePath string) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(filePath))); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	t, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	return t
+}
+
+func hashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
+
+// uniquePath appends " (n)" before the extension until path is unused
+func uniquePath(p string) string {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return p
+	}
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package power holds heavy background jobs back until the machine is
+// plugged in and idle, so a laptop running the daemon all day does not
+// drain its battery or get in the user's way.
+package power
+
+import (
+	"context"
+	"log"
+	gosync "sync"
+	"time"
+)
+
+// Jobs that are gated
+const (
+	JobIndexing = "indexing"
+	JobScrub    = "scrub"
+	JobBackup   = "backup"
+	JobOCR      = "ocr"
+)
+
+// DefaultIdleAfter is how long without user input counts as idle
+const DefaultIdleAfter = 5 * time.Minute
+
+const (
+	// pollInterval is how often a waiting job checks again
+	pollInterval = 30 * time.Second
+
+	// stateTTL is how long a reading is reused, since jobs may check
+	// before every file
+	stateTTL = 10 * time.Second
+)
+
+// Policy says when gated jobs may run
+type Policy struct {
+	RequireAC   bool
+	RequireIdle bool
+	IdleAfter   time.Duration
+	Ungated     []string // Jobs that run regardless
+}
+
+// State is what the machine reports. Readings a platform cannot provide
+// count as plugged in and idle, e.g. on desktops and headless servers.
+type State struct {
+	OnAC bool
+	Idle time.Duration // Since the last user input
+}
+
+var (
+	mu      gosync.RWMutex
+	policy  = Policy{RequireAC: true, RequireIdle: true, IdleAfter: DefaultIdleAfter}
+	ungated = map[string]bool{}
+
+	stateMu   gosync.Mutex
+	lastState State
+	lastRead  time.Time
+)
+
+// Configure sets when gated jobs may run
+func Configure(p Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if p.IdleAfter <= 0 {
+		p.IdleAfter = DefaultIdleAfter
+	}
+	policy = p
+	ungated = make(map[string]bool, len(p.Ungated))
+	for _, job := range p.Ungated {
+		ungated[job] = true
+	}
+}
+
+// Current reads the power source and idle time
+func Current() State {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if time.Since(lastRead) < stateTTL {
+		return lastState
+	}
+	state := State{OnAC: true, Idle: time.Duration(1<<63 - 1)}
+	if onAC, err := acPower(); err == nil {
+		state.OnAC = onAC
+	}
+	if idle, err := idleTime(); err == nil {
+		state.Idle = idle
+	}
+	lastState, lastRead = state, time.Now()
+	return state
+}
+
+// Ready reports whether job may run now
+func Ready(job string) bool {
+	mu.RLock()
+	p, free := policy, ungated[job]
+	mu.RUnlock()
+
+	if free || (!p.RequireAC && !p.RequireIdle) {
+		return true
+	}
+	state := Current()
+	if p.RequireAC && !state.OnAC {
+		return false
+	}
+	return !p.RequireIdle || state.Idle >= p.IdleAfter
+}
+
+// Wait blocks until job may run or ctx is done
+func Wait(ctx context.Context, job string) error {
+	if Ready(job) {
+		return nil
+	}
+	log.Printf("Holding %s until the machine is plugged in and idle", job)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if Ready(job) {
+				return nil
+			}
+		}
+	}
+}
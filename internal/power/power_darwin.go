@@ -0,0 +1,42 @@
+//go:build darwin
+
+package power
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// acPower asks pmset which source the machine draws from
+func acPower() (bool, error) {
+	out, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false, err
+	}
+	// Now drawing from 'AC Power'
+	return strings.Contains(string(out), "'AC Power'"), nil
+}
+
+// idleTime reads HIDIdleTime, nanoseconds since the last input event
+func idleTime() (time.Duration, error) {
+	out, err := exec.Command("ioreg", "-c", "IOHIDSystem", "-d", "4").Output()
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// "HIDIdleTime" = 1234567890
+		_, value, ok := strings.Cut(line, `"HIDIdleTime" = `)
+		if !ok {
+			continue
+		}
+		ns, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(ns), nil
+	}
+	return 0, errors.New("HIDIdleTime not reported")
+}
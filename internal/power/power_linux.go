@@ -0,0 +1,114 @@
+//go:build linux
+
+package power
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const powerSupplyDir = "/sys/class/power_supply"
+
+var errUnknown = errors.New("not reported")
+
+// acPower reads the power supplies in sysfs: any mains adapter online means
+// AC, a discharging battery means battery
+func acPower() (bool, error) {
+	supplies, err := os.ReadDir(powerSupplyDir)
+	if err != nil {
+		return false, err
+	}
+	mains, discharging := false, false
+	for _, supply := range supplies {
+		dir := filepath.Join(powerSupplyDir, supply.Name())
+		switch readSysfs(dir, "type") {
+		case "Mains", "USB":
+			mains = true
+			if readSysfs(dir, "online") == "1" {
+				return true, nil
+			}
+		case "Battery":
+			if readSysfs(dir, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	if mains || discharging {
+		return false, nil
+	}
+	return false, errUnknown
+}
+
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// idleTime asks xprintidle, falling back to the idle hints logind keeps
+// for each session
+func idleTime() (time.Duration, error) {
+	if out, err := exec.Command("xprintidle").Output(); err == nil {
+		if ms, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err == nil {
+			return time.Duration(ms) * time.Millisecond, nil
+		}
+	}
+
+	out, err := exec.Command("loginctl", "list-sessions", "--no-legend").Output()
+	if err != nil {
+		return 0, err
+	}
+	found := false
+	var idle time.Duration
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		since, err := sessionIdle(fields[0])
+		if err != nil {
+			continue
+		}
+		// The machine is as idle as its busiest session
+		if !found || since < idle {
+			idle = since
+		}
+		found = true
+	}
+	if !found {
+		return 0, errUnknown
+	}
+	return idle, nil
+}
+
+func sessionIdle(id string) (time.Duration, error) {
+	out, err := exec.Command("loginctl", "show-session", id, "-p", "IdleHint", "-p", "IdleSinceHint").Output()
+	if err != nil {
+		return 0, err
+	}
+	hint, since := "", int64(0)
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "IdleHint":
+			hint = value
+		case "IdleSinceHint":
+			since, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	switch {
+	case hint == "no":
+		return 0, nil
+	case hint == "yes" && since > 0:
+		// Microseconds since the epoch
+		return time.Since(time.UnixMicro(since)), nil
+	}
+	return 0, errUnknown
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !windows
+
+package power
+
+import (
+	"errors"
+	"time"
+)
+
+var errUnsupported = errors.New("not supported on this platform")
+
+// acPower is not supported here; jobs run as if plugged in
+func acPower() (bool, error) {
+	return false, errUnsupported
+}
+
+// idleTime is not supported here; jobs run as if idle
+func idleTime() (time.Duration, error) {
+	return 0, errUnsupported
+}
@@ -0,0 +1,61 @@
+//go:build windows
+
+package power
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                 = windows.NewLazySystemDLL("kernel32.dll")
+	user32                   = windows.NewLazySystemDLL("user32.dll")
+	procGetSystemPowerStatus = kernel32.NewProc("GetSystemPowerStatus")
+	procGetTickCount         = kernel32.NewProc("GetTickCount")
+	procGetLastInputInfo     = user32.NewProc("GetLastInputInfo")
+)
+
+// systemPowerStatus is SYSTEM_POWER_STATUS
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+// lastInputInfo is LASTINPUTINFO
+type lastInputInfo struct {
+	size uint32
+	time uint32
+}
+
+// acPower asks GetSystemPowerStatus for the AC line status
+func acPower() (bool, error) {
+	var status systemPowerStatus
+	if ok, _, err := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status))); ok == 0 {
+		return false, err
+	}
+	switch status.ACLineStatus {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	}
+	return false, errors.New("AC line status unknown")
+}
+
+// idleTime compares the tick count of the last input with the current one
+func idleTime() (time.Duration, error) {
+	info := lastInputInfo{size: uint32(unsafe.Sizeof(lastInputInfo{}))}
+	if ok, _, err := procGetLastInputInfo.Call(uintptr(unsafe.Pointer(&info))); ok == 0 {
+		return 0, err
+	}
+	now, _, _ := procGetTickCount.Call()
+	// Both wrap every 49.7 days; unsigned subtraction copes
+	return time.Duration(uint32(now)-info.time) * time.Millisecond, nil
+}
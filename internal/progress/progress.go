@@ -0,0 +1,121 @@
+// Package progress describes transfer progress as structured events, so one
+// handler can follow single files and multi-file sessions alike.
+package progress
+
+import (
+	"context"
+	gosync "sync"
+)
+
+// Kinds of event
+const (
+	KindFile    = "file"    // Bytes of one file moved so far
+	KindSession = "session" // Aggregate of a multi-file session
+	KindSkipped = "skipped" // A file was not sent at all
+)
+
+// Reasons a file is skipped
+const (
+	ReasonDedup = "dedup" // The receiver already had the content
+)
+
+// Event reports progress. File events carry the file's bytes; session
+// events carry the session's, with Files counting those finished or
+// skipped.
+type Event struct {
+	Kind       string `json:"kind"`
+	SessionID  string `json:"session_id,omitempty"`
+	File       string `json:"file,omitempty"`
+	Bytes      int64  `json:"bytes"`
+	Total      int64  `json:"total"`
+	Files      int    `json:"files,omitempty"`
+	TotalFiles int    `json:"total_files,omitempty"`
+	Skipped    int    `json:"skipped,omitempty"`
+	Reason     string `json:"reason,omitempty"` // Why a file was skipped
+}
+
+// Handler receives progress events. It is called on the transfer's
+// goroutine, so it must not block.
+type Handler func(Event)
+
+// Session aggregates the files of a multi-file session
+type Session struct {
+	id         string
+	totalFiles int
+	total      int64
+
+	mu      gosync.Mutex
+	done    int64            // Bytes of files finished or skipped
+	moving  map[string]int64 // Bytes of files in flight
+	files   int
+	skipped int
+}
+
+// NewSession starts a session expected to move files files of bytes bytes
+// in total
+func NewSession(id string, files int, bytes int64) *Session {
+	return &Session{id: id, totalFiles: files, total: bytes, moving: make(map[string]int64)}
+}
+
+// ID returns the session's ID
+func (s *Session) ID() string {
+	return s.id
+}
+
+// File records bytes of file moved so far, finishing it once they reach
+// total, and returns the session's aggregate
+func (s *Session) File(file string, bytes, total int64) Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bytes >= total {
+		delete(s.moving, file)
+		s.done += total
+		s.files++
+	} else {
+		s.moving[file] = bytes
+	}
+	return s.event()
+}
+
+// Skip records a file that was not sent, counting its size as moved, and
+// returns the session's aggregate
+func (s *Session) Skip(file string, size int64) Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.moving, file)
+	s.done += size
+	s.files++
+	s.skipped++
+	return s.event()
+}
+
+func (s *Session) event() Event {
+	bytes := s.done
+	for _, n := range s.moving {
+		bytes += n
+	}
+	return Event{
+		Kind:       KindSession,
+		SessionID:  s.id,
+		Bytes:      bytes,
+		Total:      s.total,
+		Files:      s.files,
+		TotalFiles: s.totalFiles,
+		Skipped:    s.skipped,
+	}
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context whose transfers report to s
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// FromContext returns the session transfers on ctx belong to, or nil
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
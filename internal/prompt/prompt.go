@@ -0,0 +1,179 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Prompt kinds
+const (
+	KindDevice = "device" // An unknown device wants to connect
+	KindFile   = "file"   // A device offers a file
+)
+
+// DefaultTimeout is how long a prompt waits for an answer
+const DefaultTimeout = 2 * time.Minute
+
+var (
+	ErrNotFound = errors.New("no such pending prompt")
+	ErrExpired  = errors.New("prompt was not answered in time")
+)
+
+// Requester identifies the device a prompt is about, as it presented itself
+type Requester struct {
+	Name        string `json:"name"`
+	Fingerprint string `json:"fingerprint"`
+	Address     string `json:"address,omitempty"`
+}
+
+// Prompt is a question waiting for the user, e.g. whether to accept a file
+type Prompt struct {
+	ID        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	Requester Requester         `json:"requester"`
+	Details   map[string]string `json:"details,omitempty"` // Kind-specific, e.g. file name and size
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Reply is the user's answer to a prompt
+type Reply struct {
+	Accept bool `json:"accept"`
+	// For KindFile, where to save the file instead of the default: a file
+	// name, or a path relative to the download directory or absolute. A
+	// trailing separator keeps the offered name. Empty saves as offered.
+	Destination string `json:"destination,omitempty"`
+}
+
+type pending struct {
+	prompt Prompt
+	answer chan Reply
+}
+
+// Queue holds the prompts waiting for an answer. Components ask through it
+// and block; GUIs list, inspect and answer prompts at their own pace.
+type Queue struct {
+	timeout time.Duration
+	notify  func(Prompt)
+	pending map[string]*pending
+	mu      sync.Mutex
+}
+
+// NewQueue creates a queue whose prompts expire after timeout, or after
+// DefaultTimeout if timeout is 0
+func NewQueue(timeout time.Duration) *Queue {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Queue{
+		timeout: timeout,
+		pending: make(map[string]*pending),
+	}
+}
+
+// SetNotify sets a function called for every new prompt, e.g. to wake a
+// GUI. It must not block.
+func (q *Queue) SetNotify(notify func(Prompt)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.notify = notify
+}
+
+// Ask queues a prompt and waits for its reply. A prompt that expires or
+// whose ctx ends counts as declined and returns an error.
+func (q *Queue) Ask(ctx context.Context, kind string, requester Requester, details map[string]string) (Reply, error) {
+	now := time.Now()
+	p := &pending{
+		prompt: Prompt{
+			ID:        uuid.New().String(),
+			Kind:      kind,
+			Requester: requester,
+			Details:   details,
+			CreatedAt: now,
+			ExpiresAt: now.Add(q.timeout),
+		},
+		answer: make(chan Reply, 1),
+	}
+
+	q.mu.Lock()
+	q.pending[p.prompt.ID] = p
+	notify := q.notify
+	q.mu.Unlock()
+	if notify != nil {
+		notify(p.prompt)
+	}
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case reply := <-p.answer:
+		return reply, nil
+	case <-timer.C:
+		err = ErrExpired
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	q.mu.Lock()
+	_, still := q.pending[p.prompt.ID]
+	delete(q.pending, p.prompt.ID)
+	q.mu.Unlock()
+	if !still {
+		// Answered just as it ran out
+		return <-p.answer, nil
+	}
+	return Reply{}, err
+}
+
+// Pending lists the prompts waiting for an answer, oldest first
+func (q *Queue) Pending() []Prompt {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	prompts := make([]Prompt, 0, len(q.pending))
+	for _, p := range q.pending {
+		prompts = append(prompts, p.prompt)
+	}
+	sort.Slice(prompts, func(i, j int) bool {
+		return prompts[i].CreatedAt.Before(prompts[j].CreatedAt)
+	})
+	return prompts
+}
+
+// Get returns a pending prompt
+func (q *Queue) Get(id string) (Prompt, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	p, ok := q.pending[id]
+	if !ok {
+		return Prompt{}, false
+	}
+	return p.prompt, true
+}
+
+// Answer accepts or declines a pending prompt
+func (q *Queue) Answer(id string, accept bool) error {
+	return q.Reply(id, Reply{Accept: accept})
+}
+
+// Reply answers a pending prompt, e.g. accepting a file under another name
+func (q *Queue) Reply(id string, reply Reply) error {
+	q.mu.Lock()
+	p, ok := q.pending[id]
+	delete(q.pending, id)
+	q.mu.Unlock()
+
+	if !ok {
+		return ErrNotFound
+	}
+	p.answer <- reply
+	return nil
+}
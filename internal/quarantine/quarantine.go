@@ -0,0 +1,293 @@
+package quarantine
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+)
+
+// Reasons a file is quarantined
+const (
+	ReasonChecksum  = "checksum_mismatch" // Received content did not match its checksum
+	ReasonCancelled = "cancelled"         // The transfer was killed
+	ReasonIdle      = "idle"              // The sender vanished and the session timed out
+	ReasonFailed    = "failed"            // The transfer broke off
+)
+
+const entryFile = "entry.json"
+
+// ErrNotFound is returned for an ID not in quarantine
+var ErrNotFound = errors.New("no such quarantined file")
+
+var (
+	mu     sync.Mutex
+	dir    string
+	maxAge time.Duration
+)
+
+// Entry describes a quarantined file. Each is kept in a directory of its
+// own, holding the file and this record as entry.json.
+type Entry struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	OriginalPath  string    `json:"original_path"`
+	Size          int64     `json:"size"`
+	Reason        string    `json:"reason"`
+	Source        string    `json:"source"`         // What received it, e.g. airdrop or sync
+	Peer          string    `json:"peer,omitempty"` // Who sent it
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// Path returns where the quarantined file is kept
+func (e Entry) Path() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return filepath.Join(dir, e.ID, e.Name)
+}
+
+// Configure sets the quarantine directory and how long files are kept
+// there, or without limit if maxAge is 0. An empty quarantineDir disables
+// quarantine: failed downloads are deleted.
+func Configure(quarantineDir string, keep time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	dir = ""
+	if quarantineDir != "" {
+		if abs, err := filepath.Abs(quarantineDir); err == nil {
+			dir = abs
+		}
+	}
+	maxAge = keep
+}
+
+// Discard disposes of a failed or cancelled download: it is quarantined
+// if enabled and deleted otherwise. Errors are logged, not returned, since
+// callers are already giving up on the file.
+func Discard(path, reason, source, peer string) {
+	entry, err := Move(path, reason, source, peer)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to quarantine %s: %v", logging.Path(path), err)
+		os.Remove(path)
+		return
+	}
+	if entry != nil {
+		log.Printf("Quarantined %s (%s) as %s", logging.Path(path), reason, entry.ID)
+	}
+}
+
+// Move moves a file into quarantine with the reason it failed. It returns
+// nil and deletes the file if quarantine is disabled.
+func Move(path, reason, source, peer string) (*Entry, error) {
+	mu.Lock()
+	root := dir
+	mu.Unlock()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if root == "" {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to delete file: %w", err)
+		}
+		return nil, nil
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	entry := &Entry{
+		ID:            id,
+		Name:          filepath.Base(path),
+		OriginalPath:  path,
+		Size:          info.Size(),
+		Reason:        reason,
+		Source:        source,
+		Peer:          peer,
+		QuarantinedAt: time.Now(),
+	}
+
+	entryDir := filepath.Join(root, id)
+	if err := os.MkdirAll(entryDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode quarantine entry: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, entryFile), data, 0600); err != nil {
+		os.RemoveAll(entryDir)
+		return nil, fmt.Errorf("failed to write quarantine entry: %w", err)
+	}
+	if err := moveFile(path, filepath.Join(entryDir, entry.Name)); err != nil {
+		os.RemoveAll(entryDir)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// List returns the quarantined files, oldest first
+func List() ([]Entry, error) {
+	mu.Lock()
+	root := dir
+	mu.Unlock()
+	if root == "" {
+		return nil, nil
+	}
+
+	dirs, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quarantine: %w", err)
+	}
+	var entries []Entry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		entry, err := readEntry(filepath.Join(root, d.Name()))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].QuarantinedAt.Before(entries[j].QuarantinedAt)
+	})
+	return entries, nil
+}
+
+// Delete removes a quarantined file for good
+func Delete(id string) error {
+	mu.Lock()
+	root := dir
+	mu.Unlock()
+
+	if root == "" || !filepath.IsLocal(id) {
+		return ErrNotFound
+	}
+	entryDir := filepath.Join(root, id)
+	if _, err := readEntry(entryDir); err != nil {
+		return ErrNotFound
+	}
+	if err := os.RemoveAll(entryDir); err != nil {
+		return fmt.Errorf("failed to delete quarantined file: %w", err)
+	}
+	return nil
+}
+
+// Cleanup deletes the files quarantined longer than the configured age
+// before now. It returns how many were deleted.
+func Cleanup(now time.Time) (int, error) {
+	mu.Lock()
+	keep := maxAge
+	mu.Unlock()
+	if keep <= 0 {
+		return 0, nil
+	}
+
+	entries, err := List()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, entry := range entries {
+		if now.Sub(entry.QuarantinedAt) < keep {
+			break
+		}
+		if err := Delete(entry.ID); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Start cleans up every interval until ctx is done
+func Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := Cleanup(now)
+			if err != nil {
+				log.Printf("Quarantine cleanup failed: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d expired quarantined file(s)", deleted)
+			}
+		}
+	}
+}
+
+func readEntry(entryDir string) (*Entry, error) {
+	data, err := os.ReadFile(filepath.Join(entryDir, entryFile))
+	if err != nil {
+		return nil, err
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// newID names an entry so that IDs sort by time
+func newID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate quarantine ID: %w", err)
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// moveFile renames src to dst, copying if they are on different volumes
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create quarantined file: %w", err)
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy file to quarantine: %w", err)
+	}
+	in.Close()
+	if err := os.Remove(src); err != nil {
+		return fmt.Errorf("failed to remove original file: %w", err)
+	}
+	return nil
+}
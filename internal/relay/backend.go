@@ -0,0 +1,54 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned by backends for missing objects
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes an object stored on a relay backend
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend is a dumb object store used to park encrypted payloads. It never
+// sees plaintext, so any storage the user already has will do.
+type Backend interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// BackendOptions selects and configures a relay backend
+type BackendOptions struct {
+	Type      string // s3, webdav or dir
+	Endpoint  string // URL, or the directory for dir
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Username  string
+	Password  string
+}
+
+// NewBackend creates the backend described by opts
+func NewBackend(opts BackendOptions) (Backend, error) {
+	switch opts.Type {
+	case "s3":
+		return NewS3Backend(opts.Endpoint, opts.Bucket, opts.Region, opts.AccessKey, opts.SecretKey)
+	case "webdav":
+		return NewWebDAVBackend(opts.Endpoint, opts.Username, opts.Password)
+	case "dir":
+		return NewDirBackend(opts.Endpoint)
+	default:
+		return nil, fmt.Errorf("unknown relay backend: %s", opts.Type)
+	}
+}
@@ -0,0 +1,469 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	envelopeMagic   = "SFMR"
+	envelopeVersion = 1
+	envelopeChunk   = 1024 * 1024
+	maxHeaderSize   = 64 * 1024
+	gcmOverhead     = 16
+)
+
+var (
+	ErrBadEnvelope      = errors.New("malformed relay envelope")
+	ErrBadSignature     = errors.New("relay envelope signature is invalid")
+	ErrUnsupportedKey   = errors.New("peer key type does not support relay encryption")
+	ErrChecksumMismatch = errors.New("relay payload checksum mismatch")
+)
+
+// envelopeHeader is encrypted to the recipient and signed by the sender
+type envelopeHeader struct {
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SHA256    []byte    `json:"sha256"`
+	Created   time.Time `json:"created"`
+	Expires   time.Time `json:"expires"`
+	Signature []byte    `json:"signature,omitempty"`
+}
+
+// signedBytes binds the header to the ephemeral key so a header cannot be
+// replayed inside another envelope
+func (h envelopeHeader) signedBytes(ephemeralPub []byte) ([]byte, error) {
+	h.Signature = nil
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(append(append([]byte("sfm-relay-v1"), ephemeralPub...), data...))
+	return sum[:], nil
+}
+
+// Envelope layout:
+//
+//	"SFMR" | version (1) | ephemeral X25519 public key (32)
+//	header length (uint32) | sealed header
+//	{ chunk length (uint32) | sealed chunk }...
+//
+// Every sealed part uses AES-256-GCM with a counter nonce; the additional
+// data marks the final chunk so truncation is detected.
+
+// envelopeSize returns the exact size of an envelope for a payload
+func envelopeSize(sealedHeader int, size int64) int64 {
+	chunks := (size + envelopeChunk - 1) / envelopeChunk
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(len(envelopeMagic)+1+32+4+sealedHeader) + chunks*(4+gcmOverhead) + size
+}
+
+// sealer writes an envelope for one recipient
+type sealer struct {
+	gcm          cipher.AEAD
+	ephemeralPub []byte
+	header       []byte
+	counter      uint64
+}
+
+func newSealer(sender crypto.PrivKey, recipient peer.ID, header envelopeHeader) (*sealer, error) {
+	recipientKey, err := recipient.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract recipient key: %w", err)
+	}
+	recipientX, err := x25519Public(recipientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPriv, ephemeralPub, err := generateX25519()
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeralPriv, recipientX)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive shared secret: %w", err)
+	}
+
+	gcm, err := newGCM(shared, ephemeralPub, recipientX)
+	if err != nil {
+		return nil, err
+	}
+
+	toSign, err := header.signedBytes(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	if header.Signature, err = sender.Sign(toSign); err != nil {
+		return nil, fmt.Errorf("failed to sign envelope: %w", err)
+	}
+
+	plainHeader, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sealer{gcm: gcm, ephemeralPub: ephemeralPub}
+	s.header = s.seal(plainHeader, false)
+	return s, nil
+}
+
+func (s *sealer) seal(plaintext []byte, final bool) []byte {
+	nonce := make([]byte, s.gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce, s.counter)
+	s.counter++
+	return s.gcm.Seal(nil, nonce, plaintext, chunkAD(final))
+}
+
+// Size returns the total envelope size for a payload of size bytes
+func (s *sealer) Size(size int64) int64 {
+	return envelopeSize(len(s.header), size)
+}
+
+// Encrypt writes the complete envelope, reading size bytes from payload
+func (s *sealer) Encrypt(w io.Writer, payload io.Reader, size int64) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString(envelopeMagic)
+	bw.WriteByte(envelopeVersion)
+	bw.Write(s.ephemeralPub)
+	binary.Write(bw, binary.BigEndian, uint32(len(s.header)))
+	if _, err := bw.Write(s.header); err != nil {
+		return err
+	}
+
+	buf := make([]byte, envelopeChunk)
+	remaining := size
+	for {
+		n := int64(envelopeChunk)
+		if remaining < n {
+			n = remaining
+		}
+		if _, err := io.ReadFull(payload, buf[:n]); err != nil {
+			return fmt.Errorf("failed to read payload: %w", err)
+		}
+		remaining -= n
+
+		sealed := s.seal(buf[:n], remaining == 0)
+		binary.Write(bw, binary.BigEndian, uint32(len(sealed)))
+		if _, err := bw.Write(sealed); err != nil {
+			return err
+		}
+		if remaining == 0 {
+			break
+		}
+	}
+	return bw.Flush()
+}
+
+// opener reads an envelope addressed to this device
+type opener struct {
+	r       *bufio.Reader
+	gcm     cipher.AEAD
+	header  envelopeHeader
+	counter uint64
+}
+
+// openEnvelope decrypts and authenticates the envelope header. The sender's
+// signature is verified against the key embedded in its peer ID.
+func openEnvelope(r io.Reader, self crypto.PrivKey) (*opener, error) {
+	br := bufio.NewReader(r)
+
+	prefix := make([]byte, len(envelopeMagic)+1+32)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, readError(err)
+	}
+	if string(prefix[:len(envelopeMagic)]) != envelopeMagic || prefix[len(envelopeMagic)] != envelopeVersion {
+		return nil, ErrBadEnvelope
+	}
+	ephemeralPub := prefix[len(envelopeMagic)+1:]
+
+	selfX, err := x25519Private(self)
+	if err != nil {
+		return nil, err
+	}
+	selfPubX, err := curve25519.X25519(selfX, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(selfX, ephemeralPub)
+	if err != nil {
+		return nil, ErrBadEnvelope
+	}
+
+	gcm, err := newGCM(shared, ephemeralPub, selfPubX)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &opener{r: br, gcm: gcm}
+	plainHeader, _, err := o.next(maxHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plainHeader, &o.header); err != nil {
+		return nil, ErrBadEnvelope
+	}
+
+	senderID, err := peer.Decode(o.header.Sender)
+	if err != nil {
+		return nil, ErrBadEnvelope
+	}
+	senderKey, err := senderID.ExtractPublicKey()
+	if err != nil {
+		return nil, ErrBadEnvelope
+	}
+	signed, err := o.header.signedBytes(ephemeralPub)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := senderKey.Verify(signed, o.header.Signature); err != nil || !ok {
+		return nil, ErrBadSignature
+	}
+
+	return o, nil
+}
+
+// next reads one sealed part; the header part is never final
+func (o *opener) next(limit int) ([]byte, bool, error) {
+	var length uint32
+	if err := binary.Read(o.r, binary.BigEndian, &length); err != nil {
+		return nil, false, readError(err)
+	}
+	if int(length) > limit+gcmOverhead || length < gcmOverhead {
+		return nil, false, ErrBadEnvelope
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(o.r, sealed); err != nil {
+		return nil, false, readError(err)
+	}
+
+	nonce := make([]byte, o.gcm.NonceSize())
+	binary.BigEndian.PutUint64(nonce, o.counter)
+	isHeader := o.counter == 0
+	o.counter++
+
+	if plain, err := o.gcm.Open(nil, nonce, sealed, chunkAD(false)); err == nil {
+		return plain, false, nil
+	}
+	if !isHeader {
+		if plain, err := o.gcm.Open(nil, nonce, sealed, chunkAD(true)); err == nil {
+			return plain, true, nil
+		}
+	}
+	return nil, false, ErrBadEnvelope
+}
+
+// readError maps a failed read of an envelope: one that ends early is
+// malformed, anything else is an I/O error worth retrying
+func readError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrBadEnvelope
+	}
+	return fmt.Errorf("failed to read envelope: %w", err)
+}
+
+// DecryptTo decrypts the payload into w and verifies its checksum
+func (o *opener) DecryptTo(w io.Writer) error {
+	hasher := sha256.New()
+	var written int64
+	for {
+		plain, final, err := o.next(envelopeChunk)
+		if err != nil {
+			return err
+		}
+		written += int64(len(plain))
+		if written > o.header.Size {
+			return ErrBadEnvelope
+		}
+		hasher.Write(plain)
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if final {
+			break
+		}
+	}
+
+	if written != o.header.Size || string(hasher.Sum(nil)) != string(o.header.SHA256) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Seal encrypts a small payload to recipient in the envelope format, signed
+// by sender, for storing or sending outside a mailbox
+func Seal(sender crypto.PrivKey, recipient peer.ID, name string, payload []byte) ([]byte, error) {
+	senderID, err := peer.IDFromPrivateKey(sender)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(payload)
+	header := envelopeHeader{
+		Sender:    senderID.String(),
+		Recipient: recipient.String(),
+		Name:      name,
+		Size:      int64(len(payload)),
+		SHA256:    sum[:],
+		Created:   time.Now().UTC(),
+	}
+	s, err := newSealer(sender, recipient, header)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := s.Encrypt(&buf, bytes.NewReader(payload), header.Size); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Open decrypts a payload sealed with Seal to this device and returns it
+// with its verified sender and name
+func Open(self crypto.PrivKey, sealed []byte) (peer.ID, string, []byte, error) {
+	o, err := openEnvelope(bytes.NewReader(sealed), self)
+	if err != nil {
+		return "", "", nil, err
+	}
+	selfID, err := peer.IDFromPrivateKey(self)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if o.header.Recipient != selfID.String() || o.header.Size > int64(len(sealed)) {
+		return "", "", nil, ErrBadEnvelope
+	}
+	sender, err := peer.Decode(o.header.Sender)
+	if err != nil {
+		return "", "", nil, ErrBadEnvelope
+	}
+
+	var buf bytes.Buffer
+	if err := o.DecryptTo(&buf); err != nil {
+		return "", "", nil, err
+	}
+	return sender, o.header.Name, buf.Bytes(), nil
+}
+
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+func newGCM(shared, ephemeralPub, recipientPub []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write([]byte("sfm-relay-v1"))
+	h.Write(shared)
+	h.Write(ephemeralPub)
+	h.Write(recipientPub)
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func generateX25519() ([]byte, []byte, error) {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(priv); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, pub, nil
+}
+
+// x25519Private converts an Ed25519 libp2p key to its X25519 scalar, so
+// the device's existing identity doubles as its relay decryption key
+func x25519Private(key crypto.PrivKey) ([]byte, error) {
+	if key.Type() != crypto.Ed25519 {
+		return nil, ErrUnsupportedKey
+	}
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	h := sha512.Sum512(raw[:32])
+	return h[:32], nil
+}
+
+// SharedSecret returns the X25519 shared secret of this device's Ed25519
+// identity key and a peer's, which both devices compute alike
+func SharedSecret(self crypto.PrivKey, remote crypto.PubKey) ([]byte, error) {
+	selfX, err := x25519Private(self)
+	if err != nil {
+		return nil, err
+	}
+	remoteX, err := x25519Public(remote)
+	if err != nil {
+		return nil, err
+	}
+	return curve25519.X25519(selfX, remoteX)
+}
+
+var curveP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519Public maps an Ed25519 public key to Montgomery form:
+// u = (1 + y) / (1 - y) mod p
+func x25519Public(key crypto.PubKey) ([]byte, error) {
+	if key.Type() != crypto.Ed25519 {
+		return nil, ErrUnsupportedKey
+	}
+	raw, err := key.Raw()
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, ErrUnsupportedKey
+	}
+
+	le := make([]byte, 32)
+	copy(le, raw)
+	le[31] &= 0x7f
+	y := new(big.Int).SetBytes(reverse(le))
+
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, curveP)
+	if den.Sign() == 0 {
+		return nil, ErrUnsupportedKey
+	}
+	u := num.Mul(num, den.ModInverse(den, curveP))
+	u.Mod(u, curveP)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reverse(out), nil
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
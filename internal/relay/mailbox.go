@@ -0,0 +1,364 @@
+package relay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const envelopeExt = ".sfmr"
+
+// Payloads the signed header rejects
+var (
+	errExpired  = errors.New("expired")
+	errTooLarge = errors.New("exceeds size limit")
+)
+
+// Mailbox parks end-to-end encrypted payloads on a relay backend for
+// offline paired devices and collects payloads addressed to this device.
+//
+// Objects are stored as inbox/<recipient>/<expiry>-<sender>-<random>.sfmr.
+// The relay only learns who sends to whom, when, and roughly how much.
+type Mailbox struct {
+	backend Backend
+	self    crypto.PrivKey
+	selfID  peer.ID
+	maxSize int64
+	ttl     time.Duration
+}
+
+// Delivery is a payload fetched from the relay
+type Delivery struct {
+	Sender  peer.ID
+	Path    string
+	Size    int64
+	Created time.Time
+}
+
+// NewMailbox creates a mailbox. self is this device's libp2p identity;
+// maxSize caps payload size and ttl sets how long parked payloads live.
+func NewMailbox(backend Backend, self crypto.PrivKey, maxSize int64, ttl time.Duration) (*Mailbox, error) {
+	if self.Type() != crypto.Ed25519 {
+		return nil, ErrUnsupportedKey
+	}
+	selfID, err := peer.IDFromPrivateKey(self)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive peer ID: %w", err)
+	}
+	return &Mailbox{
+		backend: backend,
+		self:    self,
+		selfID:  selfID,
+		maxSize: maxSize,
+		ttl:     ttl,
+	}, nil
+}
+
+func inboxPrefix(id peer.ID) string {
+	return "inbox/" + id.String() + "/"
+}
+
+// Park encrypts filePath for recipient and uploads it to the relay
+func (m *Mailbox) Park(ctx context.Context, recipient peer.ID, filePath string) (string, error) {
+	if !isPaired(recipient) {
+		return "", fmt.Errorf("peer %s is not paired", logging.Fingerprint(recipient.String()))
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if m.maxSize > 0 && info.Size() > m.maxSize {
+		return "", fmt.Errorf("file exceeds relay size limit (%s > %s)",
+			diskspace.FormatBytes(info.Size()), diskspace.FormatBytes(m.maxSize))
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	header := envelopeHeader{
+		Sender:    m.selfID.String(),
+		Recipient: recipient.String(),
+		Name:      filepath.Base(filePath),
+		Size:      info.Size(),
+		SHA256:    hasher.Sum(nil),
+		Created:   now,
+		Expires:   now.Add(m.ttl),
+	}
+
+	s, err := newSealer(m.self, recipient, header)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s%d-%s-%s%s", inboxPrefix(recipient), header.Expires.Unix(),
+		m.selfID.String(), hex.EncodeToString(suffix), envelopeExt)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(s.Encrypt(pw, file, info.Size()))
+	}()
+
+	if err := m.backend.Put(ctx, key, pr, s.Size(info.Size())); err != nil {
+		pr.CloseWithError(err)
+		return "", err
+	}
+
+	log.Printf("Parked %s for %s on relay (expires %s)",
+		logging.FileName(header.Name), logging.Fingerprint(recipient.String()), header.Expires.Format(time.RFC3339))
+	return key, nil
+}
+
+// Fetch downloads every payload addressed to this device into destDir and
+// deletes it from the relay. Expired, oversized, or unauthenticated
+// payloads and payloads from unpaired senders are deleted without being
+// delivered. A payload that fails for any other reason, such as a backend
+// or disk error, is left on the relay for the next fetch.
+func (m *Mailbox) Fetch(ctx context.Context, destDir string) ([]Delivery, error) {
+	objects, err := m.backend.List(ctx, inboxPrefix(m.selfID))
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []Delivery
+	for _, obj := range objects {
+		if ctx.Err() != nil {
+			return deliveries, ctx.Err()
+		}
+		if !strings.HasSuffix(obj.Key, envelopeExt) {
+			continue
+		}
+
+		if reason := m.rejectByName(obj); reason != "" {
+			log.Printf("Discarding relay payload %s: %s", path.Base(obj.Key), reason)
+			m.backend.Delete(ctx, obj.Key)
+			continue
+		}
+
+		delivery, err := m.fetchOne(ctx, obj, destDir)
+		if err != nil {
+			if ctx.Err() != nil {
+				return deliveries, ctx.Err()
+			}
+			if !rejected(err) {
+				log.Printf("Failed to fetch relay payload %s, keeping it: %v", path.Base(obj.Key), err)
+				continue
+			}
+			log.Printf("Discarding relay payload %s: %v", path.Base(obj.Key), err)
+			m.backend.Delete(ctx, obj.Key)
+			continue
+		}
+
+		if err := m.backend.Delete(ctx, obj.Key); err != nil {
+			log.Printf("Failed to delete fetched relay payload %s: %v", path.Base(obj.Key), err)
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, nil
+}
+
+// rejected reports whether fetchOne failed because of the payload itself,
+// which fetching again cannot fix
+func rejected(err error) bool {
+	return errors.Is(err, ErrBadEnvelope) || errors.Is(err, ErrBadSignature) ||
+		errors.Is(err, ErrChecksumMismatch) || errors.Is(err, errExpired) || errors.Is(err, errTooLarge)
+}
+
+// rejectByName applies checks that need no download
+func (m *Mailbox) rejectByName(obj ObjectInfo) string {
+	expires, sender, ok := parseKey(obj.Key)
+	if !ok {
+		return "malformed name"
+	}
+	if time.Now().After(expires) {
+		return "expired"
+	}
+	if m.maxSize > 0 && obj.Size > envelopeSize(maxHeaderSize, m.maxSize) {
+		return "exceeds size limit"
+	}
+	if !isPaired(sender) {
+		return "sender is not paired"
+	}
+	return ""
+}
+
+func (m *Mailbox) fetchOne(ctx context.Context, obj ObjectInfo, destDir string) (*Delivery, error) {
+	body, err := m.backend.Get(ctx, obj.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	o, err := openEnvelope(body, m.self)
+	if err != nil {
+		return nil, err
+	}
+
+	// The name only carries routing hints, the signed header is authoritative
+	_, keySender, _ := parseKey(obj.Key)
+	if o.header.Recipient != m.selfID.String() || o.header.Sender != keySender.String() {
+		return nil, ErrBadEnvelope
+	}
+	if time.Now().After(o.header.Expires) {
+		return nil, errExpired
+	}
+	if m.maxSize > 0 && o.header.Size > m.maxSize {
+		return nil, errTooLarge
+	}
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	reservation, err := diskspace.Reserve(destDir, o.header.Size)
+	if err != nil {
+		return nil, err
+	}
+	defer reservation.Release()
+
+	tmp, err := os.CreateTemp(destDir, ".relay-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := o.DecryptTo(tmp); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	outputPath := uniquePath(filepath.Join(destDir, filepath.Base(o.header.Name)))
+	if err := os.Rename(tmp.Name(), outputPath); err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	recordDelivery(keySender.String(), m.selfID.String(), outputPath, o.header.Size, hex.EncodeToString(o.header.SHA256))
+	log.Printf("Received %s from %s via relay",
+		logging.FileName(o.header.Name), logging.Fingerprint(keySender.String()))
+
+	return &Delivery{
+		Sender:  keySender,
+		Path:    outputPath,
+		Size:    o.header.Size,
+		Created: o.header.Created,
+	}, nil
+}
+
+// PurgeExpired deletes expired payloads this device parked for recipient
+func (m *Mailbox) PurgeExpired(ctx context.Context, recipient peer.ID) (int, error) {
+	objects, err := m.backend.List(ctx, inboxPrefix(recipient))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, obj := range objects {
+		expires, sender, ok := parseKey(obj.Key)
+		if !ok || sender != m.selfID || time.Now().Before(expires) {
+			continue
+		}
+		if err := m.backend.Delete(ctx, obj.Key); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// parseKey extracts the expiry and sender from an object key
+func parseKey(key string) (time.Time, peer.ID, bool) {
+	parts := strings.SplitN(strings.TrimSuffix(path.Base(key), envelopeExt), "-", 3)
+	if len(parts) != 3 {
+		return time.Time{}, "", false
+	}
+	unix, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	sender, err := peer.Decode(parts[1])
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return time.Unix(unix, 0), sender, true
+}
+
+func isPaired(id peer.ID) bool {
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", id.String()).Count(&count)
+	return count > 0
+}
+
+func recordDelivery(peerID, selfID, filePath string, size int64, hash string) {
+	db := storage.DB()
+
+	deviceName := "Unknown"
+	var device models.PairedDevice
+	if err := db.Where("peer_id = ?", peerID).First(&device).Error; err == nil {
+		deviceName = device.DeviceName
+	}
+
+	db.Create(&models.TransferHistory{
+		PeerID:     peerID,
+		DeviceName: deviceName,
+		FilePath:   filePath,
+		FileSize:   size,
+		Status:     "completed",
+		Direction:  "receive",
+		Progress:   100.0,
+
+		FileHash:         hash,
+		LocalFingerprint: selfID,
+		PeerFingerprint:  peerID,
+	})
+}
+
+// uniquePath appends " (n)" before the extension until path is unused
+func uniquePath(p string) string {
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return p
+	}
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebDAVBackend stores objects on a WebDAV server (Nextcloud, ownCloud, ...)
+type WebDAVBackend struct {
+	baseURL  *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAVBackend creates a backend rooted at baseURL
+func NewWebDAVBackend(baseURL, username, password string) (*WebDAVBackend, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/")
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV URL: %w", err)
+	}
+	return &WebDAVBackend{
+		baseURL:  u,
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (b *WebDAVBackend) url(key string) string {
+	u := *b.baseURL
+	u.Path = path.Join(b.baseURL.Path, key)
+	if strings.HasSuffix(key, "/") {
+		u.Path += "/"
+	}
+	return u.String()
+}
+
+func (b *WebDAVBackend) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+	return b.client.Do(req)
+}
+
+// Put uploads an object, creating parent collections as needed
+func (b *WebDAVBackend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dir := ""
+	for _, part := range strings.Split(path.Dir(key), "/") {
+		if part == "." || part == "" {
+			continue
+		}
+		dir += part + "/"
+		resp, err := b.do(ctx, "MKCOL", dir, nil, 0, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create collection: %w", err)
+		}
+		resp.Body.Close()
+		// 405 means it already exists
+		if resp.StatusCode >= 300 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("failed to create collection %s: %s", dir, resp.Status)
+		}
+	}
+
+	resp, err := b.do(ctx, http.MethodPut, key, r, size, nil)
+	if err != nil {
+		return fmt.Errorf("failed to upload: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to upload %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+// Get downloads an object
+func (b *WebDAVBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download %s: %s", key, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+type multistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+		Prop struct {
+			ContentLength string `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+			ResourceType  struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+// List returns the objects directly inside the collection prefix
+func (b *WebDAVBackend) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	dir := strings.TrimSuffix(prefix, "/") + "/"
+	header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}
+	body := strings.NewReader(`<?xml version="1.0"?><propfind xmlns="DAV:"><prop><getcontentlength/><getlastmodified/><resourcetype/></prop></propfind>`)
+
+	resp, err := b.do(ctx, "PROPFIND", dir, body, int64(body.Len()), header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("failed to list %s: %s", dir, resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse listing: %w", err)
+	}
+
+	var objects []ObjectInfo
+	for _, r := range ms.Responses {
+		if r.Prop.ResourceType.Collection != nil {
+			continue
+		}
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			continue
+		}
+		size, _ := strconv.ParseInt(r.Prop.ContentLength, 10, 64)
+		modTime, _ := http.ParseTime(r.Prop.LastModified)
+		objects = append(objects, ObjectInfo{
+			Key:     dir + path.Base(href),
+			Size:    size,
+			ModTime: modTime,
+		})
+	}
+	return objects, nil
+}
+
+// Delete removes an object
+func (b *WebDAVBackend) Delete(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete %s: %s", key, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Package removable finds mounted removable drives, such as USB sticks and
+// SD cards, and notices when one is plugged in
+package removable
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrUnsupported = errors.New("removable drive detection is not supported on this platform")
+
+// Drive is a mounted removable drive
+type Drive struct {
+	Path   string // Where the drive is mounted
+	Device string // e.g. /dev/sdb1 or E:
+	Label  string
+}
+
+// Watch calls fn for every removable drive mounted while ctx is not done,
+// including those already mounted when it starts. Drives are polled every
+// interval; fn runs on the watching goroutine.
+func Watch(ctx context.Context, interval time.Duration, fn func(Drive)) {
+	seen := make(map[string]bool)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		drives, err := Drives()
+		if err != nil {
+			log.Printf("Failed to list removable drives: %v", err)
+			if errors.Is(err, ErrUnsupported) {
+				return
+			}
+		}
+
+		mounted := make(map[string]bool, len(drives))
+		for _, drive := range drives {
+			mounted[drive.Path] = true
+			if !seen[drive.Path] {
+				fn(drive)
+			}
+		}
+		// A drive unplugged and plugged in again is seen anew
+		seen = mounted
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build darwin
+
+package removable
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Drives returns the volumes mounted under /Volumes, except the startup
+// disk, which appears there as a link to /
+func Drives() ([]Drive, error) {
+	entries, err := os.ReadDir("/Volumes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	var drives []Drive
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Type()&os.ModeSymlink != 0 {
+			continue
+		}
+		path := filepath.Join("/Volumes", entry.Name())
+		drives = append(drives, Drive{Path: path, Device: path, Label: entry.Name()})
+	}
+	return drives, nil
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package removable
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Drives returns the mounted removable drives. A block device counts as
+// removable if the kernel flags it so or it hangs off a USB bus, which
+// catches USB hard drives that claim to be fixed.
+func Drives() ([]Drive, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer file.Close()
+
+	// id parent major:minor root mountpoint options... - fstype source superoptions
+	var drives []Drive
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, field := range fields {
+			if field == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || sep+2 >= len(fields) {
+			continue
+		}
+		mountPoint, source := unescapeMount(fields[4]), fields[sep+2]
+		if fields[3] != "/" || !strings.HasPrefix(source, "/dev/") || seen[source] {
+			continue // Bind mounts and pseudo filesystems
+		}
+		if !isRemovable(filepath.Base(source)) {
+			continue
+		}
+		seen[source] = true
+		drives = append(drives, Drive{
+			Path:   mountPoint,
+			Device: source,
+			Label:  filepath.Base(mountPoint),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	return drives, nil
+}
+
+// isRemovable checks a block device, or the disk a partition is on
+func isRemovable(name string) bool {
+	sysPath, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", name))
+	if err != nil {
+		return false
+	}
+	if strings.Contains(sysPath, "/usb") {
+		return true
+	}
+	for _, dir := range []string{sysPath, filepath.Dir(sysPath)} {
+		if data, err := os.ReadFile(filepath.Join(dir, "removable")); err == nil {
+			return strings.TrimSpace(string(data)) == "1"
+		}
+	}
+	return false
+}
+
+// unescapeMount decodes the octal escapes mountinfo uses for spaces and
+// other separators
+func unescapeMount(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				out.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		out.WriteByte(s[i])
+	}
+	return out.String()
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package removable
+
+// Drives returns the mounted removable drives
+func Drives() ([]Drive, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package removable
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// Drives returns the drive letters Windows reports as removable
+func Drives() ([]Drive, error) {
+	mask, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil, err
+	}
+
+	var drives []Drive
+	for i := 0; i < 26; i++ {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		letter := string(rune('A'+i)) + ":"
+		root, err := windows.UTF16PtrFromString(letter + `\`)
+		if err != nil {
+			continue
+		}
+		if windows.GetDriveType(root) != windows.DRIVE_REMOVABLE {
+			continue
+		}
+
+		label := make([]uint16, windows.MAX_PATH+1)
+		if windows.GetVolumeInformation(root, &label[0], uint32(len(label)), nil, nil, nil, nil, 0) != nil {
+			continue // No medium, e.g. an empty card reader
+		}
+		drives = append(drives, Drive{
+			Path:   letter + `\`,
+			Device: letter,
+			Label:  windows.UTF16ToString(label),
+		})
+	}
+	return drives, nil
+}
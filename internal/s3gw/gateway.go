@@ -0,0 +1,242 @@
+package s3gw
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/hold"
+	"github.com/owner/secure-file-manager/internal/sigv4"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// Gateway exposes unlocked containers and shared folders as S3 buckets so
+// tools like restic, Kopia or media servers can read them
+type Gateway struct {
+	port      int
+	creds     sigv4.Credentials
+	readWrite bool
+	shared    map[string]string // bucket name -> directory
+	server    *http.Server
+	mu        sync.Mutex
+}
+
+// NewGateway creates an S3 gateway. shared maps bucket names to directories
+// that are always exposed; mounted containers are added automatically.
+func NewGateway(port int, accessKey, secretKey string, readWrite bool, shared map[string]string) *Gateway {
+	return &Gateway{
+		port:      port,
+		creds:     sigv4.Credentials{AccessKey: accessKey, SecretKey: secretKey},
+		readWrite: readWrite,
+		shared:    shared,
+	}
+}
+
+// Start starts the HTTP server
+func (g *Gateway) Start() error {
+	if g.creds.AccessKey == "" || g.creds.SecretKey == "" {
+		return fmt.Errorf("S3 gateway requires an access key and secret key")
+	}
+
+	g.mu.Lock()
+	g.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", g.port),
+		Handler: g,
+	}
+	g.mu.Unlock()
+
+	mode := "read-only"
+	if g.readWrite {
+		mode = "read-write"
+	}
+	log.Printf("S3 gateway (%s) listening on port %d", mode, g.port)
+	return g.server.ListenAndServe()
+}
+
+// Stop stops the HTTP server
+func (g *Gateway) Stop() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.server != nil {
+		return g.server.Close()
+	}
+	return nil
+}
+
+// ServeHTTP routes path-style S3 requests: /bucket/key
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, err := sigv4.Verify(r, g.lookupSecret, time.Now()); err != nil {
+		code := "AccessDenied"
+		if err == sigv4.ErrSignatureMismatch {
+			code = "SignatureDoesNotMatch"
+		} else if err == sigv4.ErrUnknownAccessKey {
+			code = "InvalidAccessKeyId"
+		} else if err == sigv4.ErrRequestExpired {
+			code = "RequestTimeTooSkewed"
+		}
+		writeError(w, r, http.StatusForbidden, code, err.Error())
+		return
+	}
+
+	bucketName, key := splitPath(r.URL.Path)
+
+	if bucketName == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method not allowed")
+			return
+		}
+		g.listBuckets(w, r)
+		return
+	}
+
+	buckets, err := g.buckets()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	b, ok := buckets[bucketName]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodHead:
+			w.WriteHeader(http.StatusOK)
+		case http.MethodGet:
+			if _, ok := r.URL.Query()["location"]; ok {
+				writeXML(w, http.StatusOK, locationResult{Xmlns: xmlns})
+				return
+			}
+			g.listObjects(w, r, b)
+		default:
+			writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method not allowed")
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		g.getObject(w, r, b, key)
+	case http.MethodPut:
+		if !g.writable(w, r, b) {
+			return
+		}
+		g.putObject(w, r, b, key)
+	case http.MethodDelete:
+		if !g.writable(w, r, b) {
+			return
+		}
+		g.deleteObject(w, r, b, key)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "Method not allowed")
+	}
+}
+
+// writable reports whether b accepts writes, writing an error otherwise
+func (g *Gateway) writable(w http.ResponseWriter, r *http.Request, b bucket) bool {
+	if !g.readWrite {
+		writeError(w, r, http.StatusForbidden, "AccessDenied", "Gateway is read-only")
+		return false
+	}
+	if b.Container != "" {
+		if err := hold.CheckContainer(b.Container); err != nil {
+			writeError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+func (g *Gateway) lookupSecret(accessKey string) (string, bool) {
+	if accessKey != g.creds.AccessKey {
+		return "", false
+	}
+	return g.creds.SecretKey, true
+}
+
+type bucket struct {
+	Name      string
+	Root      string
+	Created   time.Time
+	Container string // Container path for mounted containers
+}
+
+// buckets returns shared folders plus every currently mounted container
+func (g *Gateway) buckets() (map[string]bucket, error) {
+	result := make(map[string]bucket)
+
+	for name, dir := range g.shared {
+		created := time.Time{}
+		if info, err := os.Stat(dir); err == nil {
+			created = info.ModTime()
+		}
+		result[name] = bucket{Name: name, Root: dir, Created: created}
+	}
+
+	var containers []models.EncryptedContainer
+	if err := storage.DB().Where("is_mounted = ? AND mount_point <> ''", true).Find(&containers).Error; err != nil {
+		return nil, fmt.Errorf("failed to list mounted containers: %w", err)
+	}
+	for _, c := range containers {
+		name := BucketName(c.Path)
+		if _, exists := result[name]; exists || name == "" {
+			continue
+		}
+		result[name] = bucket{Name: name, Root: c.MountPoint, Created: c.CreatedAt, Container: c.Path}
+	}
+
+	return result, nil
+}
+
+var invalidBucketChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// BucketName derives a valid S3 bucket name from a container path
+func BucketName(containerPath string) string {
+	base := strings.TrimSuffix(filepath.Base(containerPath), filepath.Ext(containerPath))
+	name := invalidBucketChars.ReplaceAllString(strings.ToLower(base), "-")
+	name = strings.Trim(name, "-.")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	for len(name) > 0 && len(name) < 3 {
+		name += "0"
+	}
+	return name
+}
+
+// resolve maps an object key to a path inside the bucket root
+func (b bucket) resolve(key string) (string, bool) {
+	clean := filepath.FromSlash(key)
+	path := filepath.Join(b.Root, clean)
+	rel, err := filepath.Rel(b.Root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
+
+func splitPath(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// etag derives a stable entity tag without hashing the whole file
+func etag(info os.FileInfo) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d-%d", info.Size(), info.ModTime().UnixNano())))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,370 @@
+package s3gw
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/sigv4"
+)
+
+const (
+	xmlns          = "http://s3.amazonaws.com/doc/2006-03-01/"
+	defaultMaxKeys = 1000
+)
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Marker                string         `xml:"Marker,omitempty"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	KeyCount              int            `xml:"KeyCount,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []objectEntry  `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type locationResult struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+type errorResult struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	buckets, err := g.buckets()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	result := listAllMyBucketsResult{
+		Xmlns: xmlns,
+		Owner: owner{ID: "sfm", DisplayName: "sfm"},
+	}
+	for _, b := range buckets {
+		result.Buckets = append(result.Buckets, bucketEntry{
+			Name:         b.Name,
+			CreationDate: b.Created.UTC().Format(time.RFC3339),
+		})
+	}
+	sort.Slice(result.Buckets, func(i, j int) bool {
+		return result.Buckets[i].Name < result.Buckets[j].Name
+	})
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// listObjects implements both ListObjects (v1) and ListObjectsV2
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, b bucket) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	v2 := query.Get("list-type") == "2"
+
+	maxKeys := defaultMaxKeys
+	if mk := query.Get("max-keys"); mk != "" {
+		if n, err := strconv.Atoi(mk); err == nil && n >= 0 && n < defaultMaxKeys {
+			maxKeys = n
+		}
+	}
+
+	// Keys strictly greater than after are returned
+	after := query.Get("marker")
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid continuation token")
+				return
+			}
+			after = string(decoded)
+		}
+	}
+
+	objects, err := walkObjects(b.Root, prefix)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	result := listBucketResult{
+		Xmlns:     xmlns,
+		Name:      b.Name,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+	} else {
+		result.Marker = query.Get("marker")
+	}
+
+	seenPrefixes := make(map[string]bool)
+	last := ""
+	count := 0
+	for _, obj := range objects {
+		if obj.Key <= after {
+			continue
+		}
+
+		// Group keys below the next delimiter into a common prefix
+		if delimiter != "" {
+			rest := strings.TrimPrefix(obj.Key, prefix)
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				cp := prefix + rest[:i+len(delimiter)]
+				if seenPrefixes[cp] || cp <= after {
+					continue
+				}
+				if count == maxKeys {
+					result.IsTruncated = true
+					break
+				}
+				seenPrefixes[cp] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: cp})
+				last = cp
+				count++
+				continue
+			}
+		}
+
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          obj.Key,
+			LastModified: obj.info.ModTime().UTC().Format(time.RFC3339),
+			ETag:         etag(obj.info),
+			Size:         obj.info.Size(),
+			StorageClass: "STANDARD",
+		})
+		last = obj.Key
+		count++
+	}
+
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		} else {
+			result.NextMarker = last
+		}
+	}
+	if v2 {
+		result.KeyCount = count
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+type object struct {
+	Key  string
+	info os.FileInfo
+}
+
+// walkObjects returns all regular files under root whose key has prefix,
+// sorted by key
+func walkObjects(root, prefix string) ([]object, error) {
+	var objects []object
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, object{Key: key, info: info})
+		}
+		return nil
+	})
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, err
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, b bucket, key string) {
+	path, ok := b.resolve(key)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid key")
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+
+	w.Header().Set("ETag", etag(info))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	// ServeContent handles Range, If-Modified-Since and HEAD
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, b bucket, key string) {
+	if r.URL.Query().Get("uploadId") != "" || r.URL.Query().Has("uploads") {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Multipart uploads are not supported")
+		return
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(payloadHash, "STREAMING-") {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "Chunked payload signing is not supported")
+		return
+	}
+
+	path, ok := b.resolve(key)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid key")
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "Failed to create directory")
+		return
+	}
+
+	reservation, err := diskspace.Reserve(filepath.Dir(path), r.ContentLength)
+	if err != nil {
+		writeError(w, r, http.StatusInsufficientStorage, "EntityTooLarge", err.Error())
+		return
+	}
+	defer reservation.Release()
+
+	// Write to a temp file and rename so readers never see partial objects
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sfm-upload-*")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "Failed to create file")
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), r.Body); err != nil {
+		tmp.Close()
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "Failed to write object")
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "Failed to write object")
+		return
+	}
+
+	if payloadHash != "" && payloadHash != sigv4.UnsignedPayload && payloadHash != hex.EncodeToString(hasher.Sum(nil)) {
+		writeError(w, r, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "Payload hash does not match")
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "Failed to store object")
+		return
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		w.Header().Set("ETag", etag(info))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, b bucket, key string) {
+	path, ok := b.resolve(key)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Invalid key")
+		return
+	}
+
+	// S3 reports success for missing keys
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		writeError(w, r, http.StatusInternalServerError, "InternalError", "Failed to delete object")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, errorResult{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
@@ -0,0 +1,89 @@
+// Package sched runs background work at a lower CPU and IO priority, so
+// maintenance never makes the machine feel sluggish. Priorities are set
+// per OS thread: a worker locks its goroutine to a thread, lowers it, and
+// the thread is discarded when the goroutine exits.
+package sched
+
+import (
+	"log"
+	"runtime"
+	gosync "sync"
+)
+
+// Jobs with their own class
+const (
+	JobIndexing   = "indexing"   // Search indexing and OCR
+	JobHashing    = "hashing"    // Integrity scrubs
+	JobEncryption = "encryption" // Backups
+)
+
+// IO scheduling classes, as for ionice
+const (
+	IOClassRealtime   = "realtime"
+	IOClassBestEffort = "best-effort"
+	IOClassIdle       = "idle"
+)
+
+// Class is how a job's threads are scheduled. On Linux Nice and the IO
+// class apply as set; elsewhere any lowered priority maps to the
+// platform's background mode.
+type Class struct {
+	Nice    int    // -20 to 19, higher runs less
+	IOClass string // Empty leaves IO priority alone
+	IOLevel int    // 0 to 7 within best-effort and realtime, higher runs less
+}
+
+// lowered reports whether c asks for less than normal priority
+func (c Class) lowered() bool {
+	return c.Nice > 0 || c.IOClass == IOClassIdle || (c.IOClass == IOClassBestEffort && c.IOLevel > 4)
+}
+
+var (
+	mu      gosync.RWMutex
+	classes = map[string]Class{
+		JobIndexing:   {Nice: 10, IOClass: IOClassIdle},
+		JobHashing:    {Nice: 10, IOClass: IOClassIdle},
+		JobEncryption: {Nice: 5, IOClass: IOClassBestEffort, IOLevel: 7},
+	}
+)
+
+// Configure overrides the classes of the given jobs
+func Configure(overrides map[string]Class) {
+	mu.Lock()
+	defer mu.Unlock()
+	for job, class := range overrides {
+		classes[job] = class
+	}
+}
+
+// ClassOf returns the class a job runs at
+func ClassOf(job string) Class {
+	mu.RLock()
+	defer mu.RUnlock()
+	return classes[job]
+}
+
+// Enter applies job's class to the calling goroutine. The goroutine stays
+// locked to its thread so the class cannot leak to other goroutines; call
+// it first in a worker goroutine that exits when done.
+func Enter(job string) {
+	class := ClassOf(job)
+	if class == (Class{}) {
+		return
+	}
+	runtime.LockOSThread()
+	if err := setThreadClass(class); err != nil {
+		log.Printf("Failed to lower priority of %s: %v", job, err)
+	}
+}
+
+// Run runs fn at job's class on a goroutine of its own and waits for it
+func Run(job string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Enter(job)
+		fn()
+	}()
+	<-done
+}
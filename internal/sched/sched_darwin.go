@@ -0,0 +1,27 @@
+//go:build darwin
+
+package sched
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// From sys/resource.h
+const (
+	prioDarwinThread = 3
+	prioDarwinBG     = 0x1000
+)
+
+// setThreadClass puts the calling thread in the background band, which
+// lowers both its CPU and IO priority
+func setThreadClass(c Class) error {
+	if !c.lowered() {
+		return nil
+	}
+	if err := unix.Setpriority(prioDarwinThread, 0, prioDarwinBG); err != nil {
+		return fmt.Errorf("failed to enter background mode: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package sched
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// From linux/ioprio.h
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioClasses = map[string]int{
+	IOClassRealtime:   1,
+	IOClassBestEffort: 2,
+	IOClassIdle:       3,
+}
+
+// setThreadClass sets the nice value and IO priority of the calling
+// thread, which Linux schedules on its own
+func setThreadClass(c Class) error {
+	tid := unix.Gettid()
+	if err := unix.Setpriority(unix.PRIO_PROCESS, tid, c.Nice); err != nil {
+		return fmt.Errorf("failed to set nice value: %w", err)
+	}
+	if c.IOClass == "" {
+		return nil
+	}
+	class, ok := ioClasses[c.IOClass]
+	if !ok {
+		return fmt.Errorf("unknown IO class %q", c.IOClass)
+	}
+	level := min(max(c.IOLevel, 0), 7)
+	if class == ioClasses[IOClassIdle] {
+		level = 0
+	}
+	prio := class<<ioprioClassShift | level
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(prio)); errno != 0 {
+		return fmt.Errorf("failed to set IO priority: %w", errno)
+	}
+	return nil
+}
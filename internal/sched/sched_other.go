@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package sched
+
+// setThreadClass is not supported here; work runs at normal priority
+func setThreadClass(c Class) error {
+	return nil
+}
@@ -0,0 +1,33 @@
+//go:build windows
+
+package sched
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// From processthreadsapi.h
+const (
+	threadModeBackgroundBegin = 0x00010000
+	threadPriorityBelowNormal = -1
+)
+
+var procSetThreadPriority = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetThreadPriority")
+
+// setThreadClass puts the calling thread in background mode, which lowers
+// its CPU and IO priority, or only lowers its CPU priority for a mild class
+func setThreadClass(c Class) error {
+	if !c.lowered() {
+		return nil
+	}
+	priority := threadModeBackgroundBegin
+	if c.IOClass != IOClassIdle && c.Nice < 10 {
+		priority = threadPriorityBelowNormal
+	}
+	if ok, _, err := procSetThreadPriority.Call(uintptr(windows.CurrentThread()), uintptr(priority)); ok == 0 {
+		return fmt.Errorf("failed to set thread priority: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package scrub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	gosync "sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/sched"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// Reminder reports an archive container that has not passed verification
+// within the allowed age
+type Reminder struct {
+	Container    string        `json:"container"`
+	LastVerified time.Time     `json:"last_verified,omitzero"` // Zero if never verified
+	Overdue      time.Duration `json:"overdue"`                // Past maxAge; 0 if never verified
+}
+
+var (
+	reminderMu      gosync.Mutex
+	reminderHandler func(Reminder)
+)
+
+// SetArchive flags a tracked container as cold storage, or clears the flag
+func SetArchive(containerPath string, archive bool) error {
+	containerPath, err := filepath.Abs(containerPath)
+	if err != nil {
+		return err
+	}
+	result := storage.DB().Model(&models.EncryptedContainer{}).Where("path = ?", containerPath).Update("archive", archive)
+	if result.Error != nil {
+		return fmt.Errorf("failed to flag container: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("container is not tracked: %s", containerPath)
+	}
+	return nil
+}
+
+// SetReminderHandler sets a function called for every overdue archive when
+// reminders are checked, e.g. to show a notification. It must not block.
+func SetReminderHandler(handler func(Reminder)) {
+	reminderMu.Lock()
+	defer reminderMu.Unlock()
+	reminderHandler = handler
+}
+
+// OverdueArchives returns the archive containers that have not passed
+// verification within maxAge, most overdue first
+func OverdueArchives(maxAge time.Duration) ([]Reminder, error) {
+	now := time.Now()
+	var containers []models.EncryptedContainer
+	err := storage.DB().Where("archive = ? AND last_verified < ?", true, now.Add(-maxAge)).
+		Order("last_verified").Find(&containers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load archive containers: %w", err)
+	}
+
+	reminders := make([]Reminder, len(containers))
+	for i, container := range containers {
+		reminders[i] = Reminder{Container: container.Path, LastVerified: container.LastVerified}
+		if !container.LastVerified.IsZero() {
+			reminders[i].Overdue = now.Sub(container.LastVerified) - maxAge
+		}
+	}
+	return reminders, nil
+}
+
+// StartReminders checks for overdue archives every interval until ctx is
+// done, passing each to the reminder handler
+func StartReminders(ctx context.Context, maxAge, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reminders, err := OverdueArchives(maxAge)
+		if err != nil {
+			log.Printf("Archive reminder check failed: %v", err)
+		}
+		reminderMu.Lock()
+		handler := reminderHandler
+		reminderMu.Unlock()
+		for _, reminder := range reminders {
+			since := "ever"
+			if !reminder.LastVerified.IsZero() {
+				since = "since " + reminder.LastVerified.Format(time.DateOnly)
+			}
+			log.Printf("Archive %s has not been verified %s", logging.Path(reminder.Container), since)
+			if handler != nil {
+				handler(reminder)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Verify checks the given containers against their checksums now, at the
+// hashing job's scheduling class
+func (s *Scrubber) Verify(ctx context.Context, containerPaths ...string) (report Report, err error) {
+	paths := make([]string, len(containerPaths))
+	for i, containerPath := range containerPaths {
+		if paths[i], err = filepath.Abs(containerPath); err != nil {
+			return report, err
+		}
+	}
+	var containers []models.EncryptedContainer
+	if err := storage.DB().Where("path IN ?", paths).Find(&containers).Error; err != nil {
+		return report, fmt.Errorf("failed to load containers: %w", err)
+	}
+	if len(containers) == 0 {
+		return report, fmt.Errorf("none of the containers are tracked")
+	}
+
+	sched.Run(sched.JobHashing, func() {
+		for i := range containers {
+			if err = s.checkContainer(ctx, &containers[i], &report); err != nil {
+				return
+			}
+		}
+	})
+	return report, err
+}
+
+// ScheduleVerification verifies the given containers in the background,
+// waiting like a scrub for the device to be idle and on power, and logs
+// the result. It answers a reminder in one call.
+func (s *Scrubber) ScheduleVerification(ctx context.Context, containerPaths ...string) {
+	go func() {
+		report, err := s.Verify(ctx, containerPaths...)
+		if err != nil {
+			log.Printf("Archive verification failed: %v", err)
+			return
+		}
+		log.Printf("Archive verification %s", report)
+	}()
+}
@@ -0,0 +1,322 @@
+package scrub
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/audit"
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/power"
+	"github.com/owner/secure-file-manager/internal/sched"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+// Audit log actions
+const (
+	AuditCorrupt      = "scrub.corrupt"
+	AuditRepaired     = "scrub.repaired"
+	AuditRepairFailed = "scrub.repair_failed"
+)
+
+const (
+	auditActor = "scrubber"
+	batchSize  = 500
+	// idlePoll is how often a paused scrub checks whether it may continue
+	idlePoll = 30 * time.Second
+)
+
+// Report summarizes a scrub run
+type Report struct {
+	Files      int
+	Containers int
+	Bytes      int64
+	Corrupt    int
+	Repaired   int
+}
+
+func (r Report) String() string {
+	return fmt.Sprintf("checked %d files and %d containers (%d bytes), %d corrupt, %d repaired",
+		r.Files, r.Containers, r.Bytes, r.Corrupt, r.Repaired)
+}
+
+// RepairFunc replaces a corrupt file with a copy whose content has the given
+// hex SHA-256
+type RepairFunc func(ctx context.Context, path, hash string) error
+
+// Scrubber re-reads indexed files and tracked containers and compares them
+// with the hashes recorded for them. A file whose content changed while its
+// size and modification time did not has rotted or been tampered with; files
+// changed the ordinary way are left to the indexer.
+type Scrubber struct {
+	rate   int64
+	repair RepairFunc
+	idle   func() bool
+}
+
+// New creates a scrubber reading at most rate bytes per second, or without
+// limit if rate is 0
+func New(rate int64) *Scrubber {
+	return &Scrubber{rate: rate}
+}
+
+// SetRepair sets how corrupt files are repaired. Without it, corruption is
+// only reported.
+func (s *Scrubber) SetRepair(repair RepairFunc) {
+	s.repair = repair
+}
+
+// SetIdleCheck pauses scrubbing whenever idle returns false, e.g. while
+// transfers are running
+func (s *Scrubber) SetIdleCheck(idle func() bool) {
+	s.idle = idle
+}
+
+// Start scrubs every interval until ctx is done
+func (s *Scrubber) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := s.RunOnce(ctx)
+			if err != nil {
+				log.Printf("Scrub failed: %v", err)
+				continue
+			}
+			log.Printf("Scrub %s", report)
+		}
+	}
+}
+
+// RunOnce scrubs every indexed file with a content hash and every tracked
+// container once, at the hashing job's scheduling class
+func (s *Scrubber) RunOnce(ctx context.Context) (report Report, err error) {
+	sched.Run(sched.JobHashing, func() {
+		if err = s.scrubFiles(ctx, &report); err != nil {
+			return
+		}
+		err = s.scrubContainers(ctx, &report)
+	})
+	return report, err
+}
+
+func (s *Scrubber) scrubFiles(ctx context.Context, report *Report) error {
+	db := storage.DB()
+	var last uint
+	for {
+		var batch []models.SearchIndex
+		err := db.Select("id", "path", "file_size", "modified_time", "content_hash").
+			Where("id > ? AND is_directory = ? AND content_hash <> ''", last, false).
+			Order("id").Limit(batchSize).Find(&batch).Error
+		if err != nil {
+			return fmt.Errorf("failed to load index: %w", err)
+		}
+		for _, entry := range batch {
+			last = entry.ID
+			info, err := os.Stat(entry.Path)
+			if err != nil || info.Size() != entry.FileSize || !info.ModTime().Equal(entry.ModifiedTime) {
+				continue
+			}
+			if _, err := s.check(ctx, entry.Path, entry.ContentHash, report); err != nil {
+				return err
+			}
+			report.Files++
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (s *Scrubber) scrubContainers(ctx context.Context, report *Report) error {
+	db := storage.DB()
+	var containers []models.EncryptedContainer
+	if err := db.Find(&containers).Error; err != nil {
+		return fmt.Errorf("failed to load containers: %w", err)
+	}
+	for i := range containers {
+		if err := s.checkContainer(ctx, &containers[i], report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkContainer verifies a container against its checksum, recording
+// when it last matched. Only a cancelled ctx is returned as an error.
+func (s *Scrubber) checkContainer(ctx context.Context, container *models.EncryptedContainer, report *Report) error {
+	info, err := os.Stat(container.Path)
+	if err != nil {
+		return nil
+	}
+	if container.Checksum == "" || info.Size() != container.FileSize || !info.ModTime().Equal(container.ModifiedTime) {
+		// Rebuilt or never hashed: record the current state
+		if err := TrackContainer(container.Path, container.OriginalPath); err != nil {
+			log.Printf("Failed to update container checksum: %v", err)
+		}
+		return nil
+	}
+	ok, err := s.check(ctx, container.Path, container.Checksum, report)
+	if err != nil {
+		return err
+	}
+	if ok {
+		storage.DB().Model(container).UpdateColumn("last_verified", time.Now())
+	}
+	report.Containers++
+	return nil
+}
+
+// check hashes a file, reports it if it does not match want, and repairs it
+// if possible. It reports whether the file matched. Only a cancelled ctx
+// is returned as an error.
+func (s *Scrubber) check(ctx context.Context, path, want string, report *Report) (bool, error) {
+	if err := s.waitIdle(ctx); err != nil {
+		return false, err
+	}
+
+	got, n, err := s.hash(ctx, path)
+	report.Bytes += n
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if err != nil {
+		log.Printf("Scrub could not read %s: %v", logging.Path(path), err)
+		return false, nil
+	}
+	if got == want {
+		return true, nil
+	}
+
+	report.Corrupt++
+	log.Printf("Scrub found corruption in %s", logging.Path(path))
+	record(AuditCorrupt, path, fmt.Sprintf("expected sha256 %s, found %s", want, got))
+	if s.repair == nil {
+		return false, nil
+	}
+	if err := s.repair(ctx, path, want); err != nil {
+		record(AuditRepairFailed, path, err.Error())
+		return false, nil
+	}
+	report.Repaired++
+	record(AuditRepaired, path, "sha256 "+want)
+	return false, nil
+}
+
+func (s *Scrubber) waitIdle(ctx context.Context) error {
+	for (s.idle != nil && !s.idle()) || !power.Ready(power.JobScrub) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(idlePoll):
+		}
+	}
+	return ctx.Err()
+}
+
+// hash returns the hex SHA-256 of a file, reading no faster than the rate
+func (s *Scrubber) hash(ctx context.Context, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, &throttledReader{ctx: ctx, r: f, rate: s.rate, start: time.Now()})
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// TrackContainer records a container's checksum so scrubs can verify it
+func TrackContainer(containerPath, originalPath string) error {
+	containerPath, err := filepath.Abs(containerPath)
+	if err != nil {
+		return err
+	}
+	header, err := crypto.ReadContainerHeader(containerPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat container: %w", err)
+	}
+	checksum, _, err := New(0).hash(context.Background(), containerPath)
+	if err != nil {
+		return fmt.Errorf("failed to hash container: %w", err)
+	}
+
+	container := models.EncryptedContainer{
+		Path:          containerPath,
+		OriginalPath:  originalPath,
+		Salt:          header.Salt[:],
+		KDF:           header.KDF,
+		Argon2Time:    header.KDFTime,
+		Argon2Memory:  header.KDFMemory,
+		Argon2Threads: header.KDFThreads,
+		Checksum:      checksum,
+		FileSize:      info.Size(),
+		ModifiedTime:  info.ModTime(),
+		LastVerified:  time.Now(), // Just read in full to hash it
+	}
+	err = storage.DB().Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "path"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"salt", "kdf", "argon2_time", "argon2_memory", "argon2_threads",
+			"checksum", "file_size", "modified_time", "last_verified", "updated_at",
+		}),
+	}).Create(&container).Error
+	if err != nil {
+		return fmt.Errorf("failed to save container: %w", err)
+	}
+	return nil
+}
+
+func record(action, path, detail string) {
+	if err := audit.Record(action, auditActor, path, detail); err != nil {
+		log.Printf("Failed to audit %s: %v", action, err)
+	}
+}
+
+// throttledReader limits reads to rate bytes per second on average
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	if t.rate > 0 {
+		due := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+		if wait := due - time.Since(t.start); wait > 0 {
+			select {
+			case <-t.ctx.Done():
+				return n, t.ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+	}
+	return n, err
+}
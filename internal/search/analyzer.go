@@ -0,0 +1,226 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxTermLength skips tokens longer than this many bytes, such as hashes
+// and base64 runs
+const maxTermLength = 64
+
+// AnalyzerConfig selects how text is split into searchable terms
+type AnalyzerConfig struct {
+	Language       string // english, german, french, spanish, italian, portuguese or simple
+	Stemming       bool
+	StopWords      bool
+	FoldDiacritics bool // café matches cafe
+	CJKBigrams     bool // Index Chinese, Japanese and Korean text as overlapping character pairs
+}
+
+// DefaultAnalyzerConfig is used for directories without a configured analyzer
+var DefaultAnalyzerConfig = AnalyzerConfig{
+	Language:       "english",
+	Stemming:       true,
+	StopWords:      true,
+	FoldDiacritics: true,
+	CJKBigrams:     true,
+}
+
+// Analyzer turns text into normalized terms. Documents and queries must go
+// through the same analyzer to match.
+type Analyzer struct {
+	config    AnalyzerConfig
+	stem      func(string) string
+	stopWords map[string]bool
+}
+
+// NewAnalyzer creates an analyzer for a configuration
+func NewAnalyzer(config AnalyzerConfig) (*Analyzer, error) {
+	config.Language = strings.ToLower(config.Language)
+	if config.Language == "" {
+		config.Language = "simple"
+	}
+	lang, ok := languages[config.Language]
+	if !ok {
+		return nil, fmt.Errorf("unsupported analyzer language: %s", config.Language)
+	}
+
+	a := &Analyzer{config: config}
+	if config.Stemming {
+		a.stem = lang.stem
+	}
+	if config.StopWords && len(lang.stopWords) > 0 {
+		a.stopWords = make(map[string]bool)
+		for _, word := range strings.Fields(lang.stopWords) {
+			a.stopWords[a.normalize(word)] = true
+		}
+	}
+	return a, nil
+}
+
+// ParseAnalyzer recreates an analyzer from its Spec
+func ParseAnalyzer(spec string) (*Analyzer, error) {
+	parts := strings.Split(spec, "+")
+	config := AnalyzerConfig{Language: parts[0]}
+	for _, flag := range parts[1:] {
+		switch flag {
+		case "stem":
+			config.Stemming = true
+		case "stop":
+			config.StopWords = true
+		case "fold":
+			config.FoldDiacritics = true
+		case "cjk":
+			config.CJKBigrams = true
+		default:
+			return nil, fmt.Errorf("unknown analyzer option: %s", flag)
+		}
+	}
+	return NewAnalyzer(config)
+}
+
+// Spec identifies the analyzer's configuration, e.g. "german+stem+fold".
+// Terms built with different specs are not comparable.
+func (a *Analyzer) Spec() string {
+	spec := a.config.Language
+	if a.stem != nil {
+		spec += "+stem"
+	}
+	if a.stopWords != nil {
+		spec += "+stop"
+	}
+	if a.config.FoldDiacritics {
+		spec += "+fold"
+	}
+	if a.config.CJKBigrams {
+		spec += "+cjk"
+	}
+	return spec
+}
+
+// Tokens returns the terms of text in order, with repeats
+func (a *Analyzer) Tokens(text string) []string {
+	var tokens []string
+	emit := func(word string) {
+		if word == "" || len(word) > maxTermLength || a.stopWords[word] {
+			return
+		}
+		if a.stem != nil {
+			word = a.stem(word)
+		}
+		tokens = append(tokens, word)
+	}
+
+	var word []rune
+	var cjk []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			emit(a.normalize(trimApostrophes(word)))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		switch {
+		case len(cjk) == 1 || (len(cjk) > 0 && !a.config.CJKBigrams):
+			for _, r := range cjk {
+				tokens = append(tokens, string(r))
+			}
+		case len(cjk) > 1:
+			for i := 0; i+1 < len(cjk); i++ {
+				tokens = append(tokens, string(cjk[i:i+2]))
+			}
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r):
+			flushCJK()
+			word = append(word, r)
+		case (r == '\'' || r == '’') && len(word) > 0:
+			word = append(word, '\'')
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
+
+// Terms returns the distinct terms of text in the form stored in the index:
+// sorted and space-delimited, with a space at each end so every term can
+// be matched as " term "
+func (a *Analyzer) Terms(text string) string {
+	tokens := a.Tokens(text)
+	if len(tokens) == 0 {
+		return ""
+	}
+	sort.Strings(tokens)
+	unique := tokens[:1]
+	for _, t := range tokens[1:] {
+		if t != unique[len(unique)-1] {
+			unique = append(unique, t)
+		}
+	}
+	return " " + strings.Join(unique, " ") + " "
+}
+
+// normalize lowercases a word and folds diacritics if configured
+func (a *Analyzer) normalize(word string) string {
+	word = strings.ToLower(word)
+	if !a.config.FoldDiacritics {
+		return norm.NFC.String(word)
+	}
+
+	var b strings.Builder
+	for _, r := range norm.NFD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if folded, ok := foldings[r]; ok {
+			b.WriteString(folded)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Letters that do not decompose into a base letter and a mark
+var foldings = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+}
+
+// trimApostrophes resolves apostrophes inside a word: possessives drop
+// their 's, short elided prefixes (l', d', qu') are dropped, and any other
+// apostrophe is removed (don't -> dont)
+func trimApostrophes(word []rune) string {
+	s := strings.Trim(string(word), "'")
+	i := strings.LastIndexByte(s, '\'')
+	if i < 0 {
+		return s
+	}
+	switch {
+	case s[i+1:] == "s" || s[i+1:] == "S":
+		s = s[:i]
+	case utf8.RuneCountInString(s[:strings.IndexByte(s, '\'')]) <= 2:
+		s = s[strings.LastIndexByte(s, '\'')+1:]
+	}
+	return strings.ReplaceAll(s, "'", "")
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
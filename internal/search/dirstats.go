@@ -0,0 +1,198 @@
+package search
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+// dirStatsMu serializes writes to DirectoryStats, which are read-modify-write
+var dirStatsMu sync.Mutex
+
+type dirDelta struct {
+	size    int64
+	files   int64
+	added   time.Time // Newest modification time added
+	removed time.Time // Newest modification time removed or replaced
+}
+
+// dirStats collects changes to directory aggregates so an index run
+// writes each directory once
+type dirStats struct {
+	mu   sync.Mutex
+	dirs map[string]*dirDelta
+}
+
+func newDirStats() *dirStats {
+	return &dirStats{dirs: make(map[string]*dirDelta)}
+}
+
+// add records a change of a file at path for every directory above it
+func (s *dirStats) add(path string, size, files int64, added, removed time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		d := s.dirs[dir]
+		if d == nil {
+			d = &dirDelta{}
+			s.dirs[dir] = d
+		}
+		d.size += size
+		d.files += files
+		if added.After(d.added) {
+			d.added = added
+		}
+		if removed.After(d.removed) {
+			d.removed = removed
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+}
+
+// added records a newly indexed file
+func (s *dirStats) added(entry *models.SearchIndex) {
+	if !entry.IsDirectory {
+		s.add(entry.Path, entry.FileSize, 1, entry.ModifiedTime, time.Time{})
+	}
+}
+
+// removed records a file dropped from the index
+func (s *dirStats) removed(entry *models.SearchIndex) {
+	if !entry.IsDirectory {
+		s.add(entry.Path, -entry.FileSize, -1, time.Time{}, entry.ModifiedTime)
+	}
+}
+
+// apply writes the collected changes. It must run after the index rows
+// themselves are updated, since a removed newest file makes it look up the
+// next newest.
+func (s *dirStats) apply() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.dirs) == 0 {
+		return nil
+	}
+
+	dirStatsMu.Lock()
+	defer dirStatsMu.Unlock()
+	err := storage.DB().Transaction(func(tx *gorm.DB) error {
+		for dir, d := range s.dirs {
+			var stats models.DirectoryStats
+			err := tx.Where("path = ?", dir).First(&stats).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+
+			stats.Path = dir
+			stats.TotalSize += d.size
+			stats.FileCount += d.files
+			if stats.FileCount <= 0 {
+				if stats.ID != 0 {
+					if err := tx.Delete(&stats).Error; err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if d.added.After(stats.NewestModTime) {
+				stats.NewestModTime = d.added
+			}
+			if !d.removed.IsZero() && !d.removed.Before(stats.NewestModTime) {
+				var newest models.SearchIndex
+				err := underRoot(tx.Model(&models.SearchIndex{}), dir).
+					Where("is_directory = ?", false).
+					Select("modified_time").Order("modified_time DESC").First(&newest).Error
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+				stats.NewestModTime = newest.ModifiedTime
+			}
+			if err := tx.Save(&stats).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update directory stats: %w", err)
+	}
+	s.dirs = make(map[string]*dirDelta)
+	return nil
+}
+
+// GetDirectoryStats returns the total size, file count and newest
+// modification time of the indexed files below a directory. Directories
+// with no indexed files report zeros.
+func (idx *Indexer) GetDirectoryStats(path string) (*models.DirectoryStats, error) {
+	path = filepath.Clean(path)
+	var stats models.DirectoryStats
+	err := storage.DB().Where("path = ?", path).First(&stats).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.DirectoryStats{Path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load directory stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// RecomputeDirectoryStats rebuilds all directory aggregates from the index
+func (idx *Indexer) RecomputeDirectoryStats() error {
+	db := storage.DB()
+	totals := make(map[string]*models.DirectoryStats)
+
+	var batch []models.SearchIndex
+	result := db.Select("id", "path", "file_size", "modified_time").Where("is_directory = ?", false).
+		FindInBatches(&batch, integrityBatch, func(tx *gorm.DB, _ int) error {
+			for _, entry := range batch {
+				for dir := filepath.Dir(entry.Path); ; dir = filepath.Dir(dir) {
+					stats := totals[dir]
+					if stats == nil {
+						stats = &models.DirectoryStats{Path: dir}
+						totals[dir] = stats
+					}
+					stats.TotalSize += entry.FileSize
+					stats.FileCount++
+					if entry.ModifiedTime.After(stats.NewestModTime) {
+						stats.NewestModTime = entry.ModifiedTime
+					}
+					if parent := filepath.Dir(dir); parent == dir {
+						break
+					}
+				}
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to load index: %w", result.Error)
+	}
+
+	rows := make([]*models.DirectoryStats, 0, len(totals))
+	for _, stats := range totals {
+		rows = append(rows, stats)
+	}
+
+	dirStatsMu.Lock()
+	defer dirStatsMu.Unlock()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.DirectoryStats{}).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(rows, integrityBatch).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save directory stats: %w", err)
+	}
+	return nil
+}
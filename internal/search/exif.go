@@ -0,0 +1,131 @@
+package search
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxExifScan bounds how much of a file is read looking for EXIF data
+const maxExifScan = 256 * 1024
+
+// EXIF tags in IFD0
+const (
+	exifMake  = 0x010f
+	exifModel = 0x0110
+)
+
+// exifCamera returns the camera make and model recorded in a JPEG or
+// TIFF-based (including most raw formats) image, or "" if there is none
+func exifCamera(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxExifScan))
+	if err != nil {
+		return ""
+	}
+
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		tiff = jpegExif(data)
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		tiff = data
+	}
+	if tiff == nil {
+		return ""
+	}
+
+	fields := tiffASCII(tiff, exifMake, exifModel)
+	maker, model := fields[exifMake], fields[exifModel]
+	// Models often repeat the make ("Canon" "Canon EOS R5")
+	if maker != "" && strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		return model
+	}
+	return strings.TrimSpace(maker + " " + model)
+}
+
+// jpegExif returns the TIFF structure in a JPEG's APP1 Exif segment
+func jpegExif(data []byte) []byte {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xff {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xd9 || marker == 0xda { // End of image, start of scan
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		pos += 2 + length
+	}
+	return nil
+}
+
+// tiffASCII reads ASCII fields with the given tags from IFD0 of a TIFF
+// structure
+func tiffASCII(tiff []byte, tags ...uint16) map[uint16]string {
+	fields := make(map[uint16]string)
+	if len(tiff) < 8 {
+		return fields
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return fields
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return fields
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			break
+		}
+		tag := order.Uint16(tiff[entry:])
+		if order.Uint16(tiff[entry+2:]) != 2 { // ASCII
+			continue
+		}
+		wanted := false
+		for _, t := range tags {
+			wanted = wanted || t == tag
+		}
+		if !wanted {
+			continue
+		}
+
+		n := int(order.Uint32(tiff[entry+4:]))
+		value := tiff[entry+8 : entry+12]
+		if n > 4 {
+			offset := int(order.Uint32(tiff[entry+8:]))
+			if offset < 0 || n > len(tiff) || offset > len(tiff)-n {
+				continue
+			}
+			value = tiff[offset : offset+n]
+		} else {
+			value = value[:n]
+		}
+		fields[tag] = strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+	}
+	return fields
+}
@@ -0,0 +1,258 @@
+package search
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	exportFormat  = "sfm-search-index"
+	exportVersion = 1
+
+	// maxExportLine bounds one entry, which holds up to max_content_size of
+	// text plus its terms
+	maxExportLine = 64 * 1024 * 1024
+	importBatch   = 500
+)
+
+var ErrInvalidExport = errors.New("not a search index export")
+
+// exportHeader is the first line of an export
+type exportHeader struct {
+	Format     string         `json:"format"`
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Roots      []exportedRoot `json:"roots"`
+}
+
+type exportedRoot struct {
+	Path     string `json:"path"`
+	Analyzer string `json:"analyzer"`
+}
+
+// exportEntry is one indexed file. Paths under a root are stored relative
+// to it with forward slashes so they can be moved to another root.
+type exportEntry struct {
+	Root           int       `json:"root"` // Index into Roots, -1 for an absolute path
+	Path           string    `json:"path"`
+	FileSize       int64     `json:"file_size"`
+	ModifiedTime   time.Time `json:"modified_time"`
+	IsDirectory    bool      `json:"is_directory,omitempty"`
+	Content        string    `json:"content,omitempty"`
+	ContentIndexed bool      `json:"content_indexed,omitempty"`
+	Terms          string    `json:"terms,omitempty"`
+}
+
+// RebuildIndex drops the whole index and indexes every known root again
+func (idx *Indexer) RebuildIndex() error {
+	db := storage.DB()
+
+	var roots []models.SearchRoot
+	if err := db.Find(&roots).Error; err != nil {
+		return fmt.Errorf("failed to load index roots: %w", err)
+	}
+	if err := db.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(&models.SearchIndex{}).Error; err != nil {
+		return fmt.Errorf("failed to clear index: %w", err)
+	}
+	if err := db.Where("1 = 1").Delete(&models.FileTag{}).Error; err != nil {
+		return fmt.Errorf("failed to clear tags: %w", err)
+	}
+	if err := idx.RecomputeDirectoryStats(); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		if _, err := os.Stat(root.Path); os.IsNotExist(err) {
+			log.Printf("Index root %s no longer exists, dropping it", logging.Path(root.Path))
+			db.Delete(&root)
+			continue
+		}
+		if err := idx.IndexDirectory(root.Path); err != nil {
+			return fmt.Errorf("failed to index %s: %w", root.Path, err)
+		}
+	}
+	return nil
+}
+
+// Export writes the index, including extracted text, to w as gzipped JSON
+// lines. Treat the file like the documents it was built from.
+func (idx *Indexer) Export(w io.Writer) error {
+	db := storage.DB()
+
+	var roots []models.SearchRoot
+	if err := db.Order("path").Find(&roots).Error; err != nil {
+		return fmt.Errorf("failed to load index roots: %w", err)
+	}
+
+	gz := gzip.NewWriter(w)
+	encoder := json.NewEncoder(gz)
+
+	header := exportHeader{Format: exportFormat, Version: exportVersion, ExportedAt: time.Now().UTC()}
+	for _, root := range roots {
+		header.Roots = append(header.Roots, exportedRoot{Path: root.Path, Analyzer: root.Analyzer})
+	}
+	if err := encoder.Encode(header); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	var batch []models.SearchIndex
+	result := db.Order("id").FindInBatches(&batch, importBatch, func(tx *gorm.DB, _ int) error {
+		for _, entry := range batch {
+			root, rel := exportPath(roots, entry.Path)
+			err := encoder.Encode(exportEntry{
+				Root:           root,
+				Path:           rel,
+				FileSize:       entry.FileSize,
+				ModifiedTime:   entry.ModifiedTime,
+				IsDirectory:    entry.IsDirectory,
+				Content:        entry.Content,
+				ContentIndexed: entry.ContentIndexed,
+				Terms:          entry.Terms,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to write export: %w", result.Error)
+	}
+
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// exportPath finds the longest root containing p and returns its index and
+// p relative to it
+func exportPath(roots []models.SearchRoot, p string) (int, string) {
+	best := -1
+	for i, root := range roots {
+		if p != root.Path && !strings.HasPrefix(p, root.Path+string(filepath.Separator)) {
+			continue
+		}
+		if best < 0 || len(root.Path) > len(roots[best].Path) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return -1, p
+	}
+	rel, _ := filepath.Rel(roots[best].Path, p)
+	return best, filepath.ToSlash(rel)
+}
+
+// Import merges an export into the index and returns the number of entries
+// imported. roots maps exported root directories to local ones; roots not
+// in the map keep their exported path. Entries replace local entries for
+// the same path, and a later index run only re-reads files that differ.
+func (idx *Indexer) Import(r io.Reader, roots map[string]string) (int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, ErrInvalidExport
+	}
+	defer gz.Close()
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxExportLine)
+
+	var header exportHeader
+	if !scanner.Scan() || json.Unmarshal(scanner.Bytes(), &header) != nil || header.Format != exportFormat {
+		return 0, ErrInvalidExport
+	}
+	if header.Version > exportVersion {
+		return 0, fmt.Errorf("unsupported export version %d", header.Version)
+	}
+
+	localRoots := make([]string, len(header.Roots))
+	for i, root := range header.Roots {
+		localRoots[i] = root.Path
+		if local, ok := roots[root.Path]; ok {
+			localRoots[i] = filepath.Clean(local)
+		}
+	}
+
+	db := storage.DB()
+	imported := 0
+	var batch []models.SearchIndex
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"file_name", "file_size", "modified_time", "is_directory", "content", "content_indexed", "terms", "updated_at", "deleted_at"}),
+		}).Create(&batch).Error
+		if err != nil {
+			return fmt.Errorf("failed to import entries: %w", err)
+		}
+		imported += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for scanner.Scan() {
+		var entry exportEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return imported, fmt.Errorf("%w: %v", ErrInvalidExport, err)
+		}
+
+		localPath := entry.Path
+		if entry.Root >= 0 {
+			if entry.Root >= len(localRoots) || !filepath.IsLocal(filepath.FromSlash(entry.Path)) {
+				return imported, fmt.Errorf("%w: bad path %q", ErrInvalidExport, entry.Path)
+			}
+			localPath = filepath.Join(localRoots[entry.Root], filepath.FromSlash(entry.Path))
+		}
+
+		batch = append(batch, models.SearchIndex{
+			Path:           localPath,
+			FileName:       filepath.Base(localPath),
+			FileSize:       entry.FileSize,
+			ModifiedTime:   entry.ModifiedTime,
+			IsDirectory:    entry.IsDirectory,
+			Content:        entry.Content,
+			ContentIndexed: entry.ContentIndexed,
+			Terms:          entry.Terms,
+		})
+		if len(batch) >= importBatch {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imported, fmt.Errorf("failed to read export: %w", err)
+	}
+	if err := flush(); err != nil {
+		return imported, err
+	}
+
+	// Record the analyzer the imported terms were built with; indexing a
+	// root with a different analyzer rebuilds them
+	for i, root := range header.Roots {
+		record := models.SearchRoot{Path: localRoots[i]}
+		err := db.Where("path = ?", localRoots[i]).Assign(models.SearchRoot{Analyzer: root.Analyzer}).FirstOrCreate(&record).Error
+		if err != nil {
+			return imported, fmt.Errorf("failed to save index root: %w", err)
+		}
+	}
+	return imported, idx.RecomputeDirectoryStats()
+}
@@ -0,0 +1,126 @@
+package search
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedFormat is returned for files no extractor understands
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// Plain text formats indexed as-is
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".csv": true, ".log": true, ".json": true,
+	".xml": true, ".html": true, ".htm": true, ".yaml": true, ".yml": true,
+}
+
+// CanExtract reports whether ExtractText handles a file name
+func CanExtract(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if textExtensions[ext] {
+		return true
+	}
+	_, ok := extractors[ext]
+	return ok
+}
+
+var extractors = map[string]func(path string, limit int) (string, error){
+	".pdf":  extractPDF,
+	".docx": extractDocx,
+	".xlsx": extractXlsx,
+	".pptx": extractPptx,
+	".odt":  extractOpenDocument,
+	".ods":  extractOpenDocument,
+	".odp":  extractOpenDocument,
+}
+
+// ExtractText returns the searchable text of a file, at most limit bytes
+func ExtractText(path string, limit int) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if textExtensions[ext] {
+		return extractPlain(path, limit)
+	}
+	extract, ok := extractors[ext]
+	if !ok {
+		return "", ErrUnsupportedFormat
+	}
+	text, err := extract(path, limit)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", filepath.Base(path), err)
+	}
+	return text, nil
+}
+
+func extractPlain(path string, limit int) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data) {
+		return "", ErrUnsupportedFormat
+	}
+	return truncateText(string(data), limit), nil
+}
+
+// textBuilder collects extracted text, collapsing runs of whitespace and
+// stopping at a size limit
+type textBuilder struct {
+	buf   []byte
+	limit int
+}
+
+func (b *textBuilder) full() bool {
+	return len(b.buf) >= b.limit
+}
+
+func (b *textBuilder) WriteString(s string) {
+	for _, r := range s {
+		if b.full() {
+			return
+		}
+		switch r {
+		case '\n':
+			b.newline()
+		case ' ', '\t', '\r', '\f', 0xa0:
+			b.space()
+		default:
+			if r >= ' ' {
+				b.buf = utf8.AppendRune(b.buf, r)
+			}
+		}
+	}
+}
+
+func (b *textBuilder) space() {
+	if n := len(b.buf); n > 0 && b.buf[n-1] != ' ' && b.buf[n-1] != '\n' {
+		b.buf = append(b.buf, ' ')
+	}
+}
+
+func (b *textBuilder) newline() {
+	b.buf = bytes.TrimRight(b.buf, " ")
+	if n := len(b.buf); n > 0 && b.buf[n-1] != '\n' {
+		b.buf = append(b.buf, '\n')
+	}
+}
+
+func (b *textBuilder) String() string {
+	return truncateText(strings.TrimSpace(string(b.buf)), b.limit)
+}
+
+// truncateText cuts s to at most limit bytes without splitting a rune
+func truncateText(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	s = s[:limit]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
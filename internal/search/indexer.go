@@ -1,51 +1,140 @@
 package search
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 
+	"github.com/owner/secure-file-manager/internal/cache"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/power"
+	"github.com/owner/secure-file-manager/internal/sched"
 	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/internal/telemetry"
 	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
 )
 
 type Indexer struct {
-	maxWorkers int
-	mu         sync.Mutex
+	maxWorkers     int
+	maxContentSize int64 // 0 disables content indexing
+	ocr            *OCRQueue
+	cache          *cache.Cache
+	analyzers      map[string]*Analyzer // Root directory -> analyzer; "" is the default
+	tagRules       []TagRule
+	mu             sync.Mutex
 }
 
 func NewIndexer(maxWorkers int) *Indexer {
 	return &Indexer{
 		maxWorkers: maxWorkers,
+		analyzers:  make(map[string]*Analyzer),
 	}
 }
 
-// IndexDirectory indexes all files in a directory
-func (idx *Indexer) IndexDirectory(rootPath string) error {
+// SetContentIndexing enables full-text indexing of files up to maxSize
+// bytes, from the search.index_content and search.max_content_size settings
+func (idx *Indexer) SetContentIndexing(enabled bool, maxSize int64) {
+	if !enabled {
+		maxSize = 0
+	}
+	idx.maxContentSize = maxSize
+}
+
+// SetOCR sends images and PDFs without a text layer to q. Content
+// indexing must be enabled.
+func (idx *Indexer) SetOCR(q *OCRQueue) {
+	idx.ocr = q
+}
+
+// SetCache drops derived data cached for files that change or leave the
+// index
+func (idx *Indexer) SetCache(c *cache.Cache) {
+	idx.cache = c
+}
+
+// invalidate drops derived data cached for path
+func (idx *Indexer) invalidate(path string) {
+	if idx.cache == nil {
+		return
+	}
+	if err := idx.cache.Invalidate(path); err != nil {
+		log.Printf("Failed to invalidate cache for %s: %v", logging.Path(path), err)
+	}
+}
+
+// SetAnalyzer sets the analyzer for documents indexed from rootPath, or
+// the default for directories without their own when rootPath is empty.
+// Changing a directory's analyzer reindexes it on its next index run.
+func (idx *Indexer) SetAnalyzer(rootPath string, a *Analyzer) {
+	if rootPath != "" {
+		rootPath = filepath.Clean(rootPath)
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.analyzers[rootPath] = a
+}
+
+func (idx *Indexer) analyzerFor(rootPath string) *Analyzer {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if a, ok := idx.analyzers[filepath.Clean(rootPath)]; ok {
+		return a
+	}
+	if a, ok := idx.analyzers[""]; ok {
+		return a
+	}
+	a, _ := NewAnalyzer(DefaultAnalyzerConfig)
+	return a
+}
+
+// IndexDirectory indexes all files in a directory, once the machine is
+// plugged in and idle unless indexing is ungated
+func (idx *Indexer) IndexDirectory(rootPath string) (err error) {
+	if err := power.Wait(context.Background(), power.JobIndexing); err != nil {
+		return err
+	}
+
+	_, span := telemetry.StartSpan(context.Background(), "search.index")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	analyzer := idx.analyzerFor(rootPath)
+	if err := idx.checkAnalyzer(rootPath, analyzer); err != nil {
+		return err
+	}
+
 	type fileInfo struct {
-		path    string
-		info    os.FileInfo
-		relPath string
+		path string
+		info os.FileInfo
 	}
 
 	fileChan := make(chan fileInfo, 100)
 	errChan := make(chan error, 1)
 	var wg sync.WaitGroup
+	var indexed int64
+	dirs := newDirStats()
 
 	// Start workers
 	for i := 0; i < idx.maxWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			sched.Enter(sched.JobIndexing)
 			for fi := range fileChan {
-				if err := idx.indexFile(fi.path, fi.info, fi.relPath); err != nil {
+				if err := idx.indexFile(fi.path, fi.info, dirs, analyzer); err != nil {
 					select {
 					case errChan <- err:
 					default:
 					}
 					return
 				}
+				atomic.AddInt64(&indexed, 1)
 			}
 		}()
 	}
@@ -58,8 +147,7 @@ func (idx *Indexer) IndexDirectory(rootPath string) error {
 				return err
 			}
 
-			relPath, _ := filepath.Rel(rootPath, path)
-			fileChan <- fileInfo{path, info, relPath}
+			fileChan <- fileInfo{path, info}
 			return nil
 		})
 		close(fileChan)
@@ -67,16 +155,19 @@ func (idx *Indexer) IndexDirectory(rootPath string) error {
 
 	// Wait for workers
 	wg.Wait()
+	span.SetAttributes(telemetry.AttrFiles.Int64(indexed))
 
 	select {
 	case err := <-errChan:
+		dirs.apply()
 		return err
 	default:
-		return nil
+		return dirs.apply()
 	}
 }
 
-func (idx *Indexer) indexFile(path string, info os.FileInfo, relPath string) error {
+// indexFile indexes one file, recording size changes in dirs
+func (idx *Indexer) indexFile(path string, info os.FileInfo, dirs *dirStats, analyzer *Analyzer) error {
 	db := storage.DB()
 
 	searchIndex := models.SearchIndex{
@@ -93,43 +184,186 @@ func (idx *Indexer) indexFile(path string, info os.FileInfo, relPath string) err
 		return fmt.Errorf("failed to index file %s: %w", path, result.Error)
 	}
 
+	created := result.RowsAffected > 0
+	modified := !created && (searchIndex.FileSize != info.Size() || !searchIndex.ModifiedTime.Equal(info.ModTime()))
+
+	switch {
+	case created:
+		dirs.added(&searchIndex)
+	case modified && !info.IsDir():
+		dirs.add(path, info.Size()-searchIndex.FileSize, 0, info.ModTime(), searchIndex.ModifiedTime)
+		idx.invalidate(path)
+	}
+
 	// Update if modified
-	if result.RowsAffected == 0 {
+	if !created {
 		db.Model(&searchIndex).Updates(map[string]interface{}{
 			"file_size":     info.Size(),
 			"modified_time": info.ModTime(),
 		})
 	}
 
+	if (created || modified) && !info.IsDir() {
+		if _, err := idx.applyTags(path, info); err != nil {
+			return err
+		}
+	}
+
+	if !idx.indexesContent(info) {
+		// A changed file that is no longer indexed drops its stale text
+		if modified && searchIndex.Content != "" {
+			return saveContent(&searchIndex, "", false, analyzer)
+		}
+		return nil
+	}
+	if !created && !modified && searchIndex.ContentIndexed {
+		return nil
+	}
+
+	// The hash lets CheckIntegrity catch content changed behind an
+	// unchanged size and modification time
+	if hash, err := hashFile(path); err == nil {
+		if searchIndex.ContentHash != "" && hash != searchIndex.ContentHash && !modified {
+			idx.invalidate(path)
+		}
+		db.Model(&searchIndex).Update("content_hash", hash)
+	}
+
+	content, err := ExtractText(path, int(idx.maxContentSize))
+	if err != nil && !errors.Is(err, ErrUnsupportedFormat) {
+		log.Printf("Skipping content of %s: %v", logging.Path(path), err)
+	}
+	// Images and scanned PDFs have no text layer; OCR fills it in later and
+	// marks the entry indexed
+	if content == "" && idx.ocr != nil && idx.ocr.Accepts(path) {
+		idx.ocr.Enqueue(path, info.ModTime(), int(idx.maxContentSize), analyzer)
+		return saveContent(&searchIndex, "", false, analyzer)
+	}
+	return saveContent(&searchIndex, content, true, analyzer)
+}
+
+func (idx *Indexer) indexesContent(info os.FileInfo) bool {
+	if idx.maxContentSize <= 0 || info.IsDir() || info.Size() > idx.maxContentSize {
+		return false
+	}
+	return CanExtract(info.Name()) || (idx.ocr != nil && idx.ocr.Accepts(info.Name()))
+}
+
+func saveContent(searchIndex *models.SearchIndex, content string, indexed bool, analyzer *Analyzer) error {
+	err := storage.DB().Model(searchIndex).Updates(map[string]interface{}{
+		"content":         content,
+		"content_indexed": indexed,
+		"terms":           analyzer.Terms(content),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to index content of %s: %w", searchIndex.Path, err)
+	}
 	return nil
 }
 
-// RemoveFromIndex removes a file from the index
-func (idx *Indexer) RemoveFromIndex(path string) error {
+// checkAnalyzer reindexes rootPath if its terms were built with a
+// different analyzer
+func (idx *Indexer) checkAnalyzer(rootPath string, analyzer *Analyzer) error {
+	var root models.SearchRoot
+	err := storage.DB().Where("path = ?", filepath.Clean(rootPath)).First(&root).Error
+	if err == nil && root.Analyzer == analyzer.Spec() {
+		return nil
+	}
+	if err == nil {
+		log.Printf("Analyzer for %s changed from %s to %s, reindexing", logging.Path(rootPath), root.Analyzer, analyzer.Spec())
+	}
+	return idx.Reindex(rootPath)
+}
+
+// Reindex rebuilds the terms of everything indexed under rootPath with its
+// current analyzer. Stored text is reused, so files are not read again.
+func (idx *Indexer) Reindex(rootPath string) error {
+	rootPath = filepath.Clean(rootPath)
+	analyzer := idx.analyzerFor(rootPath)
 	db := storage.DB()
-	return db.Where("path = ?", path).Delete(&models.SearchIndex{}).Error
+
+	var batch []models.SearchIndex
+	result := underRoot(db, rootPath).Select("id", "content").FindInBatches(&batch, 100, func(tx *gorm.DB, _ int) error {
+		for _, entry := range batch {
+			if err := db.Model(&entry).Update("terms", analyzer.Terms(entry.Content)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to reindex %s: %w", rootPath, result.Error)
+	}
+
+	root := models.SearchRoot{Path: rootPath}
+	err := db.Where("path = ?", rootPath).Assign(models.SearchRoot{Analyzer: analyzer.Spec()}).FirstOrCreate(&root).Error
+	if err != nil {
+		return fmt.Errorf("failed to save analyzer for %s: %w", rootPath, err)
+	}
+	return nil
 }
 
-// UpdateIndex incrementally updates the index
-func (idx *Indexer) UpdateIndex(rootPath string) error {
+// underRoot restricts a query to entries at or below rootPath
+func underRoot(db *gorm.DB, rootPath string) *gorm.DB {
+	prefix := rootPath
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	prefix = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix)
+	return db.Where(`(path = ? OR path LIKE ? ESCAPE '\')`, rootPath, prefix+"%")
+}
+
+// RemoveFromIndex removes a file, or a directory and everything below it,
+// from the index. Rows are deleted for good so the path can be indexed
+// again.
+func (idx *Indexer) RemoveFromIndex(path string) error {
 	db := storage.DB()
 
-	// Get all indexed files
-	var indexed []models.SearchIndex
-	if err := db.Find(&indexed).Error; err != nil {
+	var entry models.SearchIndex
+	if err := db.Where("path = ?", path).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
 		return err
 	}
 
-	existingFiles := make(map[string]bool)
-	for _, item := range indexed {
-		existingFiles[item.Path] = true
+	dirs := newDirStats()
+	dirs.removed(&entry)
+	if entry.IsDirectory {
+		var batch []models.SearchIndex
+		result := underRoot(db, filepath.Clean(path)).Where("path <> ?", path).
+			Select("id", "path", "file_size", "modified_time", "is_directory").
+			FindInBatches(&batch, integrityBatch, func(tx *gorm.DB, _ int) error {
+				for i := range batch {
+					dirs.removed(&batch[i])
+				}
+				return nil
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := underRoot(db.Unscoped(), filepath.Clean(path)).Delete(&models.SearchIndex{}).Error; err != nil {
+			return err
+		}
 	}
+	if err := db.Unscoped().Delete(&entry).Error; err != nil {
+		return err
+	}
+	if err := removeTags(db, path); err != nil {
+		return err
+	}
+	idx.invalidate(path)
+	return dirs.apply()
+}
 
-	// Check for deleted files
-	for path := range existingFiles {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			idx.RemoveFromIndex(path)
-		}
+// UpdateIndex incrementally updates the index
+func (idx *Indexer) UpdateIndex(rootPath string) error {
+	rootPath = filepath.Clean(rootPath)
+
+	// Drop deleted files
+	stats := &IntegrityStats{}
+	if err := idx.checkEntries(context.Background(), underRoot(storage.DB(), rootPath), nil, false, stats); err != nil {
+		return err
 	}
 
 	// Index new/modified files
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+const integrityBatch = 500
+
+// IntegrityStats summarizes an index check
+type IntegrityStats struct {
+	Checked    int
+	Missing    int // Entries for files that no longer exist, removed
+	Duplicates int // Entries for an already indexed path spelled differently, removed
+	Renamed    int // Entries whose path was normalized
+	Stale      int // Entries whose file hash changed, reindexed
+	Purged     int // Soft-deleted entries removed for good
+}
+
+func (s IntegrityStats) String() string {
+	return fmt.Sprintf("%d checked, %d missing, %d duplicates, %d renamed, %d stale, %d purged",
+		s.Checked, s.Missing, s.Duplicates, s.Renamed, s.Stale, s.Purged)
+}
+
+// CheckIntegrity repairs the index: it removes entries for vanished files
+// and duplicate spellings of the same path, and purges soft-deleted rows.
+// With verifyHashes it also re-hashes files with a stored ContentHash and
+// reindexes those whose content changed without a new size or
+// modification time.
+func (idx *Indexer) CheckIntegrity(ctx context.Context, verifyHashes bool) (*IntegrityStats, error) {
+	db := storage.DB()
+	stats := &IntegrityStats{}
+
+	// Soft-deleted rows still hold their path in the unique index
+	result := db.Unscoped().Where("deleted_at IS NOT NULL").Delete(&models.SearchIndex{})
+	if result.Error != nil {
+		return stats, fmt.Errorf("failed to purge deleted entries: %w", result.Error)
+	}
+	stats.Purged = int(result.RowsAffected)
+
+	var roots []models.SearchRoot
+	if err := db.Find(&roots).Error; err != nil {
+		return stats, fmt.Errorf("failed to load index roots: %w", err)
+	}
+
+	if err := idx.checkEntries(ctx, db, roots, verifyHashes, stats); err != nil {
+		return stats, err
+	}
+	log.Printf("Search index check: %s", stats)
+	return stats, nil
+}
+
+// checkEntries checks every entry matched by query in batches
+func (idx *Indexer) checkEntries(ctx context.Context, query *gorm.DB, roots []models.SearchRoot, verifyHashes bool, stats *IntegrityStats) error {
+	db := storage.DB()
+
+	var batch []models.SearchIndex
+	result := query.Order("id").FindInBatches(&batch, integrityBatch, func(tx *gorm.DB, _ int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var remove []uint
+		dirs := newDirStats()
+		for _, entry := range batch {
+			stats.Checked++
+
+			info, err := os.Lstat(entry.Path)
+			if os.IsNotExist(err) {
+				remove = append(remove, entry.ID)
+				dirs.removed(&entry)
+				idx.invalidate(entry.Path)
+				if err := removeTags(db, entry.Path); err != nil {
+					return err
+				}
+				stats.Missing++
+				continue
+			}
+			if err != nil {
+				continue
+			}
+
+			if clean := filepath.Clean(entry.Path); clean != entry.Path {
+				var count int64
+				if err := db.Model(&models.SearchIndex{}).Where("path = ?", clean).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					remove = append(remove, entry.ID)
+					dirs.removed(&entry)
+					stats.Duplicates++
+					continue
+				}
+				if err := db.Model(&entry).Update("path", clean).Error; err != nil {
+					return err
+				}
+				entry.Path = clean
+				stats.Renamed++
+			}
+
+			if !verifyHashes || entry.ContentHash == "" || info.IsDir() {
+				continue
+			}
+			hash, err := hashFile(entry.Path)
+			if err != nil || hash == entry.ContentHash {
+				continue
+			}
+			if err := db.Model(&entry).Update("content_indexed", false).Error; err != nil {
+				return err
+			}
+			root, _ := exportPath(roots, entry.Path)
+			analyzer := idx.analyzerFor("")
+			if root >= 0 {
+				analyzer = idx.analyzerFor(roots[root].Path)
+			}
+			if err := idx.indexFile(entry.Path, info, dirs, analyzer); err != nil {
+				return err
+			}
+			stats.Stale++
+		}
+
+		if len(remove) > 0 {
+			if err := db.Unscoped().Delete(&models.SearchIndex{}, remove).Error; err != nil {
+				return err
+			}
+		}
+		return dirs.apply()
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to check index: %w", result.Error)
+	}
+	return nil
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
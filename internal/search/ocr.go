@@ -0,0 +1,240 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/cache"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/power"
+	"github.com/owner/secure-file-manager/internal/sched"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// ocrQueueSize bounds pending OCR jobs. Files that do not fit are picked
+// up again by the next index update.
+const ocrQueueSize = 1024
+
+// Image formats tesseract reads
+var ocrExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".tif": true, ".tiff": true,
+	".bmp": true, ".gif": true, ".webp": true,
+}
+
+type ocrJob struct {
+	path     string
+	modTime  time.Time
+	limit    int
+	analyzer *Analyzer
+}
+
+// OCRQueue recognizes text in images and scanned PDFs with an external OCR
+// command (tesseract by default) in the background, so slow OCR never
+// holds up indexing
+type OCRQueue struct {
+	command   string
+	languages string
+	timeout   time.Duration
+	jobs      chan ocrJob
+	cache     *cache.Cache
+	mu        sync.Mutex
+	pending   map[string]bool
+}
+
+// NewOCRQueue creates a queue running command with the given tesseract
+// language codes (e.g. "eng", "deu"), each file limited to timeout
+func NewOCRQueue(command string, languages []string, timeout time.Duration) (*OCRQueue, error) {
+	if command == "" {
+		command = "tesseract"
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find OCR command: %w", err)
+	}
+	if len(languages) == 0 {
+		languages = []string{"eng"}
+	}
+	return &OCRQueue{
+		command:   path,
+		languages: strings.Join(languages, "+"),
+		timeout:   timeout,
+		jobs:      make(chan ocrJob, ocrQueueSize),
+		pending:   make(map[string]bool),
+	}, nil
+}
+
+// SetCache keeps recognized text in c, so a reindex does not run OCR again
+// on unchanged files
+func (q *OCRQueue) SetCache(c *cache.Cache) {
+	q.cache = c
+}
+
+// Accepts reports whether OCR can read a file
+func (q *OCRQueue) Accepts(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ocrExtensions[ext] || ext == ".pdf"
+}
+
+// Enqueue schedules a file for OCR unless it is already pending. It never
+// blocks; a full queue drops the file.
+func (q *OCRQueue) Enqueue(path string, modTime time.Time, limit int, analyzer *Analyzer) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending[path] {
+		return
+	}
+	select {
+	case q.jobs <- ocrJob{path: path, modTime: modTime, limit: limit, analyzer: analyzer}:
+		q.pending[path] = true
+	default:
+	}
+}
+
+// Pending returns the number of files waiting for OCR
+func (q *OCRQueue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Start runs workers OCR workers until ctx is done
+func (q *OCRQueue) Start(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// On Linux, OCR commands inherit the worker thread's priority
+			sched.Enter(sched.JobIndexing)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-q.jobs:
+					if err := power.Wait(ctx, power.JobOCR); err != nil {
+						return
+					}
+					if err := q.process(ctx, job); err != nil {
+						log.Printf("OCR of %s failed: %v", logging.Path(job.path), err)
+					}
+					q.mu.Lock()
+					delete(q.pending, job.path)
+					q.mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *OCRQueue) process(ctx context.Context, job ocrJob) error {
+	// Skip files changed since they were queued; the next index update
+	// queues them again
+	info, err := os.Stat(job.path)
+	if err != nil || !info.ModTime().Equal(job.modTime) {
+		return nil
+	}
+
+	text, err := q.cachedText(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	var index models.SearchIndex
+	db := storage.DB()
+	if err := db.Where("path = ?", job.path).First(&index).Error; err != nil {
+		return fmt.Errorf("failed to load index entry: %w", err)
+	}
+	if !index.ModifiedTime.Equal(job.modTime) {
+		return nil
+	}
+	return saveContent(&index, text, true, job.analyzer)
+}
+
+// cachedText returns the text of a file from the cache, or recognizes it
+// and caches the result
+func (q *OCRQueue) cachedText(ctx context.Context, job ocrJob) (string, error) {
+	if q.cache == nil {
+		return q.text(ctx, job)
+	}
+
+	kind := "ocr-" + q.languages
+	hash, err := hashFile(job.path)
+	if err != nil {
+		return "", err
+	}
+	if data, ok := q.cache.Get(kind, job.path, hash); ok {
+		return string(data), nil
+	}
+
+	text, err := q.text(ctx, job)
+	if err != nil {
+		return "", err
+	}
+	if err := q.cache.Put(kind, job.path, hash, []byte(text)); err != nil {
+		log.Printf("Failed to cache OCR text of %s: %v", logging.Path(job.path), err)
+	}
+	return text, nil
+}
+
+// text recognizes the text of an image, or of the page images of a PDF
+func (q *OCRQueue) text(ctx context.Context, job ocrJob) (string, error) {
+	var images []string
+	if strings.EqualFold(filepath.Ext(job.path), ".pdf") {
+		dir, err := os.MkdirTemp("", "sfm-ocr-*")
+		if err != nil {
+			return "", fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		defer os.RemoveAll(dir)
+		if images, err = pdfPageImages(job.path, dir); err != nil {
+			return "", err
+		}
+	} else {
+		images = []string{job.path}
+	}
+
+	b := &textBuilder{limit: job.limit}
+	for _, image := range images {
+		if b.full() {
+			break
+		}
+		text, err := q.recognize(ctx, image)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(text)
+		b.newline()
+	}
+	return b.String(), nil
+}
+
+// recognize runs the OCR command on one image and returns its text
+func (q *OCRQueue) recognize(ctx context.Context, image string) (string, error) {
+	if q.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, q.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, q.command, image, "stdout", "-l", q.languages)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return "", fmt.Errorf("timed out after %s", q.timeout)
+		}
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
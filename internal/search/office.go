@@ -0,0 +1,159 @@
+package search
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// maxPartSize bounds how much of one archive member is decompressed, so a
+// zip bomb cannot exhaust memory
+const maxPartSize = 64 * 1024 * 1024
+
+// xmlTextRules tells extractXMLText which elements of a document format
+// carry text, matched by local name
+type xmlTextRules struct {
+	text   map[string]bool // Character data anywhere inside is text
+	breaks map[string]bool // Closing one ends a line
+	spaces map[string]bool // Stands for a space or tab
+}
+
+var (
+	// WordprocessingML and DrawingML (docx, pptx)
+	ooxmlRules = xmlTextRules{
+		text:   map[string]bool{"t": true},
+		breaks: map[string]bool{"p": true, "br": true, "cr": true},
+		spaces: map[string]bool{"tab": true},
+	}
+	// SpreadsheetML shared strings and inline strings (xlsx)
+	sheetRules = xmlTextRules{
+		text:   map[string]bool{"si": true, "is": true},
+		breaks: map[string]bool{"si": true, "is": true},
+	}
+	// OpenDocument text, spreadsheets and presentations
+	odfRules = xmlTextRules{
+		text:   map[string]bool{"p": true, "h": true},
+		breaks: map[string]bool{"p": true, "h": true, "line-break": true},
+		spaces: map[string]bool{"s": true, "tab": true},
+	}
+)
+
+var (
+	docxParts = regexp.MustCompile(`^word/(document|header\d*|footer\d*|footnotes|endnotes)\.xml$`)
+	pptxParts = regexp.MustCompile(`^ppt/(slides/slide|notesSlides/notesSlide)\d+\.xml$`)
+	xlsxParts = regexp.MustCompile(`^xl/(sharedStrings|worksheets/sheet\d+)\.xml$`)
+	odfParts  = regexp.MustCompile(`^content\.xml$`)
+	partIndex = regexp.MustCompile(`\d+`)
+)
+
+func extractDocx(path string, limit int) (string, error) {
+	return extractZipXML(path, limit, docxParts, ooxmlRules)
+}
+
+func extractPptx(path string, limit int) (string, error) {
+	return extractZipXML(path, limit, pptxParts, ooxmlRules)
+}
+
+func extractXlsx(path string, limit int) (string, error) {
+	return extractZipXML(path, limit, xlsxParts, sheetRules)
+}
+
+func extractOpenDocument(path string, limit int) (string, error) {
+	return extractZipXML(path, limit, odfParts, odfRules)
+}
+
+// extractZipXML extracts the text of the archive members matching parts,
+// in name order with numbered parts (slide2 before slide10) in number order
+func extractZipXML(path string, limit int, parts *regexp.Regexp, rules xmlTextRules) (string, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	var members []*zip.File
+	for _, f := range archive.File {
+		if parts.MatchString(f.Name) {
+			members = append(members, f)
+		}
+	}
+	if len(members) == 0 {
+		return "", fmt.Errorf("no document content found")
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return partLess(members[i].Name, members[j].Name)
+	})
+
+	b := &textBuilder{limit: limit}
+	for _, f := range members {
+		if b.full() {
+			break
+		}
+		if err := extractMember(f, b, rules); err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		b.newline()
+	}
+	return b.String(), nil
+}
+
+func extractMember(f *zip.File, b *textBuilder, rules xmlTextRules) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return extractXMLText(io.LimitReader(rc, maxPartSize), b, rules)
+}
+
+// extractXMLText writes the text of an XML document to b
+func extractXMLText(r io.Reader, b *textBuilder, rules xmlTextRules) error {
+	decoder := xml.NewDecoder(r)
+	depth := 0 // Open text elements
+	for !b.full() {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if rules.text[t.Name.Local] {
+				depth++
+			}
+		case xml.EndElement:
+			if rules.text[t.Name.Local] && depth > 0 {
+				depth--
+			}
+			if rules.breaks[t.Name.Local] {
+				b.newline()
+			} else if rules.spaces[t.Name.Local] {
+				b.space()
+			}
+		case xml.CharData:
+			if depth > 0 {
+				b.WriteString(string(t))
+			}
+		}
+	}
+	return nil
+}
+
+// partLess orders archive member names, comparing their first number
+// numerically when the rest of the name matches
+func partLess(a, b string) bool {
+	locA, locB := partIndex.FindStringIndex(a), partIndex.FindStringIndex(b)
+	if locA != nil && locB != nil && a[:locA[0]] == b[:locB[0]] && a[locA[1]:] == b[locB[1]:] {
+		numA, _ := strconv.Atoi(a[locA[0]:locA[1]])
+		numB, _ := strconv.Atoi(b[locB[0]:locB[1]])
+		return numA < numB
+	}
+	return a < b
+}
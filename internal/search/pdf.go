@@ -0,0 +1,557 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// PDF text extraction reads the text layer only: content stream text
+// operators, decoded through each font's ToUnicode map where there is one.
+// Scanned pages without a text layer yield nothing.
+
+var errPDFEncrypted = errors.New("encrypted PDF")
+
+// maxPageDepth bounds page tree recursion in malformed files
+const maxPageDepth = 32
+
+type pdfName string
+
+type pdfRef struct {
+	num, gen int
+}
+
+type pdfDict map[pdfName]interface{}
+
+// pdfKeyword is a bare token such as an operator, true, false or null
+type pdfKeyword string
+
+type pdfObject struct {
+	value  interface{}
+	stream []byte // Raw, still encoded
+}
+
+type pdfDocument struct {
+	objects map[int]pdfObject
+	trailer pdfDict
+	fonts   map[pdfRef]*pdfFont
+}
+
+var (
+	objectHeader  = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	trailerHeader = regexp.MustCompile(`trailer\s*<<`)
+)
+
+func extractPDF(path string, limit int) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\n\f\r "), []byte("%PDF-")) {
+		return "", fmt.Errorf("not a PDF file")
+	}
+
+	doc := parsePDF(data)
+	if doc.trailer["Encrypt"] != nil {
+		return "", errPDFEncrypted
+	}
+
+	b := &textBuilder{limit: limit}
+	for _, page := range doc.pages() {
+		if b.full() {
+			break
+		}
+		doc.extractPage(page, b)
+		b.newline()
+	}
+	return b.String(), nil
+}
+
+// parsePDF loads every object in the file. Objects are found by scanning
+// rather than through the xref table, which survives damaged tables; a
+// later definition of an object replaces an earlier one, as incremental
+// updates expect.
+func parsePDF(data []byte) *pdfDocument {
+	doc := &pdfDocument{
+		objects: make(map[int]pdfObject),
+		trailer: pdfDict{},
+		fonts:   make(map[pdfRef]*pdfFont),
+	}
+
+	end := 0
+	for _, loc := range objectHeader.FindAllSubmatchIndex(data, -1) {
+		if loc[0] < end {
+			continue // Inside the previous object's stream
+		}
+		num, _ := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		lex := &pdfLexer{data: data, pos: loc[1]}
+		value, err := lex.parseValue()
+		if err != nil {
+			continue
+		}
+		object := pdfObject{value: value}
+		if dict, ok := value.(pdfDict); ok {
+			object.stream = lex.readStream(dict)
+			if dict["Type"] == pdfName("XRef") {
+				doc.mergeTrailer(dict)
+			}
+		}
+		doc.objects[num] = object
+		end = lex.pos
+	}
+
+	for _, idx := range trailerHeader.FindAllIndex(data, -1) {
+		lex := &pdfLexer{data: data, pos: idx[1] - 2}
+		if value, err := lex.parseValue(); err == nil {
+			if dict, ok := value.(pdfDict); ok {
+				doc.mergeTrailer(dict)
+			}
+		}
+	}
+
+	doc.loadObjectStreams()
+	return doc
+}
+
+func (doc *pdfDocument) mergeTrailer(dict pdfDict) {
+	for _, key := range []pdfName{"Root", "Encrypt"} {
+		if value, ok := dict[key]; ok {
+			doc.trailer[key] = value
+		}
+	}
+}
+
+// loadObjectStreams unpacks objects compressed into object streams. Objects
+// defined directly in the file take precedence.
+func (doc *pdfDocument) loadObjectStreams() {
+	for _, object := range doc.objects {
+		dict, ok := object.value.(pdfDict)
+		if !ok || dict["Type"] != pdfName("ObjStm") {
+			continue
+		}
+		data, err := doc.decodeStream(object)
+		if err != nil {
+			continue
+		}
+		count, _ := doc.resolve(dict["N"]).(float64)
+		first, _ := doc.resolve(dict["First"]).(float64)
+		if first < 0 || int(first) > len(data) {
+			continue
+		}
+
+		header := &pdfLexer{data: data[:int(first)]}
+		for i := 0; i < int(count); i++ {
+			num, err1 := header.parseValue()
+			offset, err2 := header.parseValue()
+			n, ok1 := num.(float64)
+			o, ok2 := offset.(float64)
+			if err1 != nil || err2 != nil || !ok1 || !ok2 {
+				break
+			}
+			if _, exists := doc.objects[int(n)]; exists {
+				continue
+			}
+			pos := int(first) + int(o)
+			if pos < 0 || pos >= len(data) {
+				continue
+			}
+			lex := &pdfLexer{data: data, pos: pos}
+			if value, err := lex.parseValue(); err == nil {
+				doc.objects[int(n)] = pdfObject{value: value}
+			}
+		}
+	}
+}
+
+// resolve follows indirect references
+func (doc *pdfDocument) resolve(value interface{}) interface{} {
+	for i := 0; i < 8; i++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = doc.objects[ref.num].value
+	}
+	return nil
+}
+
+func (doc *pdfDocument) dict(value interface{}) pdfDict {
+	dict, _ := doc.resolve(value).(pdfDict)
+	return dict
+}
+
+// pages returns page dictionaries in document order, with inherited
+// resources filled in. Files with an unreadable page tree fall back to
+// every page object in object number order.
+func (doc *pdfDocument) pages() []pdfDict {
+	var pages []pdfDict
+	root := doc.dict(doc.trailer["Root"])
+	if root != nil {
+		doc.walkPages(doc.dict(root["Pages"]), nil, 0, &pages)
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	nums := make([]int, 0, len(doc.objects))
+	for num := range doc.objects {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	for _, num := range nums {
+		if dict, ok := doc.objects[num].value.(pdfDict); ok && dict["Type"] == pdfName("Page") {
+			pages = append(pages, dict)
+		}
+	}
+	return pages
+}
+
+func (doc *pdfDocument) walkPages(node pdfDict, resources interface{}, depth int, pages *[]pdfDict) {
+	if node == nil || depth > maxPageDepth {
+		return
+	}
+	if r, ok := node["Resources"]; ok {
+		resources = r
+	}
+	if node["Type"] == pdfName("Page") {
+		page := pdfDict{"Contents": node["Contents"], "Resources": resources}
+		*pages = append(*pages, page)
+		return
+	}
+	kids, _ := doc.resolve(node["Kids"]).([]interface{})
+	for _, kid := range kids {
+		doc.walkPages(doc.dict(kid), resources, depth+1, pages)
+	}
+}
+
+// extractPage writes the text of one page's content streams
+func (doc *pdfDocument) extractPage(page pdfDict, b *textBuilder) {
+	var content []byte
+	contents := doc.resolve(page["Contents"])
+	refs, ok := contents.([]interface{})
+	if !ok {
+		refs = []interface{}{page["Contents"]}
+	}
+	for _, ref := range refs {
+		object, ok := doc.object(ref)
+		if !ok {
+			continue
+		}
+		data, err := doc.decodeStream(object)
+		if err != nil {
+			continue
+		}
+		content = append(content, data...)
+		content = append(content, '\n')
+	}
+
+	fonts := doc.dict(doc.dict(page["Resources"])["Font"])
+	doc.runContent(content, fonts, b)
+}
+
+func (doc *pdfDocument) object(value interface{}) (pdfObject, bool) {
+	ref, ok := value.(pdfRef)
+	if !ok {
+		return pdfObject{}, false
+	}
+	object, ok := doc.objects[ref.num]
+	return object, ok
+}
+
+// runContent interprets the text operators of a content stream
+func (doc *pdfDocument) runContent(content []byte, fonts pdfDict, b *textBuilder) {
+	lex := &pdfLexer{data: content}
+	var operands []interface{}
+	var font *pdfFont
+
+	show := func(value interface{}) {
+		if s, ok := value.(pdfString); ok {
+			b.WriteString(font.decode(s))
+		}
+	}
+
+	for !b.full() {
+		value, err := lex.parseValue()
+		if err != nil {
+			return
+		}
+		op, ok := value.(pdfKeyword)
+		if !ok {
+			operands = append(operands, value)
+			continue
+		}
+
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = doc.font(fonts[name])
+				}
+			}
+		case "Tj":
+			if len(operands) >= 1 {
+				show(operands[len(operands)-1])
+			}
+		case "'", "\"":
+			b.newline()
+			if len(operands) >= 1 {
+				show(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) >= 1 {
+				items, _ := operands[len(operands)-1].([]interface{})
+				for _, item := range items {
+					// A wide negative adjustment is how PDFs write a space
+					if n, ok := item.(float64); ok && n < -200 {
+						b.space()
+					}
+					show(item)
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if ty, ok := operands[1].(float64); ok && ty != 0 {
+					b.newline()
+				} else {
+					b.space()
+				}
+			}
+		case "T*", "ET":
+			b.newline()
+		case "BI":
+			lex.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+}
+
+// decodeStream applies a stream's filters
+func (doc *pdfDocument) decodeStream(object pdfObject) ([]byte, error) {
+	dict, ok := object.value.(pdfDict)
+	if !ok || object.stream == nil {
+		return nil, fmt.Errorf("not a stream")
+	}
+
+	var filters []interface{}
+	switch f := doc.resolve(dict["Filter"]).(type) {
+	case pdfName:
+		filters = []interface{}{f}
+	case []interface{}:
+		filters = f
+	}
+
+	data := object.stream
+	for _, filter := range filters {
+		var r io.Reader
+		switch doc.resolve(filter) {
+		case pdfName("FlateDecode"):
+			zr, err := zlib.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			r = zr
+		case pdfName("ASCII85Decode"):
+			data = bytes.TrimSuffix(bytes.TrimSpace(data), []byte("~>"))
+			r = ascii85.NewDecoder(bytes.NewReader(data))
+		case pdfName("ASCIIHexDecode"):
+			data = bytes.TrimSuffix(bytes.TrimSpace(data), []byte(">"))
+			r = hex.NewDecoder(bytes.NewReader(stripSpace(data)))
+		default:
+			return nil, fmt.Errorf("unsupported filter %v", filter)
+		}
+		// Truncated deflate data is common; keep what decoded
+		decoded, err := io.ReadAll(io.LimitReader(r, maxPartSize))
+		if err != nil && len(decoded) == 0 {
+			return nil, err
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+func stripSpace(data []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		if isPDFSpace(byte(r)) {
+			return -1
+		}
+		return r
+	}, data)
+}
+
+// pdfFont maps a font's character codes to text
+type pdfFont struct {
+	width   int // Code length in bytes
+	unicode map[uint32]string
+}
+
+func (doc *pdfDocument) font(value interface{}) *pdfFont {
+	ref, isRef := value.(pdfRef)
+	if isRef {
+		if font, ok := doc.fonts[ref]; ok {
+			return font
+		}
+	}
+
+	font := &pdfFont{width: 1}
+	dict := doc.dict(value)
+	if dict["Subtype"] == pdfName("Type0") {
+		font.width = 2
+	}
+	if object, ok := doc.object(dict["ToUnicode"]); ok {
+		if data, err := doc.decodeStream(object); err == nil {
+			font.parseCMap(data)
+		}
+	}
+
+	if isRef {
+		doc.fonts[ref] = font
+	}
+	return font
+}
+
+// parseCMap reads the bfchar and bfrange mappings of a ToUnicode CMap
+func (f *pdfFont) parseCMap(data []byte) {
+	f.unicode = make(map[uint32]string)
+	lex := &pdfLexer{data: data}
+	var operands []interface{}
+	for {
+		value, err := lex.parseValue()
+		if err != nil {
+			return
+		}
+		op, ok := value.(pdfKeyword)
+		if !ok {
+			operands = append(operands, value)
+			continue
+		}
+
+		// Each mapping block is "n beginX ... endX", so the operands of an
+		// end operator are the block's entries
+		switch op {
+		case "endcodespacerange":
+			if len(operands) >= 1 {
+				if lo, ok := operands[0].(pdfString); ok && len(lo) > 0 {
+					f.width = len(lo)
+				}
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].(pdfString)
+				dst, ok2 := operands[i+1].(pdfString)
+				if ok1 && ok2 {
+					f.unicode[codeValue(src)] = utf16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].(pdfString)
+				hi, ok2 := operands[i+1].(pdfString)
+				if !ok1 || !ok2 {
+					continue
+				}
+				f.mapRange(codeValue(lo), codeValue(hi), operands[i+2])
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// maxCMapRange bounds one bfrange so a malformed CMap cannot allocate
+// unbounded memory
+const maxCMapRange = 0x10000
+
+func (f *pdfFont) mapRange(lo, hi uint32, dst interface{}) {
+	if hi < lo || hi-lo >= maxCMapRange {
+		return
+	}
+	switch d := dst.(type) {
+	case pdfString:
+		base := utf16.Decode(utf16Units(d))
+		if len(base) == 0 {
+			return
+		}
+		for code := lo; code <= hi; code++ {
+			runes := append([]rune(nil), base...)
+			runes[len(runes)-1] += rune(code - lo)
+			f.unicode[code] = string(runes)
+		}
+	case []interface{}:
+		for i, item := range d {
+			if s, ok := item.(pdfString); ok && lo+uint32(i) <= hi {
+				f.unicode[lo+uint32(i)] = utf16Text(s)
+			}
+		}
+	}
+}
+
+// decode converts a shown string to text. Without a ToUnicode map, simple
+// fonts are read as WinAnsi and composite fonts cannot be decoded.
+func (f *pdfFont) decode(s pdfString) string {
+	if f == nil {
+		return winAnsiText(s)
+	}
+	if f.unicode == nil {
+		if f.width == 1 {
+			return winAnsiText(s)
+		}
+		return ""
+	}
+
+	var out []rune
+	for i := 0; i+f.width <= len(s); i += f.width {
+		if text, ok := f.unicode[codeValue(s[i:i+f.width])]; ok {
+			out = append(out, []rune(text)...)
+		}
+	}
+	return string(out)
+}
+
+func codeValue(code []byte) uint32 {
+	var v uint32
+	for _, c := range code {
+		v = v<<8 | uint32(c)
+	}
+	return v
+}
+
+func utf16Units(s []byte) []uint16 {
+	units := make([]uint16, len(s)/2)
+	for i := range units {
+		units[i] = uint16(s[2*i])<<8 | uint16(s[2*i+1])
+	}
+	return units
+}
+
+func utf16Text(s []byte) string {
+	return string(utf16.Decode(utf16Units(s)))
+}
+
+// WinAnsi differs from Latin-1 only in 0x80-0x9f
+var winAnsiHigh = [32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+func winAnsiText(s []byte) string {
+	out := make([]rune, 0, len(s))
+	for _, c := range s {
+		switch {
+		case c >= 0x80 && c < 0xa0:
+			if r := winAnsiHigh[c-0x80]; r != 0 {
+				out = append(out, r)
+			}
+		default:
+			out = append(out, rune(c))
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,170 @@
+package search
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxOCRImages bounds how many images of one PDF are recognized
+const maxOCRImages = 200
+
+// pdfPageImages writes the images drawn on each page of a PDF into dir and
+// returns their paths in page order. Scanners embed each page as a JPEG,
+// JPEG 2000 or deflated bitmap; other encodings are skipped.
+func pdfPageImages(path, dir string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := parsePDF(data)
+	if doc.trailer["Encrypt"] != nil {
+		return nil, errPDFEncrypted
+	}
+
+	var images []string
+	seen := make(map[pdfRef]bool)
+	for _, page := range doc.pages() {
+		xobjects := doc.dict(doc.dict(page["Resources"])["XObject"])
+		names := make([]string, 0, len(xobjects))
+		for name := range xobjects {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ref, ok := xobjects[pdfName(name)].(pdfRef)
+			if !ok || seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			if len(images) >= maxOCRImages {
+				return images, nil
+			}
+
+			object := doc.objects[ref.num]
+			dict, ok := object.value.(pdfDict)
+			if !ok || dict["Subtype"] != pdfName("Image") {
+				continue
+			}
+			file := filepath.Join(dir, fmt.Sprintf("image%04d", len(images)))
+			written, err := doc.writeImage(object, file)
+			if err != nil {
+				return nil, err
+			}
+			if written != "" {
+				images = append(images, written)
+			}
+		}
+	}
+	return images, nil
+}
+
+// writeImage saves an image XObject to name plus a suitable extension and
+// returns the file written, or "" for unsupported encodings
+func (doc *pdfDocument) writeImage(object pdfObject, name string) (string, error) {
+	dict := object.value.(pdfDict)
+
+	filter := doc.resolve(dict["Filter"])
+	if filters, ok := filter.([]interface{}); ok && len(filters) == 1 {
+		filter = doc.resolve(filters[0])
+	}
+	switch filter {
+	case pdfName("DCTDecode"):
+		return name + ".jpg", os.WriteFile(name+".jpg", object.stream, 0600)
+	case pdfName("JPXDecode"):
+		return name + ".jp2", os.WriteFile(name+".jp2", object.stream, 0600)
+	case pdfName("FlateDecode"), nil:
+	default:
+		return "", nil
+	}
+	if parms := doc.dict(dict["DecodeParms"]); parms != nil {
+		if predictor, _ := doc.resolve(parms["Predictor"]).(float64); predictor > 1 {
+			return "", nil
+		}
+	}
+
+	width, _ := doc.resolve(dict["Width"]).(float64)
+	height, _ := doc.resolve(dict["Height"]).(float64)
+	bits, _ := doc.resolve(dict["BitsPerComponent"]).(float64)
+	components := doc.colorComponents(dict["ColorSpace"])
+	if dict["ImageMask"] == true {
+		bits, components = 1, 1
+	}
+	w, h := int(width), int(height)
+	if w <= 0 || h <= 0 || w*h > 100_000_000 || (bits != 1 && bits != 8) || (components != 1 && components != 3) {
+		return "", nil
+	}
+
+	data, err := doc.decodeStream(object)
+	if err != nil {
+		return "", nil
+	}
+	rowBytes := (w*components*int(bits) + 7) / 8
+	if len(data) < rowBytes*h {
+		return "", nil
+	}
+
+	var img image.Image
+	switch {
+	case components == 3:
+		rgb := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			row := data[y*rowBytes:]
+			for x := 0; x < w; x++ {
+				rgb.Set(x, y, color.RGBA{row[3*x], row[3*x+1], row[3*x+2], 0xff})
+			}
+		}
+		img = rgb
+	case bits == 8:
+		gray := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			copy(gray.Pix[y*gray.Stride:], data[y*rowBytes:y*rowBytes+w])
+		}
+		img = gray
+	default:
+		gray := image.NewGray(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			row := data[y*rowBytes:]
+			for x := 0; x < w; x++ {
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					gray.Pix[y*gray.Stride+x] = 0xff
+				}
+			}
+		}
+		img = gray
+	}
+
+	f, err := os.Create(name + ".png")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		return "", fmt.Errorf("failed to write page image: %w", err)
+	}
+	return name + ".png", nil
+}
+
+// colorComponents returns the components per pixel of a color space, or 0
+// if unsupported
+func (doc *pdfDocument) colorComponents(value interface{}) int {
+	switch cs := doc.resolve(value).(type) {
+	case pdfName:
+		switch cs {
+		case "DeviceGray", "CalGray":
+			return 1
+		case "DeviceRGB", "CalRGB":
+			return 3
+		}
+	case []interface{}:
+		if len(cs) == 2 && doc.resolve(cs[0]) == pdfName("ICCBased") {
+			n, _ := doc.resolve(doc.dict(cs[1])["N"]).(float64)
+			return int(n)
+		}
+	}
+	return 0
+}
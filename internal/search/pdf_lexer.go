@@ -0,0 +1,367 @@
+package search
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// pdfString is a PDF string's raw bytes
+type pdfString []byte
+
+var errPDFSyntax = errors.New("PDF syntax error")
+
+// maxPDFNesting bounds nested arrays and dictionaries
+const maxPDFNesting = 64
+
+// pdfLexer parses PDF objects and content stream tokens
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func isPDFSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isPDFDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isPDFSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// regular reads a run of regular (non-space, non-delimiter) characters
+func (l *pdfLexer) regular() []byte {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return l.data[start:l.pos]
+}
+
+// parseValue returns the next object: float64, bool, nil, pdfName,
+// pdfString, pdfRef, []interface{}, pdfDict or a pdfKeyword
+func (l *pdfLexer) parseValue() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+
+	switch c := l.data[l.pos]; {
+	case c == '/':
+		l.pos++
+		return pdfName(decodeName(l.regular())), nil
+	case c == '(':
+		return l.literalString(), nil
+	case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+		return l.dictionary()
+	case c == '<':
+		return l.hexString(), nil
+	case c == '[':
+		return l.array()
+	case isPDFDelimiter(c):
+		l.pos++
+		return pdfKeyword(l.data[l.pos-1 : l.pos]), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		token := l.regular()
+		n, err := strconv.ParseFloat(string(token), 64)
+		if err != nil {
+			return pdfKeyword(token), nil
+		}
+		if gen, ok := l.reference(token); ok {
+			return pdfRef{num: int(n), gen: gen}, nil
+		}
+		return n, nil
+	}
+
+	token := l.regular()
+	if len(token) == 0 {
+		l.pos++
+		return nil, errPDFSyntax
+	}
+	switch string(token) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfKeyword(token), nil
+}
+
+// reference reports whether an integer just read starts "num gen R", and
+// consumes the rest of it if so
+func (l *pdfLexer) reference(num []byte) (int, bool) {
+	for _, c := range num {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	save := l.pos
+	l.skipSpace()
+	gen := l.regular()
+	l.skipSpace()
+	if len(gen) > 0 && l.pos < len(l.data) && l.data[l.pos] == 'R' &&
+		(l.pos+1 == len(l.data) || isPDFSpace(l.data[l.pos+1]) || isPDFDelimiter(l.data[l.pos+1])) {
+		if n, err := strconv.Atoi(string(gen)); err == nil && n >= 0 {
+			l.pos++
+			return n, true
+		}
+	}
+	l.pos = save
+	return 0, false
+}
+
+func (l *pdfLexer) dictionary() (interface{}, error) {
+	if l.depth >= maxPDFNesting {
+		return nil, errPDFSyntax
+	}
+	l.depth++
+	defer func() { l.depth-- }()
+
+	l.pos += 2
+	dict := pdfDict{}
+	for {
+		l.skipSpace()
+		if l.pos+1 < len(l.data) && l.data[l.pos] == '>' && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return dict, nil
+		}
+		key, err := l.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, errPDFSyntax
+		}
+		value, err := l.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		dict[name] = value
+	}
+}
+
+func (l *pdfLexer) array() (interface{}, error) {
+	if l.depth >= maxPDFNesting {
+		return nil, errPDFSyntax
+	}
+	l.depth++
+	defer func() { l.depth-- }()
+
+	l.pos++
+	var items []interface{}
+	for {
+		l.skipSpace()
+		if l.pos < len(l.data) && l.data[l.pos] == ']' {
+			l.pos++
+			return items, nil
+		}
+		value, err := l.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, value)
+	}
+}
+
+func (l *pdfLexer) literalString() pdfString {
+	l.pos++
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// Line continuation
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func (l *pdfLexer) hexString() pdfString {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		out[i] = unhex(digits[2*i])<<4 | unhex(digits[2*i+1])
+	}
+	return out
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	}
+	return 0
+}
+
+// decodeName expands #xx escapes in a name
+func decodeName(raw []byte) string {
+	if bytes.IndexByte(raw, '#') < 0 {
+		return string(raw)
+	}
+	var out []byte
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			out = append(out, unhex(raw[i+1])<<4|unhex(raw[i+2]))
+			i += 2
+			continue
+		}
+		out = append(out, raw[i])
+	}
+	return string(out)
+}
+
+// readStream returns the data of the stream following dict, if any, and
+// moves past it
+func (l *pdfLexer) readStream(dict pdfDict) []byte {
+	save := l.pos
+	l.skipSpace()
+	if !bytes.HasPrefix(l.data[l.pos:], []byte("stream")) {
+		l.pos = save
+		return nil
+	}
+	l.pos += len("stream")
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	// Trust /Length only when endstream follows it
+	if length, ok := dict["Length"].(float64); ok && length >= 0 && start+int(length) <= len(l.data) {
+		end := start + int(length)
+		rest := bytes.TrimLeft(l.data[end:], "\x00\t\n\f\r ")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			l.pos = len(l.data) - len(rest) + len("endstream")
+			return l.data[start:end]
+		}
+	}
+
+	idx := bytes.Index(l.data[start:], []byte("endstream"))
+	if idx < 0 {
+		l.pos = len(l.data)
+		return l.data[start:]
+	}
+	end := start + idx
+	l.pos = end + len("endstream")
+	if end > start && l.data[end-1] == '\n' {
+		end--
+	}
+	if end > start && l.data[end-1] == '\r' {
+		end--
+	}
+	return l.data[start:end]
+}
+
+// skipInlineImage moves past an inline image's data after BI
+func (l *pdfLexer) skipInlineImage() {
+	for {
+		value, err := l.parseValue()
+		if err != nil {
+			return
+		}
+		if value == pdfKeyword("ID") {
+			break
+		}
+	}
+	l.pos++
+	for l.pos < len(l.data) {
+		idx := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if idx < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		end := l.pos + idx
+		l.pos = end + 2
+		if end > 0 && isPDFSpace(l.data[end-1]) && (l.pos == len(l.data) || isPDFSpace(l.data[l.pos])) {
+			return
+		}
+	}
+}
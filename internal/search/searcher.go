@@ -16,6 +16,7 @@ type SearchResult struct {
 	FileSize    int64
 	IsDirectory bool
 	MatchScore  float64
+	Snippet     string // Text around the match, for content searches
 }
 
 type Searcher struct{}
@@ -56,6 +57,74 @@ func (s *Searcher) SearchByName(pattern string, caseSensitive bool) ([]SearchRes
 	return results, nil
 }
 
+// SearchByContent searches the extracted text of indexed documents.
+// Documents containing every analyzed term of the query rank first, then
+// those containing the query verbatim.
+func (s *Searcher) SearchByContent(query string) ([]SearchResult, error) {
+	db := storage.DB()
+
+	// Each indexed directory's terms are matched against the query as
+	// analyzed for that directory
+	var roots []models.SearchRoot
+	if err := db.Find(&roots).Error; err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	results := make([]SearchResult, 0)
+	seen := make(map[string]bool)
+	add := func(indices []models.SearchIndex, score float64, terms []string) {
+		for _, idx := range indices {
+			if seen[idx.Path] {
+				continue
+			}
+			seen[idx.Path] = true
+			excerpt := snippet(idx.Content, query)
+			for _, term := range terms {
+				if excerpt != "" {
+					break
+				}
+				excerpt = snippet(idx.Content, term)
+			}
+			results = append(results, SearchResult{
+				Path:        idx.Path,
+				FileName:    idx.FileName,
+				FileSize:    idx.FileSize,
+				IsDirectory: idx.IsDirectory,
+				MatchScore:  score,
+				Snippet:     excerpt,
+			})
+		}
+	}
+
+	for _, root := range roots {
+		analyzer, err := ParseAnalyzer(root.Analyzer)
+		if err != nil {
+			continue
+		}
+		terms := analyzer.Tokens(query)
+		if len(terms) == 0 {
+			continue
+		}
+		q := underRoot(db, root.Path)
+		for _, term := range terms {
+			q = q.Where("terms LIKE ?", "% "+term+" %")
+		}
+		var indices []models.SearchIndex
+		if err := q.Find(&indices).Error; err != nil {
+			return nil, fmt.Errorf("search failed: %w", err)
+		}
+		add(indices, 1.0, terms)
+	}
+
+	var indices []models.SearchIndex
+	if err := db.Where("LOWER(content) LIKE LOWER(?)", "%"+query+"%").Find(&indices).Error; err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	add(indices, 0.7, nil)
+
+	return results, nil
+}
+
 // SearchByRegex searches files using regex pattern
 func (s *Searcher) SearchByRegex(pattern string) ([]SearchResult, error) {
 	re, err := regexp.Compile(pattern)
@@ -145,6 +214,29 @@ func (s *Searcher) SearchBySize(minSize, maxSize int64) ([]SearchResult, error)
 	return results, nil
 }
 
+// snippet returns the line of content around the first match of query
+func snippet(content, query string) string {
+	const context = 60
+
+	pos := strings.Index(strings.ToLower(content), strings.ToLower(query))
+	if pos < 0 {
+		return ""
+	}
+	// Lowercasing can change byte lengths outside ASCII
+	pos = min(pos, len(content))
+	start := strings.LastIndexByte(content[:pos], '\n') + 1
+	if pos-start > context {
+		start = pos - context
+	}
+	end := min(pos+len(query), len(content))
+	if nl := strings.IndexByte(content[end:], '\n'); nl >= 0 && nl < context {
+		end += nl
+	} else {
+		end = min(end+context, len(content))
+	}
+	return strings.ToValidUTF8(content[start:end], "")
+}
+
 func calculateMatchScore(filename, pattern string) float64 {
 	filename = strings.ToLower(filename)
 	pattern = strings.ToLower(pattern)
@@ -0,0 +1,441 @@
+package search
+
+import "unicode"
+
+type language struct {
+	stem      func(string) string
+	stopWords string
+}
+
+// Light stemmers conflate plurals and common inflections without the
+// aggressive suffix stripping that hurts precision
+var languages = map[string]language{
+	"simple": {stem: func(s string) string { return s }},
+	"english": {stem: porterStem, stopWords: `a an and are as at be been but by can did do does for from had has
+		have he her his how i if in into is it its just no not of on or our she so such than that the their
+		then there these they this to too very was we were what when where which who why will with you your`},
+	"german": {stem: runeStemmer(germanStem), stopWords: `aber als am an auch auf aus bei bin bis da dass
+		dem den der des die das du ein eine einem einen einer eines er es für hat im in ist ja mit nach nicht
+		noch nur oder sie sind so über um und uns von war waren wie wir wird werden zu zum zur`},
+	"french": {stem: runeStemmer(frenchStem), stopWords: `au aux avec ce ces cette dans de des du elle elles
+		en est et été il ils je la le les leur lui mais me nous ne on ou par pas plus pour qu que qui sa sans
+		se son ses sont sur ta te tu un une vous`},
+	"spanish": {stem: runeStemmer(spanishStem), stopWords: `al como con de del el ella en era es esa ese
+		esta este estos estas fue la las le les lo los más mi no o para pero por que se sin son su sus un una
+		unas unos y ya`},
+	"italian": {stem: runeStemmer(italianStem), stopWords: `al che come con da dal dei del della delle di
+		e è era fra gli i il in la le lo ma non o per più questa questo si sono su tra un una uno`},
+	"portuguese": {stem: runeStemmer(portugueseStem), stopWords: `a ao as com como da das de do dos e é
+		ela ele em era este esta mais mas na nas não no nos o os ou para por que se sem são seu sua um uma
+		umas uns`},
+}
+
+func runeStemmer(stem func([]rune) []rune) func(string) string {
+	return func(s string) string {
+		return string(stem([]rune(s)))
+	}
+}
+
+func hasSuffix(s []rune, suffix string) bool {
+	r := []rune(suffix)
+	if len(r) > len(s) {
+		return false
+	}
+	for i := range r {
+		if s[len(s)-len(r)+i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// germanStem is Savoy's light German stemmer
+func germanStem(s []rune) []rune {
+	for i, r := range s {
+		switch r {
+		case 'ä', 'à', 'á', 'â':
+			s[i] = 'a'
+		case 'ö', 'ò', 'ó', 'ô':
+			s[i] = 'o'
+		case 'ü', 'ù', 'ú', 'û':
+			s[i] = 'u'
+		case 'ï', 'ì', 'í', 'î':
+			s[i] = 'i'
+		}
+	}
+
+	stEnding := func(r rune) bool {
+		switch r {
+		case 'b', 'd', 'f', 'g', 'h', 'k', 'l', 'm', 'n', 't':
+			return true
+		}
+		return false
+	}
+
+	n := len(s)
+	switch {
+	case n > 5 && hasSuffix(s, "ern"):
+		n -= 3
+	case n > 4 && (hasSuffix(s, "em") || hasSuffix(s, "en") || hasSuffix(s, "er") || hasSuffix(s, "es")):
+		n -= 2
+	case n > 3 && s[n-1] == 'e':
+		n--
+	case n > 3 && s[n-1] == 's' && stEnding(s[n-2]):
+		n--
+	}
+	s = s[:n]
+
+	switch {
+	case n > 5 && hasSuffix(s, "est"):
+		n -= 3
+	case n > 4 && (hasSuffix(s, "er") || hasSuffix(s, "en")):
+		n -= 2
+	case n > 4 && hasSuffix(s, "st") && stEnding(s[n-3]):
+		n -= 2
+	}
+	return s[:n]
+}
+
+// frenchStem strips plurals and feminine endings
+func frenchStem(s []rune) []rune {
+	n := len(s)
+	if n < 6 {
+		return s
+	}
+	if s[n-1] == 'x' {
+		if s[n-3] == 'a' && s[n-2] == 'u' && s[n-4] != 'e' {
+			s[n-2] = 'l' // chevaux -> cheval
+		}
+		return s[:n-1]
+	}
+	for _, ending := range []rune{'s', 'r', 'e', 'é'} {
+		if s[n-1] == ending {
+			n--
+		}
+	}
+	if n > 1 && s[n-1] == s[n-2] && unicode.IsLetter(s[n-1]) {
+		n--
+	}
+	return s[:n]
+}
+
+// spanishStem strips gender and number endings
+func spanishStem(s []rune) []rune {
+	n := len(s)
+	if n < 5 {
+		return s
+	}
+	foldVowels(s)
+	switch s[n-1] {
+	case 'o', 'a', 'e':
+		return s[:n-1]
+	case 's':
+		switch {
+		case s[n-2] == 'e' && s[n-3] == 's' && s[n-4] == 'e':
+			return s[:n-2]
+		case s[n-2] == 'e' && s[n-3] == 'c':
+			s[n-3] = 'z' // luces -> luz
+			return s[:n-2]
+		case s[n-2] == 'o' || s[n-2] == 'a' || s[n-2] == 'e':
+			return s[:n-2]
+		}
+	}
+	return s
+}
+
+// italianStem strips gender and number endings
+func italianStem(s []rune) []rune {
+	n := len(s)
+	if n < 6 {
+		return s
+	}
+	foldVowels(s)
+	switch s[n-1] {
+	case 'e':
+		if s[n-2] == 'i' || s[n-2] == 'h' {
+			return s[:n-2]
+		}
+		return s[:n-1]
+	case 'i':
+		if s[n-2] == 'h' || s[n-2] == 'i' {
+			return s[:n-2]
+		}
+		return s[:n-1]
+	case 'a', 'o':
+		if s[n-2] == 'i' {
+			return s[:n-2]
+		}
+		return s[:n-1]
+	}
+	return s
+}
+
+// portugueseStem reduces plurals to the singular, then strips the gender
+// ending
+func portugueseStem(s []rune) []rune {
+	if len(s) < 4 {
+		return s
+	}
+	for _, rule := range []struct{ from, to string }{
+		{"ões", "ão"}, {"ães", "ão"}, {"oes", "ao"}, {"aes", "ao"},
+		{"ais", "al"}, {"éis", "el"}, {"eis", "el"}, {"óis", "ol"}, {"ois", "ol"},
+		{"ns", "m"}, {"res", "r"}, {"zes", "z"}, {"ses", "s"}, {"s", ""},
+	} {
+		if hasSuffix(s, rule.from) {
+			s = append(s[:len(s)-len([]rune(rule.from))], []rune(rule.to)...)
+			break
+		}
+	}
+	if n := len(s); n >= 5 && (s[n-1] == 'a' || s[n-1] == 'o' || s[n-1] == 'e') {
+		s = s[:n-1]
+	}
+	return s
+}
+
+func foldVowels(s []rune) {
+	for i, r := range s {
+		switch r {
+		case 'à', 'á', 'â', 'ä':
+			s[i] = 'a'
+		case 'è', 'é', 'ê', 'ë':
+			s[i] = 'e'
+		case 'ì', 'í', 'î', 'ï':
+			s[i] = 'i'
+		case 'ò', 'ó', 'ô', 'ö':
+			s[i] = 'o'
+		case 'ù', 'ú', 'û', 'ü':
+			s[i] = 'u'
+		}
+	}
+}
+
+// porterStem is Porter's English stemming algorithm. Words with anything
+// but the letters a-z are returned unchanged.
+func porterStem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	p := &porter{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.step2()
+		p.step3()
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// porter holds a word being stemmed: b[:k+1] is the current word and
+// b[:j+1] the stem left by the last successful ends
+type porter struct {
+	b    []byte
+	k, j int
+}
+
+func (p *porter) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !p.cons(i-1)
+	}
+	return true
+}
+
+// m counts the vowel-consonant sequences in b[:j+1]
+func (p *porter) m() int {
+	n, i := 0, 0
+	for ; i <= p.j && p.cons(i); i++ {
+	}
+	for i <= p.j {
+		for ; i <= p.j && !p.cons(i); i++ {
+		}
+		if i > p.j {
+			break
+		}
+		n++
+		for ; i <= p.j && p.cons(i); i++ {
+		}
+	}
+	return n
+}
+
+func (p *porter) vowelInStem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doublec reports a double consonant at j
+func (p *porter) doublec(j int) bool {
+	return j >= 1 && p.b[j] == p.b[j-1] && p.cons(j)
+}
+
+// cvc reports consonant-vowel-consonant ending at i, the last not w, x or y
+func (p *porter) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	c := p.b[i]
+	return c != 'w' && c != 'x' && c != 'y'
+}
+
+func (p *porter) ends(s string) bool {
+	if len(s) > p.k+1 || string(p.b[p.k-len(s)+1:p.k+1]) != s {
+		return false
+	}
+	p.j = p.k - len(s)
+	return true
+}
+
+func (p *porter) setTo(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = p.j + len(s)
+}
+
+func (p *porter) replace(s string) {
+	if p.m() > 0 {
+		p.setTo(s)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing
+func (p *porter) step1ab() {
+	if p.b[p.k] == 's' {
+		switch {
+		case p.ends("sses"):
+			p.k -= 2
+		case p.ends("ies"):
+			p.setTo("i")
+		case p.b[p.k-1] != 's':
+			p.k--
+		}
+	}
+	if p.ends("eed") {
+		if p.m() > 0 {
+			p.k--
+		}
+	} else if (p.ends("ed") || p.ends("ing")) && p.vowelInStem() {
+		p.k = p.j
+		switch {
+		case p.ends("at"):
+			p.setTo("ate")
+		case p.ends("bl"):
+			p.setTo("ble")
+		case p.ends("iz"):
+			p.setTo("ize")
+		case p.doublec(p.k):
+			if c := p.b[p.k]; c != 'l' && c != 's' && c != 'z' {
+				p.k--
+			}
+		default:
+			p.j = p.k
+			if p.m() == 1 && p.cvc(p.k) {
+				p.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel in the stem
+func (p *porter) step1c() {
+	if p.ends("y") && p.vowelInStem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+// suffixRule replaces a suffix when the stem's measure allows
+type suffixRule struct{ suffix, replacement string }
+
+func (p *porter) applyFirst(rules []suffixRule) bool {
+	for _, rule := range rules {
+		if p.ends(rule.suffix) {
+			p.replace(rule.replacement)
+			return true
+		}
+	}
+	return false
+}
+
+// step2 maps double suffixes to single ones
+func (p *porter) step2() {
+	p.applyFirst(step2Rules[p.b[p.k-1]])
+}
+
+var step2Rules = map[byte][]suffixRule{
+	'a': {{"ational", "ate"}, {"tional", "tion"}},
+	'c': {{"enci", "ence"}, {"anci", "ance"}},
+	'e': {{"izer", "ize"}},
+	'l': {{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"}},
+	'o': {{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}},
+	's': {{"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"}, {"ousness", "ous"}},
+	't': {{"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"}},
+	'g': {{"logi", "log"}},
+}
+
+// step3 handles -ic-, -full, -ness and similar
+func (p *porter) step3() {
+	p.applyFirst(step3Rules[p.b[p.k]])
+}
+
+var step3Rules = map[byte][]suffixRule{
+	'e': {{"icate", "ic"}, {"ative", ""}, {"alize", "al"}},
+	'i': {{"iciti", "ic"}},
+	'l': {{"ical", "ic"}, {"ful", ""}},
+	's': {{"ness", ""}},
+}
+
+// step4 removes -ant, -ence and similar from stems of measure above one
+func (p *porter) step4() {
+	matched := false
+	for _, suffix := range step4Suffixes[p.b[p.k-1]] {
+		if p.ends(suffix) {
+			matched = suffix != "ion" || (p.j >= 0 && (p.b[p.j] == 's' || p.b[p.j] == 't'))
+			if matched {
+				break
+			}
+		}
+	}
+	if matched && p.m() > 1 {
+		p.k = p.j
+	}
+}
+
+var step4Suffixes = map[byte][]string{
+	'a': {"al"},
+	'c': {"ance", "ence"},
+	'e': {"er"},
+	'i': {"ic"},
+	'l': {"able", "ible"},
+	'n': {"ant", "ement", "ment", "ent"},
+	'o': {"ion", "ou"},
+	's': {"ism"},
+	't': {"ate", "iti"},
+	'u': {"ous"},
+	'v': {"ive"},
+	'z': {"ize"},
+}
+
+// step5 removes a final -e and reduces -ll on long stems
+func (p *porter) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		if a := p.m(); a > 1 || (a == 1 && !p.cvc(p.k-1)) {
+			p.k--
+		}
+	}
+	if p.b[p.k] == 'l' && p.doublec(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
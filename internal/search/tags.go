@@ -0,0 +1,280 @@
+package search
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TagRule assigns Tags to files matching every condition that is set
+type TagRule struct {
+	Name    string
+	Tags    []string
+	Glob    string // Base name, or end of the path if it contains a slash; ** matches any directories
+	MIME    string // e.g. "image/*"
+	Camera  string // EXIF make and model, case-insensitive, e.g. "*iPhone*"
+	MinSize int64
+	MaxSize int64
+}
+
+func (r TagRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("tag rule needs a name")
+	}
+	if len(r.Tags) == 0 {
+		return fmt.Errorf("tag rule %s assigns no tags", r.Name)
+	}
+	if r.Glob == "" && r.MIME == "" && r.Camera == "" && r.MinSize == 0 && r.MaxSize == 0 {
+		return fmt.Errorf("tag rule %s has no conditions", r.Name)
+	}
+	for _, pattern := range []string{r.Glob, r.MIME, r.Camera} {
+		if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+			return fmt.Errorf("tag rule %s: bad pattern %q", r.Name, pattern)
+		}
+	}
+	return nil
+}
+
+// fileFacts computes the properties rules test, each at most once
+type fileFacts struct {
+	path   string
+	info   os.FileInfo
+	mime   *string
+	camera *string
+}
+
+func (f *fileFacts) mimeType() string {
+	if f.mime == nil {
+		t := mimeType(f.path)
+		f.mime = &t
+	}
+	return *f.mime
+}
+
+func (f *fileFacts) cameraModel() string {
+	if f.camera == nil {
+		c := ""
+		// Raw formats often have no registered type
+		if t := f.mimeType(); strings.HasPrefix(t, "image/") || t == "application/octet-stream" {
+			c = exifCamera(f.path)
+		}
+		f.camera = &c
+	}
+	return *f.camera
+}
+
+func (r TagRule) matches(f *fileFacts) bool {
+	size := f.info.Size()
+	if (r.MinSize > 0 && size < r.MinSize) || (r.MaxSize > 0 && size > r.MaxSize) {
+		return false
+	}
+	if r.Glob != "" && !matchGlob(r.Glob, filepath.ToSlash(f.path)) {
+		return false
+	}
+	if r.MIME != "" {
+		if ok, _ := path.Match(r.MIME, f.mimeType()); !ok {
+			return false
+		}
+	}
+	if r.Camera != "" {
+		camera := f.cameraModel()
+		if ok, _ := path.Match(strings.ToLower(r.Camera), strings.ToLower(camera)); camera == "" || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SetTagRules sets the rules that tag files as they are indexed. Files
+// already indexed are retagged by ApplyTagRules.
+func (idx *Indexer) SetTagRules(rules []TagRule) error {
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.tagRules = rules
+	return nil
+}
+
+// ApplyTagRules re-evaluates the tag rules for every file indexed under
+// rootPath, e.g. after the rules changed, and returns the number of tags
+// added or removed
+func (idx *Indexer) ApplyTagRules(rootPath string) (int, error) {
+	changed := 0
+	var batch []models.SearchIndex
+	result := underRoot(storage.DB(), filepath.Clean(rootPath)).Where("is_directory = ?", false).
+		FindInBatches(&batch, integrityBatch, func(tx *gorm.DB, _ int) error {
+			for _, entry := range batch {
+				info, err := os.Stat(entry.Path)
+				if err != nil {
+					continue
+				}
+				n, err := idx.applyTags(entry.Path, info)
+				if err != nil {
+					return err
+				}
+				changed += n
+			}
+			return nil
+		})
+	if result.Error != nil {
+		return changed, fmt.Errorf("failed to apply tag rules: %w", result.Error)
+	}
+	return changed, nil
+}
+
+// applyTags brings the tags of a file in line with the current rules and
+// returns the number of tags added or removed
+func (idx *Indexer) applyTags(filePath string, info os.FileInfo) (int, error) {
+	idx.mu.Lock()
+	rules := idx.tagRules
+	idx.mu.Unlock()
+
+	wanted := make(map[string]string) // Tag -> rule
+	facts := &fileFacts{path: filePath, info: info}
+	for _, rule := range rules {
+		if !rule.matches(facts) {
+			continue
+		}
+		for _, tag := range rule.Tags {
+			if _, ok := wanted[tag]; !ok {
+				wanted[tag] = rule.Name
+			}
+		}
+	}
+
+	db := storage.DB()
+	var existing []models.FileTag
+	if err := db.Where("path = ?", filePath).Find(&existing).Error; err != nil {
+		return 0, fmt.Errorf("failed to load tags of %s: %w", filePath, err)
+	}
+
+	changed := 0
+	for _, tag := range existing {
+		if _, ok := wanted[tag.Tag]; ok {
+			delete(wanted, tag.Tag)
+			continue
+		}
+		if err := db.Delete(&tag).Error; err != nil {
+			return changed, fmt.Errorf("failed to untag %s: %w", filePath, err)
+		}
+		changed++
+	}
+	for tag, rule := range wanted {
+		err := db.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.FileTag{Path: filePath, Tag: tag, Rule: rule}).Error
+		if err != nil {
+			return changed, fmt.Errorf("failed to tag %s: %w", filePath, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// removeTags drops the tags of path and anything below it
+func removeTags(db *gorm.DB, filePath string) error {
+	return underRoot(db, filePath).Delete(&models.FileTag{}).Error
+}
+
+// GetTags returns the tags of a file
+func (s *Searcher) GetTags(filePath string) ([]string, error) {
+	var tags []string
+	err := storage.DB().Model(&models.FileTag{}).Where("path = ?", filePath).Order("tag").Pluck("tag", &tags).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tags: %w", err)
+	}
+	return tags, nil
+}
+
+// SearchByTag finds files with a tag
+func (s *Searcher) SearchByTag(tag string) ([]SearchResult, error) {
+	db := storage.DB()
+
+	var indices []models.SearchIndex
+	err := db.Where("path IN (?)", db.Model(&models.FileTag{}).Select("path").Where("tag = ?", tag)).
+		Find(&indices).Error
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(indices))
+	for _, idx := range indices {
+		results = append(results, SearchResult{
+			Path:        idx.Path,
+			FileName:    idx.FileName,
+			FileSize:    idx.FileSize,
+			IsDirectory: idx.IsDirectory,
+			MatchScore:  1.0,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, nil
+}
+
+// mimeType returns the MIME type of a file from its extension, or from
+// its first bytes if the extension is unknown
+func mimeType(filePath string) string {
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(filePath))); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.Read(head)
+	t, _, _ := strings.Cut(http.DetectContentType(head[:n]), ";")
+	return t
+}
+
+// matchGlob matches a slash-separated path against a pattern. A pattern
+// without a slash matches the base name; otherwise it matches the end of
+// the path, or the whole path if it starts with a slash. ** stands for any
+// number of directories.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "**") {
+		pattern = "**/" + pattern
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
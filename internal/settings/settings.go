@@ -0,0 +1,286 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"github.com/spf13/viper"
+	"gorm.io/gorm"
+)
+
+// Type identifies the value type of a setting
+type Type string
+
+const (
+	TypeBool   Type = "bool"
+	TypeInt    Type = "int"
+	TypeString Type = "string"
+)
+
+// Runtime-adjustable settings
+const (
+	DoNotDisturb   = "airdrop.do_not_disturb"
+	AutoAccept     = "airdrop.auto_accept"
+	BandwidthLimit = "transfer.bandwidth_limit" // bytes per second, 0 = unlimited
+)
+
+// Source identifies where an effective value came from
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceRuntime Source = "runtime"
+)
+
+// Definition describes a runtime-adjustable setting
+type Definition struct {
+	Key     string
+	Type    Type
+	Default interface{}
+}
+
+// Value is the effective value of a setting
+type Value struct {
+	Key    string      `json:"key"`
+	Type   Type        `json:"type"`
+	Value  interface{} `json:"value"`
+	Source Source      `json:"source"`
+}
+
+// ChangeFunc is called after a setting's effective value changes
+type ChangeFunc func(key string, value interface{})
+
+var (
+	ErrUnknownSetting = errors.New("unknown setting")
+	ErrTypeMismatch   = errors.New("setting type mismatch")
+	ErrPinned         = errors.New("setting is pinned by the config file")
+)
+
+var (
+	mu          sync.RWMutex
+	definitions = map[string]Definition{}
+	subscribers = map[string][]ChangeFunc{}
+)
+
+func init() {
+	Register(Definition{Key: DoNotDisturb, Type: TypeBool, Default: false})
+	Register(Definition{Key: AutoAccept, Type: TypeBool, Default: false})
+	Register(Definition{Key: BandwidthLimit, Type: TypeInt, Default: int64(0)})
+}
+
+// Register adds a runtime-adjustable setting
+func Register(def Definition) {
+	mu.Lock()
+	defer mu.Unlock()
+	definitions[def.Key] = def
+}
+
+// Subscribe registers a callback for changes to a setting
+func Subscribe(key string, fn ChangeFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	subscribers[key] = append(subscribers[key], fn)
+}
+
+// Get returns the effective value of a setting.
+// Precedence: pinned file value > runtime (database) value > file value > default
+func Get(key string) (Value, error) {
+	mu.RLock()
+	def, ok := definitions[key]
+	mu.RUnlock()
+	if !ok {
+		return Value{}, fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+
+	if !isPinned(key) {
+		var setting models.Setting
+		err := storage.DB().Where("key = ?", key).First(&setting).Error
+		if err == nil {
+			value, err := decode(def.Type, setting.Value)
+			if err != nil {
+				return Value{}, fmt.Errorf("failed to decode setting %s: %w", key, err)
+			}
+			return Value{Key: key, Type: def.Type, Value: value, Source: SourceRuntime}, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return Value{}, fmt.Errorf("failed to load setting %s: %w", key, err)
+		}
+	}
+
+	if viper.IsSet(key) {
+		value, err := coerce(def.Type, viper.Get(key))
+		if err != nil {
+			return Value{}, fmt.Errorf("invalid config value for %s: %w", key, err)
+		}
+		return Value{Key: key, Type: def.Type, Value: value, Source: SourceFile}, nil
+	}
+
+	return Value{Key: key, Type: def.Type, Value: def.Default, Source: SourceDefault}, nil
+}
+
+// Set stores a runtime value and notifies subscribers
+func Set(key string, value interface{}) error {
+	mu.RLock()
+	def, ok := definitions[key]
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSetting, key)
+	}
+	if isPinned(key) {
+		return fmt.Errorf("%w: %s", ErrPinned, key)
+	}
+
+	value, err := coerce(def.Type, value)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode setting: %w", err)
+	}
+
+	db := storage.DB()
+	var setting models.Setting
+	if err := db.Where("key = ?", key).FirstOrCreate(&setting, models.Setting{Key: key}).Error; err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	if err := db.Model(&setting).Updates(map[string]interface{}{
+		"type":  string(def.Type),
+		"value": string(data),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+
+	notify(key, value)
+	return nil
+}
+
+// Reset removes a runtime value, reverting to the file config or default
+func Reset(key string) error {
+	if err := storage.DB().Where("key = ?", key).Delete(&models.Setting{}).Error; err != nil {
+		return fmt.Errorf("failed to reset setting %s: %w", key, err)
+	}
+
+	current, err := Get(key)
+	if err != nil {
+		return err
+	}
+	notify(key, current.Value)
+	return nil
+}
+
+// List returns the effective values of all registered settings
+func List() ([]Value, error) {
+	mu.RLock()
+	keys := make([]string, 0, len(definitions))
+	for key := range definitions {
+		keys = append(keys, key)
+	}
+	mu.RUnlock()
+	sort.Strings(keys)
+
+	values := make([]Value, 0, len(keys))
+	for _, key := range keys {
+		value, err := Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// GetBool returns a bool setting, falling back to false on error
+func GetBool(key string) bool {
+	value, err := Get(key)
+	if err != nil {
+		return false
+	}
+	b, _ := value.Value.(bool)
+	return b
+}
+
+// GetInt returns an integer setting, falling back to 0 on error
+func GetInt(key string) int64 {
+	value, err := Get(key)
+	if err != nil {
+		return 0
+	}
+	i, _ := value.Value.(int64)
+	return i
+}
+
+// GetString returns a string setting, falling back to "" on error
+func GetString(key string) string {
+	value, err := Get(key)
+	if err != nil {
+		return ""
+	}
+	s, _ := value.Value.(string)
+	return s
+}
+
+func notify(key string, value interface{}) {
+	mu.RLock()
+	fns := append([]ChangeFunc(nil), subscribers[key]...)
+	mu.RUnlock()
+
+	for _, fn := range fns {
+		fn(key, value)
+	}
+}
+
+// isPinned reports whether the config file forbids runtime overrides of key
+func isPinned(key string) bool {
+	for _, pinned := range viper.GetStringSlice("settings.pinned") {
+		if pinned == key {
+			return true
+		}
+	}
+	return false
+}
+
+func decode(t Type, data string) (interface{}, error) {
+	var raw interface{}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, err
+	}
+	return coerce(t, raw)
+}
+
+// coerce converts a value from JSON, YAML, or Go callers to the setting type
+func coerce(t Type, value interface{}) (interface{}, error) {
+	switch t {
+	case TypeBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+	case TypeInt:
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case uint32:
+			return int64(v), nil
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		}
+	case TypeString:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: expected %s, got %T", ErrTypeMismatch, t, value)
+}
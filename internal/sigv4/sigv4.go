@@ -0,0 +1,223 @@
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm       = "AWS4-HMAC-SHA256"
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+	TimeFormat      = "20060102T150405Z"
+	dateFormat      = "20060102"
+	maxClockSkew    = 15 * time.Minute
+)
+
+// requiredHeaders must be signed, so a captured request cannot be replayed
+// to another host or with another body
+var requiredHeaders = []string{"host", "x-amz-content-sha256", "x-amz-date"}
+
+var (
+	ErrMissingAuth       = errors.New("missing authorization")
+	ErrMalformedAuth     = errors.New("malformed authorization header")
+	ErrUnknownAccessKey  = errors.New("unknown access key")
+	ErrSignatureMismatch = errors.New("signature does not match")
+	ErrRequestExpired    = errors.New("request time too skewed")
+)
+
+// Credentials is an access key pair
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+}
+
+// HashPayload returns the hex SHA256 used in x-amz-content-sha256
+func HashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign adds SigV4 authentication headers to req. payloadHash is the hex
+// SHA256 of the body or UnsignedPayload.
+func Sign(req *http.Request, creds Credentials, region, service, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(TimeFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if req.Host == "" {
+		req.Host = req.URL.Host
+	}
+
+	signedHeaders := requiredHeaders
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(dateFormat), region, service)
+
+	canonical := canonicalRequest(req, signedHeaders, payloadHash)
+	signature := signature(creds.SecretKey, now.Format(dateFormat), region, service, stringToSign(amzDate, scope, canonical))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		Algorithm, creds.AccessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+// Verify checks the SigV4 Authorization header of req. lookup returns the
+// secret for an access key. It returns the access key that signed req.
+func Verify(req *http.Request, lookup func(accessKey string) (string, bool), now time.Time) (string, error) {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return "", ErrMissingAuth
+	}
+	if !strings.HasPrefix(auth, Algorithm+" ") {
+		return "", ErrMalformedAuth
+	}
+
+	fields := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(auth, Algorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return "", ErrMalformedAuth
+		}
+		fields[kv[0]] = kv[1]
+	}
+
+	// Credential=AKID/20060102/region/service/aws4_request
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[4] != "aws4_request" {
+		return "", ErrMalformedAuth
+	}
+	accessKey, date, region, service := credential[0], credential[1], credential[2], credential[3]
+
+	secret, ok := lookup(accessKey)
+	if !ok {
+		return "", ErrUnknownAccessKey
+	}
+
+	amzDate := req.Header.Get("X-Amz-Date")
+	requestTime, err := time.Parse(TimeFormat, amzDate)
+	if err != nil {
+		return "", ErrMalformedAuth
+	}
+	if skew := now.Sub(requestTime); skew > maxClockSkew || skew < -maxClockSkew {
+		return "", ErrRequestExpired
+	}
+	if requestTime.Format(dateFormat) != date {
+		return "", ErrMalformedAuth
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	if fields["Signature"] == "" {
+		return "", ErrMalformedAuth
+	}
+	for _, name := range requiredHeaders {
+		if !slices.Contains(signedHeaders, name) {
+			return "", ErrMalformedAuth
+		}
+	}
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		return "", ErrMalformedAuth
+	}
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, region, service)
+	canonical := canonicalRequest(req, signedHeaders, payloadHash)
+	expected := signature(secret, date, region, service, stringToSign(amzDate, scope, canonical))
+
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return "", ErrSignatureMismatch
+	}
+	return accessKey, nil
+}
+
+func canonicalRequest(req *http.Request, signedHeaders []string, payloadHash string) string {
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		var value string
+		if name == "host" {
+			value = req.Host
+			if value == "" {
+				value = req.URL.Host
+			}
+		} else {
+			value = strings.Join(req.Header.Values(name), ",")
+		}
+		headers.WriteString(name)
+		headers.WriteString(":")
+		headers.WriteString(strings.Join(strings.Fields(value), " "))
+		headers.WriteString("\n")
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, encode(key)+"="+encode(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// encode applies AWS URI encoding (RFC 3986 unreserved characters only)
+func encode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func stringToSign(amzDate, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	return strings.Join([]string{Algorithm, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+}
+
+func signature(secret, date, region, service, toSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
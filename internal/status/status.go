@@ -0,0 +1,213 @@
+package status
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	gosync "sync"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// Protection levels
+const (
+	LevelProtected   = "protected"   // Encrypted and recently backed up
+	LevelPartial     = "partial"     // Either encrypted or recently backed up / synced
+	LevelUnprotected = "unprotected" // Neither
+	LevelMissing     = "missing"     // Folder does not exist
+)
+
+// maxStragglers bounds how many plaintext paths a report lists
+const maxStragglers = 50
+
+var (
+	mu           gosync.RWMutex
+	folders      []string
+	backupMaxAge = 7 * 24 * time.Hour
+	syncMaxAge   = 7 * 24 * time.Hour
+)
+
+// Configure sets the important folders and how old a backup or sync may be
+// before it no longer counts
+func Configure(importantFolders []string, maxBackupAge, maxSyncAge time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	folders = nil
+	for _, folder := range importantFolders {
+		if abs, err := filepath.Abs(folder); err == nil {
+			folders = append(folders, abs)
+		}
+	}
+	if maxBackupAge > 0 {
+		backupMaxAge = maxBackupAge
+	}
+	if maxSyncAge > 0 {
+		syncMaxAge = maxSyncAge
+	}
+}
+
+// FolderStatus answers "is this folder actually protected?"
+type FolderStatus struct {
+	Path             string    `json:"path"`
+	Exists           bool      `json:"exists"`
+	Encrypted        bool      `json:"encrypted"`           // Inside a mounted container
+	Container        string    `json:"container,omitempty"` // The container holding it
+	LastBackup       time.Time `json:"last_backup,omitempty"`
+	BackupRepository string    `json:"backup_repository,omitempty"`
+	LastSync         time.Time `json:"last_sync,omitempty"`
+	SyncJob          string    `json:"sync_job,omitempty"`
+	Stragglers       []string  `json:"stragglers,omitempty"` // Plaintext left next to encrypted copies
+	PlaintextFiles   int       `json:"plaintext_files"`      // Files stored outside any container
+	Level            string    `json:"level"`
+	Warnings         []string  `json:"warnings,omitempty"`
+}
+
+// Dashboard reports on every configured important folder
+func Dashboard() ([]FolderStatus, error) {
+	mu.RLock()
+	configured := append([]string(nil), folders...)
+	mu.RUnlock()
+
+	reports := make([]FolderStatus, 0, len(configured))
+	for _, folder := range configured {
+		report, err := Check(folder)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// Check reports on a single folder
+func Check(folder string) (FolderStatus, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return FolderStatus{}, fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	mu.RLock()
+	maxBackup, maxSync := backupMaxAge, syncMaxAge
+	mu.RUnlock()
+
+	report := FolderStatus{Path: folder}
+	if info, err := os.Stat(folder); err == nil && info.IsDir() {
+		report.Exists = true
+	} else {
+		report.Level = LevelMissing
+		report.Warnings = append(report.Warnings, "folder does not exist")
+		return report, nil
+	}
+
+	db := storage.DB()
+
+	var containers []models.EncryptedContainer
+	if err := db.Find(&containers).Error; err != nil {
+		return report, fmt.Errorf("failed to load containers: %w", err)
+	}
+	for _, c := range containers {
+		if c.IsMounted && c.MountPoint != "" && within(folder, c.MountPoint) {
+			report.Encrypted = true
+			report.Container = c.Path
+		}
+	}
+
+	if !report.Encrypted {
+		report.PlaintextFiles = countFiles(folder)
+
+		// The source of a container still on disk is a plaintext copy
+		for _, c := range containers {
+			if len(report.Stragglers) >= maxStragglers {
+				break
+			}
+			if c.OriginalPath == "" || !within(c.OriginalPath, folder) {
+				continue
+			}
+			if _, err := os.Stat(c.OriginalPath); err == nil {
+				report.Stragglers = append(report.Stragglers, c.OriginalPath)
+			}
+		}
+	}
+
+	var runs []models.BackupRun
+	if err := db.Order("created_at desc").Find(&runs).Error; err != nil {
+		return report, fmt.Errorf("failed to load backups: %w", err)
+	}
+	for _, run := range runs {
+		if within(folder, run.Source) {
+			report.LastBackup = run.CreatedAt
+			report.BackupRepository = run.Repository
+			break
+		}
+	}
+
+	var jobs []models.MirrorJob
+	if err := db.Where("last_status = ?", "completed").Order("last_run desc").Find(&jobs).Error; err != nil {
+		return report, fmt.Errorf("failed to load mirror jobs: %w", err)
+	}
+	for _, job := range jobs {
+		if within(folder, job.SourcePath) {
+			report.LastSync = job.LastRun
+			report.SyncJob = job.Name
+			break
+		}
+	}
+
+	now := time.Now()
+	backedUp := !report.LastBackup.IsZero() && now.Sub(report.LastBackup) <= maxBackup
+	synced := !report.LastSync.IsZero() && now.Sub(report.LastSync) <= maxSync
+
+	if !report.Encrypted {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d file(s) stored unencrypted", report.PlaintextFiles))
+	}
+	if len(report.Stragglers) > 0 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%d plaintext original(s) of encrypted containers still on disk", len(report.Stragglers)))
+	}
+	switch {
+	case report.LastBackup.IsZero():
+		report.Warnings = append(report.Warnings, "never backed up")
+	case !backedUp:
+		report.Warnings = append(report.Warnings, "last backup is older than "+maxBackup.String())
+	}
+	if !report.LastSync.IsZero() && !synced {
+		report.Warnings = append(report.Warnings, "last sync is older than "+maxSync.String())
+	}
+
+	switch {
+	case report.Encrypted && backedUp && len(report.Stragglers) == 0:
+		report.Level = LevelProtected
+	case report.Encrypted || backedUp || synced:
+		report.Level = LevelPartial
+	default:
+		report.Level = LevelUnprotected
+	}
+
+	return report, nil
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func countFiles(dir string) int {
+	count := 0
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			count++
+		}
+		return nil
+	})
+	return count
+}
@@ -0,0 +1,159 @@
+package status
+
+import (
+	"context"
+	"sort"
+	gosync "sync"
+	"time"
+)
+
+// ThroughputWindow is how many per-second samples are kept
+const ThroughputWindow = 120
+
+// ring counts bytes per second over the last ThroughputWindow seconds.
+// Each slot belongs to one second and is reused when that second comes
+// round again, so idle time needs no ticking.
+type ring struct {
+	seconds [ThroughputWindow]int64
+	bytes   [ThroughputWindow]int64
+}
+
+func (r *ring) add(now time.Time, n int64) {
+	sec := now.Unix()
+	slot := sec % ThroughputWindow
+	if r.seconds[slot] != sec {
+		r.seconds[slot] = sec
+		r.bytes[slot] = 0
+	}
+	r.bytes[slot] += n
+}
+
+// samples returns the complete seconds up to end, oldest first
+func (r *ring) samples(end int64) []int64 {
+	samples := make([]int64, ThroughputWindow)
+	for i := range samples {
+		sec := end - ThroughputWindow + int64(i)
+		if slot := sec % ThroughputWindow; r.seconds[slot] == sec {
+			samples[i] = r.bytes[slot]
+		}
+	}
+	return samples
+}
+
+// Meter records the throughput of one transfer
+type Meter struct {
+	id        string
+	source    string
+	direction string
+	file      string
+	startedAt time.Time
+	ring      ring // Guarded by meterMu
+}
+
+// TransferThroughput is the recent throughput of one transfer
+type TransferThroughput struct {
+	ID        string    `json:"id"`
+	Source    string    `json:"source"`    // airdrop or sync
+	Direction string    `json:"direction"` // send or receive
+	File      string    `json:"file"`
+	StartedAt time.Time `json:"started_at"`
+	Samples   []int64   `json:"samples"` // Bytes per second, oldest first
+}
+
+// ThroughputSnapshot holds per-second samples of every active transfer and
+// of all transfers together, aligned so the last sample of each is the
+// second ending at At. Seconds before a transfer started are 0.
+type ThroughputSnapshot struct {
+	At        time.Time            `json:"at"`
+	Transfers []TransferThroughput `json:"transfers"`
+	Total     []int64              `json:"total"` // Including transfers that have finished
+}
+
+var (
+	meterMu gosync.Mutex
+	meters  = map[*Meter]bool{}
+	total   ring
+)
+
+// StartMeter starts recording the throughput of a transfer until Stop
+func StartMeter(id, source, direction, file string) *Meter {
+	m := &Meter{id: id, source: source, direction: direction, file: file, startedAt: time.Now()}
+	meterMu.Lock()
+	meters[m] = true
+	meterMu.Unlock()
+	return m
+}
+
+// Add records n bytes moved now. A nil meter records nothing.
+func (m *Meter) Add(n int64) {
+	if m == nil || n <= 0 {
+		return
+	}
+	now := time.Now()
+	meterMu.Lock()
+	m.ring.add(now, n)
+	total.add(now, n)
+	meterMu.Unlock()
+}
+
+// Stop removes the transfer from snapshots; its bytes stay in the total
+func (m *Meter) Stop() {
+	if m == nil {
+		return
+	}
+	meterMu.Lock()
+	delete(meters, m)
+	meterMu.Unlock()
+}
+
+// Throughput returns the samples of the active transfers and the total
+func Throughput() ThroughputSnapshot {
+	now := time.Now()
+	end := now.Unix()
+
+	meterMu.Lock()
+	defer meterMu.Unlock()
+
+	snapshot := ThroughputSnapshot{
+		At:        time.Unix(end, 0),
+		Transfers: make([]TransferThroughput, 0, len(meters)),
+		Total:     total.samples(end),
+	}
+	for m := range meters {
+		snapshot.Transfers = append(snapshot.Transfers, TransferThroughput{
+			ID:        m.id,
+			Source:    m.source,
+			Direction: m.direction,
+			File:      m.file,
+			StartedAt: m.startedAt,
+			Samples:   m.ring.samples(end),
+		})
+	}
+	sort.Slice(snapshot.Transfers, func(i, j int) bool {
+		return snapshot.Transfers[i].StartedAt.Before(snapshot.Transfers[j].StartedAt)
+	})
+	return snapshot
+}
+
+// WatchThroughput sends a snapshot every second until ctx is done, e.g. to
+// push to a WebSocket. Snapshots a slow reader has not taken are dropped.
+func WatchThroughput(ctx context.Context) <-chan ThroughputSnapshot {
+	ch := make(chan ThroughputSnapshot, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				select {
+				case ch <- Throughput():
+				default:
+				}
+			}
+		}
+	}()
+	return ch
+}
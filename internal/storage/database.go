@@ -36,10 +36,40 @@ func Init(dbPath string) error {
 		&models.TransferHistory{},
 		&models.AccountInfo{},
 		&models.SearchIndex{},
+		&models.Setting{},
+		&models.JournalOperation{},
+		&models.JournalStep{},
+		&models.MirrorJob{},
+		&models.BackupRun{},
+		&models.LegalHold{},
+		&models.MasterCredential{},
+		&models.FileLock{},
+		&models.SearchRoot{},
+		&models.DirectoryStats{},
+		&models.CacheEntry{},
+		&models.FileTag{},
+		&models.FileUsage{},
+		&models.RemoteFile{},
+		&models.Approval{},
+		&models.AuditEvent{},
+		&models.EscrowedKey{},
+		&models.TrustedKey{},
+		&models.CourierBundle{},
+		&models.CourierFile{},
+		&models.AirDropSession{},
+		&models.DirectoryPin{},
+		&models.APIToken{},
 	); err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// Devices used to be unique by peer ID alone, which kept them to one account
+	if db.Migrator().HasIndex(&models.PairedDevice{}, "idx_paired_devices_peer_id") {
+		if err := db.Migrator().DropIndex(&models.PairedDevice{}, "idx_paired_devices_peer_id"); err != nil {
+			return fmt.Errorf("failed to migrate paired devices: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+var ErrUnknownAccount = errors.New("unknown account")
+
+// AccountNamespace is the DHT namespace an account's devices advertise
+// under. It is derived from the account ID so the ID itself is never
+// published.
+func AccountNamespace(accountID string) string {
+	sum := sha256.Sum256([]byte("sfm-account:" + accountID))
+	return "/sfm/account/" + hex.EncodeToString(sum[:16])
+}
+
+// CreateAccount starts a new account on this device, e.g. a family mesh
+// next to a personal one. Devices join it with PairIntoAccount.
+func (pm *PairingManager) CreateAccount(name string) (*models.AccountInfo, error) {
+	if name == "" {
+		return nil, fmt.Errorf("account name is required")
+	}
+	var count int64
+	storage.DB().Model(&models.AccountInfo{}).Where("name = ?", name).Count(&count)
+	if count > 0 {
+		return nil, fmt.Errorf("account %q already exists", name)
+	}
+
+	id, err := newAccountID()
+	if err != nil {
+		return nil, err
+	}
+	return ensureAccount(pm.node, id, name)
+}
+
+func newAccountID() (string, error) {
+	id := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate account ID: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(id), nil
+}
+
+// Accounts lists the accounts this device takes part in
+func Accounts() ([]models.AccountInfo, error) {
+	var accounts []models.AccountInfo
+	if err := storage.DB().Order("name").Find(&accounts).Error; err != nil {
+		return nil, fmt.Errorf("failed to load accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// ResolveAccount returns the ID of the account with the given name or ID
+func ResolveAccount(nameOrID string) (string, error) {
+	var account models.AccountInfo
+	err := storage.DB().Where("account_id = ? OR name = ?", nameOrID, nameOrID).First(&account).Error
+	if err != nil {
+		return "", ErrUnknownAccount
+	}
+	return account.AccountID, nil
+}
+
+// LeaveAccount forgets an account and unpairs the devices paired in it.
+// Devices also paired in other accounts stay paired there.
+func (pm *PairingManager) LeaveAccount(accountID string) error {
+	db := storage.DB()
+	if err := db.Where("account_id = ?", accountID).Delete(&models.PairedDevice{}).Error; err != nil {
+		return fmt.Errorf("failed to unpair account devices: %w", err)
+	}
+	if err := db.Where("account_id = ?", accountID).Delete(&models.AccountInfo{}).Error; err != nil {
+		return fmt.Errorf("failed to remove account: %w", err)
+	}
+	return nil
+}
+
+// ensureAccount records that this device takes part in an account, naming
+// it if it has no name yet
+func ensureAccount(node *P2PNode, accountID, name string) (*models.AccountInfo, error) {
+	pubKey, err := crypto.MarshalPublicKey(node.GetPrivateKey().GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	db := storage.DB()
+	var account models.AccountInfo
+	err = db.Where("account_id = ?", accountID).Attrs(models.AccountInfo{
+		DeviceName: "Local Device",
+		PeerID:     node.GetPeerID().String(),
+		PrivateKey: []byte{}, // The device key stays in the data directory
+		PublicKey:  pubKey,
+	}).FirstOrCreate(&account, models.AccountInfo{AccountID: accountID}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to save account: %w", err)
+	}
+
+	if account.Name == "" && name != "" {
+		if err := db.Model(&account).Update("name", name).Error; err != nil {
+			return nil, fmt.Errorf("failed to name account: %w", err)
+		}
+	}
+	return &account, nil
+}
+
+func pairedInAccount(peerID, accountID string) bool {
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).
+		Where("peer_id = ? AND account_id = ?", peerID, accountID).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/owner/secure-file-manager/internal/status"
+)
+
+var (
+	ErrNoTransfer     = errors.New("no such transfer")
+	ErrTransferKilled = errors.New("transfer was killed")
+)
+
+// TransferInfo describes a file transfer in progress
+type TransferInfo struct {
+	ID          string    `json:"id"`
+	PeerID      string    `json:"peer_id"`
+	Direction   string    `json:"direction"` // send or receive
+	File        string    `json:"file"`
+	Size        int64     `json:"size"`
+	Transferred int64     `json:"transferred"`
+	Rate        float64   `json:"rate"` // Bytes per second since it started
+	StartedAt   time.Time `json:"started_at"`
+}
+
+type activeTransfer struct {
+	info        TransferInfo
+	transferred atomic.Int64
+	killed      atomic.Bool
+	stream      network.Stream // Reset to kill the transfer, nil until opened
+	meter       *status.Meter
+}
+
+// track registers a transfer until the returned function is called
+func (tm *TransferManager) track(peerID, direction, file string, size int64, stream network.Stream) (*activeTransfer, func()) {
+	t := &activeTransfer{
+		info: TransferInfo{
+			ID:        uuid.New().String(),
+			PeerID:    peerID,
+			Direction: direction,
+			File:      file,
+			Size:      size,
+			StartedAt: time.Now(),
+		},
+		stream: stream,
+	}
+	t.meter = status.StartMeter(t.info.ID, "sync", direction, file)
+	tm.transfersMu.Lock()
+	if tm.transfers == nil {
+		tm.transfers = make(map[string]*activeTransfer)
+	}
+	tm.transfers[t.info.ID] = t
+	tm.transfersMu.Unlock()
+
+	return t, func() {
+		tm.transfersMu.Lock()
+		delete(tm.transfers, t.info.ID)
+		tm.transfersMu.Unlock()
+		t.meter.Stop()
+	}
+}
+
+// progress records bytes moved, failing once the transfer is killed
+func (t *activeTransfer) progress(transferred int64) error {
+	t.meter.Add(transferred - t.transferred.Swap(transferred))
+	if t.killed.Load() {
+		return ErrTransferKilled
+	}
+	return nil
+}
+
+// ActiveTransfers lists the file transfers in progress, oldest first
+func (tm *TransferManager) ActiveTransfers() []TransferInfo {
+	tm.transfersMu.Lock()
+	defer tm.transfersMu.Unlock()
+
+	now := time.Now()
+	transfers := make([]TransferInfo, 0, len(tm.transfers))
+	for _, t := range tm.transfers {
+		info := t.info
+		info.Transferred = t.transferred.Load()
+		if elapsed := now.Sub(info.StartedAt).Seconds(); elapsed > 0 {
+			info.Rate = float64(info.Transferred) / elapsed
+		}
+		transfers = append(transfers, info)
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].StartedAt.Before(transfers[j].StartedAt)
+	})
+	return transfers
+}
+
+// Kill aborts a transfer in progress. Its stream is reset, so the peer
+// sees it fail, and a partly received file is removed.
+func (tm *TransferManager) Kill(id string) error {
+	tm.transfersMu.Lock()
+	t, ok := tm.transfers[id]
+	tm.transfersMu.Unlock()
+
+	if !ok {
+		return ErrNoTransfer
+	}
+	t.killed.Store(true)
+	if t.stream != nil {
+		t.stream.Reset()
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/quarantine"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+)
+
+const (
+	AppendProtocolID = "/sfm/append/1.1.0"
+
+	appendModeTail byte = 1 // Receiver's copy is a prefix, only the tail follows
+	appendModeFull byte = 2 // Prefix diverged, the whole file follows
+)
+
+// RegisterAppendHandler registers the append-only sync protocol handler
+func (tm *TransferManager) RegisterAppendHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(AppendProtocolID), tm.handleIncomingAppend)
+}
+
+// SendAppend syncs a growing file (logs, mbox, WAL files) to a peer.
+// If the peer's copy is an unchanged prefix of the local file only the
+// appended tail is transferred, otherwise the whole file is resent.
+// Returns the number of file bytes actually sent. Files covered by the
+// approval policy are only sent once approved, as for SendFile.
+func (tm *TransferManager) SendAppend(ctx context.Context, peerID peer.ID, filePath string) (sentBytes int64, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "sync.append", telemetry.AttrPeer.String(peerID.String()))
+	defer func() {
+		span.SetAttributes(telemetry.AttrBytes.Int64(sentBytes))
+		telemetry.EndSpan(span, err)
+	}()
+
+	approval, err := tm.approveSend(ctx, peerID, filePath)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if err := checkApproved(approval, fileInfo); err != nil {
+		return 0, err
+	}
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(AppendProtocolID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	writer := bufio.NewWriter(stream)
+
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Send encrypted metadata: filename, file size
+	if err := writeFileHeader(writer, key, filepath.Base(filePath), fileInfo.Size()); err != nil {
+		return 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	// Receive the size and prefix hash of the peer's copy
+	var remoteSize int64
+	if err := binary.Read(reader, binary.LittleEndian, &remoteSize); err != nil {
+		return 0, fmt.Errorf("failed to read remote size: %w", err)
+	}
+	remoteHash := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(reader, remoteHash); err != nil {
+		return 0, fmt.Errorf("failed to read remote hash: %w", err)
+	}
+
+	// Compare against the same-length prefix of the local file
+	mode := appendModeFull
+	offset := int64(0)
+	if remoteSize > 0 && remoteSize <= fileInfo.Size() {
+		localHash, err := hashPrefix(file, remoteSize)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(localHash, remoteHash) {
+			mode = appendModeTail
+			offset = remoteSize
+		}
+	}
+	if mode == appendModeTail {
+		span.SetAttributes(telemetry.AttrMode.String("tail"))
+	} else {
+		span.SetAttributes(telemetry.AttrMode.String("full"))
+	}
+
+	if err := writer.WriteByte(mode); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(writer, binary.LittleEndian, offset); err != nil {
+		return 0, err
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	toSend := fileInfo.Size() - offset
+	sent := int64(0)
+	buffer := make([]byte, ChunkSize)
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+
+	for sent < toSend {
+		n, err := file.Read(buffer)
+		if err != nil && err != io.EOF {
+			return sent, fmt.Errorf("failed to read file: %w", err)
+		}
+		if n == 0 {
+			break
+		}
+		// Don't send data appended after we stat'ed the file
+		if int64(n) > toSend-sent {
+			n = int(toSend - sent)
+		}
+
+		encrypted, err := crypto.Encrypt(buffer[:n], key)
+		if err != nil {
+			return sent, fmt.Errorf("failed to encrypt chunk: %w", err)
+		}
+
+		if err := binary.Write(writer, binary.LittleEndian, uint32(len(encrypted))); err != nil {
+			return sent, err
+		}
+		if _, err := writer.Write(encrypted); err != nil {
+			return sent, err
+		}
+
+		sent += int64(n)
+		tm.reportFile(ctx, "", filePath, sent, toSend)
+		if err := flow.Wait(ctx, n); err != nil {
+			return sent, err
+		}
+	}
+
+	// Send checksum of the complete file so the receiver can verify the result
+	fullHash, err := hashPrefix(file, fileInfo.Size())
+	if err != nil {
+		return sent, err
+	}
+	if _, err := writer.Write(fullHash); err != nil {
+		return sent, err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return sent, err
+	}
+
+	tm.recordTransfer(peerID.String(), filePath, sent, "send", "completed", hex.EncodeToString(fullHash))
+
+	return sent, nil
+}
+
+func (tm *TransferManager) handleIncomingAppend(stream network.Stream) {
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	writer := bufio.NewWriter(stream)
+
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	// Read metadata
+	header, err := readFileHeader(reader, key)
+	if err != nil {
+		return
+	}
+	filename := header.Name
+	fileSize := header.Size
+
+	if err := os.MkdirAll(tm.downloadDir, 0755); err != nil {
+		return
+	}
+	outputPath := filepath.Join(tm.downloadDir, filename)
+
+	// Worst case the whole file is resent
+	reservation, err := diskspace.Reserve(tm.downloadDir, fileSize)
+	if err != nil {
+		return
+	}
+	defer reservation.Release()
+
+	outFile, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer outFile.Close()
+
+	// Report our current size and hash so the sender can detect pure growth
+	localInfo, err := outFile.Stat()
+	if err != nil {
+		return
+	}
+	localHash, err := hashPrefix(outFile, localInfo.Size())
+	if err != nil {
+		return
+	}
+	if err := binary.Write(writer, binary.LittleEndian, localInfo.Size()); err != nil {
+		return
+	}
+	if _, err := writer.Write(localHash); err != nil {
+		return
+	}
+	if err := writer.Flush(); err != nil {
+		return
+	}
+
+	mode, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+	var offset int64
+	if err := binary.Read(reader, binary.LittleEndian, &offset); err != nil {
+		return
+	}
+
+	switch mode {
+	case appendModeTail:
+		if offset != localInfo.Size() {
+			return
+		}
+	case appendModeFull:
+		offset = 0
+	default:
+		return
+	}
+
+	if err := outFile.Truncate(offset); err != nil {
+		return
+	}
+	if _, err := outFile.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	received := int64(0)
+	toReceive := fileSize - offset
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+
+	for received < toReceive {
+		var chunkSize uint32
+		if err := binary.Read(reader, binary.LittleEndian, &chunkSize); err != nil {
+			return
+		}
+
+		encryptedChunk := make([]byte, chunkSize)
+		if _, err := io.ReadFull(reader, encryptedChunk); err != nil {
+			return
+		}
+
+		decrypted, err := crypto.Decrypt(encryptedChunk, key)
+		if err != nil {
+			return
+		}
+
+		if _, err := outFile.Write(decrypted); err != nil {
+			return
+		}
+
+		received += int64(len(decrypted))
+		tm.reportFile(context.Background(), "", outputPath, received, toReceive)
+		flow.Wait(context.Background(), len(decrypted))
+	}
+
+	// Verify checksum of the assembled file
+	expectedChecksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(reader, expectedChecksum); err != nil {
+		return
+	}
+
+	actualChecksum, err := hashPrefix(outFile, fileSize)
+	if err != nil || !bytes.Equal(expectedChecksum, actualChecksum) {
+		// Never leave a corrupted file behind: drop a bad tail and keep the
+		// verified prefix, or quarantine a bad full copy
+		if mode == appendModeTail {
+			outFile.Truncate(offset)
+		} else {
+			outFile.Close()
+			quarantine.Discard(outputPath, quarantine.ReasonChecksum, "sync", stream.Conn().RemotePeer().String())
+		}
+		return
+	}
+
+	peerID := stream.Conn().RemotePeer().String()
+	tm.recordTransfer(peerID, outputPath, received, "receive", "completed", hex.EncodeToString(actualChecksum))
+}
+
+// hashPrefix computes SHA256 of the first n bytes of a file
+func hashPrefix(file *os.File, n int64) ([]byte, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(file, 0, n)); err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hasher.Sum(nil), nil
+}
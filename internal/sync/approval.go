@@ -0,0 +1,500 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/audit"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const (
+	ApprovalProtocolID = "/sfm/approval/1.0.0"
+
+	// DefaultApprovalTimeout is how long a transfer waits for approval
+	DefaultApprovalTimeout = 10 * time.Minute
+
+	// maxApprovalTimeout caps how long a request from a peer stays pending
+	maxApprovalTimeout = 24 * time.Hour
+
+	approvalMessageTimeout = 10 * time.Second
+)
+
+// Approval protocol operations
+const (
+	approvalOpRequest  = "request"
+	approvalOpDecision = "decision"
+)
+
+// Approval statuses
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+	ApprovalExpired  = "expired"
+
+	approvalDeciding = "deciding" // Decision being sent to the requester
+)
+
+// Audit log actions
+const (
+	AuditApprovalRequested = "approval.requested"
+	AuditApprovalReceived  = "approval.received"
+	AuditApprovalGranted   = "approval.granted"
+	AuditApprovalDenied    = "approval.denied"
+	AuditApprovalExpired   = "approval.expired"
+	AuditDeviceTrusted     = "device.trusted"
+	AuditDeviceUntrusted   = "device.untrusted"
+)
+
+var (
+	ErrApprovalDenied  = errors.New("transfer was denied by the approving device")
+	ErrApprovalExpired = errors.New("transfer was not approved in time")
+	ErrNoApprovers     = errors.New("no other trusted device is connected to approve the transfer")
+	ErrApprovalChanged = errors.New("file changed after it was approved")
+)
+
+// ApprovalPolicy selects the files that may only be sent once another
+// trusted device confirms the transfer
+type ApprovalPolicy struct {
+	Tags    []string // Files with any of these tags, e.g. "restricted"
+	Folders []string // Files below any of these folders
+	Timeout time.Duration
+}
+
+type approvalMessage struct {
+	Op            string    `json:"op"`
+	ID            string    `json:"id"`
+	Path          string    `json:"path,omitempty"`
+	Size          int64     `json:"size,omitempty"`
+	Hash          string    `json:"hash,omitempty"`
+	Recipient     string    `json:"recipient,omitempty"`
+	RecipientName string    `json:"recipient_name,omitempty"`
+	ExpiresAt     time.Time `json:"expires_at,omitempty"`
+	Approved      bool      `json:"approved,omitempty"`
+}
+
+type approvalResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// pendingApproval is an outgoing request waiting for a decision
+type pendingApproval struct {
+	approvers map[peer.ID]bool
+	decision  chan approvalDecision
+}
+
+type approvalDecision struct {
+	approver peer.ID
+	approved bool
+}
+
+// RegisterApprovalHandler registers the approval protocol handler
+func (tm *TransferManager) RegisterApprovalHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(ApprovalProtocolID), tm.handleApprovalMessage)
+}
+
+// SetApprovalPolicy sets which files need a second device's approval before
+// SendFile sends them
+func (tm *TransferManager) SetApprovalPolicy(policy ApprovalPolicy) {
+	if policy.Timeout <= 0 {
+		policy.Timeout = DefaultApprovalTimeout
+	}
+	folders := make([]string, len(policy.Folders))
+	for i, folder := range policy.Folders {
+		folders[i] = filepath.Clean(folder)
+	}
+	policy.Folders = folders
+	tm.approvalMu.Lock()
+	defer tm.approvalMu.Unlock()
+	tm.sendPolicy = policy
+}
+
+// SetDeviceTrusted marks a paired device as allowed, or no longer allowed,
+// to approve restricted transfers
+func (tm *TransferManager) SetDeviceTrusted(peerID peer.ID, trusted bool) error {
+	result := storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", peerID.String()).Update("trusted", trusted)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save device trust: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("device not paired: %s", peerID)
+	}
+
+	action := AuditDeviceTrusted
+	if !trusted {
+		action = AuditDeviceUntrusted
+	}
+	tm.audit(action, tm.node.GetPeerID().String(), peerID.String(), pairedDeviceName(peerID.String()))
+	return nil
+}
+
+// PendingApprovals returns the requests from other devices waiting for this
+// device's decision
+func PendingApprovals() ([]models.Approval, error) {
+	var approvals []models.Approval
+	err := storage.DB().Where("outgoing = ? AND status = ? AND expires_at > ?", false, ApprovalPending, time.Now()).
+		Order("created_at").Find(&approvals).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load approvals: %w", err)
+	}
+	return approvals, nil
+}
+
+// DecideApproval approves or denies a request from another device and sends
+// the decision back to it
+func (tm *TransferManager) DecideApproval(ctx context.Context, requestID string, approve bool) error {
+	var approval models.Approval
+	err := storage.DB().Where("request_id = ? AND outgoing = ? AND status = ?", requestID, false, ApprovalPending).
+		First(&approval).Error
+	if err != nil {
+		return fmt.Errorf("no pending approval %s: %w", requestID, err)
+	}
+	if time.Now().After(approval.ExpiresAt) {
+		tm.finishApproval(&approval, ApprovalExpired, "")
+		return ErrApprovalExpired
+	}
+
+	requester, err := peer.Decode(approval.PeerID)
+	if err != nil {
+		return fmt.Errorf("invalid requesting device: %w", err)
+	}
+
+	// Claim the request first so it cannot be decided twice
+	db := storage.DB()
+	claim := db.Model(&approval).Where("status = ?", ApprovalPending).Update("status", approvalDeciding)
+	if claim.Error != nil {
+		return fmt.Errorf("failed to save approval: %w", claim.Error)
+	}
+	if claim.RowsAffected == 0 {
+		return fmt.Errorf("approval %s was already decided", requestID)
+	}
+	if err := tm.sendApprovalMessage(ctx, requester, approvalMessage{Op: approvalOpDecision, ID: requestID, Approved: approve}); err != nil {
+		db.Model(&approval).Update("status", ApprovalPending)
+		return err
+	}
+
+	status := ApprovalDenied
+	if approve {
+		status = ApprovalApproved
+	}
+	tm.finishApproval(&approval, status, tm.node.GetPeerID().String())
+	return nil
+}
+
+// currentApprovalPolicy returns the policy in effect
+func (tm *TransferManager) currentApprovalPolicy() ApprovalPolicy {
+	tm.approvalMu.Lock()
+	defer tm.approvalMu.Unlock()
+	return tm.sendPolicy
+}
+
+// needsApproval reports whether the policy covers a file
+func needsApproval(policy ApprovalPolicy, filePath string) (bool, error) {
+	for _, folder := range policy.Folders {
+		if rel, err := filepath.Rel(folder, filePath); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true, nil
+		}
+	}
+	if len(policy.Tags) == 0 {
+		return false, nil
+	}
+	var count int64
+	err := storage.DB().Model(&models.FileTag{}).Where("path = ? AND tag IN ?", filePath, policy.Tags).Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check tags: %w", err)
+	}
+	return count > 0, nil
+}
+
+// approveSend asks the other connected trusted devices to approve sending a
+// file to recipient if the policy covers it, and waits for the first
+// decision. It returns the approved request, or nil if none was needed.
+func (tm *TransferManager) approveSend(ctx context.Context, recipient peer.ID, filePath string) (*models.Approval, error) {
+	filePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+	policy := tm.currentApprovalPolicy()
+	needed, err := needsApproval(policy, filePath)
+	if err != nil || !needed {
+		return nil, err
+	}
+
+	local := tm.node.GetPeerID().String()
+	approvers := make(map[peer.ID]bool)
+	for _, peerID := range tm.connectedPairedPeers() {
+		if peerID != recipient && deviceTrusted(peerID.String()) {
+			approvers[peerID] = true
+		}
+	}
+	if len(approvers) == 0 {
+		tm.audit(AuditApprovalDenied, local, filePath, ErrNoApprovers.Error())
+		return nil, ErrNoApprovers
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	hash, err := fileSHA256(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	approval := models.Approval{
+		RequestID:     uuid.New().String(),
+		Outgoing:      true,
+		FilePath:      filePath,
+		Size:          info.Size(),
+		ModTime:       info.ModTime(),
+		Hash:          hash,
+		Recipient:     recipient.String(),
+		RecipientName: pairedDeviceName(recipient.String()),
+		Status:        ApprovalPending,
+		ExpiresAt:     time.Now().Add(policy.Timeout),
+	}
+	if err := storage.DB().Create(&approval).Error; err != nil {
+		return nil, fmt.Errorf("failed to save approval request: %w", err)
+	}
+
+	pending := &pendingApproval{approvers: approvers, decision: make(chan approvalDecision, 1)}
+	tm.approvalMu.Lock()
+	tm.approvals[approval.RequestID] = pending
+	tm.approvalMu.Unlock()
+	defer func() {
+		tm.approvalMu.Lock()
+		delete(tm.approvals, approval.RequestID)
+		tm.approvalMu.Unlock()
+	}()
+
+	request := approvalMessage{
+		Op:            approvalOpRequest,
+		ID:            approval.RequestID,
+		Path:          filePath,
+		Size:          approval.Size,
+		Hash:          hash,
+		Recipient:     approval.Recipient,
+		RecipientName: approval.RecipientName,
+		ExpiresAt:     approval.ExpiresAt,
+	}
+	asked := 0
+	for peerID := range approvers {
+		if err := tm.sendApprovalMessage(ctx, peerID, request); err != nil {
+			log.Printf("Failed to request approval from %s: %v", logging.Fingerprint(peerID.String()), err)
+			continue
+		}
+		asked++
+	}
+	if asked == 0 {
+		tm.finishApproval(&approval, ApprovalDenied, "")
+		return nil, ErrNoApprovers
+	}
+	tm.audit(AuditApprovalRequested, local, filePath,
+		fmt.Sprintf("request %s to send to %s, approvers asked: %d", approval.RequestID, approval.RecipientName, asked))
+
+	timer := time.NewTimer(policy.Timeout)
+	defer timer.Stop()
+	select {
+	case decision := <-pending.decision:
+		if !decision.approved {
+			tm.finishApproval(&approval, ApprovalDenied, decision.approver.String())
+			return nil, ErrApprovalDenied
+		}
+		tm.finishApproval(&approval, ApprovalApproved, decision.approver.String())
+		return &approval, nil
+	case <-timer.C:
+		tm.finishApproval(&approval, ApprovalExpired, "")
+		return nil, ErrApprovalExpired
+	case <-ctx.Done():
+		tm.finishApproval(&approval, ApprovalExpired, "")
+		return nil, ctx.Err()
+	}
+}
+
+// checkApproved returns ErrApprovalChanged if a file is no longer the one
+// an approval was given for. A nil approval means none was needed.
+func checkApproved(approval *models.Approval, info os.FileInfo) error {
+	if approval != nil && (info.Size() != approval.Size || !info.ModTime().Equal(approval.ModTime)) {
+		return ErrApprovalChanged
+	}
+	return nil
+}
+
+// isApprovalError reports whether err is the approval policy refusing a
+// send, which no other way of delivering the file may get around
+func isApprovalError(err error) bool {
+	return errors.Is(err, ErrApprovalDenied) || errors.Is(err, ErrApprovalExpired) ||
+		errors.Is(err, ErrNoApprovers) || errors.Is(err, ErrApprovalChanged)
+}
+
+// finishApproval records the outcome of a request and audits it
+func (tm *TransferManager) finishApproval(approval *models.Approval, status, decidedBy string) {
+	approval.Status = status
+	approval.DecidedBy = decidedBy
+	if err := storage.DB().Save(approval).Error; err != nil {
+		log.Printf("Failed to save approval %s: %v", approval.RequestID, err)
+	}
+
+	action := AuditApprovalExpired
+	switch status {
+	case ApprovalApproved:
+		action = AuditApprovalGranted
+	case ApprovalDenied:
+		action = AuditApprovalDenied
+	}
+	actor := decidedBy
+	if actor == "" {
+		actor = tm.node.GetPeerID().String()
+	}
+	tm.audit(action, actor, approval.FilePath, fmt.Sprintf("request %s to send to %s", approval.RequestID, approval.RecipientName))
+}
+
+func (tm *TransferManager) audit(action, actor, target, detail string) {
+	if err := audit.Record(action, actor, target, detail); err != nil {
+		log.Printf("Failed to audit %s: %v", action, err)
+	}
+}
+
+func (tm *TransferManager) sendApprovalMessage(ctx context.Context, peerID peer.ID, message approvalMessage) error {
+	ctx, cancel := context.WithTimeout(ctx, approvalMessageTimeout)
+	defer cancel()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(ApprovalProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return err
+	}
+
+	if err := writeEncryptedJSON(stream, key, message); err != nil {
+		return err
+	}
+
+	var response approvalResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &response); err != nil {
+		return fmt.Errorf("failed to read approval response: %w", err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("peer refused approval message: %s", response.Error)
+	}
+	return nil
+}
+
+func (tm *TransferManager) handleApprovalMessage(stream network.Stream) {
+	defer stream.Close()
+
+	remote := stream.Conn().RemotePeer()
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	var message approvalMessage
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &message); err != nil {
+		return
+	}
+
+	respond := func(response approvalResponse) {
+		if err := writeEncryptedJSON(stream, key, response); err != nil {
+			log.Printf("Failed to answer approval message from %s: %v", logging.Fingerprint(remote.String()), err)
+		}
+	}
+
+	if !isPairedPeer(remote.String()) {
+		respond(approvalResponse{Error: "not paired"})
+		return
+	}
+
+	switch message.Op {
+	case approvalOpRequest:
+		if message.ID == "" || message.Path == "" {
+			respond(approvalResponse{Error: "invalid request"})
+			return
+		}
+		approval := models.Approval{
+			RequestID:     message.ID,
+			PeerID:        remote.String(),
+			FilePath:      message.Path,
+			Size:          message.Size,
+			Hash:          message.Hash,
+			Recipient:     message.Recipient,
+			RecipientName: message.RecipientName,
+			Status:        ApprovalPending,
+			ExpiresAt:     capApprovalExpiry(message.ExpiresAt),
+		}
+		if err := storage.DB().Create(&approval).Error; err != nil {
+			respond(approvalResponse{Error: "failed to store request"})
+			return
+		}
+		tm.audit(AuditApprovalReceived, remote.String(), message.Path,
+			fmt.Sprintf("request %s from %s to send to %s", message.ID, pairedDeviceName(remote.String()), message.RecipientName))
+		log.Printf("%s asks to approve sending %s to %s", pairedDeviceName(remote.String()), logging.Path(message.Path), message.RecipientName)
+		respond(approvalResponse{})
+
+	case approvalOpDecision:
+		tm.approvalMu.Lock()
+		pending := tm.approvals[message.ID]
+		tm.approvalMu.Unlock()
+		// Only a trusted device that was asked may decide
+		if pending == nil || !pending.approvers[remote] || !deviceTrusted(remote.String()) {
+			respond(approvalResponse{Error: "unknown request"})
+			return
+		}
+		select {
+		case pending.decision <- approvalDecision{approver: remote, approved: message.Approved}:
+		default: // Another device decided first
+		}
+		respond(approvalResponse{})
+
+	default:
+		respond(approvalResponse{Error: "unknown operation"})
+	}
+}
+
+// capApprovalExpiry limits a peer-supplied expiry to maxApprovalTimeout
+// from now
+func capApprovalExpiry(expires time.Time) time.Time {
+	if limit := time.Now().Add(maxApprovalTimeout); expires.IsZero() || expires.After(limit) {
+		return limit
+	}
+	return expires
+}
+
+func deviceTrusted(peerID string) bool {
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ? AND trusted = ?", peerID, true).Count(&count)
+	return count > 0
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,215 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/owner/secure-file-manager/internal/compare"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+const catalogBatch = 500
+
+var ErrNotCataloged = errors.New("file is not in the availability catalog")
+
+// CatalogShare records the files of a paired peer's share in the
+// availability catalog, replacing what was recorded for it before. If
+// localDir is set, the share's files belong there locally and those
+// missing become placeholders. It returns the number of files recorded.
+func (tm *TransferManager) CatalogShare(ctx context.Context, peerID peer.ID, share, localDir string, opts compare.Options) (int, error) {
+	entries, err := tm.FetchManifest(ctx, peerID, share, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	files := make([]models.RemoteFile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir {
+			continue
+		}
+		file := models.RemoteFile{
+			PeerID:  peerID.String(),
+			Share:   share,
+			RelPath: entry.Path,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+			Hash:    entry.Hash,
+		}
+		if localDir != "" {
+			file.LocalPath = filepath.Join(localDir, filepath.FromSlash(entry.Path))
+		}
+		files = append(files, file)
+	}
+
+	err = storage.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("peer_id = ? AND share = ?", peerID.String(), share).Delete(&models.RemoteFile{}).Error; err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(files, catalogBatch).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to save catalog: %w", err)
+	}
+	return len(files), nil
+}
+
+// ForgetPeerCatalog drops everything cataloged for a peer, e.g. after
+// unpairing
+func ForgetPeerCatalog(peerID peer.ID) error {
+	if err := storage.DB().Where("peer_id = ?", peerID.String()).Delete(&models.RemoteFile{}).Error; err != nil {
+		return fmt.Errorf("failed to forget catalog: %w", err)
+	}
+	return nil
+}
+
+// Placeholders returns the cataloged files that belong below dir but are
+// not stored locally, one entry per local path
+func Placeholders(dir string) ([]models.RemoteFile, error) {
+	dir = filepath.Clean(dir)
+	prefix := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.TrimSuffix(dir, string(filepath.Separator)) + string(filepath.Separator))
+
+	var files []models.RemoteFile
+	err := storage.DB().Where(`local_path LIKE ? ESCAPE '\'`, prefix+"%").
+		Order("local_path, mod_time DESC").Find(&files).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load catalog: %w", err)
+	}
+
+	placeholders := files[:0]
+	for i, file := range files {
+		if i > 0 && file.LocalPath == files[i-1].LocalPath {
+			continue
+		}
+		if _, err := os.Stat(file.LocalPath); os.IsNotExist(err) {
+			placeholders = append(placeholders, file)
+		}
+	}
+	return placeholders, nil
+}
+
+// Materialize fetches a placeholder from a paired peer that has it, trying
+// connected peers and newer copies first. Files already present are left
+// alone.
+func (tm *TransferManager) Materialize(ctx context.Context, localPath string) error {
+	localPath = filepath.Clean(localPath)
+	if _, err := os.Stat(localPath); err == nil {
+		return nil
+	}
+
+	var sources []models.RemoteFile
+	if err := storage.DB().Where("local_path = ?", localPath).Order("mod_time DESC").Find(&sources).Error; err != nil {
+		return fmt.Errorf("failed to load catalog: %w", err)
+	}
+	if len(sources) == 0 {
+		return ErrNotCataloged
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return tm.connected(sources[i].PeerID) && !tm.connected(sources[j].PeerID)
+	})
+
+	var lastErr error
+	for _, source := range sources {
+		peerID, err := peer.Decode(source.PeerID)
+		if err != nil {
+			continue
+		}
+		lastErr = tm.MirrorGetFile(ctx, peerID, source.Share, source.RelPath, localPath)
+		if lastErr == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("failed to materialize %s: %w", localPath, lastErr)
+}
+
+// RepairFile replaces a local file with a copy from a paired peer whose
+// cataloged content has the given hex SHA-256. Only the blocks that differ
+// are fetched when the peer's copy has the same size; otherwise the whole
+// file is. The result is verified before it replaces the file, which keeps
+// its modification time. It fits scrub.RepairFunc.
+func (tm *TransferManager) RepairFile(ctx context.Context, localPath, hash string) error {
+	localPath = filepath.Clean(localPath)
+
+	var sources []models.RemoteFile
+	if err := storage.DB().Where("local_path = ? AND hash = ?", localPath, hash).Find(&sources).Error; err != nil {
+		return fmt.Errorf("failed to load catalog: %w", err)
+	}
+	if len(sources) == 0 {
+		return ErrNotCataloged
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return tm.connected(sources[i].PeerID) && !tm.connected(sources[j].PeerID)
+	})
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", localPath, err)
+	}
+	tmp := filepath.Join(filepath.Dir(localPath), ".sfm-repair-"+filepath.Base(localPath))
+	defer os.Remove(tmp)
+
+	var lastErr error
+	for _, source := range sources {
+		peerID, err := peer.Decode(source.PeerID)
+		if err != nil {
+			continue
+		}
+		fetched, blocks, err := tm.repairBlocks(ctx, peerID, source.Share, source.RelPath, localPath, tmp)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Peers from before block repair, or a copy of another size
+			fetched, blocks = -1, 0
+			if lastErr = tm.MirrorGetFile(ctx, peerID, source.Share, source.RelPath, tmp); lastErr != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				continue
+			}
+		}
+		// The peer's copy may have changed since it was cataloged
+		got, err := fileSHA256(tmp)
+		if err != nil {
+			return fmt.Errorf("failed to hash copy: %w", err)
+		}
+		if got != hash {
+			lastErr = fmt.Errorf("copy from %s no longer matches", pairedDeviceName(source.PeerID))
+			continue
+		}
+		if err := os.Rename(tmp, localPath); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", localPath, err)
+		}
+		// An unchanged mtime keeps the indexer from treating the repair as an edit
+		os.Chtimes(localPath, info.ModTime(), info.ModTime())
+
+		if fetched < 0 {
+			log.Printf("Repaired %s with the whole copy from %s", logging.Path(localPath), pairedDeviceName(source.PeerID))
+		} else {
+			log.Printf("Repaired %s from %s: fetched %d of %d blocks", logging.Path(localPath), pairedDeviceName(source.PeerID), fetched, blocks)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to repair %s: %w", localPath, lastErr)
+}
+
+func (tm *TransferManager) connected(peerID string) bool {
+	id, err := peer.Decode(peerID)
+	return err == nil && tm.node.host.Network().Connectedness(id) == network.Connected
+}
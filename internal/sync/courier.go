@@ -0,0 +1,574 @@
+package sync
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/owner/secure-file-manager/internal/compare"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/relay"
+	"github.com/owner/secure-file-manager/internal/removable"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+// Courier sync carries shared folder deltas on a removable drive between
+// devices with no usable network. The drive holds a relay mailbox: each
+// bundle is end-to-end encrypted to its target device and signed by its
+// sender, so a lost drive leaks only who syncs with whom.
+//
+// The sender queues the changes the target has not acknowledged yet. The
+// target applies them to its copy of the share and queues an
+// acknowledgement back, which the sender reads the next time the drive
+// returns. Until then every trip carries the unacknowledged changes again.
+const (
+	// CourierDir marks a drive for courier use and holds its mailbox
+	CourierDir = ".sfm-courier"
+	// CourierTTL is how long a bundle waits on a drive to be delivered
+	CourierTTL = 90 * 24 * time.Hour
+
+	courierBundlePrefix = "courier-"
+	courierBundleExt    = ".tar"
+	courierAckExt       = ".ack"
+	courierManifestName = "manifest.json"
+	courierFilesDir     = "files/"
+	maxCourierManifest  = 64 * 1024 * 1024
+)
+
+// Courier bundle statuses
+const (
+	CourierQueued       = "queued"
+	CourierAcknowledged = "acknowledged"
+	CourierFailed       = "failed"
+)
+
+// CourierTarget is a share to carry to a device on every trip
+type CourierTarget struct {
+	PeerID peer.ID
+	Share  string
+}
+
+// CourierResult describes what ingesting a drive did
+type CourierResult struct {
+	Bundles int // Applied to local shares
+	Files   int
+	Deleted int
+	Acks    int // Acknowledgements of bundles this device sent
+	Queued  int // Changes queued for targets
+}
+
+// courierManifest is the first entry of a bundle. The files follow under
+// files/, in the same order.
+type courierManifest struct {
+	ID      string          `json:"id"`
+	Share   string          `json:"share"`
+	Created time.Time       `json:"created"`
+	Entries []compare.Entry `json:"entries"`
+	Deleted []string        `json:"deleted,omitempty"`
+}
+
+type courierAck struct {
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// PrepareCourierDrive marks a drive for courier sync. Drives without the
+// mark are never written to.
+func PrepareCourierDrive(drivePath string) error {
+	if err := os.MkdirAll(filepath.Join(drivePath, CourierDir), 0700); err != nil {
+		return fmt.Errorf("failed to prepare courier drive: %w", err)
+	}
+	return nil
+}
+
+// IsCourierDrive reports whether a drive was prepared for courier sync
+func IsCourierDrive(drivePath string) bool {
+	info, err := os.Stat(filepath.Join(drivePath, CourierDir))
+	return err == nil && info.IsDir()
+}
+
+func (tm *TransferManager) courierMailbox(drivePath string) (*relay.Mailbox, error) {
+	if !IsCourierDrive(drivePath) {
+		return nil, fmt.Errorf("%s is not prepared for courier sync", logging.Path(drivePath))
+	}
+	backend, err := relay.NewDirBackend(filepath.Join(drivePath, CourierDir))
+	if err != nil {
+		return nil, err
+	}
+	return relay.NewMailbox(backend, tm.node.GetPrivateKey(), 0, CourierTTL)
+}
+
+// QueueCourier queues the changes to a share that a device has not
+// acknowledged onto a drive, and returns how many files and deletions the
+// bundle carries. Files that need approval to send are left out, since no
+// approving device can be reached offline.
+func (tm *TransferManager) QueueCourier(ctx context.Context, drivePath string, peerID peer.ID, share string) (int, error) {
+	mailbox, err := tm.courierMailbox(drivePath)
+	if err != nil {
+		return 0, err
+	}
+	root, ok := tm.sharedFolderFor(peerID.String(), share)
+	if !ok {
+		return 0, fmt.Errorf("unknown share %q for %s", share, logging.Fingerprint(peerID.String()))
+	}
+
+	manifest, err := tm.courierDelta(ctx, root, peerID, share)
+	if err != nil {
+		return 0, err
+	}
+	changes := len(manifest.Entries) + len(manifest.Deleted)
+	if changes == 0 {
+		return 0, nil
+	}
+
+	dir, err := os.MkdirTemp("", "sfm-courier-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	bundlePath := filepath.Join(dir, courierBundlePrefix+manifest.ID+courierBundleExt)
+	if err := writeCourierBundle(bundlePath, root, manifest); err != nil {
+		return 0, err
+	}
+	if info, err := os.Stat(bundlePath); err == nil {
+		reservation, err := diskspace.Reserve(drivePath, info.Size())
+		if err != nil {
+			return 0, err
+		}
+		defer reservation.Release()
+	}
+	if _, err := mailbox.Park(ctx, peerID, bundlePath); err != nil {
+		return 0, fmt.Errorf("failed to queue bundle: %w", err)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+	bundle := models.CourierBundle{
+		BundleID: manifest.ID,
+		PeerID:   peerID.String(),
+		Share:    share,
+		Manifest: data,
+		Changes:  changes,
+		Status:   CourierQueued,
+	}
+	if err := storage.DB().Create(&bundle).Error; err != nil {
+		return 0, fmt.Errorf("failed to record bundle: %w", err)
+	}
+	log.Printf("Queued %d change(s) to %s for %s by courier", changes, share, logging.Fingerprint(peerID.String()))
+	return changes, nil
+}
+
+// courierDelta compares a share with what a device acknowledged
+func (tm *TransferManager) courierDelta(ctx context.Context, root string, peerID peer.ID, share string) (*courierManifest, error) {
+	entries, err := compare.Scan(ctx, root, compare.Options{Hash: true, Exclude: []string{MirrorArchiveDir}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan share: %w", err)
+	}
+
+	var acked []models.CourierFile
+	if err := storage.DB().Where("peer_id = ? AND share = ?", peerID.String(), share).Find(&acked).Error; err != nil {
+		return nil, fmt.Errorf("failed to load courier state: %w", err)
+	}
+	known := make(map[string]models.CourierFile, len(acked))
+	for _, file := range acked {
+		known[file.RelPath] = file
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate bundle ID: %w", err)
+	}
+	manifest := &courierManifest{ID: hex.EncodeToString(id), Share: share, Created: time.Now().UTC()}
+
+	policy := tm.currentApprovalPolicy()
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		present[entry.Path] = true
+		if file, ok := known[entry.Path]; ok && file.IsDir == entry.IsDir && file.Hash == entry.Hash {
+			continue
+		}
+		if !entry.IsDir {
+			needed, err := needsApproval(policy, filepath.Join(root, filepath.FromSlash(entry.Path)))
+			if err != nil {
+				return nil, err
+			}
+			if needed {
+				log.Printf("Not queueing %s by courier, it needs approval", logging.Path(entry.Path))
+				continue
+			}
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+	}
+	for relPath := range known {
+		if !present[relPath] {
+			manifest.Deleted = append(manifest.Deleted, relPath)
+		}
+	}
+	sort.Strings(manifest.Deleted)
+	return manifest, nil
+}
+
+// writeCourierBundle writes the manifest and the files it lists to a tar
+func writeCourierBundle(bundlePath, root string, manifest *courierManifest) error {
+	file, err := os.OpenFile(bundlePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: courierManifestName, Mode: 0600, Size: int64(len(data))}); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	for _, entry := range manifest.Entries {
+		if entry.IsDir {
+			continue
+		}
+		if err := addCourierFile(tw, root, entry); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return file.Close()
+}
+
+func addCourierFile(tw *tar.Writer, root string, entry compare.Entry) error {
+	file, err := os.Open(filepath.Join(root, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.Path, err)
+	}
+	defer file.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    courierFilesDir + entry.Path,
+		Mode:    0600,
+		Size:    entry.Size,
+		ModTime: entry.ModTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	// The hash in the manifest was taken while scanning, the target checks it
+	if n, err := io.Copy(tw, io.LimitReader(file, entry.Size)); err != nil || n != entry.Size {
+		return fmt.Errorf("%s changed while queueing", entry.Path)
+	}
+	return nil
+}
+
+// IngestCourier applies the bundles a drive carries for this device and
+// queues their acknowledgements, and reads acknowledgements of bundles
+// this device sent
+func (tm *TransferManager) IngestCourier(ctx context.Context, drivePath string) (*CourierResult, error) {
+	mailbox, err := tm.courierMailbox(drivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "sfm-courier-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	deliveries, err := mailbox.Fetch(ctx, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read courier drive: %w", err)
+	}
+
+	result := &CourierResult{}
+	for _, delivery := range deliveries {
+		name := filepath.Base(delivery.Path)
+		sender := delivery.Sender.String()
+
+		switch {
+		case strings.HasSuffix(name, courierAckExt):
+			if err := recordCourierAck(sender, delivery.Path); err != nil {
+				log.Printf("Failed to read courier acknowledgement from %s: %v", logging.Fingerprint(sender), err)
+				continue
+			}
+			result.Acks++
+
+		case strings.HasSuffix(name, courierBundleExt):
+			ack := courierAck{ID: strings.TrimSuffix(strings.TrimPrefix(name, courierBundlePrefix), courierBundleExt)}
+			manifest, err := tm.applyCourierBundle(sender, delivery.Path)
+			if err != nil {
+				log.Printf("Failed to apply courier bundle from %s: %v", logging.Fingerprint(sender), err)
+				ack.Error = err.Error()
+			} else {
+				ack.ID = manifest.ID
+				result.Bundles++
+				result.Files += len(manifest.Entries)
+				result.Deleted += len(manifest.Deleted)
+			}
+			if err := queueCourierAck(ctx, mailbox, delivery.Sender, dir, ack); err != nil {
+				log.Printf("Failed to acknowledge courier bundle for %s: %v", logging.Fingerprint(sender), err)
+			}
+		}
+		os.Remove(delivery.Path)
+	}
+	return result, nil
+}
+
+// applyCourierBundle writes a bundle's files into the share it names and
+// removes its deletions. Files are checked against the manifest hashes.
+func (tm *TransferManager) applyCourierBundle(sender, bundlePath string) (*courierManifest, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	header, err := tr.Next()
+	if err != nil || header.Name != courierManifestName || header.Size > maxCourierManifest {
+		return nil, fmt.Errorf("bundle has no manifest")
+	}
+	var manifest courierManifest
+	if err := json.NewDecoder(io.LimitReader(tr, header.Size)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+
+	root, ok := tm.sharedFolderFor(sender, manifest.Share)
+	if !ok {
+		return nil, fmt.Errorf("unknown share %q", manifest.Share)
+	}
+	if !tm.shareWritable(sender, manifest.Share) {
+		return nil, fmt.Errorf("share %q is read-only", manifest.Share)
+	}
+
+	expected := make(map[string]compare.Entry, len(manifest.Entries))
+	for _, entry := range manifest.Entries {
+		target, err := sharePath(root, entry.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		if entry.IsDir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create %s: %w", entry.Path, err)
+			}
+			continue
+		}
+		expected[entry.Path] = entry
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		relPath := strings.TrimPrefix(header.Name, courierFilesDir)
+		entry, ok := expected[relPath]
+		if !ok || header.Name == relPath || header.Size != entry.Size {
+			return nil, fmt.Errorf("bundle holds unexpected entry %q", header.Name)
+		}
+		delete(expected, relPath)
+
+		target, _ := sharePath(root, relPath)
+		if err := receiveCourierFile(tr, target, entry); err != nil {
+			return nil, fmt.Errorf("%s: %w", relPath, err)
+		}
+	}
+	if len(expected) > 0 {
+		return nil, fmt.Errorf("bundle is missing %d file(s)", len(expected))
+	}
+
+	// Deepest first, so directories are empty by the time they are reached
+	deleted := append([]string(nil), manifest.Deleted...)
+	sort.Sort(sort.Reverse(sort.StringSlice(deleted)))
+	for _, relPath := range deleted {
+		target, err := sharePath(root, relPath)
+		if err != nil {
+			continue
+		}
+		// A directory that still holds local files is kept
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			log.Printf("Kept %s deleted by courier: %v", logging.Path(target), err)
+		}
+	}
+
+	log.Printf("Applied courier bundle from %s to %s: %d change(s)", logging.Fingerprint(sender),
+		manifest.Share, len(manifest.Entries)+len(manifest.Deleted))
+	return &manifest, nil
+}
+
+func receiveCourierFile(r io.Reader, target string, entry compare.Entry) error {
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	reservation, err := diskspace.Reserve(dir, entry.Size)
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
+
+	tmp, err := os.CreateTemp(dir, ".sfm-courier-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != entry.Hash {
+		return fmt.Errorf("checksum mismatch")
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	os.Chtimes(target, entry.ModTime, entry.ModTime)
+	return nil
+}
+
+func queueCourierAck(ctx context.Context, mailbox *relay.Mailbox, recipient peer.ID, dir string, ack courierAck) error {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	ackPath := filepath.Join(dir, courierBundlePrefix+filepath.Base(ack.ID)+courierAckExt)
+	if err := os.WriteFile(ackPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write acknowledgement: %w", err)
+	}
+	defer os.Remove(ackPath)
+	_, err = mailbox.Park(ctx, recipient, ackPath)
+	return err
+}
+
+// recordCourierAck marks a bundle delivered, and what it carried as known
+// to the device that acknowledged it
+func recordCourierAck(sender, ackPath string) error {
+	data, err := os.ReadFile(ackPath)
+	if err != nil {
+		return err
+	}
+	var ack courierAck
+	if err := json.Unmarshal(data, &ack); err != nil {
+		return fmt.Errorf("invalid acknowledgement: %w", err)
+	}
+
+	db := storage.DB()
+	var bundle models.CourierBundle
+	if err := db.Where("bundle_id = ? AND peer_id = ?", ack.ID, sender).First(&bundle).Error; err != nil {
+		return fmt.Errorf("unknown bundle %s", ack.ID)
+	}
+	if bundle.Status != CourierQueued {
+		return nil
+	}
+	if ack.Error != "" {
+		log.Printf("Courier bundle for %s failed: %s", logging.Fingerprint(sender), ack.Error)
+		return db.Model(&bundle).Updates(map[string]interface{}{"status": CourierFailed, "error": ack.Error}).Error
+	}
+
+	var manifest courierManifest
+	if err := json.Unmarshal(bundle.Manifest, &manifest); err != nil {
+		return fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	for _, entry := range manifest.Entries {
+		file := models.CourierFile{
+			PeerID:  sender,
+			Share:   bundle.Share,
+			RelPath: entry.Path,
+			IsDir:   entry.IsDir,
+			Size:    entry.Size,
+			ModTime: entry.ModTime,
+			Hash:    entry.Hash,
+		}
+		err := db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "peer_id"}, {Name: "share"}, {Name: "rel_path"}},
+			DoUpdates: clause.AssignmentColumns([]string{"is_dir", "size", "mod_time", "hash", "updated_at"}),
+		}).Create(&file).Error
+		if err != nil {
+			return fmt.Errorf("failed to record courier state: %w", err)
+		}
+	}
+	if len(manifest.Deleted) > 0 {
+		err := db.Where("peer_id = ? AND share = ? AND rel_path IN ?", sender, bundle.Share, manifest.Deleted).
+			Delete(&models.CourierFile{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to record courier state: %w", err)
+		}
+	}
+	return db.Model(&bundle).Update("status", CourierAcknowledged).Error
+}
+
+// CourierBundles returns the bundles queued for devices, newest first
+func CourierBundles() ([]models.CourierBundle, error) {
+	var bundles []models.CourierBundle
+	if err := storage.DB().Order("created_at DESC").Find(&bundles).Error; err != nil {
+		return nil, fmt.Errorf("failed to load courier bundles: %w", err)
+	}
+	return bundles, nil
+}
+
+// SyncCourierDrive ingests a drive, then queues the changes for every
+// target onto it
+func (tm *TransferManager) SyncCourierDrive(ctx context.Context, drivePath string, targets []CourierTarget) (*CourierResult, error) {
+	result, err := tm.IngestCourier(ctx, drivePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		n, err := tm.QueueCourier(ctx, drivePath, target.PeerID, target.Share)
+		if err != nil {
+			log.Printf("Failed to queue %s for %s by courier: %v", target.Share, logging.Fingerprint(target.PeerID.String()), err)
+			continue
+		}
+		result.Queued += n
+	}
+	return result, nil
+}
+
+// WatchCourierDrives syncs every removable drive prepared for courier use
+// when it is plugged in, polling every interval until ctx is done
+func (tm *TransferManager) WatchCourierDrives(ctx context.Context, interval time.Duration, targets []CourierTarget) {
+	removable.Watch(ctx, interval, func(drive removable.Drive) {
+		if !IsCourierDrive(drive.Path) {
+			return
+		}
+		result, err := tm.SyncCourierDrive(ctx, drive.Path, targets)
+		if err != nil {
+			log.Printf("Courier sync with %s failed: %v", logging.Path(drive.Path), err)
+			return
+		}
+		log.Printf("Courier sync with %s: applied %d bundle(s), %d acknowledgement(s), queued %d change(s)",
+			logging.Path(drive.Path), result.Bundles, result.Acks, result.Queued)
+	})
+}
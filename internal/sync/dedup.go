@@ -0,0 +1,269 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const (
+	DedupProtocolID = "/sfm/dedup/1.0.0"
+
+	// DefaultDedupMinSize is the smallest file offered by hash first;
+	// smaller files are cheaper to send than to look up
+	DefaultDedupMinSize = 1024 * 1024 // 1MB
+
+	// dedupTimeout covers the receiver re-hashing its candidate copies
+	dedupTimeout = 2 * time.Minute
+)
+
+// dedupRequest offers a file by content hash. Share and Path are set for
+// mirror puts; otherwise the file goes to the download directory as Name.
+type dedupRequest struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	Name    string    `json:"name,omitempty"`
+	Share   string    `json:"share,omitempty"`
+	Path    string    `json:"path,omitempty"`
+	ModTime time.Time `json:"mtime,omitempty"`
+}
+
+type dedupResponse struct {
+	Have  bool   `json:"have"` // The receiver copied the content locally
+	Error string `json:"error,omitempty"`
+}
+
+// RegisterDedupHandler registers the dedup protocol handler
+func (tm *TransferManager) RegisterDedupHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(DedupProtocolID), tm.handleDedupRequest)
+}
+
+// SetDedupMinSize sets the smallest file that is offered by content hash
+// before sending. 0 disables deduplication.
+func (tm *TransferManager) SetDedupMinSize(size int64) {
+	tm.dedupMinSize = size
+}
+
+// offerByHash asks the peer to materialize a file from identical content it
+// already has. It reports whether the peer did, in which case the bytes need
+// not be sent. Any failure, including a peer that does not speak the
+// protocol, just means the file is sent normally.
+func (tm *TransferManager) offerByHash(ctx context.Context, peerID peer.ID, localPath string, info os.FileInfo, request dedupRequest) bool {
+	if tm.dedupMinSize <= 0 || info.Size() < tm.dedupMinSize {
+		return false
+	}
+	hash, err := contentHash(localPath, info)
+	if err != nil {
+		log.Printf("Failed to hash %s for dedup: %v", logging.Path(localPath), err)
+		return false
+	}
+	request.Hash = hash
+	request.Size = info.Size()
+
+	ctx, cancel := context.WithTimeout(ctx, dedupTimeout)
+	defer cancel()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(DedupProtocolID))
+	if err != nil {
+		return false
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return false
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := writeEncryptedJSON(stream, key, request); err != nil {
+		return false
+	}
+	var response dedupResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &response); err != nil {
+		return false
+	}
+	if response.Error != "" {
+		log.Printf("Peer %s refused dedup offer: %s", logging.Fingerprint(peerID.String()), response.Error)
+	}
+	return response.Have
+}
+
+func (tm *TransferManager) handleDedupRequest(stream network.Stream) {
+	defer stream.Close()
+	defer tm.busy()()
+
+	remote := stream.Conn().RemotePeer().String()
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	var request dedupRequest
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &request); err != nil {
+		return
+	}
+
+	respond := func(response dedupResponse) {
+		if err := writeEncryptedJSON(stream, key, response); err != nil {
+			log.Printf("Failed to answer dedup offer from %s: %v", logging.Fingerprint(remote), err)
+		}
+	}
+
+	// Answering would tell any peer whether this device holds a given file
+	if !isPairedPeer(remote) {
+		respond(dedupResponse{Error: "not paired"})
+		return
+	}
+	if request.Hash == "" || request.Size < 0 {
+		respond(dedupResponse{Error: "invalid request"})
+		return
+	}
+
+	var target string
+	var modTime time.Time
+	if request.Share != "" {
+		_, path, err := tm.mirrorTarget(remote, mirrorRequest{Op: MirrorPut, Share: request.Share, Path: request.Path})
+		if err != nil {
+			respond(dedupResponse{Error: err.Error()})
+			return
+		}
+		target, modTime = path, request.ModTime
+	} else {
+		name := filepath.Base(request.Name)
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			respond(dedupResponse{Error: "invalid name"})
+			return
+		}
+		target = filepath.Join(tm.downloadDir, name)
+	}
+
+	source, ok := findLocalContent(request.Hash, request.Size)
+	if !ok {
+		respond(dedupResponse{})
+		return
+	}
+	if err := materialize(source, target, request.Hash, request.Size, modTime); err != nil {
+		log.Printf("Failed to copy %s for dedup: %v", logging.Path(target), err)
+		respond(dedupResponse{})
+		return
+	}
+
+	if request.Share == "" {
+		tm.recordTransfer(remote, target, request.Size, "receive", "completed", request.Hash)
+	}
+	respond(dedupResponse{Have: true})
+}
+
+// contentHash returns the hex SHA-256 of a file, taken from the search
+// index when the indexed entry is still current
+func contentHash(path string, info os.FileInfo) (string, error) {
+	var entry models.SearchIndex
+	err := storage.DB().Select("file_size", "modified_time", "content_hash").
+		Where("path = ? AND content_hash <> ''", path).First(&entry).Error
+	if err == nil && entry.FileSize == info.Size() && entry.ModifiedTime.Equal(info.ModTime()) {
+		return entry.ContentHash, nil
+	}
+	return fileSHA256(path)
+}
+
+// findLocalContent looks for a local file with the given content in the
+// search index and the availability catalog. Candidates are re-hashed, since
+// either may be out of date.
+func findLocalContent(hash string, size int64) (string, bool) {
+	db := storage.DB()
+	var paths []string
+	db.Model(&models.SearchIndex{}).
+		Where("content_hash = ? AND file_size = ? AND is_directory = ?", hash, size, false).
+		Pluck("path", &paths)
+	var catalogued []string
+	db.Model(&models.RemoteFile{}).
+		Where("hash = ? AND size = ? AND local_path <> ''", hash, size).
+		Pluck("local_path", &catalogued)
+	paths = append(paths, catalogued...)
+
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Size() != size {
+			continue
+		}
+		if got, err := fileSHA256(path); err == nil && got == hash {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// materialize copies source to target through a temporary file, checking
+// the copy against hash. A zero modTime keeps the time of the copy.
+func materialize(source, target, hash string, size int64, modTime time.Time) error {
+	if source == target {
+		if !modTime.IsZero() {
+			return os.Chtimes(target, modTime, modTime)
+		}
+		return nil
+	}
+
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	reservation, err := diskspace.Reserve(dir, size)
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
+
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open source: %w", err)
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(dir, ".sfm-dedup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(in, hasher)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+	// The source may have changed since it was matched
+	if hex.EncodeToString(hasher.Sum(nil)) != hash {
+		return fmt.Errorf("source changed while copying")
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(target, modTime, modTime)
+	}
+	return nil
+}
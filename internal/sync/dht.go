@@ -2,9 +2,13 @@ package sync
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	"github.com/owner/secure-file-manager/internal/logging"
 	"github.com/owner/secure-file-manager/internal/storage"
 	"github.com/owner/secure-file-manager/pkg/models"
 )
@@ -17,26 +21,31 @@ func NewDHTManager(node *P2PNode) *DHTManager {
 	return &DHTManager{node: node}
 }
 
-// AdvertiseAccount advertises this device on the DHT
-func (dm *DHTManager) AdvertiseAccount(ctx context.Context, accountID string) error {
-	// This is a simplified version - in production would use proper DHT advertising
-	// For now, we'll just store in local database
-	db := storage.DB()
+// discoverTimeout bounds a DHT lookup of an account's devices
+const discoverTimeout = 30 * time.Second
 
-	var accountInfo models.AccountInfo
-	result := db.Where("account_id = ?", accountID).FirstOrCreate(&accountInfo, models.AccountInfo{
-		AccountID:  accountID,
-		DeviceName: "Local Device",
-		PeerID:     dm.node.GetPeerID().String(),
-	})
+// AdvertiseAccount advertises this device on the DHT under the account's
+// namespace, so each account's devices only find each other
+func (dm *DHTManager) AdvertiseAccount(ctx context.Context, accountID string) error {
+	if _, err := ensureAccount(dm.node, accountID, ""); err != nil {
+		return err
+	}
+	if dm.node.dht == nil {
+		return nil
+	}
 
-	return result.Error
+	discovery := drouting.NewRoutingDiscovery(dm.node.dht)
+	if _, err := discovery.Advertise(ctx, AccountNamespace(accountID)); err != nil {
+		return fmt.Errorf("failed to advertise account: %w", err)
+	}
+	return nil
 }
 
-// DiscoverPeers discovers peers with the same account ID
+// DiscoverPeers discovers the devices paired in an account. Addresses are
+// filled in for those advertising in the account's namespace; devices that
+// are neither found there nor known from mDNS are looked up by their DNS
+// name, if they have one.
 func (dm *DHTManager) DiscoverPeers(ctx context.Context, accountID string) ([]peer.AddrInfo, error) {
-	// In production, would query DHT for peers advertising the same account ID
-	// For now, return paired devices from database
 	db := storage.DB()
 
 	var devices []models.PairedDevice
@@ -45,17 +54,50 @@ func (dm *DHTManager) DiscoverPeers(ctx context.Context, accountID string) ([]pe
 	}
 
 	peers := make([]peer.AddrInfo, 0, len(devices))
+	index := make(map[peer.ID]int, len(devices))
+	dnsNamed := make(map[int]models.PairedDevice)
 	for _, device := range devices {
 		peerID, err := peer.Decode(device.PeerID)
 		if err != nil {
 			continue
 		}
 
+		if device.DNSName != "" {
+			dnsNamed[len(peers)] = device
+		}
+		index[peerID] = len(peers)
 		peers = append(peers, peer.AddrInfo{
 			ID: peerID,
 		})
 	}
 
+	if dm.node.dht != nil && len(peers) > 0 {
+		dhtCtx, cancel := context.WithTimeout(ctx, discoverTimeout)
+		found, err := drouting.NewRoutingDiscovery(dm.node.dht).FindPeers(dhtCtx, AccountNamespace(accountID))
+		if err == nil {
+			for info := range found {
+				// Anyone may advertise in a namespace; only paired devices count
+				if i, ok := index[info.ID]; ok {
+					peers[i].Addrs = info.Addrs
+				}
+			}
+		}
+		cancel()
+	}
+
+	for i, device := range dnsNamed {
+		if len(peers[i].Addrs) > 0 || len(dm.node.host.Peerstore().Addrs(peers[i].ID)) > 0 {
+			continue
+		}
+		addrs, err := resolveDNS(ctx, device.DNSName, peers[i].ID)
+		if err != nil {
+			// The resolver error names the DNS name, so leave it out
+			log.Printf("DNS lookup of %s failed", logging.DeviceName(device.DeviceName))
+			continue
+		}
+		peers[i].Addrs = addrs
+	}
+
 	return peers, nil
 }
 
@@ -87,6 +129,29 @@ func (dm *DHTManager) UpdatePeerStatus(ctx context.Context) error {
 	return nil
 }
 
+// StartAdvertisingAccounts periodically advertises every account this
+// device takes part in
+func (dm *DHTManager) StartAdvertisingAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			accounts, err := Accounts()
+			if err != nil {
+				continue
+			}
+			for _, account := range accounts {
+				dm.AdvertiseAccount(ctx, account.AccountID)
+			}
+			dm.UpdatePeerStatus(ctx)
+		}
+	}
+}
+
 // StartPeriodicAdvertisement starts periodic DHT advertisement
 func (dm *DHTManager) StartPeriodicAdvertisement(ctx context.Context, accountID string, interval time.Duration) {
 	ticker := time.NewTicker(interval)
@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/owner/secure-file-manager/internal/directory"
+	"github.com/owner/secure-file-manager/internal/logging"
+)
+
+// SetDirectory enables sending to directory addresses like alice@example.org
+func (tm *TransferManager) SetDirectory(client *directory.Client) {
+	tm.directory = client
+}
+
+// SendToAddress resolves a directory address and sends a file to the
+// first of its devices that takes it, trying them in the order listed
+func (tm *TransferManager) SendToAddress(ctx context.Context, address, filePath string) error {
+	if tm.directory == nil {
+		return fmt.Errorf("no key directory is configured")
+	}
+	entry, err := tm.directory.Resolve(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", address, err)
+	}
+	peerIDs, err := entry.PeerIDs()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, peerID := range peerIDs {
+		if peerID == tm.node.host.ID() {
+			continue
+		}
+		if lastErr = tm.SendFile(ctx, peerID, filePath); lastErr == nil {
+			return nil
+		}
+		log.Printf("Sending to %s via %s failed: %v", address, logging.Fingerprint(peerID.String()), lastErr)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		return fmt.Errorf("%s lists no other devices", address)
+	}
+	return fmt.Errorf("failed to send to %s: %w", address, lastErr)
+}
@@ -0,0 +1,118 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// dnsTimeout bounds the DNS lookups for one device
+const dnsTimeout = 10 * time.Second
+
+// SetDeviceDNSName stores the DNS name a paired device can be found under
+// when mDNS and the DHT do not find it, e.g. a dynamic DNS hostname. name
+// is either host:port, resolved by its A and AAAA records, or a bare host
+// publishing _sfm TXT or SRV records; empty clears it.
+func SetDeviceDNSName(peerID peer.ID, name string) error {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+	if name != "" {
+		host := name
+		if h, port, err := net.SplitHostPort(name); err == nil {
+			if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+				return fmt.Errorf("invalid port in DNS name: %s", name)
+			}
+			host = h
+		}
+		if host == "" || strings.ContainsAny(host, "/ @") {
+			return fmt.Errorf("invalid DNS name: %s", name)
+		}
+	}
+	result := storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", peerID.String()).Update("dns_name", name)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save DNS name: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("device not paired: %s", peerID)
+	}
+	return nil
+}
+
+// resolveDNS returns the addresses a device publishes under name:
+//   - host:port resolves host's A and AAAA records at port
+//   - otherwise TXT records at _sfm.<name> holding multiaddrs, e.g.
+//     "/ip4/203.0.113.7/tcp/4001", are used
+//   - failing those, SRV records at _sfm._tcp.<name> give hosts and ports
+//
+// Multiaddrs naming a different peer are skipped.
+func resolveDNS(ctx context.Context, name string, peerID peer.ID) ([]multiaddr.Multiaddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+	resolver := net.DefaultResolver
+
+	if host, port, err := net.SplitHostPort(name); err == nil {
+		return lookupHost(ctx, resolver, host, port)
+	}
+
+	var addrs []multiaddr.Multiaddr
+	if records, err := resolver.LookupTXT(ctx, "_sfm."+name); err == nil {
+		for _, record := range records {
+			addr, err := multiaddr.NewMultiaddr(strings.TrimSpace(record))
+			if err != nil {
+				continue
+			}
+			transport, id := peer.SplitAddr(addr)
+			if transport == nil || (id != "" && id != peerID) {
+				continue
+			}
+			addrs = append(addrs, transport)
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	_, srvs, err := resolver.LookupSRV(ctx, "sfm", "tcp", name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	for _, srv := range srvs {
+		found, err := lookupHost(ctx, resolver, strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, found...)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", name)
+	}
+	return addrs, nil
+}
+
+// lookupHost returns TCP multiaddrs for the IP addresses of host
+func lookupHost(ctx context.Context, resolver *net.Resolver, host, port string) ([]multiaddr.Multiaddr, error) {
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	addrs := make([]multiaddr.Multiaddr, 0, len(ips))
+	for _, ip := range ips {
+		family := "ip6"
+		if ip.IP.To4() != nil {
+			family = "ip4"
+		}
+		addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/%s/%s/tcp/%s", family, ip.IP, port))
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
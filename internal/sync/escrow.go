@@ -0,0 +1,276 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/hold"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/relay"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	EscrowProtocolID = "/sfm/escrow/1.0.0"
+
+	escrowTimeout = 10 * time.Second
+)
+
+// Escrow protocol operations
+const (
+	escrowOpDeposit  = "deposit"
+	escrowOpWithdraw = "withdraw"
+)
+
+// Audit log actions
+const (
+	AuditEscrowDeposited = "escrow.deposited"
+	AuditEscrowWithdrawn = "escrow.withdrawn"
+	AuditEscrowReceived  = "escrow.received"
+	AuditEscrowUsed      = "escrow.used"
+)
+
+var ErrNoEscrow = errors.New("no recovery key is escrowed here for this container")
+
+type escrowRequest struct {
+	Op            string `json:"op"`
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name,omitempty"`
+	Sealed        []byte `json:"sealed,omitempty"`
+}
+
+type escrowResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// RegisterEscrowHandler registers the escrow protocol handler
+func (tm *TransferManager) RegisterEscrowHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(EscrowProtocolID), tm.handleEscrowRequest)
+}
+
+// EscrowRecoveryKey seals a container's recovery key to a paired device and
+// deposits it there, so the container can be recovered on that device if
+// the password is lost. The escrow must be repeated after the container is
+// rebuilt (e.g. a new duress password), which changes its key.
+func (tm *TransferManager) EscrowRecoveryKey(ctx context.Context, holder peer.ID, containerPath, password string) error {
+	if !isPairedPeer(holder.String()) {
+		return fmt.Errorf("device not paired: %s", holder)
+	}
+
+	key, err := crypto.ExportRecoveryKey(containerPath, password)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(containerPath)
+	sealed, err := relay.Seal(tm.node.GetPrivateKey(), holder, name, data)
+	if err != nil {
+		return fmt.Errorf("failed to seal recovery key: %w", err)
+	}
+
+	request := escrowRequest{Op: escrowOpDeposit, ContainerID: key.ContainerID, ContainerName: name, Sealed: sealed}
+	if err := tm.sendEscrowRequest(ctx, holder, request); err != nil {
+		return err
+	}
+	tm.audit(AuditEscrowDeposited, tm.node.GetPeerID().String(), containerPath, "with "+pairedDeviceName(holder.String()))
+	return nil
+}
+
+// WithdrawEscrow removes a container's recovery key from a paired device
+func (tm *TransferManager) WithdrawEscrow(ctx context.Context, holder peer.ID, containerPath string) error {
+	id, err := crypto.ContainerID(containerPath)
+	if err != nil {
+		return err
+	}
+	if err := tm.sendEscrowRequest(ctx, holder, escrowRequest{Op: escrowOpWithdraw, ContainerID: id}); err != nil {
+		return err
+	}
+	tm.audit(AuditEscrowWithdrawn, tm.node.GetPeerID().String(), containerPath, "from "+pairedDeviceName(holder.String()))
+	return nil
+}
+
+// EscrowedKeys lists the recovery keys other devices deposited here
+func EscrowedKeys() ([]models.EscrowedKey, error) {
+	var keys []models.EscrowedKey
+	if err := storage.DB().Order("container_name").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to load escrowed keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RecoverContainer gives a container a new password using the recovery key
+// its owner escrowed with this device. The container file must be copied
+// here first; the escrowed key is used up, since recovery changes the key.
+// Every key escrowed for the container ID is tried, since a container copy
+// carries no owner and any paired device can deposit under any ID.
+func (tm *TransferManager) RecoverContainer(containerPath, newPassword string) error {
+	if err := hold.CheckContainer(containerPath); err != nil {
+		return err
+	}
+	id, err := crypto.ContainerID(containerPath)
+	if err != nil {
+		return err
+	}
+
+	db := storage.DB()
+	var escrows []models.EscrowedKey
+	if err := db.Where("container_id = ?", id).Order("updated_at DESC").Find(&escrows).Error; err != nil {
+		return fmt.Errorf("failed to load escrowed keys: %w", err)
+	}
+	if len(escrows) == 0 {
+		return ErrNoEscrow
+	}
+
+	var lastErr error
+	for _, escrow := range escrows {
+		if err := tm.recoverWithEscrow(containerPath, &escrow, newPassword); err != nil {
+			lastErr = err
+			continue
+		}
+		if err := db.Delete(&escrow).Error; err != nil {
+			log.Printf("Failed to remove used recovery key: %v", err)
+		}
+		tm.audit(AuditEscrowUsed, tm.node.GetPeerID().String(), containerPath,
+			"key from "+pairedDeviceName(escrow.OwnerPeerID))
+		return nil
+	}
+	return lastErr
+}
+
+// recoverWithEscrow opens one escrowed key and recovers the container with it
+func (tm *TransferManager) recoverWithEscrow(containerPath string, escrow *models.EscrowedKey, newPassword string) error {
+	sender, _, data, err := relay.Open(tm.node.GetPrivateKey(), escrow.Sealed)
+	if err != nil {
+		return fmt.Errorf("failed to open recovery key: %w", err)
+	}
+	if sender.String() != escrow.OwnerPeerID {
+		return fmt.Errorf("recovery key was not sealed by its owner")
+	}
+	var key crypto.RecoveryKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return fmt.Errorf("failed to decode recovery key: %w", err)
+	}
+	return crypto.RecoverContainer(containerPath, &key, newPassword)
+}
+
+func (tm *TransferManager) sendEscrowRequest(ctx context.Context, peerID peer.ID, request escrowRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, escrowTimeout)
+	defer cancel()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(EscrowProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return err
+	}
+
+	if err := writeEncryptedJSON(stream, key, request); err != nil {
+		return err
+	}
+
+	var response escrowResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &response); err != nil {
+		return fmt.Errorf("failed to read escrow response: %w", err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("peer refused escrow request: %s", response.Error)
+	}
+	return nil
+}
+
+func (tm *TransferManager) handleEscrowRequest(stream network.Stream) {
+	defer stream.Close()
+
+	remote := stream.Conn().RemotePeer().String()
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	var request escrowRequest
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &request); err != nil {
+		return
+	}
+
+	respond := func(response escrowResponse) {
+		if err := writeEncryptedJSON(stream, key, response); err != nil {
+			log.Printf("Failed to answer escrow request from %s: %v", logging.Fingerprint(remote), err)
+		}
+	}
+
+	if !isPairedPeer(remote) {
+		respond(escrowResponse{Error: "not paired"})
+		return
+	}
+	if request.ContainerID == "" {
+		respond(escrowResponse{Error: "invalid request"})
+		return
+	}
+
+	db := storage.DB()
+	switch request.Op {
+	case escrowOpDeposit:
+		// Only accept keys the owner sealed to this device for the
+		// container they are filed under
+		sender, _, data, err := relay.Open(tm.node.GetPrivateKey(), request.Sealed)
+		if err != nil || sender.String() != remote {
+			respond(escrowResponse{Error: "invalid recovery key"})
+			return
+		}
+		var key crypto.RecoveryKey
+		if err := json.Unmarshal(data, &key); err != nil || key.ContainerID != request.ContainerID {
+			respond(escrowResponse{Error: "invalid recovery key"})
+			return
+		}
+		escrow := models.EscrowedKey{
+			OwnerPeerID:   remote,
+			ContainerID:   request.ContainerID,
+			ContainerName: filepath.Base(request.ContainerName),
+			Sealed:        request.Sealed,
+		}
+		err = db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "owner_peer_id"}, {Name: "container_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"container_name", "sealed", "updated_at"}),
+		}).Create(&escrow).Error
+		if err != nil {
+			respond(escrowResponse{Error: "failed to store recovery key"})
+			return
+		}
+		tm.audit(AuditEscrowReceived, remote, escrow.ContainerName, "from "+pairedDeviceName(remote))
+		respond(escrowResponse{})
+
+	case escrowOpWithdraw:
+		if err := db.Where("owner_peer_id = ? AND container_id = ?", remote, request.ContainerID).Delete(&models.EscrowedKey{}).Error; err != nil {
+			respond(escrowResponse{Error: "failed to remove recovery key"})
+			return
+		}
+		respond(escrowResponse{})
+
+	default:
+		respond(escrowResponse{Error: "unknown operation"})
+	}
+}
+
+func isPairedPeer(peerID string) bool {
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", peerID).Count(&count)
+	return count > 0
+}
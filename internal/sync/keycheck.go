@@ -0,0 +1,171 @@
+package sync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+// AuditDeviceKeyChanged is audited when a paired device presents a key
+// other than the one stored when it was paired
+const AuditDeviceKeyChanged = "device.key_changed"
+
+// keyPingTimeout bounds reaching one device during a key check
+const keyPingTimeout = 15 * time.Second
+
+// KeyAlert reports a paired device presenting a different identity key
+type KeyAlert struct {
+	PeerID               string    `json:"peer_id"`
+	DeviceName           string    `json:"device_name"`
+	AccountID            string    `json:"account_id"`
+	StoredFingerprint    string    `json:"stored_fingerprint"`
+	PresentedFingerprint string    `json:"presented_fingerprint"`
+	DetectedAt           time.Time `json:"detected_at"`
+}
+
+// SetKeyAlertHandler sets a function called for every key alert, e.g. to
+// show a notification. It must not block.
+func (tm *TransferManager) SetKeyAlertHandler(handler func(KeyAlert)) {
+	tm.keyAlertMu.Lock()
+	defer tm.keyAlertMu.Unlock()
+	tm.onKeyAlert = handler
+}
+
+// VerifyDeviceKeys pings every paired device that can be reached and
+// compares the identity key it presents with the stored one. A mismatch
+// is audited, logged, passed to the alert handler and costs the device
+// its trust for approvals. Devices that cannot be reached are skipped.
+func (tm *TransferManager) VerifyDeviceKeys(ctx context.Context) ([]KeyAlert, error) {
+	var devices []models.PairedDevice
+	if err := storage.DB().Find(&devices).Error; err != nil {
+		return nil, fmt.Errorf("failed to load paired devices: %w", err)
+	}
+
+	var alerts []KeyAlert
+	presentedKeys := make(map[peer.ID][]byte) // A device may be paired in several accounts
+	for _, device := range devices {
+		if ctx.Err() != nil {
+			break
+		}
+		peerID, err := peer.Decode(device.PeerID)
+		if err != nil {
+			continue
+		}
+		presented, ok := presentedKeys[peerID]
+		if !ok {
+			presented, _ = tm.presentedKey(ctx, peerID)
+			presentedKeys[peerID] = presented
+		}
+		if presented == nil {
+			continue
+		}
+		if bytes.Equal(presented, device.PublicKey) {
+			continue
+		}
+		alert := KeyAlert{
+			PeerID:               device.PeerID,
+			DeviceName:           device.DeviceName,
+			AccountID:            device.AccountID,
+			StoredFingerprint:    keyFingerprint(device.PublicKey),
+			PresentedFingerprint: keyFingerprint(presented),
+			DetectedAt:           time.Now(),
+		}
+		tm.raiseKeyAlert(device, alert)
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+// StartKeyVerification checks device keys every interval until ctx is done
+func (tm *TransferManager) StartKeyVerification(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := tm.VerifyDeviceKeys(ctx); err != nil {
+			log.Printf("Device key check failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// presentedKey pings a device and returns the marshaled public key its
+// connection was authenticated with
+func (tm *TransferManager) presentedKey(ctx context.Context, peerID peer.ID) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, keyPingTimeout)
+	defer cancel()
+	if result := <-ping.Ping(ctx, tm.node.host, peerID); result.Error != nil {
+		return nil, result.Error
+	}
+
+	for _, conn := range tm.node.host.Network().ConnsToPeer(peerID) {
+		if pub := conn.RemotePublicKey(); pub != nil {
+			return crypto.MarshalPublicKey(pub)
+		}
+	}
+	return nil, fmt.Errorf("no authenticated connection to %s", peerID)
+}
+
+// raiseKeyAlert records an alert once per presented key, so a device that
+// keeps presenting the same wrong key is not reported every check
+func (tm *TransferManager) raiseKeyAlert(device models.PairedDevice, alert KeyAlert) {
+	tm.keyAlertMu.Lock()
+	if tm.keyAlerted == nil {
+		tm.keyAlerted = make(map[uint]string)
+	}
+	seen := tm.keyAlerted[device.ID] == alert.PresentedFingerprint
+	tm.keyAlerted[device.ID] = alert.PresentedFingerprint
+	handler := tm.onKeyAlert
+	tm.keyAlertMu.Unlock()
+	if seen {
+		return
+	}
+
+	log.Printf("SECURITY ALERT: %s (%s) presented identity key %s, but %s was stored when it was paired",
+		logging.DeviceName(device.DeviceName), logging.Fingerprint(device.PeerID),
+		logging.Fingerprint(alert.PresentedFingerprint), logging.Fingerprint(alert.StoredFingerprint))
+	tm.audit(AuditDeviceKeyChanged, device.PeerID, device.DeviceName,
+		fmt.Sprintf("stored key %s, presented key %s", alert.StoredFingerprint, alert.PresentedFingerprint))
+	if device.Trusted {
+		if err := storage.DB().Model(&device).Update("trusted", false).Error; err != nil {
+			log.Printf("Failed to revoke trust in %s: %v", logging.DeviceName(device.DeviceName), err)
+		}
+	}
+	if handler != nil {
+		handler(alert)
+	}
+}
+
+// keyFingerprint returns the hex SHA-256 of a marshaled public key,
+// grouped for reading aloud
+func keyFingerprint(key []byte) string {
+	if len(key) == 0 {
+		return "none"
+	}
+	sum := sha256.Sum256(key)
+	digest := hex.EncodeToString(sum[:16])
+	var b bytes.Buffer
+	for i := 0; i < len(digest); i += 4 {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(digest[i : i+4])
+	}
+	return b.String()
+}
@@ -0,0 +1,430 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+const (
+	LockProtocolID = "/sfm/lock/1.0.0"
+
+	// DefaultLockTTL is how long a lock lasts unless renewed
+	DefaultLockTTL = time.Hour
+	// MaxLockTTL caps locks so a vanished device cannot hold a file forever
+	MaxLockTTL = 24 * time.Hour
+
+	maxLockListSize = 4 * 1024 * 1024
+	lockTimeout     = 10 * time.Second
+)
+
+// Lock protocol operations
+const (
+	lockOpLock   = "lock"
+	lockOpUnlock = "unlock"
+	lockOpList   = "list"
+)
+
+// LockedError reports a file locked by another device
+type LockedError struct {
+	Lock models.FileLock
+}
+
+func (e *LockedError) Error() string {
+	msg := fmt.Sprintf("%s is locked by %s", e.Lock.Path, e.Lock.DeviceName)
+	if e.Lock.Note != "" {
+		msg += ": " + e.Lock.Note
+	}
+	return msg
+}
+
+type lockRequest struct {
+	Op        string    `json:"op"`
+	Share     string    `json:"share,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+type lockResponse struct {
+	Error    string            `json:"error,omitempty"`
+	Conflict *models.FileLock  `json:"conflict,omitempty"`
+	Locks    []models.FileLock `json:"locks,omitempty"`
+}
+
+// RegisterLockHandler registers the lock protocol handler
+func (tm *TransferManager) RegisterLockHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(LockProtocolID), tm.handleLockRequest)
+}
+
+// LockFile takes an advisory lock on a file in a share and announces it to
+// connected paired devices. Locking a file this device already holds renews
+// it. A conflicting lock, here or on a peer, returns a *LockedError.
+func (tm *TransferManager) LockFile(ctx context.Context, share, relPath, note string, ttl time.Duration) (*models.FileLock, error) {
+	relPath, err := cleanLockPath(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	if ttl > MaxLockTTL {
+		ttl = MaxLockTTL
+	}
+
+	self := tm.node.GetPeerID().String()
+	deviceName, _ := os.Hostname()
+	lock := models.FileLock{
+		Share:      share,
+		Path:       relPath,
+		Owner:      self,
+		DeviceName: deviceName,
+		Note:       note,
+		ExpiresAt:  time.Now().Add(ttl),
+	}
+	if conflict, err := storeLock(lock); err != nil {
+		return nil, err
+	} else if conflict != nil {
+		return nil, &LockedError{Lock: *conflict}
+	}
+
+	// Peers may hold a lock we have not heard about yet
+	request := lockRequest{Op: lockOpLock, Share: share, Path: relPath, Note: note, ExpiresAt: lock.ExpiresAt}
+	for _, peerID := range tm.connectedPairedPeers() {
+		response, err := tm.sendLockRequest(ctx, peerID, request)
+		if err != nil {
+			log.Printf("Failed to announce lock to %s: %v", logging.Fingerprint(peerID.String()), err)
+			continue
+		}
+		if response.Conflict != nil {
+			tm.UnlockFile(ctx, share, relPath)
+			return nil, &LockedError{Lock: *response.Conflict}
+		}
+	}
+
+	return &lock, nil
+}
+
+// UnlockFile releases a lock this device holds and tells connected peers
+func (tm *TransferManager) UnlockFile(ctx context.Context, share, relPath string) error {
+	relPath, err := cleanLockPath(relPath)
+	if err != nil {
+		return err
+	}
+
+	self := tm.node.GetPeerID().String()
+	if err := deleteLock(share, relPath, self); err != nil {
+		return err
+	}
+
+	request := lockRequest{Op: lockOpUnlock, Share: share, Path: relPath}
+	for _, peerID := range tm.connectedPairedPeers() {
+		if _, err := tm.sendLockRequest(ctx, peerID, request); err != nil {
+			log.Printf("Failed to announce unlock to %s: %v", logging.Fingerprint(peerID.String()), err)
+		}
+	}
+	return nil
+}
+
+// CheckLock returns a *LockedError if another device holds a lock on the
+// file. Filesystem layers call this before opening a file for writing.
+func (tm *TransferManager) CheckLock(share, relPath string) error {
+	lock, err := GetLock(share, relPath)
+	if err != nil || lock == nil {
+		return err
+	}
+	if lock.Owner == tm.node.GetPeerID().String() {
+		return nil
+	}
+	return &LockedError{Lock: *lock}
+}
+
+// GetLock returns the active lock on a file, or nil
+func GetLock(share, relPath string) (*models.FileLock, error) {
+	relPath, err := cleanLockPath(relPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock models.FileLock
+	err = storage.DB().Where("share = ? AND path = ? AND expires_at > ?", share, relPath, time.Now()).First(&lock).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load lock: %w", err)
+	}
+	return &lock, nil
+}
+
+// ListLocks returns active locks, in one share or all shares if share is
+// empty, and drops expired ones
+func ListLocks(share string) ([]models.FileLock, error) {
+	db := storage.DB()
+	db.Where("expires_at <= ?", time.Now()).Delete(&models.FileLock{})
+
+	query := db.Order("share, path")
+	if share != "" {
+		query = query.Where("share = ?", share)
+	}
+	var locks []models.FileLock
+	if err := query.Find(&locks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list locks: %w", err)
+	}
+	return locks, nil
+}
+
+// RefreshLocks replaces what this device knows about a peer's locks with
+// the peer's own list, catching up on announcements missed while offline
+func (tm *TransferManager) RefreshLocks(ctx context.Context, peerID peer.ID) error {
+	response, err := tm.sendLockRequest(ctx, peerID, lockRequest{Op: lockOpList})
+	if err != nil {
+		return err
+	}
+
+	owner := peerID.String()
+	deviceName := pairedDeviceName(owner)
+	return storage.DB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("owner = ?", owner).Delete(&models.FileLock{}).Error; err != nil {
+			return err
+		}
+		for _, lock := range response.Locks {
+			path, err := cleanLockPath(lock.Path)
+			if err != nil || lock.Owner != owner || !lock.ExpiresAt.After(time.Now()) {
+				continue
+			}
+			record := models.FileLock{
+				Share:      lock.Share,
+				Path:       path,
+				Owner:      owner,
+				DeviceName: deviceName,
+				Note:       lock.Note,
+				ExpiresAt:  capExpiry(lock.ExpiresAt),
+			}
+			// An expired lock gives way; an active one from another device,
+			// including our own, is kept
+			if err := tx.Where("share = ? AND path = ? AND expires_at <= ?", record.Share, record.Path, time.Now()).
+				Delete(&models.FileLock{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("share = ? AND path = ?", record.Share, record.Path).FirstOrCreate(&record).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WatchLocks refreshes a paired peer's locks each time it connects
+func (tm *TransferManager) WatchLocks() {
+	tm.node.host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			remote := conn.RemotePeer()
+			var count int64
+			storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", remote.String()).Count(&count)
+			if count == 0 {
+				return
+			}
+			go func() {
+				ctx, cancel := context.WithTimeout(tm.node.ctx, lockTimeout)
+				defer cancel()
+				if err := tm.RefreshLocks(ctx, remote); err != nil {
+					log.Printf("Failed to refresh locks from %s: %v", logging.Fingerprint(remote.String()), err)
+				}
+			}()
+		},
+	})
+}
+
+func (tm *TransferManager) sendLockRequest(ctx context.Context, peerID peer.ID, request lockRequest) (*lockResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(LockProtocolID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeEncryptedJSON(stream, key, request); err != nil {
+		return nil, err
+	}
+
+	var response lockResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxLockListSize, &response); err != nil {
+		return nil, fmt.Errorf("failed to read lock response: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("peer refused lock request: %s", response.Error)
+	}
+	return &response, nil
+}
+
+func (tm *TransferManager) handleLockRequest(stream network.Stream) {
+	defer stream.Close()
+
+	remote := stream.Conn().RemotePeer().String()
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	var request lockRequest
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &request); err != nil {
+		return
+	}
+
+	respond := func(response lockResponse) {
+		if err := writeEncryptedJSON(stream, key, response); err != nil {
+			log.Printf("Failed to answer lock request from %s: %v", logging.Fingerprint(remote), err)
+		}
+	}
+
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", remote).Count(&count)
+	if count == 0 {
+		respond(lockResponse{Error: "not paired"})
+		return
+	}
+
+	switch request.Op {
+	case lockOpList:
+		// Only this device's own locks; each device is the authority on its own
+		var locks []models.FileLock
+		storage.DB().Where("owner = ? AND expires_at > ?", tm.node.GetPeerID().String(), time.Now()).Find(&locks)
+		respond(lockResponse{Locks: locks})
+
+	case lockOpLock:
+		relPath, err := cleanLockPath(request.Path)
+		if err != nil {
+			respond(lockResponse{Error: err.Error()})
+			return
+		}
+		conflict, err := storeLock(models.FileLock{
+			Share:      request.Share,
+			Path:       relPath,
+			Owner:      remote,
+			DeviceName: pairedDeviceName(remote),
+			Note:       request.Note,
+			ExpiresAt:  capExpiry(request.ExpiresAt),
+		})
+		if err != nil {
+			respond(lockResponse{Error: "failed to store lock"})
+			return
+		}
+		respond(lockResponse{Conflict: conflict})
+
+	case lockOpUnlock:
+		relPath, err := cleanLockPath(request.Path)
+		if err != nil {
+			respond(lockResponse{Error: err.Error()})
+			return
+		}
+		if err := deleteLock(request.Share, relPath, remote); err != nil {
+			respond(lockResponse{Error: "failed to remove lock"})
+			return
+		}
+		respond(lockResponse{})
+
+	default:
+		respond(lockResponse{Error: "unknown operation"})
+	}
+}
+
+// storeLock saves or renews lock unless another owner holds an active lock
+// on the same file, which is returned instead
+func storeLock(lock models.FileLock) (*models.FileLock, error) {
+	var conflict *models.FileLock
+	err := storage.DB().Transaction(func(tx *gorm.DB) error {
+		var existing models.FileLock
+		err := tx.Where("share = ? AND path = ?", lock.Share, lock.Path).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(&lock).Error
+		}
+		if err != nil {
+			return err
+		}
+		if existing.Owner != lock.Owner && existing.ExpiresAt.After(time.Now()) {
+			conflict = &existing
+			return nil
+		}
+		lock.ID = existing.ID
+		lock.CreatedAt = existing.CreatedAt
+		return tx.Save(&lock).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save lock: %w", err)
+	}
+	return conflict, nil
+}
+
+// capExpiry limits a peer-supplied expiry to MaxLockTTL from now
+func capExpiry(expires time.Time) time.Time {
+	if limit := time.Now().Add(MaxLockTTL); expires.After(limit) {
+		return limit
+	}
+	return expires
+}
+
+func deleteLock(share, relPath, owner string) error {
+	err := storage.DB().Where("share = ? AND path = ? AND owner = ?", share, relPath, owner).Delete(&models.FileLock{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to remove lock: %w", err)
+	}
+	return nil
+}
+
+// connectedPairedPeers returns paired devices with an open connection
+func (tm *TransferManager) connectedPairedPeers() []peer.ID {
+	var devices []models.PairedDevice
+	storage.DB().Find(&devices)
+
+	var peers []peer.ID
+	for _, device := range devices {
+		peerID, err := peer.Decode(device.PeerID)
+		if err != nil {
+			continue
+		}
+		if tm.node.host.Network().Connectedness(peerID) == network.Connected {
+			peers = append(peers, peerID)
+		}
+	}
+	return peers
+}
+
+func pairedDeviceName(peerID string) string {
+	var device models.PairedDevice
+	if err := storage.DB().Where("peer_id = ?", peerID).First(&device).Error; err != nil {
+		return "Unknown"
+	}
+	return device.DeviceName
+}
+
+// cleanLockPath normalizes a share-relative path so every device keys the
+// same file the same way
+func cleanLockPath(relPath string) (string, error) {
+	clean := path.Clean("/" + strings.ReplaceAll(relPath, "\\", "/"))
+	clean = strings.TrimPrefix(clean, "/")
+	if clean == "" || clean == "." {
+		return "", fmt.Errorf("invalid path")
+	}
+	return clean, nil
+}
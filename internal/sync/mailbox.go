@@ -0,0 +1,75 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/relay"
+)
+
+// SetMailbox enables store-and-forward delivery through a cloud relay
+func (tm *TransferManager) SetMailbox(mailbox *relay.Mailbox) {
+	tm.mailbox = mailbox
+}
+
+// SendOrPark sends a file directly, or parks it on the cloud relay when
+// the peer cannot be reached. It reports whether the file was parked.
+// Files covered by the approval policy are only sent or parked once
+// approved, and a refused approval is returned without parking.
+func (tm *TransferManager) SendOrPark(ctx context.Context, peerID peer.ID, filePath string) (bool, error) {
+	approval, err := tm.approveSend(ctx, peerID, filePath)
+	if err != nil {
+		return false, err
+	}
+	err = tm.sendFile(ctx, peerID, filePath, approval)
+	if err == nil {
+		return false, nil
+	}
+	if tm.mailbox == nil || isApprovalError(err) || len(tm.node.host.Network().ConnsToPeer(peerID)) > 0 {
+		return false, err
+	}
+	info, statErr := os.Stat(filePath)
+	if statErr != nil {
+		return false, err
+	}
+	if changedErr := checkApproved(approval, info); changedErr != nil {
+		return false, changedErr
+	}
+
+	log.Printf("Peer %s unreachable (%v), parking on relay", logging.Fingerprint(peerID.String()), err)
+	if _, parkErr := tm.mailbox.Park(ctx, peerID, filePath); parkErr != nil {
+		return false, fmt.Errorf("direct send failed (%v) and relay failed: %w", err, parkErr)
+	}
+	tm.recordTransfer(peerID.String(), filePath, info.Size(), "send", "parked", "")
+	return true, nil
+}
+
+// StartMailboxPolling fetches parked payloads into the download directory
+// every interval until ctx is done
+func (tm *TransferManager) StartMailboxPolling(ctx context.Context, interval time.Duration) {
+	if tm.mailbox == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if deliveries, err := tm.mailbox.Fetch(ctx, tm.downloadDir); err != nil {
+			log.Printf("Relay fetch failed: %v", err)
+		} else if len(deliveries) > 0 {
+			log.Printf("Fetched %d file(s) from relay", len(deliveries))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/compare"
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const (
+	ManifestProtocolID = "/sfm/manifest/1.0.0"
+	maxManifestSize    = 64 * 1024 * 1024
+)
+
+type manifestRequest struct {
+	Share   string   `json:"share"`
+	Hash    bool     `json:"hash"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+type manifestResponse struct {
+	Error   string          `json:"error,omitempty"`
+	Entries []compare.Entry `json:"entries,omitempty"`
+}
+
+// SetSharedFolders sets the folders paired peers may list, keyed by share
+// name. Writing into them needs SetShareWriters.
+func (tm *TransferManager) SetSharedFolders(shares map[string]string) {
+	tm.sharesMu.Lock()
+	defer tm.sharesMu.Unlock()
+	tm.shares = shares
+}
+
+// SetShareAccounts limits shares to the devices paired in one account,
+// mapping share name to account name or ID. Other shares are open to every
+// paired device.
+func (tm *TransferManager) SetShareAccounts(accounts map[string]string) {
+	tm.sharesMu.Lock()
+	defer tm.sharesMu.Unlock()
+	tm.shareAccounts = accounts
+}
+
+// SetShareWriters lets peers change shares, mapping share name to the peer
+// IDs whose mirror writes and courier bundles it accepts. Other shares are
+// read-only to every peer.
+func (tm *TransferManager) SetShareWriters(writers map[string][]string) {
+	tm.sharesMu.Lock()
+	defer tm.sharesMu.Unlock()
+	tm.shareWriters = writers
+}
+
+// shareWritable reports whether a remote peer may write into a share
+func (tm *TransferManager) shareWritable(remote, name string) bool {
+	tm.sharesMu.RLock()
+	defer tm.sharesMu.RUnlock()
+	for _, writer := range tm.shareWriters[name] {
+		if writer == remote {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedFolderFor resolves a share for a remote peer, hiding shares of
+// accounts the peer is not paired in
+func (tm *TransferManager) sharedFolderFor(remote, name string) (string, bool) {
+	tm.sharesMu.RLock()
+	dir, ok := tm.shares[name]
+	account, scoped := tm.shareAccounts[name]
+	tm.sharesMu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if scoped {
+		// An unknown account hides the share rather than opening it
+		accountID, err := ResolveAccount(account)
+		if err != nil || !pairedInAccount(remote, accountID) {
+			return "", false
+		}
+	}
+	return dir, true
+}
+
+// RegisterManifestHandler registers the manifest protocol handler
+func (tm *TransferManager) RegisterManifestHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(ManifestProtocolID), tm.handleManifestRequest)
+}
+
+// FetchManifest scans a paired peer's shared folder
+func (tm *TransferManager) FetchManifest(ctx context.Context, peerID peer.ID, share string, opts compare.Options) ([]compare.Entry, error) {
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(ManifestProtocolID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	request := manifestRequest{Share: share, Hash: opts.Hash, Exclude: opts.Exclude}
+	if err := writeEncryptedJSON(stream, key, request); err != nil {
+		return nil, err
+	}
+
+	var response manifestResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxManifestSize, &response); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("peer refused manifest: %s", response.Error)
+	}
+	return response.Entries, nil
+}
+
+// CompareWithPeer compares a local directory (left) with a paired peer's
+// shared folder (right)
+func (tm *TransferManager) CompareWithPeer(ctx context.Context, localDir string, peerID peer.ID, share string, opts compare.Options) (*compare.Result, error) {
+	local, err := compare.Scan(ctx, localDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := tm.FetchManifest(ctx, peerID, share, opts)
+	if err != nil {
+		return nil, err
+	}
+	return compare.Diff(local, remote, opts), nil
+}
+
+func (tm *TransferManager) handleManifestRequest(stream network.Stream) {
+	defer stream.Close()
+
+	remote := stream.Conn().RemotePeer().String()
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	var request manifestRequest
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &request); err != nil {
+		return
+	}
+
+	respond := func(response manifestResponse) {
+		if err := writeEncryptedJSON(stream, key, response); err != nil {
+			log.Printf("Failed to send manifest to %s: %v", logging.Fingerprint(remote), err)
+		}
+	}
+
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", remote).Count(&count)
+	if count == 0 {
+		respond(manifestResponse{Error: "not paired"})
+		return
+	}
+
+	dir, ok := tm.sharedFolderFor(remote, request.Share)
+	if !ok {
+		respond(manifestResponse{Error: "unknown share"})
+		return
+	}
+
+	entries, err := compare.Scan(context.Background(), dir, compare.Options{Hash: request.Hash, Exclude: request.Exclude})
+	if err != nil {
+		log.Printf("Failed to scan share for %s: %v", logging.Fingerprint(remote), err)
+		respond(manifestResponse{Error: "scan failed"})
+		return
+	}
+	respond(manifestResponse{Entries: entries})
+}
+
+// writeEncryptedJSON writes [length: uint32][encrypted JSON]
+func writeEncryptedJSON(w io.Writer, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := crypto.Encrypt(data, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt message: %w", err)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(encrypted))); err != nil {
+		return err
+	}
+	_, err = w.Write(encrypted)
+	return err
+}
+
+// readEncryptedJSON reads a message written by writeEncryptedJSON
+func readEncryptedJSON(r io.Reader, key []byte, limit uint32, v interface{}) error {
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return err
+	}
+	if length > limit {
+		return fmt.Errorf("message too large: %d bytes", length)
+	}
+
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(r, encrypted); err != nil {
+		return err
+	}
+
+	data, err := crypto.Decrypt(encrypted, key)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,465 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/progress"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const MirrorProtocolID = "/sfm/mirror/1.0.0"
+
+// Mirror operations applied to a peer's shared folder
+const (
+	MirrorPut     = "put"
+	MirrorMkdir   = "mkdir"
+	MirrorDelete  = "delete"
+	MirrorArchive = "archive"
+	MirrorGet     = "get"    // Read a file, or a range of it, back from the share
+	MirrorHashes  = "hashes" // Hash a file in blocks, to repair only the blocks that differ
+)
+
+// MirrorArchiveDir is where archived paths are moved inside a share, as
+// <share>/.sfm-archive/<timestamp>/<path>
+const MirrorArchiveDir = ".sfm-archive"
+
+type mirrorRequest struct {
+	Op      string    `json:"op"`
+	Share   string    `json:"share"`
+	Path    string    `json:"path"`
+	Size    int64     `json:"size,omitempty"`
+	ModTime time.Time `json:"mtime,omitempty"`
+	Stamp   string    `json:"stamp,omitempty"` // Archive batch name
+
+	Offset    int64 `json:"offset,omitempty"`     // get only: start of the range
+	Length    int64 `json:"length,omitempty"`     // get only: range length, 0 for the whole file
+	BlockSize int64 `json:"block_size,omitempty"` // hashes only
+}
+
+type mirrorResponse struct {
+	Error   string    `json:"error,omitempty"`
+	Size    int64     `json:"size,omitempty"`   // get only, followed by the data
+	ModTime time.Time `json:"mtime,omitempty"`  // get only
+	Hashes  [][]byte  `json:"hashes,omitempty"` // hashes only: SHA-256 of each block
+}
+
+// RegisterMirrorHandler registers the mirror protocol handler
+func (tm *TransferManager) RegisterMirrorHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(MirrorProtocolID), tm.handleMirrorRequest)
+}
+
+// MirrorPutFile copies localPath to relPath inside a peer's shared folder,
+// preserving the modification time. Files covered by the approval policy
+// are only copied once approved, as for SendFile.
+func (tm *TransferManager) MirrorPutFile(ctx context.Context, peerID peer.ID, share, relPath, localPath string) error {
+	approval, err := tm.approveSend(ctx, peerID, localPath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if err := checkApproved(approval, info); err != nil {
+		return err
+	}
+
+	offer := dedupRequest{Share: share, Path: relPath, ModTime: info.ModTime()}
+	if tm.offerByHash(ctx, peerID, localPath, info, offer) {
+		tm.reportSkipped(ctx, relPath, info.Size(), progress.ReasonDedup)
+		return nil
+	}
+
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+	data := &progressReader{r: flow.Reader(ctx, file), report: func(read int64) {
+		tm.reportFile(ctx, "", relPath, read, info.Size())
+	}}
+	err = tm.mirrorOp(ctx, peerID, mirrorRequest{
+		Op:      MirrorPut,
+		Share:   share,
+		Path:    relPath,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, func(w io.Writer, key []byte) error {
+		return writeMirrorData(w, key, data)
+	})
+	// An empty file has no reads to report
+	if err == nil && info.Size() == 0 {
+		tm.reportFile(ctx, "", relPath, 0, 0)
+	}
+	return err
+}
+
+// MirrorMkdir creates relPath inside a peer's shared folder
+func (tm *TransferManager) MirrorMkdir(ctx context.Context, peerID peer.ID, share, relPath string) error {
+	return tm.mirrorOp(ctx, peerID, mirrorRequest{Op: MirrorMkdir, Share: share, Path: relPath}, nil)
+}
+
+// MirrorDelete removes relPath (recursively) from a peer's shared folder
+func (tm *TransferManager) MirrorDelete(ctx context.Context, peerID peer.ID, share, relPath string) error {
+	return tm.mirrorOp(ctx, peerID, mirrorRequest{Op: MirrorDelete, Share: share, Path: relPath}, nil)
+}
+
+// MirrorArchive moves relPath into the share's archive folder under stamp
+func (tm *TransferManager) MirrorArchive(ctx context.Context, peerID peer.ID, share, relPath, stamp string) error {
+	return tm.mirrorOp(ctx, peerID, mirrorRequest{Op: MirrorArchive, Share: share, Path: relPath, Stamp: stamp}, nil)
+}
+
+// MirrorGetFile copies relPath from a peer's shared folder to localPath,
+// preserving the modification time
+func (tm *TransferManager) MirrorGetFile(ctx context.Context, peerID peer.ID, share, relPath, localPath string) error {
+	defer tm.busy()()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(MirrorProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return err
+	}
+
+	if err := writeEncryptedJSON(stream, key, mirrorRequest{Op: MirrorGet, Share: share, Path: relPath}); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(stream)
+	var response mirrorResponse
+	if err := readEncryptedJSON(reader, key, maxFileHeaderSize, &response); err != nil {
+		return fmt.Errorf("failed to read mirror response: %w", err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("peer rejected get %s: %s", relPath, response.Error)
+	}
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+	if err := receiveMirrorFile(flow.Reader(ctx, reader), key, localPath, response.Size, response.ModTime); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", relPath, err)
+	}
+	return nil
+}
+
+func (tm *TransferManager) mirrorOp(ctx context.Context, peerID peer.ID, request mirrorRequest, body func(io.Writer, []byte) error) error {
+	defer tm.busy()()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(MirrorProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(stream)
+	if err := writeEncryptedJSON(writer, key, request); err != nil {
+		return err
+	}
+	if body != nil {
+		if err := body(writer, key); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	var response mirrorResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &response); err != nil {
+		return fmt.Errorf("failed to read mirror response: %w", err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("peer rejected %s %s: %s", request.Op, request.Path, response.Error)
+	}
+	return nil
+}
+
+// writeMirrorData sends [encrypted chunks][SHA-256] like a file transfer
+func writeMirrorData(w io.Writer, key []byte, r io.Reader) error {
+	hasher := sha256.New()
+	buffer := make([]byte, ChunkSize)
+
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			encrypted, encErr := crypto.Encrypt(buffer[:n], key)
+			if encErr != nil {
+				return fmt.Errorf("failed to encrypt chunk: %w", encErr)
+			}
+			if err := binary.Write(w, binary.LittleEndian, uint32(len(encrypted))); err != nil {
+				return err
+			}
+			if _, err := w.Write(encrypted); err != nil {
+				return err
+			}
+			hasher.Write(buffer[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+
+	_, err := w.Write(hasher.Sum(nil))
+	return err
+}
+
+func (tm *TransferManager) handleMirrorRequest(stream network.Stream) {
+	defer stream.Close()
+
+	defer tm.busy()()
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(stream)
+	var request mirrorRequest
+	if err := readEncryptedJSON(reader, key, maxFileHeaderSize, &request); err != nil {
+		return
+	}
+
+	switch request.Op {
+	case MirrorGet:
+		tm.serveMirrorGet(stream, key, stream.Conn().RemotePeer().String(), request)
+		return
+	case MirrorHashes:
+		tm.serveMirrorHashes(stream, key, stream.Conn().RemotePeer().String(), request)
+		return
+	}
+
+	response := mirrorResponse{}
+	if err := tm.applyMirrorRequest(stream.Conn().RemotePeer().String(), key, request, reader); err != nil {
+		response.Error = err.Error()
+	}
+	writeEncryptedJSON(stream, key, response)
+}
+
+// serveMirrorGet answers a get with the file's size and mtime, then its
+// data. A get with a length answers with that range of the file.
+func (tm *TransferManager) serveMirrorGet(w io.Writer, key []byte, remote string, request mirrorRequest) {
+	_, target, err := tm.mirrorTarget(remote, request)
+	if err != nil {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: err.Error()})
+		return
+	}
+
+	file, err := os.Open(target)
+	if err != nil {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: "not found"})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: "not a file"})
+		return
+	}
+
+	size := info.Size()
+	if request.Length > 0 {
+		if request.Offset < 0 || request.Offset >= size {
+			writeEncryptedJSON(w, key, mirrorResponse{Error: "invalid range"})
+			return
+		}
+		size = min(request.Length, size-request.Offset)
+		if _, err := file.Seek(request.Offset, io.SeekStart); err != nil {
+			writeEncryptedJSON(w, key, mirrorResponse{Error: "failed to read"})
+			return
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	if err := writeEncryptedJSON(writer, key, mirrorResponse{Size: size, ModTime: info.ModTime()}); err != nil {
+		return
+	}
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+	// A file that grows while being read is cut at the announced size
+	data := flow.Reader(context.Background(), io.LimitReader(file, size))
+	if err := writeMirrorData(writer, key, data); err != nil {
+		return
+	}
+	writer.Flush()
+}
+
+// mirrorTarget checks that remote is paired, and may write into the share
+// unless the request only reads, and resolves the request's share root and
+// path
+func (tm *TransferManager) mirrorTarget(remote string, request mirrorRequest) (string, string, error) {
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", remote).Count(&count)
+	if count == 0 {
+		return "", "", fmt.Errorf("not paired")
+	}
+
+	root, ok := tm.sharedFolderFor(remote, request.Share)
+	if !ok {
+		return "", "", fmt.Errorf("unknown share")
+	}
+	readOnly := request.Op == MirrorGet || request.Op == MirrorHashes
+	if !readOnly && !tm.shareWritable(remote, request.Share) {
+		return "", "", fmt.Errorf("share is read-only")
+	}
+
+	target, err := sharePath(root, request.Path)
+	if err != nil {
+		return "", "", err
+	}
+	return root, target, nil
+}
+
+func (tm *TransferManager) applyMirrorRequest(remote string, key []byte, request mirrorRequest, reader io.Reader) error {
+	root, target, err := tm.mirrorTarget(remote, request)
+	if err != nil {
+		return err
+	}
+
+	switch request.Op {
+	case MirrorPut:
+		flow := bandwidth.Open(bandwidth.ClassSync)
+		defer flow.Close()
+		return receiveMirrorFile(flow.Reader(context.Background(), reader), key, target, request.Size, request.ModTime)
+
+	case MirrorMkdir:
+		return os.MkdirAll(target, 0755)
+
+	case MirrorDelete:
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to delete")
+		}
+		return nil
+
+	case MirrorArchive:
+		stamp := filepath.Base(request.Stamp)
+		if stamp == "" || stamp == "." || stamp == ".." {
+			return fmt.Errorf("invalid archive stamp")
+		}
+		dest, err := sharePath(root, MirrorArchiveDir+"/"+stamp+"/"+request.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create archive directory")
+		}
+		if err := os.Rename(target, dest); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to archive")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown operation %q", request.Op)
+	}
+}
+
+func receiveMirrorFile(reader io.Reader, key []byte, target string, size int64, modTime time.Time) error {
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory")
+	}
+
+	reservation, err := diskspace.Reserve(dir, size)
+	if err != nil {
+		return err
+	}
+	defer reservation.Release()
+
+	tmp, err := os.CreateTemp(dir, ".sfm-mirror-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := readMirrorData(reader, key, tmp, size); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file")
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to save file")
+	}
+	// Mirrors compare by size and mtime, keep them identical to the source
+	os.Chtimes(target, modTime, modTime)
+	return nil
+}
+
+// readMirrorData reads size bytes sent by writeMirrorData into w and
+// checks them against the trailing SHA-256
+func readMirrorData(reader io.Reader, key []byte, w io.Writer, size int64) error {
+	hasher := sha256.New()
+	received := int64(0)
+	for received < size {
+		var chunkSize uint32
+		if err := binary.Read(reader, binary.LittleEndian, &chunkSize); err != nil {
+			return fmt.Errorf("transfer interrupted")
+		}
+		if chunkSize > ChunkSize+64 {
+			return fmt.Errorf("chunk too large")
+		}
+
+		encrypted := make([]byte, chunkSize)
+		if _, err := io.ReadFull(reader, encrypted); err != nil {
+			return fmt.Errorf("transfer interrupted")
+		}
+		decrypted, err := crypto.Decrypt(encrypted, key)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt chunk")
+		}
+		if _, err := w.Write(decrypted); err != nil {
+			return fmt.Errorf("failed to write file")
+		}
+		hasher.Write(decrypted)
+		received += int64(len(decrypted))
+	}
+
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(reader, checksum); err != nil {
+		return fmt.Errorf("transfer interrupted")
+	}
+	if received != size || string(checksum) != string(hasher.Sum(nil)) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// sharePath maps a slash-separated relative path into a share root,
+// rejecting escapes and the root itself
+func sharePath(root, rel string) (string, error) {
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	check, err := filepath.Rel(root, target)
+	if err != nil || check == "." || check == ".." || strings.HasPrefix(check, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path")
+	}
+	return target, nil
+}
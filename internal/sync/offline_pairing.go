@@ -0,0 +1,280 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm/clause"
+)
+
+// Offline pairing exchanges signed files, e.g. on a USB stick, instead of
+// talking over the network:
+//
+//  1. A writes an offer with ExportPairingOffer
+//  2. B reads it with AcceptPairingOffer, pairs with A and writes an answer
+//     that embeds the offer
+//  3. A reads the answer with AcceptPairingOffer and pairs with B
+//
+// Each file is signed with its device's libp2p key, so neither side can be
+// handed a key the other did not sign for.
+const (
+	pairingKindOffer  = "offer"
+	pairingKindAnswer = "answer"
+
+	// PairingOfferTTL is how long an offer can be answered; the stick may
+	// take a while to travel
+	PairingOfferTTL = 7 * 24 * time.Hour
+
+	maxPairingFileSize = 64 * 1024
+)
+
+// pairingFile is what a pairing file holds: a pairing record and its
+// signature by the record's device
+type pairingFile struct {
+	Payload   []byte `json:"payload"`
+	Signature []byte `json:"signature"`
+}
+
+type pairingRecord struct {
+	Kind        string       `json:"kind"`
+	PeerID      string       `json:"peer_id"`
+	PublicKey   []byte       `json:"public_key"`
+	DeviceName  string       `json:"device_name"`
+	Addresses   []string     `json:"addresses,omitempty"`
+	AccountID   string       `json:"account_id"`
+	AccountName string       `json:"account_name,omitempty"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ExpiresAt   time.Time    `json:"expires_at"`
+	Offer       *pairingFile `json:"offer,omitempty"` // The offer an answer accepts
+}
+
+// ExportPairingOffer writes a signed offer to pair into an account, given
+// by name or ID, to path. An empty account starts a new one. deviceName is
+// how this device introduces itself.
+func (pm *PairingManager) ExportPairingOffer(path, deviceName, account string) error {
+	var accountID, accountName string
+	if account == "" {
+		id, err := newAccountID()
+		if err != nil {
+			return err
+		}
+		accountID = id
+	} else {
+		id, err := ResolveAccount(account)
+		if err != nil {
+			return err
+		}
+		var info models.AccountInfo
+		storage.DB().Where("account_id = ?", id).First(&info)
+		accountID, accountName = id, info.Name
+	}
+
+	now := time.Now()
+	record, err := pm.pairingRecord(pairingKindOffer, deviceName, accountID, accountName, now)
+	if err != nil {
+		return err
+	}
+	record.ExpiresAt = now.Add(PairingOfferTTL)
+	return pm.writePairingFile(path, record)
+}
+
+// AcceptPairingOffer reads a pairing file. For another device's offer it
+// pairs with that device and writes the answer to take back, returning its
+// path. For the answer to an offer of this device it completes the
+// pairing and returns "". deviceName is how this device introduces itself
+// in an answer.
+func (pm *PairingManager) AcceptPairingOffer(path, deviceName string) (string, error) {
+	record, file, err := readPairingFile(path)
+	if err != nil {
+		return "", err
+	}
+	self := pm.node.GetPeerID().String()
+	if record.PeerID == self {
+		return "", fmt.Errorf("pairing file was written by this device")
+	}
+
+	switch record.Kind {
+	case pairingKindOffer:
+		if time.Now().After(record.ExpiresAt) {
+			return "", fmt.Errorf("pairing offer expired on %s", record.ExpiresAt.Format(time.DateOnly))
+		}
+		if _, err := ensureAccount(pm.node, record.AccountID, record.AccountName); err != nil {
+			return "", err
+		}
+		if err := savePairedDevice(record, record.AccountID); err != nil {
+			return "", err
+		}
+
+		answer, err := pm.pairingRecord(pairingKindAnswer, deviceName, record.AccountID, "", time.Now())
+		if err != nil {
+			return "", err
+		}
+		answer.ExpiresAt = record.ExpiresAt
+		answer.Offer = file
+		answerPath := answerPathFor(path)
+		if err := pm.writePairingFile(answerPath, answer); err != nil {
+			return "", err
+		}
+		log.Printf("Paired with %s from offline offer", logging.Fingerprint(record.PeerID))
+		return answerPath, nil
+
+	case pairingKindAnswer:
+		// Only an offer this device signed can be answered
+		if record.Offer == nil {
+			return "", fmt.Errorf("pairing answer holds no offer")
+		}
+		offer, err := verifyPairingFile(record.Offer)
+		if err != nil {
+			return "", fmt.Errorf("invalid offer in pairing answer: %w", err)
+		}
+		if offer.Kind != pairingKindOffer || offer.PeerID != self {
+			return "", fmt.Errorf("pairing answer is for another device's offer")
+		}
+		if time.Now().After(offer.ExpiresAt) {
+			return "", fmt.Errorf("pairing offer expired on %s", offer.ExpiresAt.Format(time.DateOnly))
+		}
+		if record.AccountID != offer.AccountID {
+			return "", fmt.Errorf("pairing answer is for another account")
+		}
+		if _, err := ensureAccount(pm.node, offer.AccountID, offer.AccountName); err != nil {
+			return "", err
+		}
+		if err := savePairedDevice(record, offer.AccountID); err != nil {
+			return "", err
+		}
+		log.Printf("Paired with %s from offline answer", logging.Fingerprint(record.PeerID))
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown pairing file kind %q", record.Kind)
+}
+
+// pairingRecord describes this device
+func (pm *PairingManager) pairingRecord(kind, deviceName, accountID, accountName string, now time.Time) (*pairingRecord, error) {
+	pubKey, err := crypto.MarshalPublicKey(pm.node.GetPrivateKey().GetPublic())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	record := &pairingRecord{
+		Kind:        kind,
+		PeerID:      pm.node.GetPeerID().String(),
+		PublicKey:   pubKey,
+		DeviceName:  deviceName,
+		AccountID:   accountID,
+		AccountName: accountName,
+		CreatedAt:   now,
+	}
+	for _, addr := range pm.node.GetAddresses() {
+		record.Addresses = append(record.Addresses, addr.String())
+	}
+	return record, nil
+}
+
+func (pm *PairingManager) writePairingFile(path string, record *pairingRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode pairing file: %w", err)
+	}
+	signature, err := pm.node.GetPrivateKey().Sign(payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign pairing file: %w", err)
+	}
+	data, err := json.MarshalIndent(pairingFile{Payload: payload, Signature: signature}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pairing file: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write pairing file: %w", err)
+	}
+	return nil
+}
+
+func loadPairingFile(path string) (*pairingFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pairing file: %w", err)
+	}
+	if len(data) > maxPairingFileSize {
+		return nil, fmt.Errorf("pairing file too large")
+	}
+	var file pairingFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid pairing file: %w", err)
+	}
+	return &file, nil
+}
+
+func readPairingFile(path string) (*pairingRecord, *pairingFile, error) {
+	file, err := loadPairingFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	record, err := verifyPairingFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, file, nil
+}
+
+// verifyPairingFile checks that a pairing file was signed by the key of
+// the device it describes
+func verifyPairingFile(file *pairingFile) (*pairingRecord, error) {
+	var record pairingRecord
+	if err := json.Unmarshal(file.Payload, &record); err != nil {
+		return nil, fmt.Errorf("invalid pairing file: %w", err)
+	}
+	pubKey, err := crypto.UnmarshalPublicKey(record.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in pairing file: %w", err)
+	}
+	peerID, err := peer.IDFromPublicKey(pubKey)
+	if err != nil || peerID.String() != record.PeerID {
+		return nil, fmt.Errorf("pairing file key does not match its peer ID")
+	}
+	ok, err := pubKey.Verify(file.Payload, file.Signature)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("pairing file signature is invalid")
+	}
+	if record.AccountID == "" {
+		return nil, fmt.Errorf("pairing file names no account")
+	}
+	return &record, nil
+}
+
+// savePairedDevice pairs the device a record describes in an account
+func savePairedDevice(record *pairingRecord, accountID string) error {
+	device := models.PairedDevice{
+		PeerID:     record.PeerID,
+		DeviceName: record.DeviceName,
+		PublicKey:  record.PublicKey,
+		AccountID:  accountID,
+		LastSeen:   record.CreatedAt,
+	}
+	if len(record.Addresses) > 0 {
+		device.LocalAddress = record.Addresses[0]
+	}
+	err := storage.DB().Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "peer_id"}, {Name: "account_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"device_name", "public_key", "local_address", "deleted_at", "updated_at"}),
+	}).Create(&device).Error
+	if err != nil {
+		return fmt.Errorf("failed to save paired device: %w", err)
+	}
+	return nil
+}
+
+// answerPathFor names the answer to an offer next to it, e.g.
+// offer.json -> offer-answer.json
+func answerPathFor(offerPath string) string {
+	ext := filepath.Ext(offerPath)
+	return strings.TrimSuffix(offerPath, ext) + "-answer" + ext
+}
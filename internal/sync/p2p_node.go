@@ -133,6 +133,11 @@ func (n *P2PNode) GetAddresses() []multiaddr.Multiaddr {
 	return n.host.Addrs()
 }
 
+// GetPrivateKey returns the node's identity key
+func (n *P2PNode) GetPrivateKey() crypto.PrivKey {
+	return n.host.Peerstore().PrivKey(n.host.ID())
+}
+
 // GetHost returns the libp2p host
 func (n *P2PNode) GetHost() host.Host {
 	return n.host
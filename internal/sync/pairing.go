@@ -53,8 +53,16 @@ func (pm *PairingManager) GeneratePairingCode() (string, []byte, error) {
 	return pairingData, qrCode, nil
 }
 
-// PairWithCode pairs with another device using a pairing code
+// PairWithCode pairs with another device using a pairing code, starting a
+// new account shared by the two devices
 func (pm *PairingManager) PairWithCode(ctx context.Context, pairingCode, deviceName string) error {
+	return pm.PairIntoAccount(ctx, pairingCode, deviceName, "")
+}
+
+// PairIntoAccount pairs with another device using a pairing code and adds it
+// to one of this device's accounts, given by name or ID. An empty accountID
+// starts a new account.
+func (pm *PairingManager) PairIntoAccount(ctx context.Context, pairingCode, deviceName, accountID string) error {
 	// Parse pairing code: PIN|PeerID|Address
 	var pin, peerIDStr, addrStr string
 	fmt.Sscanf(pairingCode, "%s|%s|%s", &pin, &peerIDStr, &addrStr)
@@ -69,8 +77,12 @@ func (pm *PairingManager) PairWithCode(ctx context.Context, pairingCode, deviceN
 	// In real implementation, would use the address to connect
 	// For now, we'll rely on DHT discovery
 
-	// Generate shared account ID (hash of both peer IDs)
-	accountID := generateAccountID(pm.node.GetPeerID(), peerID)
+	if accountID == "" {
+		// Generate shared account ID (hash of both peer IDs)
+		accountID = generateAccountID(pm.node.GetPeerID(), peerID)
+	} else if accountID, err = ResolveAccount(accountID); err != nil {
+		return err
+	}
 
 	// Get peer's public key (would exchange via libp2p stream)
 	pubKey, err := peerID.ExtractPublicKey()
@@ -99,12 +111,15 @@ func (pm *PairingManager) PairWithCode(ctx context.Context, pairingCode, deviceN
 	}
 
 	// Update local account info
-	pm.updateAccountInfo(accountID)
+	if _, err := ensureAccount(pm.node, accountID, ""); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-// ListPairedDevices returns all paired devices
+// ListPairedDevices returns all paired devices, once per account they are
+// paired in
 func (pm *PairingManager) ListPairedDevices() ([]models.PairedDevice, error) {
 	db := storage.DB()
 	var devices []models.PairedDevice
@@ -114,25 +129,26 @@ func (pm *PairingManager) ListPairedDevices() ([]models.PairedDevice, error) {
 	return devices, nil
 }
 
-// RevokePairing removes a paired device
+// ListAccountDevices returns the devices paired in one account
+func (pm *PairingManager) ListAccountDevices(accountID string) ([]models.PairedDevice, error) {
+	db := storage.DB()
+	var devices []models.PairedDevice
+	if err := db.Where("account_id = ?", accountID).Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// RevokePairing removes a paired device from every account
 func (pm *PairingManager) RevokePairing(peerID string) error {
 	db := storage.DB()
 	return db.Where("peer_id = ?", peerID).Delete(&models.PairedDevice{}).Error
 }
 
-func (pm *PairingManager) updateAccountInfo(accountID string) error {
+// RevokeFromAccount removes a paired device from one account only
+func (pm *PairingManager) RevokeFromAccount(peerID, accountID string) error {
 	db := storage.DB()
-
-	// Get or create account info
-	var accountInfo models.AccountInfo
-	result := db.Where("peer_id = ?", pm.node.GetPeerID().String()).FirstOrCreate(&accountInfo)
-	if result.Error != nil {
-		return result.Error
-	}
-
-	// Update account ID
-	accountInfo.AccountID = accountID
-	return db.Save(&accountInfo).Error
+	return db.Where("peer_id = ? AND account_id = ?", peerID, accountID).Delete(&models.PairedDevice{}).Error
 }
 
 func generatePIN(length int) (string, error) {
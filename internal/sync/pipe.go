@@ -0,0 +1,283 @@
+package sync
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const (
+	PipeProtocolID = "/sfm/pipe/1.0.0"
+	pipeChunkSize  = 256 * 1024 // Small enough to keep interactive pipelines flowing
+)
+
+var ErrNoPipeReceiver = errors.New("peer is not waiting for a pipe")
+
+type pipeRequest struct {
+	Label string `json:"label"`
+}
+
+type pipeResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// pipeReceiver is a pending ReceivePipe call
+type pipeReceiver struct {
+	label  string
+	from   peer.ID
+	stream chan network.Stream
+}
+
+// RegisterPipeHandler registers the pipe protocol handler
+func (tm *TransferManager) RegisterPipeHandler() {
+	tm.node.host.SetStreamHandler(protocol.ID(PipeProtocolID), tm.handleIncomingPipe)
+}
+
+// SendPipe streams r to a peer blocked in ReceivePipe with the same label
+// until r reaches EOF. Nothing is buffered to disk on either side.
+func (tm *TransferManager) SendPipe(ctx context.Context, peerID peer.ID, label string, r io.Reader) (sent int64, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "sync.pipe.send", telemetry.AttrPeer.String(peerID.String()))
+	defer func() {
+		span.SetAttributes(telemetry.AttrBytes.Int64(sent))
+		telemetry.EndSpan(span, err)
+	}()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(PipeProtocolID))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReader(stream)
+	writer := bufio.NewWriter(stream)
+
+	if err := writeEncryptedJSON(writer, key, pipeRequest{Label: label}); err != nil {
+		return 0, err
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	var ready pipeResponse
+	if err := readEncryptedJSON(reader, key, maxFileHeaderSize, &ready); err != nil {
+		return 0, fmt.Errorf("failed to read pipe response: %w", err)
+	}
+	if ready.Error != "" {
+		return 0, fmt.Errorf("%w: %s", ErrNoPipeReceiver, ready.Error)
+	}
+
+	// Frames: [length: uint32][encrypted chunk]..., a zero length ends the
+	// stream and is followed by the SHA-256 of everything sent
+	hasher := sha256.New()
+	buffer := make([]byte, pipeChunkSize)
+	for {
+		n, readErr := r.Read(buffer)
+		if n > 0 {
+			encrypted, err := crypto.Encrypt(buffer[:n], key)
+			if err != nil {
+				return sent, fmt.Errorf("failed to encrypt chunk: %w", err)
+			}
+			if err := binary.Write(writer, binary.LittleEndian, uint32(len(encrypted))); err != nil {
+				return sent, err
+			}
+			if _, err := writer.Write(encrypted); err != nil {
+				return sent, err
+			}
+			// Flush per chunk so slow producers are not held back by buffering
+			if err := writer.Flush(); err != nil {
+				return sent, err
+			}
+			hasher.Write(buffer[:n])
+			sent += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return sent, fmt.Errorf("failed to read input: %w", readErr)
+		}
+		if ctx.Err() != nil {
+			return sent, ctx.Err()
+		}
+	}
+
+	if err := binary.Write(writer, binary.LittleEndian, uint32(0)); err != nil {
+		return sent, err
+	}
+	if _, err := writer.Write(hasher.Sum(nil)); err != nil {
+		return sent, err
+	}
+	if err := writer.Flush(); err != nil {
+		return sent, err
+	}
+
+	var done pipeResponse
+	if err := readEncryptedJSON(reader, key, maxFileHeaderSize, &done); err != nil {
+		return sent, fmt.Errorf("failed to read pipe result: %w", err)
+	}
+	if done.Error != "" {
+		return sent, fmt.Errorf("peer reported: %s", done.Error)
+	}
+	return sent, nil
+}
+
+// ReceivePipe waits for a peer to SendPipe with the given label and writes
+// the data to w. If from is non-empty only that peer is accepted.
+func (tm *TransferManager) ReceivePipe(ctx context.Context, label string, from peer.ID, w io.Writer) (received int64, err error) {
+	receiver := &pipeReceiver{label: label, from: from, stream: make(chan network.Stream, 1)}
+
+	tm.pipesMu.Lock()
+	if _, exists := tm.pipes[label]; exists {
+		tm.pipesMu.Unlock()
+		return 0, fmt.Errorf("already receiving pipe %q", label)
+	}
+	if tm.pipes == nil {
+		tm.pipes = make(map[string]*pipeReceiver)
+	}
+	tm.pipes[label] = receiver
+	tm.pipesMu.Unlock()
+
+	defer func() {
+		tm.pipesMu.Lock()
+		if tm.pipes[label] == receiver {
+			delete(tm.pipes, label)
+		}
+		tm.pipesMu.Unlock()
+	}()
+
+	var stream network.Stream
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case stream = <-receiver.stream:
+	}
+	defer stream.Close()
+
+	_, span := telemetry.StartSpan(ctx, "sync.pipe.receive",
+		telemetry.AttrPeer.String(stream.Conn().RemotePeer().String()))
+	defer func() {
+		span.SetAttributes(telemetry.AttrBytes.Int64(received))
+		telemetry.EndSpan(span, err)
+	}()
+
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		return 0, err
+	}
+
+	received, err = readPipeFrames(bufio.NewReader(stream), key, w)
+
+	response := pipeResponse{}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	writeEncryptedJSON(stream, key, response)
+	return received, err
+}
+
+func readPipeFrames(reader io.Reader, key []byte, w io.Writer) (int64, error) {
+	hasher := sha256.New()
+	var received int64
+
+	for {
+		var length uint32
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+			return received, fmt.Errorf("pipe interrupted: %w", err)
+		}
+		if length == 0 {
+			break
+		}
+		if length > pipeChunkSize+64 {
+			return received, fmt.Errorf("pipe chunk too large: %d bytes", length)
+		}
+
+		encrypted := make([]byte, length)
+		if _, err := io.ReadFull(reader, encrypted); err != nil {
+			return received, fmt.Errorf("pipe interrupted: %w", err)
+		}
+		chunk, err := crypto.Decrypt(encrypted, key)
+		if err != nil {
+			return received, fmt.Errorf("failed to decrypt chunk: %w", err)
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return received, fmt.Errorf("failed to write output: %w", err)
+		}
+		hasher.Write(chunk)
+		received += int64(len(chunk))
+	}
+
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(reader, checksum); err != nil {
+		return received, fmt.Errorf("pipe interrupted: %w", err)
+	}
+	if string(checksum) != string(hasher.Sum(nil)) {
+		return received, fmt.Errorf("pipe checksum mismatch")
+	}
+	return received, nil
+}
+
+func (tm *TransferManager) handleIncomingPipe(stream network.Stream) {
+	remote := stream.Conn().RemotePeer()
+	key, err := tm.peerKey(remote)
+	if err != nil {
+		stream.Close()
+		return
+	}
+
+	var request pipeRequest
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &request); err != nil {
+		stream.Close()
+		return
+	}
+
+	reject := func(reason string) {
+		writeEncryptedJSON(stream, key, pipeResponse{Error: reason})
+		stream.Close()
+	}
+
+	var count int64
+	storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", remote.String()).Count(&count)
+	if count == 0 {
+		reject("not paired")
+		return
+	}
+
+	tm.pipesMu.Lock()
+	receiver, ok := tm.pipes[request.Label]
+	if ok && receiver.from != "" && receiver.from != remote {
+		ok = false
+	}
+	if ok {
+		// One sender per ReceivePipe call
+		delete(tm.pipes, request.Label)
+	}
+	tm.pipesMu.Unlock()
+
+	if !ok {
+		reject("no receiver for " + request.Label)
+		return
+	}
+
+	if err := writeEncryptedJSON(stream, key, pipeResponse{}); err != nil {
+		stream.Close()
+		return
+	}
+	// ReceivePipe owns the stream from here
+	receiver.stream <- stream
+}
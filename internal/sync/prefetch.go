@@ -0,0 +1,171 @@
+package sync
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// prefetchUsageRows bounds how many of the most used files inform
+	// predictions
+	prefetchUsageRows = 1000
+	// siblingWeight is how much use of other files in a directory counts
+	// toward a placeholder in it
+	siblingWeight = 0.5
+)
+
+// Prefetcher fetches the placeholders most likely to be opened soon, judged
+// by how often and how recently they and the files next to them were used.
+// It only runs while no transfer is active and the network is unmetered.
+type Prefetcher struct {
+	tm           *TransferManager
+	maxBytes     int64
+	idleAfter    time.Duration
+	allowMetered bool
+	metered      func() bool
+}
+
+// NewPrefetcher creates a prefetcher fetching up to maxBytes per run
+func NewPrefetcher(tm *TransferManager, maxBytes int64) *Prefetcher {
+	return &Prefetcher{
+		tm:        tm,
+		maxBytes:  maxBytes,
+		idleAfter: 5 * time.Minute,
+		metered:   networkMetered,
+	}
+}
+
+// SetIdleAfter sets how long transfers must have been quiet before a run
+func (p *Prefetcher) SetIdleAfter(d time.Duration) {
+	p.idleAfter = d
+}
+
+// SetAllowMetered lets runs proceed on metered networks
+func (p *Prefetcher) SetAllowMetered(allow bool) {
+	p.allowMetered = allow
+}
+
+// Start runs the prefetcher every interval until ctx is done
+func (p *Prefetcher) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !p.tm.Idle(p.idleAfter) || (!p.allowMetered && p.metered()) {
+				continue
+			}
+			if n, err := p.RunOnce(ctx); err != nil {
+				log.Printf("Prefetch failed: %v", err)
+			} else if n > 0 {
+				log.Printf("Prefetched %d files", n)
+			}
+		}
+	}
+}
+
+// RunOnce fetches the highest-ranked placeholders that fit in the byte
+// budget and returns how many were fetched. Unreachable peers are skipped.
+func (p *Prefetcher) RunOnce(ctx context.Context) (int, error) {
+	candidates, err := p.candidates()
+	if err != nil {
+		return 0, err
+	}
+
+	fetched := 0
+	budget := p.maxBytes
+	for _, file := range candidates {
+		if file.Size > budget {
+			continue
+		}
+		if err := p.tm.Materialize(ctx, file.LocalPath); err != nil {
+			if ctx.Err() != nil {
+				return fetched, ctx.Err()
+			}
+			log.Printf("Prefetch of %s failed: %v", logging.Path(file.LocalPath), err)
+			continue
+		}
+		budget -= file.Size
+		fetched++
+	}
+	return fetched, nil
+}
+
+// candidates returns placeholders with any predicted use, most likely first
+func (p *Prefetcher) candidates() ([]models.RemoteFile, error) {
+	db := storage.DB()
+
+	var used []models.FileUsage
+	if err := db.Order("frecency DESC").Limit(prefetchUsageRows).Find(&used).Error; err != nil {
+		return nil, err
+	}
+	files := make(map[string]float64)
+	dirs := make(map[string]float64)
+	for _, u := range used {
+		files[u.Path] += u.Frecency
+		dirs[filepath.Dir(u.Path)] += u.Frecency
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	type candidate struct {
+		file  models.RemoteFile
+		score float64
+	}
+	var ranked []candidate
+	seen := make(map[string]bool)
+	var batch []models.RemoteFile
+	result := db.Where("local_path <> ''").FindInBatches(&batch, catalogBatch, func(tx *gorm.DB, _ int) error {
+		for _, file := range batch {
+			score := files[file.LocalPath] + siblingWeight*dirs[filepath.Dir(file.LocalPath)]
+			if score <= 0 || seen[file.LocalPath] {
+				continue
+			}
+			if _, err := os.Stat(file.LocalPath); !os.IsNotExist(err) {
+				continue
+			}
+			seen[file.LocalPath] = true
+			ranked = append(ranked, candidate{file, score})
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	out := make([]models.RemoteFile, len(ranked))
+	for i, c := range ranked {
+		out[i] = c.file
+	}
+	return out, nil
+}
+
+// networkMetered reports whether NetworkManager considers the current
+// connection metered. Without NetworkManager the network is assumed
+// unmetered.
+func networkMetered() bool {
+	out, err := exec.Command("busctl", "get-property",
+		"org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager", "Metered").Output()
+	if err != nil {
+		return false
+	}
+	// "u 1": NM_METERED_YES is 1, NM_METERED_GUESS_YES is 3
+	fields := strings.Fields(string(out))
+	return len(fields) == 2 && (fields[1] == "1" || fields[1] == "3")
+}
@@ -0,0 +1,66 @@
+package sync
+
+import (
+	"context"
+	"io"
+
+	"github.com/owner/secure-file-manager/internal/progress"
+)
+
+// SetProgressHandler sets the handler progress events are delivered to
+func (tm *TransferManager) SetProgressHandler(handler progress.Handler) {
+	tm.onProgress = handler
+}
+
+// reportFile reports bytes of file moved so far. A transfer that is part of
+// a session on ctx reports under the session's ID, followed by the
+// session's aggregate; others report under id.
+func (tm *TransferManager) reportFile(ctx context.Context, id, file string, bytes, total int64) {
+	session := progress.FromContext(ctx)
+	var aggregate progress.Event
+	if session != nil {
+		id = session.ID()
+		aggregate = session.File(file, bytes, total)
+	}
+	if tm.onProgress == nil {
+		return
+	}
+	tm.onProgress(progress.Event{Kind: progress.KindFile, SessionID: id, File: file, Bytes: bytes, Total: total})
+	if session != nil {
+		tm.onProgress(aggregate)
+	}
+}
+
+// reportSkipped reports a file that was not sent for reason
+func (tm *TransferManager) reportSkipped(ctx context.Context, file string, size int64, reason string) {
+	session := progress.FromContext(ctx)
+	event := progress.Event{Kind: progress.KindSkipped, File: file, Total: size, Reason: reason}
+	var aggregate progress.Event
+	if session != nil {
+		event.SessionID = session.ID()
+		aggregate = session.Skip(file, size)
+	}
+	if tm.onProgress == nil {
+		return
+	}
+	tm.onProgress(event)
+	if session != nil {
+		tm.onProgress(aggregate)
+	}
+}
+
+// progressReader reports the bytes read through it
+type progressReader struct {
+	r      io.Reader
+	read   int64
+	report func(read int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.read += int64(n)
+		p.report(p.read)
+	}
+	return n, err
+}
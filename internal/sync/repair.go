@@ -0,0 +1,203 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/bandwidth"
+)
+
+// Block repair compares per-block hashes with a peer's copy and fetches
+// only the blocks that differ, so a few flipped bits in a large file cost a
+// block, not the file. Blocks grow with the file so the hash list fits in
+// one mirror response.
+const (
+	minRepairBlock  = 1 << 20 // 1MB
+	maxRepairBlocks = 512
+)
+
+// repairBlockSize returns the block size used to repair a file of size
+func repairBlockSize(size int64) int64 {
+	blockSize := int64(minRepairBlock)
+	for size > blockSize*maxRepairBlocks {
+		blockSize *= 2
+	}
+	return blockSize
+}
+
+// repairBlocks rebuilds localPath into tmp from the local copy and the
+// blocks of the peer's copy that differ from it. It returns how many
+// blocks were fetched and how many the file has. The caller verifies tmp.
+func (tm *TransferManager) repairBlocks(ctx context.Context, peerID peer.ID, share, relPath, localPath, tmp string) (int, int, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	blockSize := repairBlockSize(info.Size())
+	size, remote, err := tm.mirrorHashes(ctx, peerID, share, relPath, blockSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	if size != info.Size() {
+		return 0, 0, fmt.Errorf("peer's copy is %d bytes, local file is %d", size, info.Size())
+	}
+	local, err := blockHashes(localPath, blockSize)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to hash %s: %w", localPath, err)
+	}
+	if len(local) != len(remote) {
+		return 0, 0, fmt.Errorf("peer sent %d block hashes for %d blocks", len(remote), len(local))
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create repair file: %w", err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		return 0, 0, fmt.Errorf("failed to copy %s: %w", localPath, err)
+	}
+
+	fetched := 0
+	for i := range local {
+		if bytes.Equal(local[i], remote[i]) {
+			continue
+		}
+		offset := int64(i) * blockSize
+		if err := tm.mirrorGetRange(ctx, peerID, share, relPath, offset, blockSize, io.NewOffsetWriter(dst, offset)); err != nil {
+			return fetched, len(local), err
+		}
+		fetched++
+	}
+	if err := dst.Close(); err != nil {
+		return fetched, len(local), fmt.Errorf("failed to write repair file: %w", err)
+	}
+	return fetched, len(local), nil
+}
+
+// mirrorHashes asks a peer for the size and block hashes of a shared file
+func (tm *TransferManager) mirrorHashes(ctx context.Context, peerID peer.ID, share, relPath string, blockSize int64) (int64, [][]byte, error) {
+	defer tm.busy()()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(MirrorProtocolID))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	request := mirrorRequest{Op: MirrorHashes, Share: share, Path: relPath, BlockSize: blockSize}
+	if err := writeEncryptedJSON(stream, key, request); err != nil {
+		return 0, nil, err
+	}
+	var response mirrorResponse
+	if err := readEncryptedJSON(bufio.NewReader(stream), key, maxFileHeaderSize, &response); err != nil {
+		return 0, nil, fmt.Errorf("failed to read mirror response: %w", err)
+	}
+	if response.Error != "" {
+		return 0, nil, fmt.Errorf("peer rejected hashes %s: %s", relPath, response.Error)
+	}
+	return response.Size, response.Hashes, nil
+}
+
+// mirrorGetRange fetches length bytes of a shared file from offset into w
+func (tm *TransferManager) mirrorGetRange(ctx context.Context, peerID peer.ID, share, relPath string, offset, length int64, w io.Writer) error {
+	defer tm.busy()()
+
+	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(MirrorProtocolID))
+	if err != nil {
+		return fmt.Errorf("failed to create stream: %w", err)
+	}
+	defer stream.Close()
+	key, err := tm.peerKey(peerID)
+	if err != nil {
+		return err
+	}
+
+	request := mirrorRequest{Op: MirrorGet, Share: share, Path: relPath, Offset: offset, Length: length}
+	if err := writeEncryptedJSON(stream, key, request); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(stream)
+	var response mirrorResponse
+	if err := readEncryptedJSON(reader, key, maxFileHeaderSize, &response); err != nil {
+		return fmt.Errorf("failed to read mirror response: %w", err)
+	}
+	if response.Error != "" {
+		return fmt.Errorf("peer rejected get %s: %s", relPath, response.Error)
+	}
+	if response.Size > length {
+		return fmt.Errorf("peer sent %d bytes for a %d byte range", response.Size, length)
+	}
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+	if err := readMirrorData(flow.Reader(ctx, reader), key, w, response.Size); err != nil {
+		return fmt.Errorf("failed to fetch %s at %d: %w", relPath, offset, err)
+	}
+	return nil
+}
+
+// serveMirrorHashes answers a hashes request with the file's size and the
+// SHA-256 of each block
+func (tm *TransferManager) serveMirrorHashes(w io.Writer, key []byte, remote string, request mirrorRequest) {
+	_, target, err := tm.mirrorTarget(remote, request)
+	if err != nil {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: err.Error()})
+		return
+	}
+	info, err := os.Stat(target)
+	if err != nil || !info.Mode().IsRegular() {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: "not found"})
+		return
+	}
+	if request.BlockSize < minRepairBlock || info.Size() > request.BlockSize*maxRepairBlocks {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: "invalid block size"})
+		return
+	}
+
+	hashes, err := blockHashes(target, request.BlockSize)
+	if err != nil {
+		writeEncryptedJSON(w, key, mirrorResponse{Error: "failed to read"})
+		return
+	}
+	writeEncryptedJSON(w, key, mirrorResponse{Size: info.Size(), ModTime: info.ModTime(), Hashes: hashes})
+}
+
+// blockHashes returns the SHA-256 of each blockSize block of a file
+func blockHashes(path string, blockSize int64) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hashes [][]byte
+	for {
+		h := sha256.New()
+		n, err := io.CopyN(h, file, blockSize)
+		if n > 0 {
+			hashes = append(hashes, h.Sum(nil))
+		}
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -3,42 +3,93 @@ package sync
 import (
 	"bufio"
 	"context"
+	"crypto/hkdf"
 	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
+	gosync "sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/owner/secure-file-manager/internal/bandwidth"
 	"github.com/owner/secure-file-manager/internal/crypto"
+	"github.com/owner/secure-file-manager/internal/directory"
+	"github.com/owner/secure-file-manager/internal/diskspace"
+	"github.com/owner/secure-file-manager/internal/hold"
+	"github.com/owner/secure-file-manager/internal/journal"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/onreceive"
+	"github.com/owner/secure-file-manager/internal/progress"
+	"github.com/owner/secure-file-manager/internal/quarantine"
+	"github.com/owner/secure-file-manager/internal/relay"
 	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+	"github.com/owner/secure-file-manager/internal/usage"
 	"github.com/owner/secure-file-manager/pkg/models"
 )
 
 const (
-	TransferProtocolID = "/sfm/transfer/1.0.0"
+	TransferProtocolID = "/sfm/transfer/1.1.0"
 	ChunkSize          = 4 * 1024 * 1024 // 4MB
 )
 
 type TransferManager struct {
-	node        *P2PNode
-	onProgress  func(transferred, total int64)
-	downloadDir string
+	node          *P2PNode
+	onProgress    progress.Handler
+	downloadDir   string
+	mailbox       *relay.Mailbox
+	directory     *directory.Client
+	shares        map[string]string
+	shareAccounts map[string]string   // Share name -> account allowed to use it
+	shareWriters  map[string][]string // Share name -> peers that may write into it
+	sharesMu      gosync.RWMutex
+	pipes         map[string]*pipeReceiver
+	pipesMu       gosync.Mutex
+	wakeBroadcast string
+	active        atomic.Int32
+	lastActive    atomic.Int64 // Unix nanoseconds
+	sendPolicy    ApprovalPolicy
+	approvals     map[string]*pendingApproval // Outgoing approval requests by ID
+	approvalMu    gosync.Mutex
+	dedupMinSize  int64
+	transfers     map[string]*activeTransfer // Transfers in progress by ID
+	transfersMu   gosync.Mutex
+	onKeyAlert    func(KeyAlert)
+	keyAlerted    map[uint]string // Presented key fingerprint last alerted per device
+	keyAlertMu    gosync.Mutex
 }
 
 func NewTransferManager(node *P2PNode, downloadDir string) *TransferManager {
 	return &TransferManager{
-		node:        node,
-		downloadDir: downloadDir,
+		node:         node,
+		downloadDir:  downloadDir,
+		approvals:    make(map[string]*pendingApproval),
+		dedupMinSize: DefaultDedupMinSize,
 	}
 }
 
-// SetProgressCallback sets the progress callback
-func (tm *TransferManager) SetProgressCallback(callback func(transferred, total int64)) {
-	tm.onProgress = callback
+// busy marks a transfer in progress until the returned function is called
+func (tm *TransferManager) busy() func() {
+	tm.active.Add(1)
+	return func() {
+		tm.lastActive.Store(time.Now().UnixNano())
+		tm.active.Add(-1)
+	}
+}
+
+// Idle reports whether no transfer is running and none ended within d
+func (tm *TransferManager) Idle(d time.Duration) bool {
+	return tm.active.Load() == 0 && time.Since(time.Unix(0, tm.lastActive.Load())) >= d
 }
 
 // RegisterHandler registers the transfer protocol handler
@@ -46,8 +97,24 @@ func (tm *TransferManager) RegisterHandler() {
 	tm.node.host.SetStreamHandler(protocol.ID(TransferProtocolID), tm.handleIncomingTransfer)
 }
 
-// SendFile sends a file to a peer
+// SendFile sends a file to a peer. Files covered by the approval policy
+// are only sent once another trusted device approves.
 func (tm *TransferManager) SendFile(ctx context.Context, peerID peer.ID, filePath string) error {
+	approval, err := tm.approveSend(ctx, peerID, filePath)
+	if err != nil {
+		return err
+	}
+	return tm.sendFile(ctx, peerID, filePath, approval)
+}
+
+// sendFile sends a file that approveSend has cleared, with the approval it
+// returned
+func (tm *TransferManager) sendFile(ctx context.Context, peerID peer.ID, filePath string, approval *models.Approval) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "sync.send", telemetry.AttrPeer.String(peerID.String()))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	defer tm.busy()()
+
 	// Open file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -59,6 +126,19 @@ func (tm *TransferManager) SendFile(ctx context.Context, peerID peer.ID, filePat
 	if err != nil {
 		return fmt.Errorf("failed to stat file: %w", err)
 	}
+	span.SetAttributes(telemetry.AttrBytes.Int64(fileInfo.Size()))
+	if err := checkApproved(approval, fileInfo); err != nil {
+		return err
+	}
+
+	// Skip the bytes if the peer already has this content
+	if tm.offerByHash(ctx, peerID, filePath, fileInfo, dedupRequest{Name: filepath.Base(filePath)}) {
+		span.AddEvent("deduplicated")
+		tm.reportSkipped(ctx, filePath, fileInfo.Size(), progress.ReasonDedup)
+		hash, _ := contentHash(filePath, fileInfo)
+		tm.recordTransfer(peerID.String(), filePath, fileInfo.Size(), "send", "completed", hash)
+		return nil
+	}
 
 	// Create stream to peer
 	stream, err := tm.node.host.NewStream(ctx, peerID, protocol.ID(TransferProtocolID))
@@ -67,28 +147,22 @@ func (tm *TransferManager) SendFile(ctx context.Context, peerID peer.ID, filePat
 	}
 	defer stream.Close()
 
+	active, untrack := tm.track(peerID.String(), "send", filePath, fileInfo.Size(), stream)
+	defer untrack()
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+
 	writer := bufio.NewWriter(stream)
 
-	// Send metadata: filename length, filename, file size
-	filename := filepath.Base(filePath)
-	if err := binary.Write(writer, binary.LittleEndian, uint32(len(filename))); err != nil {
-		return err
-	}
-	if _, err := writer.WriteString(filename); err != nil {
-		return err
-	}
-	if err := binary.Write(writer, binary.LittleEndian, fileInfo.Size()); err != nil {
+	key, err := tm.peerKey(peerID)
+	if err != nil {
 		return err
 	}
 
-	// Encrypt and send file
-	key := make([]byte, 32)
-	// In production, derive key from shared secret
-	if _, err := io.ReadFull(file, key); err != nil && err != io.EOF {
-		// For now, use a simple key derivation
-		copy(key, []byte("temporary-key-for-demo-purposes"))
+	// Send encrypted metadata: filename, file size
+	if err := writeFileHeader(writer, key, filepath.Base(filePath), fileInfo.Size()); err != nil {
+		return err
 	}
-	file.Seek(0, 0)
 
 	// Send file in chunks
 	transferred := int64(0)
@@ -120,10 +194,14 @@ func (tm *TransferManager) SendFile(ctx context.Context, peerID peer.ID, filePat
 
 		hasher.Write(buffer[:n])
 		transferred += int64(n)
-
-		if tm.onProgress != nil {
-			tm.onProgress(transferred, fileInfo.Size())
+		if err := active.progress(transferred); err != nil {
+			return err
+		}
+		if err := flow.Wait(ctx, n); err != nil {
+			return err
 		}
+
+		tm.reportFile(ctx, active.info.ID, filePath, transferred, fileInfo.Size())
 	}
 
 	// Send checksum
@@ -136,100 +214,174 @@ func (tm *TransferManager) SendFile(ctx context.Context, peerID peer.ID, filePat
 		return err
 	}
 
+	span.AddEvent("complete")
+
 	// Record transfer
-	tm.recordTransfer(peerID.String(), filePath, fileInfo.Size(), "send", "completed")
+	tm.recordTransfer(peerID.String(), filePath, fileInfo.Size(), "send", "completed", hex.EncodeToString(checksum))
 
 	return nil
 }
 
 func (tm *TransferManager) handleIncomingTransfer(stream network.Stream) {
 	defer stream.Close()
+	defer tm.busy()()
+
+	_, span := telemetry.StartSpan(context.Background(), "sync.receive",
+		telemetry.AttrPeer.String(stream.Conn().RemotePeer().String()))
+	defer span.End()
 
 	reader := bufio.NewReader(stream)
 
+	key, err := tm.peerKey(stream.Conn().RemotePeer())
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+
 	// Read metadata
-	var filenameLen uint32
-	if err := binary.Read(reader, binary.LittleEndian, &filenameLen); err != nil {
+	header, err := readFileHeader(reader, key)
+	if err != nil {
 		return
 	}
+	filename := header.Name
+	fileSize := header.Size
+	span.SetAttributes(telemetry.AttrBytes.Int64(fileSize))
 
-	filenameBytes := make([]byte, filenameLen)
-	if _, err := io.ReadFull(reader, filenameBytes); err != nil {
+	// The file arrives in a partial file and is only moved to outputPath
+	// once verified; the journal lets a crash at any step be resolved
+	outputPath := filepath.Join(tm.downloadDir, filename)
+	partPath := journal.PartialPath(outputPath)
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
 		return
 	}
-	filename := string(filenameBytes)
 
-	var fileSize int64
-	if err := binary.Read(reader, binary.LittleEndian, &fileSize); err != nil {
+	// Refuse early rather than failing partway through the stream
+	reservation, err := diskspace.Reserve(tm.downloadDir, fileSize)
+	if err != nil {
+		span.RecordError(err)
 		return
 	}
+	defer reservation.Release()
 
-	// Create output file
-	outputPath := filepath.Join(tm.downloadDir, filename)
-	if err := os.MkdirAll(tm.downloadDir, 0755); err != nil {
+	peerID := stream.Conn().RemotePeer().String()
+	op, err := journal.Begin(journal.KindReceive, journal.ReceiveParams{
+		Part:   partPath,
+		Target: outputPath,
+		Source: "sync",
+		Peer:   peerID,
+	})
+	if err != nil {
+		span.RecordError(err)
 		return
 	}
 
-	outFile, err := os.Create(outputPath)
+	outFile, err := os.Create(partPath)
 	if err != nil {
+		op.Abort(err)
 		return
 	}
 	defer outFile.Close()
 
-	// Receive and decrypt file
-	key := make([]byte, 32)
-	copy(key, []byte("temporary-key-for-demo-purposes"))
-
-	received := int64(0)
-	hasher := sha256.New()
-
-	for received < fileSize {
-		var chunkSize uint32
-		if err := binary.Read(reader, binary.LittleEndian, &chunkSize); err != nil {
-			return
-		}
-
-		encryptedChunk := make([]byte, chunkSize)
-		if _, err := io.ReadFull(reader, encryptedChunk); err != nil {
+	active, untrack := tm.track(peerID, "receive", outputPath, fileSize, stream)
+	defer untrack()
+	flow := bandwidth.Open(bandwidth.ClassSync)
+	defer flow.Close()
+
+	// A file that does not arrive whole is quarantined, not left behind
+	complete := false
+	reason := quarantine.ReasonFailed
+	var failure error
+	defer func() {
+		if complete {
 			return
 		}
-
-		// Decrypt chunk
-		decrypted, err := crypto.Decrypt(encryptedChunk, key)
-		if err != nil {
-			return
+		if active.killed.Load() {
+			reason = quarantine.ReasonCancelled
 		}
+		outFile.Close()
+		quarantine.Discard(partPath, reason, "sync", peerID)
+		op.Abort(fmt.Errorf("%s: %w", reason, failure))
+	}()
 
-		if _, err := outFile.Write(decrypted); err != nil {
-			return
-		}
-
-		hasher.Write(decrypted)
-		received += int64(len(decrypted))
+	// Receive and decrypt file
+	hasher := sha256.New()
 
-		if tm.onProgress != nil {
-			tm.onProgress(received, fileSize)
+	failure = op.Run("receive", nil, func() error {
+		received := int64(0)
+		for received < fileSize {
+			var chunkSize uint32
+			if err := binary.Read(reader, binary.LittleEndian, &chunkSize); err != nil {
+				return err
+			}
+
+			encryptedChunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(reader, encryptedChunk); err != nil {
+				return err
+			}
+
+			// Decrypt chunk
+			decrypted, err := crypto.Decrypt(encryptedChunk, key)
+			if err != nil {
+				return err
+			}
+
+			if _, err := outFile.Write(decrypted); err != nil {
+				return err
+			}
+
+			hasher.Write(decrypted)
+			received += int64(len(decrypted))
+			if err := active.progress(received); err != nil {
+				return err
+			}
+			// Reading slower holds the sender back too
+			flow.Wait(context.Background(), len(decrypted))
+
+			tm.reportFile(context.Background(), active.info.ID, outputPath, received, fileSize)
 		}
+		return outFile.Close()
+	})
+	if failure != nil {
+		return
 	}
 
 	// Verify checksum
-	expectedChecksum := make([]byte, 32)
-	if _, err := io.ReadFull(reader, expectedChecksum); err != nil {
+	actualChecksum := hasher.Sum(nil)
+	failure = op.Run("verify", nil, func() error {
+		expectedChecksum := make([]byte, 32)
+		if _, err := io.ReadFull(reader, expectedChecksum); err != nil {
+			return err
+		}
+		if string(expectedChecksum) != string(actualChecksum) {
+			span.AddEvent("checksum_mismatch")
+			reason = quarantine.ReasonChecksum
+			return fmt.Errorf("checksum mismatch")
+		}
+		return nil
+	})
+	if failure != nil {
 		return
 	}
 
-	actualChecksum := hasher.Sum(nil)
-	if string(expectedChecksum) != string(actualChecksum) {
-		os.Remove(outputPath)
+	failure = op.Run("place", nil, func() error {
+		return os.Rename(partPath, outputPath)
+	})
+	if failure != nil {
 		return
 	}
+	complete = true
+	op.Commit()
+	span.AddEvent("complete")
 
 	// Record transfer
-	peerID := stream.Conn().RemotePeer().String()
-	tm.recordTransfer(peerID, outputPath, fileSize, "receive", "completed")
+	tm.recordTransfer(peerID, outputPath, fileSize, "receive", "completed", hex.EncodeToString(actualChecksum))
+
+	onreceive.Received(outputPath, "sync", peerID)
 }
 
-func (tm *TransferManager) recordTransfer(peerID, filePath string, fileSize int64, direction, status string) {
+// recordTransfer stores a transfer in the history. hash is the hex SHA-256
+// of the file, empty if unknown.
+func (tm *TransferManager) recordTransfer(peerID, filePath string, fileSize int64, direction, status, hash string) {
 	db := storage.DB()
 
 	// Get device name
@@ -240,16 +392,105 @@ func (tm *TransferManager) recordTransfer(peerID, filePath string, fileSize int6
 	}
 
 	transfer := models.TransferHistory{
-		PeerID:     peerID,
-		DeviceName: deviceName,
-		FilePath:   filePath,
-		FileSize:   fileSize,
-		Status:     status,
-		Direction:  direction,
-		Progress:   100.0,
+		PeerID:           peerID,
+		DeviceName:       deviceName,
+		FilePath:         filePath,
+		FileSize:         fileSize,
+		Status:           status,
+		Direction:        direction,
+		Progress:         100.0,
+		FileHash:         hash,
+		LocalFingerprint: tm.node.host.ID().String(),
+		PeerFingerprint:  peerID,
 	}
 
 	db.Create(&transfer)
+	if status == "completed" {
+		if err := usage.Record(filePath, usage.EventTransfer); err != nil {
+			log.Printf("Failed to record usage of %s: %v", logging.Path(filePath), err)
+		}
+	}
+}
+
+// maxFileHeaderSize bounds the encrypted metadata blob a peer may send
+const maxFileHeaderSize = 64 * 1024
+
+// fileHeader is the per-file metadata sent ahead of the data. It travels
+// encrypted so file names never appear in the stream preamble.
+type fileHeader struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// writeFileHeader writes [length: uint32][encrypted JSON header]
+func writeFileHeader(w io.Writer, key []byte, name string, size int64) error {
+	data, err := json.Marshal(fileHeader{Name: name, Size: size})
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := crypto.Encrypt(data, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt metadata: %w", err)
+	}
+
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(encrypted))); err != nil {
+		return err
+	}
+	_, err = w.Write(encrypted)
+	return err
+}
+
+// readFileHeader reads and decrypts a header written by writeFileHeader
+func readFileHeader(r io.Reader, key []byte) (fileHeader, error) {
+	var header fileHeader
+
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return header, err
+	}
+	if length > maxFileHeaderSize {
+		return header, fmt.Errorf("metadata too large: %d bytes", length)
+	}
+
+	encrypted := make([]byte, length)
+	if _, err := io.ReadFull(r, encrypted); err != nil {
+		return header, err
+	}
+
+	data, err := crypto.Decrypt(encrypted, key)
+	if err != nil {
+		return header, fmt.Errorf("failed to decrypt metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return header, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	// Never let a peer choose a path outside the download directory
+	header.Name = filepath.Base(header.Name)
+	return header, nil
+}
+
+// peerKey derives the key for every stream with peerID from the X25519
+// shared secret of the two devices' identity keys, so only they can read
+// its headers, requests and data. The peer ID embeds its Ed25519 key, and
+// the connection has proven the peer holds it.
+func (tm *TransferManager) peerKey(peerID peer.ID) ([]byte, error) {
+	remote, err := peerID.ExtractPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key of %s: %w", peerID, err)
+	}
+	shared, err := relay.SharedSecret(tm.node.GetPrivateKey(), remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive transfer key: %w", err)
+	}
+	// Both sides salt with the two IDs in the same order
+	self := tm.node.host.ID()
+	low, high := self, peerID
+	if high < low {
+		low, high = high, low
+	}
+	return hkdf.Key(sha256.New, shared, []byte(string(low)+string(high)), "sfm-transfer", crypto.KeySize)
 }
 
 // GetTransferHistory returns transfer history
@@ -259,3 +500,68 @@ func (tm *TransferManager) GetTransferHistory(limit int) ([]models.TransferHisto
 	err := db.Order("created_at DESC").Limit(limit).Find(&history).Error
 	return history, err
 }
+
+// DeleteTransfer removes a transfer history record unless it is held
+func (tm *TransferManager) DeleteTransfer(id uint) error {
+	if err := hold.CheckTransfer(id); err != nil {
+		return err
+	}
+	if err := storage.DB().Delete(&models.TransferHistory{}, id).Error; err != nil {
+		return fmt.Errorf("failed to delete transfer: %w", err)
+	}
+	return nil
+}
+
+// PruneTransferHistory removes records older than cutoff, skipping held
+// records. It returns the number of records removed.
+func (tm *TransferManager) PruneTransferHistory(cutoff time.Time) (int64, error) {
+	ids, all, err := hold.HeldTransferIDs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load holds: %w", err)
+	}
+	if all {
+		return 0, nil
+	}
+
+	query := storage.DB().Where("created_at < ?", cutoff)
+	if len(ids) > 0 {
+		query = query.Where("id NOT IN ?", ids)
+	}
+	result := query.Delete(&models.TransferHistory{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune transfer history: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+var (
+	ErrNoRecordedHash = errors.New("transfer has no recorded hash")
+	ErrHashMismatch   = errors.New("file does not match the recorded hash")
+)
+
+// Verify re-checks a file against the hash recorded for a transfer, e.g.
+// to settle whether a received file is the one that was sent. filePath
+// may differ from the recorded path if the file has moved since.
+func (tm *TransferManager) Verify(historyID uint, filePath string) error {
+	var transfer models.TransferHistory
+	if err := storage.DB().First(&transfer, historyID).Error; err != nil {
+		return fmt.Errorf("failed to load transfer: %w", err)
+	}
+	if transfer.FileHash == "" {
+		return ErrNoRecordedHash
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return fmt.Errorf("failed to hash file: %w", err)
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != transfer.FileHash {
+		return ErrHashMismatch
+	}
+	return nil
+}
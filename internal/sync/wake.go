@@ -0,0 +1,180 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/owner/secure-file-manager/internal/logging"
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/internal/telemetry"
+	"github.com/owner/secure-file-manager/internal/wol"
+	"github.com/owner/secure-file-manager/pkg/models"
+)
+
+const (
+	wakeRetryInterval  = 3 * time.Second
+	wakeResendInterval = 30 * time.Second
+)
+
+var ErrNoMACAddress = errors.New("no MAC address known for device")
+
+// SetWakeBroadcast sets the UDP address magic packets are sent to
+func (tm *TransferManager) SetWakeBroadcast(addr string) {
+	tm.wakeBroadcast = addr
+}
+
+// SetDeviceMAC stores the MAC address used to wake a paired device
+func SetDeviceMAC(peerID peer.ID, mac string) error {
+	if mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address: %w", err)
+		}
+		mac = hw.String()
+	}
+	result := storage.DB().Model(&models.PairedDevice{}).Where("peer_id = ?", peerID.String()).Update("mac_address", mac)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save MAC address: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("device not paired: %s", peerID)
+	}
+	return nil
+}
+
+// WatchLANPeers learns the LAN address and MAC of paired devices whenever
+// they connect, so they can be woken and dialed later
+func (tm *TransferManager) WatchLANPeers() error {
+	sub, err := tm.node.host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to peer events: %w", err)
+	}
+
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-tm.node.ctx.Done():
+				return
+			case e, ok := <-sub.Out():
+				if !ok {
+					return
+				}
+				evt := e.(event.EvtPeerIdentificationCompleted)
+				tm.rememberLANAddress(evt.Peer, evt.Conn.RemoteMultiaddr(), evt.ListenAddrs)
+			}
+		}
+	}()
+	return nil
+}
+
+// rememberLANAddress stores the listen address a paired peer has on the
+// LAN it connected from, and its MAC from the ARP table
+func (tm *TransferManager) rememberLANAddress(peerID peer.ID, remote multiaddr.Multiaddr, listenAddrs []multiaddr.Multiaddr) {
+	ip := lanIP(remote)
+	if ip == nil {
+		return
+	}
+
+	db := storage.DB()
+	var device models.PairedDevice
+	if err := db.Where("peer_id = ?", peerID.String()).First(&device).Error; err != nil {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	for _, addr := range listenAddrs {
+		if listenIP := lanIP(addr); listenIP != nil && listenIP.Equal(ip) {
+			updates["local_address"] = addr.String()
+			break
+		}
+	}
+	if mac, err := wol.LookupMAC(ip); err == nil {
+		updates["mac_address"] = mac.String()
+	}
+	if len(updates) > 0 {
+		db.Model(&device).Updates(updates)
+	}
+}
+
+// lanIP returns the private IPv4 address of addr, or nil
+func lanIP(addr multiaddr.Multiaddr) net.IP {
+	value, err := addr.ValueForProtocol(multiaddr.P_IP4)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil || !ip.IsPrivate() {
+		return nil
+	}
+	return ip
+}
+
+// WakePeer makes sure a paired device is reachable before a scheduled run.
+// A connected peer returns at once; otherwise a magic packet is sent and
+// the peer is dialed until it answers or timeout passes.
+func (tm *TransferManager) WakePeer(ctx context.Context, peerID peer.ID, timeout time.Duration) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "sync.wake", telemetry.AttrPeer.String(peerID.String()))
+	retries := 0
+	defer func() {
+		span.SetAttributes(telemetry.AttrRetries.Int(retries))
+		telemetry.EndSpan(span, err)
+	}()
+
+	h := tm.node.host
+	if h.Network().Connectedness(peerID) == network.Connected {
+		return nil
+	}
+
+	var device models.PairedDevice
+	if err := storage.DB().Where("peer_id = ?", peerID.String()).First(&device).Error; err != nil {
+		return fmt.Errorf("device not paired: %s", peerID)
+	}
+	if device.MACAddress == "" {
+		return ErrNoMACAddress
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Peerstore addresses expire while a device sleeps; the remembered LAN
+	// address does not
+	info := h.Peerstore().PeerInfo(peerID)
+	if addr, err := multiaddr.NewMultiaddr(device.LocalAddress); err == nil {
+		info.Addrs = append(info.Addrs, addr)
+	}
+
+	log.Printf("Waking %s", logging.DeviceName(device.DeviceName))
+	lastSent := time.Time{}
+	for {
+		if time.Since(lastSent) >= wakeResendInterval {
+			if err := wol.Wake(device.MACAddress, tm.wakeBroadcast); err != nil {
+				return err
+			}
+			lastSent = time.Now()
+		}
+
+		dialCtx, dialCancel := context.WithTimeout(ctx, wakeRetryInterval)
+		err := h.Connect(dialCtx, info)
+		dialCancel()
+		if err == nil {
+			log.Printf("%s is awake", logging.DeviceName(device.DeviceName))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not wake up: %w", device.DeviceName, ctx.Err())
+		case <-time.After(wakeRetryInterval):
+			retries++
+		}
+	}
+}
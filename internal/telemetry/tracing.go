@@ -0,0 +1,150 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/owner/secure-file-manager"
+
+// Span attribute keys shared by the transfer and sync pipelines
+const (
+	AttrPeer      = attribute.Key("sfm.peer")
+	AttrSessionID = attribute.Key("sfm.session_id")
+	AttrBytes     = attribute.Key("sfm.bytes")
+	AttrChunks    = attribute.Key("sfm.chunks")
+	AttrChunk     = attribute.Key("sfm.chunk_index")
+	AttrRetries   = attribute.Key("sfm.retries")
+	AttrMode      = attribute.Key("sfm.mode")
+	AttrFiles     = attribute.Key("sfm.files")
+)
+
+// Options configures trace export
+type Options struct {
+	Endpoint    string  // OTLP/HTTP collector, e.g. localhost:4318
+	Insecure    bool    // Use plain HTTP to the collector
+	ServiceName string  // Reported service.name
+	SampleRatio float64 // Fraction of root traces sampled (0..1)
+}
+
+// Init installs a global tracer provider exporting spans via OTLP/HTTP.
+// Until Init is called all spans are no-ops. The returned function flushes
+// and shuts down the exporter.
+func Init(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	clientOpts := []otlptracehttp.Option{}
+	if opts.Endpoint != "" {
+		clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+	}
+	if opts.Insecure {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = "sfm"
+	}
+	res := resource.NewSchemaless(attribute.String("service.name", serviceName))
+
+	ratio := opts.SampleRatio
+	if ratio <= 0 || ratio > 1 {
+		ratio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+var (
+	mu       sync.Mutex
+	shutdown func(context.Context) error
+)
+
+// Configure starts trace export if enabled, replacing any exporter started
+// by an earlier call, and otherwise leaves spans as no-ops. Shutdown flushes
+// the exporter on exit.
+func Configure(ctx context.Context, enabled bool, opts Options) error {
+	if err := Shutdown(ctx); err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	stop, err := Init(ctx, opts)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	shutdown = stop
+	mu.Unlock()
+	return nil
+}
+
+// Shutdown flushes and stops the exporter started by Configure, if any
+func Shutdown(ctx context.Context) error {
+	mu.Lock()
+	stop := shutdown
+	shutdown = nil
+	mu.Unlock()
+	if stop == nil {
+		return nil
+	}
+	if err := stop(ctx); err != nil {
+		return fmt.Errorf("failed to shut down trace export: %w", err)
+	}
+	return nil
+}
+
+// Tracer returns the tracer used across SFM subsystems
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// StartSpan starts a span on the SFM tracer
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records err (if any) on span and ends it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectHTTP propagates the span context in ctx to an outgoing request so
+// traces continue on the receiving device
+func InjectHTTP(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// ExtractHTTP returns the request context joined to the sender's trace
+func ExtractHTTP(r *http.Request) context.Context {
+	return otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+}
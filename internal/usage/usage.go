@@ -0,0 +1,144 @@
+package usage
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/owner/secure-file-manager/internal/storage"
+	"github.com/owner/secure-file-manager/pkg/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Usage events
+const (
+	EventOpen      = "open"
+	EventTransfer  = "transfer"
+	EventSearchHit = "search_hit" // A search result was opened
+)
+
+// halfLife is how long it takes a use to count half as much toward
+// FrequentFiles
+const halfLife = 14 * 24 * time.Hour
+
+// Frecency scores are stored relative to a fixed epoch, so a use adds
+// weight * 2^((t - epoch) / halfLife). Newer uses add exponentially more,
+// which decays older ones without rewriting rows, and scores of different
+// files compare directly.
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var weights = map[string]float64{
+	EventOpen:      1,
+	EventTransfer:  1,
+	EventSearchHit: 0.5,
+}
+
+// Record notes that path was used
+func Record(path, event string) error {
+	weight, ok := weights[event]
+	if !ok {
+		return fmt.Errorf("unknown usage event: %s", event)
+	}
+
+	now := time.Now()
+	row := models.FileUsage{
+		Path:     filepath.Clean(path),
+		LastUsed: now,
+		Frecency: weight * math.Exp2(now.Sub(epoch).Hours()/halfLife.Hours()),
+	}
+	counter := ""
+	switch event {
+	case EventOpen:
+		row.Opens, counter = 1, "opens"
+	case EventTransfer:
+		row.Transfers, counter = 1, "transfers"
+	case EventSearchHit:
+		row.SearchHits, counter = 1, "search_hits"
+	}
+
+	err := storage.DB().Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "path"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			counter:      gorm.Expr(counter + " + 1"),
+			"frecency":   gorm.Expr("frecency + excluded.frecency"),
+			"last_used":  now,
+			"updated_at": now,
+		}),
+	}).Create(&row).Error
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// RecentFiles returns up to limit files, most recently used first
+func RecentFiles(limit int) ([]models.FileUsage, error) {
+	return list("last_used DESC", limit)
+}
+
+// FrequentFiles returns up to limit files, most used first. Recent uses
+// count more, halving every two weeks.
+func FrequentFiles(limit int) ([]models.FileUsage, error) {
+	return list("frecency DESC", limit)
+}
+
+// list returns files that still exist, locally or as placeholders, in the
+// given order. Files that are gone are forgotten.
+func list(order string, limit int) ([]models.FileUsage, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	db := storage.DB()
+	files := make([]models.FileUsage, 0, limit)
+	for offset := 0; len(files) < limit; offset += limit {
+		var page []models.FileUsage
+		if err := db.Order(order).Offset(offset).Limit(limit).Find(&page).Error; err != nil {
+			return nil, fmt.Errorf("failed to load usage: %w", err)
+		}
+		var gone []uint
+		for _, f := range page {
+			if _, err := os.Stat(f.Path); os.IsNotExist(err) && !placeholder(db, f.Path) {
+				gone = append(gone, f.ID)
+			} else if len(files) < limit {
+				files = append(files, f)
+			}
+		}
+		if len(gone) > 0 {
+			if err := db.Delete(&models.FileUsage{}, gone).Error; err != nil {
+				return nil, fmt.Errorf("failed to forget missing files: %w", err)
+			}
+			offset -= len(gone)
+		}
+		if len(page) < limit {
+			break
+		}
+	}
+	return files, nil
+}
+
+// placeholder reports whether path is in the availability catalog
+func placeholder(db *gorm.DB, path string) bool {
+	var count int64
+	db.Model(&models.RemoteFile{}).Where("local_path = ?", path).Count(&count)
+	return count > 0
+}
+
+// Forget drops the usage of path
+func Forget(path string) error {
+	if err := storage.DB().Where("path = ?", filepath.Clean(path)).Delete(&models.FileUsage{}).Error; err != nil {
+		return fmt.Errorf("failed to forget usage: %w", err)
+	}
+	return nil
+}
+
+// Prune drops files not used since cutoff and returns how many were removed
+func Prune(cutoff time.Time) (int64, error) {
+	result := storage.DB().Where("last_used < ?", cutoff).Delete(&models.FileUsage{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune usage: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package wol
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// LookupMAC finds the MAC of a LAN host in the kernel's ARP table. The
+// host must have been reached recently.
+func LookupMAC(ip net.IP) (net.HardwareAddr, error) {
+	file, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ARP table: %w", err)
+	}
+	defer file.Close()
+
+	// IP address  HW type  Flags  HW address  Mask  Device
+	scanner := bufio.NewScanner(file)
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !net.ParseIP(fields[0]).Equal(ip) {
+			continue
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil || mac.String() == "00:00:00:00:00:00" {
+			break
+		}
+		return mac, nil
+	}
+	return nil, fmt.Errorf("no ARP entry for %s", ip)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package wol
+
+import "net"
+
+// LookupMAC finds the MAC of a LAN host in the kernel's ARP table
+func LookupMAC(ip net.IP) (net.HardwareAddr, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,55 @@
+package wol
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// DefaultBroadcast is where magic packets go unless configured otherwise
+const DefaultBroadcast = "255.255.255.255:9"
+
+var ErrUnsupported = errors.New("MAC lookup is not supported on this platform")
+
+// MagicPacket builds a Wake-on-LAN packet: six 0xFF bytes followed by the
+// MAC repeated 16 times
+func MagicPacket(mac net.HardwareAddr) []byte {
+	packet := make([]byte, 0, 6+16*len(mac))
+	for i := 0; i < 6; i++ {
+		packet = append(packet, 0xFF)
+	}
+	for i := 0; i < 16; i++ {
+		packet = append(packet, mac...)
+	}
+	return packet
+}
+
+// Wake broadcasts a magic packet for mac. broadcast is a host:port UDP
+// address; empty means DefaultBroadcast.
+func Wake(mac, broadcast string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return fmt.Errorf("invalid MAC address: %w", err)
+	}
+	if len(hw) != 6 {
+		return fmt.Errorf("invalid MAC address: %s", mac)
+	}
+	if broadcast == "" {
+		broadcast = DefaultBroadcast
+	}
+
+	addr, err := net.ResolveUDPAddr("udp4", broadcast)
+	if err != nil {
+		return fmt.Errorf("invalid broadcast address: %w", err)
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(MagicPacket(hw)); err != nil {
+		return fmt.Errorf("failed to send magic packet: %w", err)
+	}
+	return nil
+}
@@ -15,11 +15,17 @@ type EncryptedContainer struct {
 	Path         string         `gorm:"uniqueIndex;not null"`
 	OriginalPath string         `gorm:"not null"`
 	Salt         []byte         `gorm:"not null"`
+	KDF          uint8          `gorm:"not null;default:0"` // crypto.KDF* ID; the Argon2 fields hold its costs
 	Argon2Time   uint32         `gorm:"not null"`
 	Argon2Memory uint32         `gorm:"not null"`
 	Argon2Threads uint8         `gorm:"not null"`
 	IsMounted    bool           `gorm:"default:false"`
 	MountPoint   string
+	Checksum     string         // Hex SHA-256 of the file, kept by the scrubber
+	FileSize     int64
+	ModifiedTime time.Time
+	Archive      bool           `gorm:"default:false"` // Cold storage: remind when not verified for a while
+	LastVerified time.Time      // When the file last matched Checksum
 }
 
 // PairedDevice represents a device paired for P2P sync
@@ -28,13 +34,16 @@ type PairedDevice struct {
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
-	PeerID       string         `gorm:"uniqueIndex;not null"`
+	PeerID       string         `gorm:"uniqueIndex:idx_paired_device;not null"`
 	DeviceName   string         `gorm:"not null"`
 	PublicKey    []byte         `gorm:"not null"`
-	AccountID    string         `gorm:"index;not null"`
+	AccountID    string         `gorm:"uniqueIndex:idx_paired_device;index;not null"` // A device may be paired in several accounts
 	LastSeen     time.Time
 	IsOnline     bool           `gorm:"default:false"`
 	LocalAddress string
+	MACAddress   string         // For Wake-on-LAN; learned on the LAN or set by hand
+	DNSName      string         // Looked up when mDNS and the DHT fail; host:port or a name with _sfm records
+	Trusted      bool           `gorm:"default:false"` // May approve restricted transfers
 }
 
 // TransferHistory tracks file transfer history
@@ -47,18 +56,26 @@ type TransferHistory struct {
 	DeviceName string
 	FilePath   string         `gorm:"not null"`
 	FileSize   int64
-	Status     string         `gorm:"not null"` // pending, transferring, completed, failed
+	Status     string         `gorm:"not null"` // pending, transferring, completed, failed, parked
 	Direction  string         `gorm:"not null"` // send, receive
 	Progress   float64        `gorm:"default:0"`
 	Error      string
+
+	// Confirmation details for settling disputes later
+	FileHash         string // Hex SHA-256 of the file as sent or received
+	LocalFingerprint string // This device's peer ID
+	PeerFingerprint  string // The other device's peer ID
+	Receipt          []byte // Signed receipt, once the protocol exchanges them
 }
 
-// AccountInfo stores local account information
+// AccountInfo stores local account information, one row per account this
+// device takes part in
 type AccountInfo struct {
 	ID         uint      `gorm:"primarykey"`
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 	AccountID  string    `gorm:"uniqueIndex;not null"`
+	Name       string    `gorm:"index"` // Local label, e.g. "personal" or "family"
 	DeviceName string    `gorm:"not null"`
 	PeerID     string    `gorm:"not null"`
 	PrivateKey []byte    `gorm:"not null"`
@@ -67,14 +84,323 @@ type AccountInfo struct {
 
 // SearchIndex represents indexed file metadata
 type SearchIndex struct {
-	ID           uint           `gorm:"primarykey"`
+	ID             uint           `gorm:"primarykey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	Path           string         `gorm:"uniqueIndex;not null"`
+	FileName       string         `gorm:"index;not null"`
+	FileSize       int64
+	ModifiedTime   time.Time
+	IsDirectory    bool
+	ContentHash    string
+	Content        string `gorm:"type:text"` // Extracted text when content indexing is on
+	ContentIndexed bool   // Content is up to date, including OCR
+	Terms          string `gorm:"type:text"` // Analyzed terms of Content, " term1 term2 "
+}
+
+// SearchRoot records the analyzer an indexed directory's terms were built
+// with, so a changed analyzer triggers a reindex
+type SearchRoot struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Path      string `gorm:"uniqueIndex;not null"`
+	Analyzer  string `gorm:"not null"` // Analyzer spec, e.g. "german+stem+stop+fold+cjk"
+}
+
+// DirectoryStats aggregates the indexed files below a directory, kept up
+// to date by the indexer so folder sizes need no tree walk
+type DirectoryStats struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Path          string `gorm:"uniqueIndex;not null"`
+	TotalSize     int64
+	FileCount     int64
+	NewestModTime time.Time
+}
+
+// FileTag attaches a tag to an indexed file
+type FileTag struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	Path      string    `gorm:"uniqueIndex:idx_tag_path;not null"`
+	Tag       string    `gorm:"uniqueIndex:idx_tag_path;index;not null"`
+	Rule      string    `gorm:"index"` // Name of the rule that assigned the tag
+}
+
+// FileUsage counts how a file has been used, for recent and frequent
+// file lists
+type FileUsage struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Path       string    `gorm:"uniqueIndex;not null"`
+	Opens      int64
+	Transfers  int64
+	SearchHits int64
+	LastUsed   time.Time `gorm:"index"`
+	Frecency   float64   `gorm:"index"` // Decaying score, comparable across files
+}
+
+// RemoteFile is an entry of the availability catalog: a file in a paired
+// device's share. Entries whose LocalPath does not exist are placeholders
+// that can be fetched on demand.
+type RemoteFile struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	PeerID    string    `gorm:"uniqueIndex:idx_remote_file;not null"`
+	Share     string    `gorm:"uniqueIndex:idx_remote_file;not null"`
+	RelPath   string    `gorm:"uniqueIndex:idx_remote_file;not null"` // Slash-separated, relative to the share
+	LocalPath string    `gorm:"index"`                                // Where the file belongs locally, if the share is mapped
+	Size      int64
+	ModTime   time.Time
+	Hash      string // Hex SHA-256, if the share was scanned with hashes
+}
+
+// CacheEntry is a piece of derived data (thumbnail, preview, OCR text)
+// cached for a source file
+type CacheEntry struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Kind       string    `gorm:"uniqueIndex:idx_cache_source;not null"` // e.g. "thumb-256", "ocr"
+	SourcePath string    `gorm:"uniqueIndex:idx_cache_source;not null"`
+	SourceHash string    `gorm:"not null"` // Content hash of the source when derived
+	Container  string    `gorm:"index"` // Set if the source is in a container; the source and hash are then keyed hashes and the data encrypted
+	Size       int64
+	AccessedAt time.Time `gorm:"index"`
+}
+
+// Setting stores a runtime-adjustable option that overrides the file config
+type Setting struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Key       string    `gorm:"uniqueIndex;not null"`
+	Type      string    `gorm:"not null"` // bool, int, string
+	Value     string    `gorm:"not null"` // JSON-encoded
+}
+
+// JournalOperation records a multi-step operation for crash recovery
+type JournalOperation struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	OpID      string    `gorm:"uniqueIndex;not null"`
+	Kind      string    `gorm:"index;not null"`
+	Params    string    // JSON-encoded operation parameters
+	State     string    `gorm:"index;not null"` // pending, committed, aborted, recovered
+	Error     string
+}
+
+// JournalStep records the intent and outcome of one step of an operation
+type JournalStep struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	OpID      string    `gorm:"index;not null"`
+	Seq       int       `gorm:"not null"`
+	Name      string    `gorm:"not null"`
+	State     string    `gorm:"not null"` // intent, done, failed, undone
+	Data      string    // JSON-encoded step data
+}
+
+// MirrorJob is a scheduled one-way mirror of a local folder to a paired
+// device's shared folder
+type MirrorJob struct {
+	ID           uint      `gorm:"primarykey"`
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
-	Path         string         `gorm:"uniqueIndex;not null"`
-	FileName     string         `gorm:"index;not null"`
-	FileSize     int64
-	ModifiedTime time.Time
-	IsDirectory  bool
-	ContentHash  string
+	Name         string    `gorm:"uniqueIndex;not null"`
+	SourcePath   string    `gorm:"not null"`
+	PeerID       string    `gorm:"index;not null"`
+	Share        string    `gorm:"not null"`
+	DeletePolicy string    `gorm:"not null"` // propagate, retain, archive
+	Interval     int64     // Seconds between runs, 0 = manual only
+	Exclude      string    // JSON-encoded patterns
+	Enabled      bool
+	LastRun      time.Time
+	LastStatus   string    // completed, failed
+	LastError    string
+}
+
+// BackupRun records a completed snapshot backup
+type BackupRun struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time
+	Source     string    `gorm:"index;not null"`
+	Repository string    `gorm:"not null"`
+	SnapshotID string    `gorm:"not null"`
+	Files      int
+	BytesAdded int64
+}
+
+// LegalHold blocks deletion and modification of a record through SFM until
+// released with the master credential
+type LegalHold struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	Kind      string    `gorm:"uniqueIndex:idx_hold_target;not null"` // container, transfer
+	Target    string    `gorm:"uniqueIndex:idx_hold_target;not null"` // Container path, transfer ID, or * for all
+	Reason    string
+}
+
+// MasterCredential is the Argon2id hash of the credential that releases
+// legal holds
+type MasterCredential struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Salt          []byte    `gorm:"not null"`
+	Hash          []byte    `gorm:"not null"`
+	Argon2Time    uint32    `gorm:"not null"`
+	Argon2Memory  uint32    `gorm:"not null"`
+	Argon2Threads uint8     `gorm:"not null"`
+}
+
+// FileLock is an advisory lock on a file in a shared folder, announced to
+// paired devices so they can warn before editing the same document
+type FileLock struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Share      string    `gorm:"uniqueIndex:idx_lock_path;not null"`
+	Path       string    `gorm:"uniqueIndex:idx_lock_path;not null"` // Slash-separated, relative to the share
+	Owner      string    `gorm:"index;not null"`                     // Peer ID of the holder
+	DeviceName string
+	Note       string    // e.g. "being edited on desktop"
+	ExpiresAt  time.Time `gorm:"index"`
+}
+
+// Approval is a request for another trusted device to confirm sending a
+// restricted file. Both the requesting and the approving device keep one.
+type Approval struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	RequestID     string    `gorm:"uniqueIndex:idx_approval_request;not null"`
+	Outgoing      bool      `gorm:"uniqueIndex:idx_approval_request"` // Requested by this device
+	PeerID        string    `gorm:"index"`                            // Requesting device, for incoming requests
+	FilePath      string    `gorm:"not null"`
+	Size          int64
+	ModTime       time.Time
+	Hash          string    // Hex SHA-256 of the file
+	Recipient     string    `gorm:"not null"` // Peer ID the file is for
+	RecipientName string
+	Status        string    `gorm:"index;not null"` // pending, approved, denied, expired
+	DecidedBy     string    // Peer ID of the approving device
+	ExpiresAt     time.Time
+}
+
+// EscrowedKey is a container recovery key another device deposited here,
+// sealed to this device's key
+type EscrowedKey struct {
+	ID            uint      `gorm:"primarykey"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	OwnerPeerID   string    `gorm:"uniqueIndex:idx_escrow;not null"`
+	ContainerID   string    `gorm:"uniqueIndex:idx_escrow;not null"`
+	ContainerName string    // Base name, to help the user find the container
+	Sealed        []byte    `gorm:"not null"`
+}
+
+// AuditEvent is an entry of the append-only audit log
+type AuditEvent struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	Action    string    `gorm:"index;not null"` // e.g. approval.granted
+	Actor     string    // Peer ID of the device that acted, or the local component
+	Target    string    // File path or peer ID acted on
+	Detail    string
+}
+
+// TrustedKey is an AirDrop device identity key the user imported, e.g.
+// from an OpenSSH or age public key. Keys are matched in their X25519
+// form, which every supported format reduces to.
+type TrustedKey struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	KeyID       string    `gorm:"uniqueIndex;not null"` // Hex of the X25519 form
+	Name        string
+	PublicKey   []byte    // Ed25519 key; empty if imported as an age recipient
+	Fingerprint string    `gorm:"index"` // Of PublicKey, empty with it
+	Format      string    `gorm:"not null"` // Format it was imported from
+}
+
+// CourierBundle is a delta of a shared folder queued on a removable drive
+// for a device with no usable network
+type CourierBundle struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	BundleID  string    `gorm:"uniqueIndex;not null"`
+	PeerID    string    `gorm:"index;not null"`
+	Share     string    `gorm:"not null"`
+	Manifest  []byte    // JSON-encoded files and deletions it carries
+	Changes   int
+	Status    string    `gorm:"not null"` // queued, acknowledged, failed
+	Error     string
+}
+
+// CourierFile is a file or directory a device acknowledged receiving by
+// courier; deltas are computed against these
+type CourierFile struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	PeerID    string    `gorm:"uniqueIndex:idx_courier_file;not null"`
+	Share     string    `gorm:"uniqueIndex:idx_courier_file;not null"`
+	RelPath   string    `gorm:"uniqueIndex:idx_courier_file;not null"` // Slash-separated, relative to the share
+	IsDir     bool
+	Size      int64
+	ModTime   time.Time
+	Hash      string // Hex SHA-256
+}
+
+// AirDropSession is an accepted incoming AirDrop transfer, kept so a
+// restarted receiver can resume it with its partial file
+type AirDropSession struct {
+	ID          uint      `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	SessionID   string    `gorm:"uniqueIndex;not null"`
+	SenderName  string
+	Fingerprint string    `gorm:"index"`
+	SessionKey  []byte    `gorm:"not null"` // Sealed with a key derived from the device identity
+	Metadata    []byte    `gorm:"not null"` // JSON-encoded file metadata
+	TotalChunks int
+	Chunks      []byte    // Bitmap of the chunks received and synced to disk
+	Checksums   []byte    // SHA-256 of each received chunk, 32 bytes per chunk index
+	FilePath    string    `gorm:"not null"`
+	FECData     int       // Data chunks per FEC group, 0 without FEC
+	FECParity   int
+	Web         bool      // Started by a browser; chunks carry additional data
+}
+
+// DirectoryPin remembers which key owned a directory address when it was
+// first resolved, so a directory server cannot later swap in its own
+type DirectoryPin struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Address   string    `gorm:"uniqueIndex;not null"` // handle@host
+	Owner     string    `gorm:"not null"`             // Peer ID of the entry's signing key
+}
+
+// APIToken is a scoped bearer token for the daemon API. Only a hash of the
+// token is stored.
+type APIToken struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Name      string    `gorm:"not null"`            // What it was issued for, e.g. "dashboard widget"
+	Hash      string    `gorm:"uniqueIndex;not null"` // Hex SHA-256 of the token
+	Scopes    string    `gorm:"not null"`            // JSON-encoded scope names
+	ExpiresAt time.Time // Zero never expires
+	RevokedAt time.Time // Zero unless revoked
+	LastUsed  time.Time
 }